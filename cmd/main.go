@@ -1,22 +1,87 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log/slog"
 	config "mail/config"
+	"mail/database"
+	"mail/database/postgres"
+	"mail/internal/app/delivery"
 	httpserver "mail/internal/app/httpserver"
+	"mail/internal/app/outbound"
+	"mail/internal/app/smtpserver"
+	"mail/pkg/blobstore"
+	"mail/pkg/mailauth"
+	"mail/pkg/password"
+	"mail/pkg/search"
+	"mail/pkg/spam"
+	"net"
 )
 
 func main() {
-	var srv httpserver.HTTPServer
 	configPath := flag.String("config-path", "./config/config.yaml", "path to config file")
 	flag.Parse()
 	
 	config, err := config.GetConfig(*configPath)
 	if err != nil {
 		slog.Error(err.Error())
+		return
 	}
 
+	repo := database.NewInMemoryRepositories()
+	if config.Postgres.Host != "" {
+		ctx := context.Background()
+		db, err := postgres.Open(ctx, config.Postgres)
+		if err != nil {
+			slog.Error(err.Error())
+			return
+		}
+		defer db.Close()
+
+		if err := postgres.Migrate(ctx, db); err != nil {
+			slog.Error(err.Error())
+			return
+		}
+		repo = postgres.NewRepositories(db)
+	}
+
+	index := search.NewIndex(repo)
+	repo.Messages = search.Indexed(repo.Messages, index)
+
+	passwords, err := password.NewHasher(config.Password)
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+
+	var verifier *mailauth.Verifier
+	if config.SMTPServer.VerifyAuth {
+		verifier = mailauth.NewVerifier(net.DefaultResolver, config.SMTPServer.Hostname)
+	}
+	deliverer := delivery.NewDeliverer(repo, config.SMTPServer.LocalDomains, spam.New(config.Spam, repo.Spam), verifier)
+	router := &outbound.Router{
+		Local:   deliverer,
+		Remote:  outbound.NewSMTPRelay(config.Outbound),
+		IsLocal: deliverer.IsLocal,
+	}
+	queue := outbound.NewQueue(repo, router, config.Outbound)
+	go queue.Run(context.Background())
+
+	smtpSrv := smtpserver.NewSMTPServer(deliverer)
+	go func() {
+		if err := smtpSrv.Start(config); err != nil {
+			slog.Error(err.Error())
+		}
+	}()
+
+	blobs, err := blobstore.New(config.Attachments)
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+
+	srv := httpserver.NewHTTPServer(repo, passwords, queue, blobs, config.Attachments, index)
 	if err := srv.Start(config); err != nil {
 		slog.Error(err.Error())
 	}
@@ -1,9 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"gopkg.in/yaml.v2"
 	"log/slog"
 	"os"
+	"time"
 )
 
 type Config struct {
@@ -12,6 +14,145 @@ type Config struct {
 		Port             string   `yaml:"port"`
 		AllowedIPsByCORS []string `yaml:"allowed_ips_by_cors"`
 	} `yaml:"httpserver"`
+	SMTPServer  SMTPServerConfig  `yaml:"smtpserver"`
+	Postgres    PostgresConfig    `yaml:"postgres"`
+	Password    PasswordConfig    `yaml:"password"`
+	Outbound    OutboundConfig    `yaml:"outbound"`
+	Attachments AttachmentsConfig `yaml:"attachments"`
+	Spam        SpamConfig        `yaml:"spam"`
+	Account     AccountConfig     `yaml:"account"`
+	WebAuthn    WebAuthnConfig    `yaml:"webauthn"`
+	Session     SessionConfig     `yaml:"session"`
+	// Admins - адреса пользователей, которым доступны /admin/... API,
+	// например управление ключами DKIM.
+	Admins []string `yaml:"admins"`
+}
+
+// MinSecretLength - минимальная длина account.secret в байтах.
+const MinSecretLength = 32
+
+// AccountConfig - подтверждение адреса при регистрации и сброс пароля.
+// Если VerifyEmail включён, новый аккаунт ограничен, пока пользователь
+// не перейдёт по ссылке из письма.
+type AccountConfig struct {
+	VerifyEmail bool          `yaml:"verify_email"`
+	Secret      string        `yaml:"secret"`     // ключ подписи ссылок, сессионных и CSRF-токенов
+	VerifyURL   string        `yaml:"verify_url"` // страница фронтенда, токен добавляется в конец
+	VerifyTTL   time.Duration `yaml:"verify_ttl"`
+	ResetURL    string        `yaml:"reset_url"`
+	ResetTTL    time.Duration `yaml:"reset_ttl"`
+}
+
+// WebAuthnConfig - вход по ключам доступа (passkeys). RPID - домен
+// сайта, к которому привязываются ключи; пустой RPID выключает WebAuthn.
+type WebAuthnConfig struct {
+	RPID    string   `yaml:"rp_id"`
+	RPName  string   `yaml:"rp_name"`
+	Origins []string `yaml:"origins"` // адреса фронтенда со схемой, например https://giga-mail.ru
+}
+
+// SessionConfig - сроки сессий и атрибуты куки. Сессия истекает через
+// IdleTimeout без запросов и в любом случае через TTL после входа. С
+// Secure кука отправляется только по HTTPS и получает префикс __Host-.
+type SessionConfig struct {
+	TTL         time.Duration `yaml:"ttl"`
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	Secure      bool          `yaml:"secure"`
+	SameSite    string        `yaml:"same_site"` // lax (по умолчанию), strict или none
+}
+
+// SpamConfig - проверка входящих писем на спам. Письма с оценкой не ниже
+// Threshold попадают в папку «Спам».
+type SpamConfig struct {
+	Threshold   float64  `yaml:"threshold"`
+	MinTraining int      `yaml:"min_training"` // писем каждого класса до включения байесовского фильтра
+	DNSBL       []string `yaml:"dnsbl"`        // зоны, например zen.spamhaus.org
+	Offline     bool     `yaml:"offline"`      // не ходить в DNS, списки считаются пустыми
+}
+
+// AttachmentsConfig - где хранить вложения и сколько их можно загрузить.
+type AttachmentsConfig struct {
+	Storage         string   `yaml:"storage"` // fs или s3
+	Dir             string   `yaml:"dir"`
+	S3              S3Config `yaml:"s3"`
+	MaxFileBytes    int64    `yaml:"max_file_bytes"`
+	MaxMessageBytes int64    `yaml:"max_message_bytes"` // суммарно на одно письмо
+}
+
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"` // host:port без схемы
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	UseSSL    bool   `yaml:"use_ssl"`
+}
+
+// SMTPServerConfig - встроенный SMTP-сервер для входящей почты. Письма
+// принимаются только на адреса из LocalDomains.
+type SMTPServerConfig struct {
+	IP              string        `yaml:"ip"`
+	Port            string        `yaml:"port"`
+	Hostname        string        `yaml:"hostname"` // для приветствия и заголовка Received
+	LocalDomains    []string      `yaml:"local_domains"`
+	MaxMessageBytes int64         `yaml:"max_message_bytes"`
+	MaxRecipients   int           `yaml:"max_recipients"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	// VerifyAuth включает проверку SPF, DKIM и DMARC входящих писем
+	// и заголовок Authentication-Results.
+	VerifyAuth bool `yaml:"verify_auth"`
+}
+
+// OutboundConfig - очередь исходящей почты и SMTP-релей, через который
+// она отправляется.
+type OutboundConfig struct {
+	Hostname       string        `yaml:"hostname"` // для HELO, Message-ID и адреса MAILER-DAEMON
+	RelayHost      string        `yaml:"relay_host"`
+	RelayPort      string        `yaml:"relay_port"`
+	Username       string        `yaml:"username"`
+	Password       string        `yaml:"password"`
+	StartTLS       bool          `yaml:"starttls"`
+	Workers        int           `yaml:"workers"`
+	PollInterval   time.Duration `yaml:"poll_interval"`
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+}
+
+// PasswordConfig задаёт алгоритм хэширования паролей и его стоимость.
+// При повышении параметров пароли перехэшируются при следующем входе.
+type PasswordConfig struct {
+	Algorithm string `yaml:"algorithm"` // argon2id или bcrypt
+	Argon2    struct {
+		Time    uint32 `yaml:"time"`
+		Memory  uint32 `yaml:"memory"` // в KiB
+		Threads uint8  `yaml:"threads"`
+	} `yaml:"argon2"`
+	BcryptCost int `yaml:"bcrypt_cost"`
+	// AllowPlaintext разрешает вход по паролям, сохранённым открытым
+	// текстом до появления хэширования. Включается только на время
+	// миграции: такие пароли перехэшируются при входе.
+	AllowPlaintext bool `yaml:"allow_plaintext"`
+}
+
+// PostgresConfig описывает подключение к базе. Если host пустой,
+// сервер работает на хранилищах в памяти.
+type PostgresConfig struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	DBName   string `yaml:"dbname"`
+	SSLMode  string `yaml:"sslmode"`
+}
+
+func (c PostgresConfig) DSN() string {
+	sslMode := c.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s", c.User, c.Password, c.Host, c.Port, c.DBName, sslMode)
 }
 
 func GetConfig(path string) (*Config, error) {
@@ -27,6 +168,21 @@ func GetConfig(path string) (*Config, error) {
 	if err = d.Decode(config); err != nil {
 		return nil, err
 	}
+	switch config.Session.SameSite {
+	case "", "lax", "strict":
+	case "none":
+		// браузеры не принимают SameSite=None без Secure
+		if !config.Session.Secure {
+			return nil, fmt.Errorf("session.same_site: none requires session.secure")
+		}
+	default:
+		return nil, fmt.Errorf("session.same_site: unknown value %q", config.Session.SameSite)
+	}
+	// без постоянного секрета ссылки и CSRF-токены перестают работать
+	// после перезапуска, а короткий секрет можно подобрать
+	if len(config.Account.Secret) < MinSecretLength {
+		return nil, fmt.Errorf("account.secret must be at least %d bytes", MinSecretLength)
+	}
 	slog.Info("loaded config")
 	return config, nil
 
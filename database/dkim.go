@@ -0,0 +1,15 @@
+package database
+
+import "time"
+
+// DKIMKey - ключ подписи DKIM домена. Подписывает письма только активный
+// ключ, остальные остаются опубликованными, пока не будут удалены, чтобы
+// письма, подписанные до ротации, ещё проходили проверку.
+type DKIMKey struct {
+	ID         int64
+	Domain     string
+	Selector   string
+	PrivateKey []byte // PEM
+	Active     bool
+	CreatedAt  time.Time
+}
@@ -0,0 +1,255 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Поля, которые проверяют условия правил фильтрации.
+const (
+	FilterFieldFrom    = "from"
+	FilterFieldTo      = "to"
+	FilterFieldSubject = "subject"
+	FilterFieldHeader  = "header"
+	FilterFieldSize    = "size"
+)
+
+// Операторы сравнения. Contains, Is и Matches работают со строками без
+// учёта регистра (i;ascii-casemap из RFC 5228), Over и Under - с размером.
+const (
+	FilterOpContains = "contains"
+	FilterOpIs       = "is"
+	FilterOpMatches  = "matches"
+	FilterOpOver     = "over"
+	FilterOpUnder    = "under"
+)
+
+// Действия правил. Stop прекращает обработку следующих правил.
+const (
+	FilterActionFileInto = "fileinto"
+	FilterActionLabel    = "label"
+	FilterActionFlag     = "flag"
+	FilterActionRedirect = "redirect"
+	FilterActionDiscard  = "discard"
+	FilterActionStop     = "stop"
+)
+
+// FilterCondition - условие правила. Header задаёт имя заголовка для
+// FilterFieldHeader, Not инвертирует результат.
+type FilterCondition struct {
+	Field  string
+	Header string
+	Op     string
+	Value  string
+	Not    bool
+}
+
+// FilterAction - действие правила. Используется только поле, нужное
+// для Type: FolderID для fileinto, LabelID для label, Flag для flag,
+// Address для redirect.
+type FilterAction struct {
+	Type     string
+	FolderID int64
+	LabelID  int64
+	Flag     string
+	Address  string
+}
+
+// FilterRule - правило, которое применяется к входящим письмам в порядке
+// Position. Правило срабатывает, если выполнены все условия (MatchAll)
+// или хотя бы одно. Правило без условий срабатывает всегда.
+type FilterRule struct {
+	ID         int64
+	Owner      string
+	Name       string
+	Position   int
+	Enabled    bool
+	MatchAll   bool
+	Conditions []FilterCondition
+	Actions    []FilterAction
+}
+
+func (r FilterRule) clone() FilterRule {
+	r.Conditions = append([]FilterCondition(nil), r.Conditions...)
+	r.Actions = append([]FilterAction(nil), r.Actions...)
+	return r
+}
+
+// FilterOutcome - итог применения правил к письму. FolderID == 0 - письмо
+// остаётся в папке по умолчанию. Discard без fileinto означает, что
+// письмо не нужно сохранять.
+type FilterOutcome struct {
+	Rules     []int64
+	FolderID  int64
+	Labels    []int64
+	Flags     []string
+	Redirects []string
+	Discard   bool
+}
+
+// Dropped сообщает, что письмо не нужно класть в ящик.
+func (o FilterOutcome) Dropped() bool {
+	return o.Discard && o.FolderID == 0
+}
+
+// RunFilters применяет включённые правила к письму по порядку, как
+// интерпретатор Sieve: fileinto переопределяет папку, метки, флаги
+// и адреса пересылки накапливаются.
+func RunFilters(rules []FilterRule, message Message) FilterOutcome {
+	var outcome FilterOutcome
+	for _, rule := range rules {
+		if !rule.Enabled || !rule.Match(message) {
+			continue
+		}
+		outcome.Rules = append(outcome.Rules, rule.ID)
+		for _, action := range rule.Actions {
+			switch action.Type {
+			case FilterActionFileInto:
+				outcome.FolderID = action.FolderID
+			case FilterActionLabel:
+				outcome.Labels = appendUniqueID(outcome.Labels, action.LabelID)
+			case FilterActionFlag:
+				outcome.Flags, _ = ApplyFlags(outcome.Flags, []string{action.Flag}, nil)
+			case FilterActionRedirect:
+				outcome.Redirects = appendUniqueAddress(outcome.Redirects, action.Address)
+			case FilterActionDiscard:
+				outcome.Discard = true
+			case FilterActionStop:
+				return outcome
+			}
+		}
+	}
+	return outcome
+}
+
+func appendUniqueID(ids []int64, id int64) []int64 {
+	for _, v := range ids {
+		if v == id {
+			return ids
+		}
+	}
+	return append(ids, id)
+}
+
+func appendUniqueAddress(addresses []string, address string) []string {
+	for _, v := range addresses {
+		if strings.EqualFold(v, address) {
+			return addresses
+		}
+	}
+	return append(addresses, address)
+}
+
+// Match проверяет условия правила без учёта Enabled.
+func (r FilterRule) Match(message Message) bool {
+	if len(r.Conditions) == 0 {
+		return true
+	}
+	for _, condition := range r.Conditions {
+		matched := condition.Match(message)
+		if matched && !r.MatchAll {
+			return true
+		}
+		if !matched && r.MatchAll {
+			return false
+		}
+	}
+	return r.MatchAll
+}
+
+// Match проверяет одно условие. Для адресов сравниваются и адрес,
+// и имя, to проверяет получателей из To и Cc.
+func (c FilterCondition) Match(message Message) bool {
+	var matched bool
+	switch c.Field {
+	case FilterFieldSize:
+		size := MessageSize(message)
+		limit, _ := strconv.ParseInt(c.Value, 10, 64)
+		matched = c.Op == FilterOpOver && size > limit || c.Op == FilterOpUnder && size < limit
+	case FilterFieldFrom:
+		matched = c.matchAddresses([]Address{message.From})
+	case FilterFieldTo:
+		matched = c.matchAddresses(append(append([]Address(nil), message.To...), message.Cc...))
+	case FilterFieldSubject:
+		matched = c.matchString(message.Subject)
+	case FilterFieldHeader:
+		for key, values := range message.Headers {
+			if !strings.EqualFold(key, c.Header) {
+				continue
+			}
+			for _, value := range values {
+				if c.matchString(value) {
+					matched = true
+				}
+			}
+		}
+	}
+	return matched != c.Not
+}
+
+func (c FilterCondition) matchAddresses(addresses []Address) bool {
+	for _, address := range addresses {
+		if c.matchString(address.Email) || address.Name != "" && c.matchString(address.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c FilterCondition) matchString(value string) bool {
+	value = strings.ToLower(value)
+	pattern := strings.ToLower(c.Value)
+	switch c.Op {
+	case FilterOpContains:
+		return strings.Contains(value, pattern)
+	case FilterOpIs:
+		return value == pattern
+	case FilterOpMatches:
+		return wildcardMatch(pattern, value)
+	}
+	return false
+}
+
+// wildcardMatch сравнивает строку с шаблоном :matches, где * - любая
+// последовательность символов, ? - один символ, \ экранирует следующий.
+func wildcardMatch(pattern string, value string) bool {
+	p, v := []rune(pattern), []rune(value)
+	star, mark := -1, 0
+	i, j := 0, 0
+	for j < len(v) {
+		switch {
+		case i < len(p) && p[i] == '*':
+			star, mark = i, j
+			i++
+		case i < len(p) && p[i] == '\\' && i+1 < len(p) && p[i+1] == v[j]:
+			i += 2
+			j++
+		case i < len(p) && p[i] != '\\' && (p[i] == '?' || p[i] == v[j]):
+			i++
+			j++
+		case star >= 0:
+			i = star + 1
+			mark++
+			j = mark
+		default:
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+	return i == len(p)
+}
+
+// MessageSize - размер письма для условия size: длина исходного текста,
+// а если его нет, сумма размеров текстов и вложений.
+func MessageSize(message Message) int64 {
+	if len(message.Raw) > 0 {
+		return int64(len(message.Raw))
+	}
+	size := int64(len(message.TextBody) + len(message.HTMLBody))
+	for _, a := range message.Attachments {
+		size += a.Size
+	}
+	return size
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWildcardMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, value string
+		want           bool
+	}{
+		{"*@example.com", "john@example.com", true},
+		{"*@example.com", "john@example.org", false},
+		{"j?hn*", "john smith", true},
+		{"report", "report", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{`\*sale\*`, "*sale*", true},
+		{`\*sale\*`, "big sale", false},
+		{"отч?т", "отчёт", true},
+	} {
+		if got := wildcardMatch(tc.pattern, tc.value); got != tc.want {
+			t.Errorf("wildcardMatch(%q, %q) = %v", tc.pattern, tc.value, got)
+		}
+	}
+}
+
+func TestRunFilters(t *testing.T) {
+	message := Message{
+		From:    Address{Name: "Mark Brown", Email: "mark@example.com"},
+		Cc:      []Address{{Email: "team@giga-mail.ru"}},
+		Subject: "Квартальный ОТЧЁТ",
+		Headers: map[string][]string{"List-Id": {"<news.example.com>"}},
+		Raw:     make([]byte, 2048),
+	}
+	rules := []FilterRule{
+		{
+			ID: 1, Enabled: true, MatchAll: true,
+			Conditions: []FilterCondition{
+				{Field: FilterFieldSubject, Op: FilterOpContains, Value: "отчёт"},
+				{Field: FilterFieldTo, Op: FilterOpIs, Value: "TEAM@giga-mail.ru"},
+			},
+			Actions: []FilterAction{
+				{Type: FilterActionFileInto, FolderID: 10},
+				{Type: FilterActionFlag, Flag: FlagFlagged},
+			},
+		},
+		{
+			ID: 2, Enabled: false,
+			Actions: []FilterAction{{Type: FilterActionDiscard}},
+		},
+		{
+			ID: 3, Enabled: true,
+			Conditions: []FilterCondition{
+				{Field: FilterFieldSize, Op: FilterOpUnder, Value: "1024"},
+				{Field: FilterFieldHeader, Header: "list-id", Op: FilterOpMatches, Value: "*news*"},
+			},
+			Actions: []FilterAction{
+				{Type: FilterActionLabel, LabelID: 7},
+				{Type: FilterActionRedirect, Address: "me@example.com"},
+				{Type: FilterActionStop},
+				{Type: FilterActionFileInto, FolderID: 20},
+			},
+		},
+		{
+			ID: 4, Enabled: true,
+			Actions: []FilterAction{{Type: FilterActionDiscard}},
+		},
+	}
+	got := RunFilters(rules, message)
+	want := FilterOutcome{
+		Rules:     []int64{1, 3},
+		FolderID:  10,
+		Labels:    []int64{7},
+		Flags:     []string{FlagFlagged},
+		Redirects: []string{"me@example.com"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v want %+v", got, want)
+	}
+
+	rules[0].Conditions[0].Not = true
+	rules[2].Conditions[1].Value = "other*"
+	got = RunFilters(rules, message)
+	if !reflect.DeepEqual(got.Rules, []int64{4}) || !got.Dropped() {
+		t.Errorf("unexpected outcome: %+v", got)
+	}
+}
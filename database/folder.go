@@ -0,0 +1,39 @@
+package database
+
+// Системные папки есть у каждого пользователя, их нельзя переименовать
+// или удалить. Остальные папки создаёт пользователь, в том числе вложенные.
+const (
+	FolderInbox  = "inbox"
+	FolderSent   = "sent"
+	FolderDrafts = "drafts"
+	FolderTrash  = "trash"
+	FolderSpam   = "spam"
+)
+
+var SystemFolders = []string{FolderInbox, FolderSent, FolderDrafts, FolderTrash, FolderSpam}
+
+// Folder - папка пользователя. ParentID == 0 у папок верхнего уровня,
+// System пустой у пользовательских папок. Total и Unread заполняются
+// только при чтении.
+type Folder struct {
+	ID       int64
+	Owner    string
+	ParentID int64
+	Name     string
+	System   string
+	Total    int
+	Unread   int
+}
+
+func (f Folder) IsSystem() bool {
+	return f.System != ""
+}
+
+func systemFolderOrder(system string) int {
+	for i, name := range SystemFolders {
+		if name == system {
+			return i
+		}
+	}
+	return len(SystemFolders)
+}
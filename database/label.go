@@ -0,0 +1,13 @@
+package database
+
+// Label - метка пользователя. В отличие от папки, у письма может быть
+// сколько угодно меток. Color - цвет в формате #rrggbb или пустая строка.
+// Total и Unread заполняются только при чтении.
+type Label struct {
+	ID     int64
+	Owner  string
+	Name   string
+	Color  string
+	Total  int
+	Unread int
+}
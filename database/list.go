@@ -0,0 +1,150 @@
+package database
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Поля, по которым можно сортировать список писем.
+const (
+	SortDate    = "date"
+	SortSender  = "from"
+	SortSubject = "subject"
+)
+
+// Cursor - позиция в списке писем: ключ сортировки последнего отданного
+// письма и его ID. В отличие от смещения он не сдвигается, когда
+// в папку приходят новые письма. Для SortDate используется Date,
+// для остальных полей - Text.
+type Cursor struct {
+	Date time.Time
+	Text string
+	ID   int64
+}
+
+// ListQuery задаёт выборку писем папки, а при FolderID == 0 - всех
+// папок. Пустые фильтры не применяются, Until не включается в интервал.
+type ListQuery struct {
+	FolderID       int64
+	LabelID        int64
+	Sort           string
+	Desc           bool
+	Unread         bool
+	Flagged        bool
+	HasAttachments bool
+	From           string
+	Since          time.Time
+	Until          time.Time
+	After          *Cursor
+	Limit          int
+}
+
+// MessagePage - страница писем. Total и Unread считаются по всем письмам,
+// подходящим под фильтры, Next пуст на последней странице.
+type MessagePage struct {
+	Messages []Message
+	Total    int
+	Unread   int
+	Next     *Cursor
+}
+
+// SenderKey - ключ сортировки по отправителю: имя, а если его нет, адрес.
+func SenderKey(from Address) string {
+	if from.Name != "" {
+		return strings.ToLower(from.Name)
+	}
+	return strings.ToLower(from.Email)
+}
+
+// CursorOf возвращает позицию письма в списке с сортировкой q.Sort.
+func (q ListQuery) CursorOf(message Message) Cursor {
+	switch q.Sort {
+	case SortSender:
+		return Cursor{Text: SenderKey(message.From), ID: message.ID}
+	case SortSubject:
+		base, _ := BaseSubject(message.Subject)
+		return Cursor{Text: base, ID: message.ID}
+	default:
+		return Cursor{Date: message.Date, ID: message.ID}
+	}
+}
+
+// Match сообщает, подходит ли письмо под фильтры запроса (без курсора).
+func (q ListQuery) Match(message Message) bool {
+	if q.FolderID != 0 && message.FolderID != q.FolderID || q.LabelID != 0 && !message.HasLabel(q.LabelID) {
+		return false
+	}
+	if q.Unread && message.HasFlag(FlagSeen) || q.Flagged && !message.HasFlag(FlagFlagged) {
+		return false
+	}
+	if q.HasAttachments && len(message.Attachments) == 0 {
+		return false
+	}
+	if q.From != "" {
+		from := strings.ToLower(q.From)
+		if !strings.Contains(strings.ToLower(message.From.Email), from) &&
+			!strings.Contains(strings.ToLower(message.From.Name), from) {
+			return false
+		}
+	}
+	if !q.Since.IsZero() && message.Date.Before(q.Since) {
+		return false
+	}
+	return q.Until.IsZero() || message.Date.Before(q.Until)
+}
+
+// compare сравнивает позиции a и b с учётом направления сортировки:
+// отрицательное значение - a идёт в списке раньше.
+func (q ListQuery) compare(a Cursor, b Cursor) int {
+	result := 0
+	switch {
+	case q.Sort == SortSender || q.Sort == SortSubject:
+		result = strings.Compare(a.Text, b.Text)
+	case a.Date.Before(b.Date):
+		result = -1
+	case a.Date.After(b.Date):
+		result = 1
+	}
+	if result == 0 {
+		switch {
+		case a.ID < b.ID:
+			result = -1
+		case a.ID > b.ID:
+			result = 1
+		}
+	}
+	if q.Desc {
+		return -result
+	}
+	return result
+}
+
+// Page отбирает из писем страницу по запросу. Нужна хранилищам, которые
+// фильтруют письма сами, а не в базе.
+func (q ListQuery) Page(messages []Message) MessagePage {
+	page := MessagePage{Messages: make([]Message, 0)}
+	matched := make([]Message, 0)
+	for _, message := range messages {
+		if !q.Match(message) {
+			continue
+		}
+		page.Total++
+		if !message.HasFlag(FlagSeen) {
+			page.Unread++
+		}
+		if q.After == nil || q.compare(q.CursorOf(message), *q.After) > 0 {
+			matched = append(matched, message)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return q.compare(q.CursorOf(matched[i]), q.CursorOf(matched[j])) < 0
+	})
+	if q.Limit > 0 && len(matched) > q.Limit {
+		matched = matched[:q.Limit]
+		next := q.CursorOf(matched[len(matched)-1])
+		page.Next = &next
+	}
+	page.Messages = matched
+	return page
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"regexp"
+	"time"
+)
+
+// Системные флаги в терминах IMAP, остальные значения считаются
+// пользовательскими ключевыми словами.
+const (
+	FlagSeen     = `\Seen`
+	FlagFlagged  = `\Flagged`
+	FlagAnswered = `\Answered`
+	FlagDraft    = `\Draft`
+	FlagDeleted  = `\Deleted`
+
+	// FlagImportant - ключевое слово для важных писем, как у почтовых
+	// клиентов, поддерживающих $Important.
+	FlagImportant = `$Important`
+)
+
+var SystemFlags = []string{FlagSeen, FlagFlagged, FlagAnswered, FlagDraft, FlagDeleted}
+
+// keyword - atom из RFC 3501 без символов, запрещённых во флагах.
+var keyword = regexp.MustCompile(`^[^\x00-\x20\x7f()\{%*"\\\]]{1,64}$`)
+
+// ValidFlag допускает системные флаги и ключевые слова IMAP.
+func ValidFlag(flag string) bool {
+	for _, system := range SystemFlags {
+		if flag == system {
+			return true
+		}
+	}
+	return keyword.MatchString(flag)
+}
+
+// ApplyFlags добавляет и снимает флаги, сохраняя порядок остальных.
+// changed сообщает, изменился ли набор флагов.
+func ApplyFlags(flags []string, add []string, remove []string) (result []string, changed bool) {
+	removed := make(map[string]bool, len(remove))
+	for _, flag := range remove {
+		removed[flag] = true
+	}
+	seen := make(map[string]bool, len(flags)+len(add))
+	result = make([]string, 0, len(flags)+len(add))
+	for _, flag := range append(append([]string(nil), flags...), add...) {
+		if removed[flag] || seen[flag] {
+			continue
+		}
+		seen[flag] = true
+		result = append(result, flag)
+	}
+	if len(result) != len(flags) {
+		return result, true
+	}
+	for i := range result {
+		if result[i] != flags[i] {
+			return result, true
+		}
+	}
+	return result, false
+}
+
+type Address struct {
+	Name  string
+	Email string
+}
+
+// AttachmentMeta описывает вложение письма. У загруженных через API
+// вложений StorageKey - ключ в хранилище вложений, у полученных писем он
+// пуст и содержимое достаётся из исходного текста письма по ID.
+type AttachmentMeta struct {
+	ID          int64
+	Filename    string
+	ContentType string
+	Size        int64
+	ContentID   string
+	StorageKey  string
+}
+
+// Message - письмо в ящике конкретного пользователя (Owner). Одно и то же
+// письмо у отправителя и получателя хранится двумя отдельными записями.
+// Raw - исходный текст письма, он сохраняется при Create, но при чтении
+// не заполняется: его отдаёт только MessageRepository.GetRaw.
+// Version растёт при каждом изменении письма и нужна для защиты от
+// одновременной записи. References - Message-ID предков письма из
+// References и In-Reply-To, ближайший родитель последним. Labels - ID
+// меток по возрастанию, Update их не меняет: для этого есть UpdateLabels.
+type Message struct {
+	ID          int64
+	Owner       string
+	ThreadID    int64
+	MessageID   string
+	References  []string
+	From        Address
+	To          []Address
+	Cc          []Address
+	Bcc         []Address
+	Subject     string
+	TextBody    string
+	HTMLBody    string
+	Headers     map[string][]string
+	Attachments []AttachmentMeta
+	Flags       []string
+	Labels      []int64
+	FolderID    int64
+	Date        time.Time
+	ReceivedAt  time.Time
+	UpdatedAt   time.Time
+	Version     int64
+	Raw         []byte
+}
+
+func (m Message) HasFlag(flag string) bool {
+	for _, f := range m.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func (m Message) HasLabel(id int64) bool {
+	for _, label := range m.Labels {
+		if label == id {
+			return true
+		}
+	}
+	return false
+}
+
+// clone копирует срезы и заголовки, чтобы хранилище в памяти не делило
+// их с вызывающим кодом.
+func (m Message) clone() Message {
+	m.To = append([]Address(nil), m.To...)
+	m.Cc = append([]Address(nil), m.Cc...)
+	m.Bcc = append([]Address(nil), m.Bcc...)
+	m.Attachments = append([]AttachmentMeta(nil), m.Attachments...)
+	m.Flags = append([]string(nil), m.Flags...)
+	m.References = append([]string(nil), m.References...)
+	m.Labels = append([]int64(nil), m.Labels...)
+	if m.Headers != nil {
+		headers := make(map[string][]string, len(m.Headers))
+		for k, v := range m.Headers {
+			headers[k] = append([]string(nil), v...)
+		}
+		m.Headers = headers
+	}
+	return m
+}
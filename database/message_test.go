@@ -0,0 +1,53 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValidFlag(t *testing.T) {
+	for _, flag := range []string{FlagSeen, FlagDeleted, FlagImportant, "work", "$Label1"} {
+		if !ValidFlag(flag) {
+			t.Errorf("%q should be valid", flag)
+		}
+	}
+	for _, flag := range []string{"", `\Recent`, `\Custom`, "two words", "a(b", "x]", `"q"`, strings.Repeat("a", 65)} {
+		if ValidFlag(flag) {
+			t.Errorf("%q should be invalid", flag)
+		}
+	}
+}
+
+func TestApplyFlags(t *testing.T) {
+	flags, changed := ApplyFlags([]string{FlagSeen, "work"}, []string{FlagFlagged, "work"}, []string{FlagSeen})
+	if !changed || strings.Join(flags, " ") != `work \Flagged` {
+		t.Errorf("got %q, %v", flags, changed)
+	}
+	if _, changed := ApplyFlags([]string{FlagSeen}, []string{FlagSeen}, []string{FlagFlagged}); changed {
+		t.Error("no-op update reported as changed")
+	}
+}
+
+func TestMessageStoreUpdateFlags(t *testing.T) {
+	ctx := context.Background()
+	store := NewMessageStore()
+	id, _ := store.Create(ctx, Message{Owner: "nick@giga-mail.ru", Flags: []string{FlagSeen}})
+	other, _ := store.Create(ctx, Message{Owner: "jane@giga-mail.ru"})
+
+	n, err := store.UpdateFlags(ctx, "nick@giga-mail.ru", []int64{id, other, 100500}, []string{FlagSeen}, nil)
+	if err != nil || n != 1 {
+		t.Fatalf("got %d, %v want 1", n, err)
+	}
+	if message, _ := store.GetByID(ctx, "nick@giga-mail.ru", id); message.Version != 1 {
+		t.Errorf("unchanged message got version %d", message.Version)
+	}
+	store.UpdateFlags(ctx, "nick@giga-mail.ru", []int64{id}, []string{FlagImportant}, []string{FlagSeen})
+	if message, _ := store.GetByID(ctx, "nick@giga-mail.ru", id); message.Version != 2 ||
+		message.HasFlag(FlagSeen) || !message.HasFlag(FlagImportant) {
+		t.Errorf("unexpected message: %+v", message)
+	}
+	if message, _ := store.GetByID(ctx, "jane@giga-mail.ru", other); len(message.Flags) != 0 {
+		t.Errorf("foreign message changed: %+v", message)
+	}
+}
@@ -0,0 +1,25 @@
+package database
+
+import "time"
+
+const (
+	OutboundPending = "pending"
+	OutboundSent    = "sent"
+	OutboundFailed  = "failed"
+)
+
+// OutboundMessage - запись очереди исходящей почты. Sender - локальный
+// пользователь, которому уходят уведомления о недоставке, Recipients -
+// адреса, доставка на которые ещё не завершена.
+type OutboundMessage struct {
+	ID            int64
+	Sender        string
+	From          string
+	Recipients    []string
+	Data          []byte
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	Status        string
+	CreatedAt     time.Time
+}
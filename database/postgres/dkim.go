@@ -0,0 +1,96 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mail/database"
+	"strings"
+)
+
+type DKIMRepository struct {
+	db *sql.DB
+}
+
+func NewDKIMRepository(db *sql.DB) *DKIMRepository {
+	return &DKIMRepository{db: db}
+}
+
+const dkimColumns = `id, domain, selector, private_key, active, created_at`
+
+func scanDKIMKey(row rowScanner) (database.DKIMKey, error) {
+	var key database.DKIMKey
+	err := row.Scan(&key.ID, &key.Domain, &key.Selector, &key.PrivateKey, &key.Active, &key.CreatedAt)
+	return key, err
+}
+
+func (r *DKIMRepository) List(ctx context.Context, domain string) ([]database.DKIMKey, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+dkimColumns+` FROM dkim_keys WHERE domain = $1 ORDER BY id DESC`, strings.ToLower(domain))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]database.DKIMKey, 0)
+	for rows.Next() {
+		key, err := scanDKIMKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (r *DKIMRepository) Active(ctx context.Context, domain string) (database.DKIMKey, error) {
+	key, err := scanDKIMKey(r.db.QueryRowContext(ctx,
+		`SELECT `+dkimColumns+` FROM dkim_keys WHERE domain = $1 AND active`, strings.ToLower(domain)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, database.ErrDKIMKeyNotFound
+	}
+	return key, err
+}
+
+func (r *DKIMRepository) Rotate(ctx context.Context, key database.DKIMKey) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	domain := strings.ToLower(key.Domain)
+	if _, err := tx.ExecContext(ctx, `UPDATE dkim_keys SET active = FALSE WHERE domain = $1 AND active`, domain); err != nil {
+		return 0, err
+	}
+	var id int64
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO dkim_keys (domain, selector, private_key, active, created_at)
+		VALUES ($1, $2, $3, TRUE, $4) RETURNING id`,
+		domain, key.Selector, key.PrivateKey, key.CreatedAt).Scan(&id)
+	if isUniqueViolation(err) {
+		return 0, database.ErrDKIMKeyExists
+	}
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func (r *DKIMRepository) Delete(ctx context.Context, domain string, id int64) error {
+	var active bool
+	err := r.db.QueryRowContext(ctx,
+		`DELETE FROM dkim_keys WHERE id = $1 AND domain = $2 AND NOT active RETURNING active`,
+		id, strings.ToLower(domain)).Scan(&active)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	err = r.db.QueryRowContext(ctx,
+		`SELECT active FROM dkim_keys WHERE id = $1 AND domain = $2`, id, strings.ToLower(domain)).Scan(&active)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.ErrDKIMKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return database.ErrDKIMKeyActive
+}
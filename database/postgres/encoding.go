@@ -0,0 +1,41 @@
+package postgres
+
+import (
+	"database/sql"
+	"mail/database"
+	"time"
+)
+
+// Пустые срезы и карты сохраняются как [] и {}, а не null.
+
+func nonNilAddresses(v []database.Address) []database.Address {
+	if v == nil {
+		return []database.Address{}
+	}
+	return v
+}
+
+func nonNilAttachments(v []database.AttachmentMeta) []database.AttachmentMeta {
+	if v == nil {
+		return []database.AttachmentMeta{}
+	}
+	return v
+}
+
+func nonNilStrings(v []string) []string {
+	if v == nil {
+		return []string{}
+	}
+	return v
+}
+
+func nonNilHeaders(v map[string][]string) map[string][]string {
+	if v == nil {
+		return map[string][]string{}
+	}
+	return v
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,166 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"mail/database"
+)
+
+const filterColumns = `id, owner, name, position, enabled, match_all, conditions, actions`
+
+type FilterRepository struct {
+	db *sql.DB
+}
+
+func NewFilterRepository(db *sql.DB) *FilterRepository {
+	return &FilterRepository{db: db}
+}
+
+func scanFilter(scanner rowScanner) (database.FilterRule, error) {
+	var rule database.FilterRule
+	var conditions, actions []byte
+	err := scanner.Scan(&rule.ID, &rule.Owner, &rule.Name, &rule.Position, &rule.Enabled,
+		&rule.MatchAll, &conditions, &actions)
+	if err != nil {
+		return rule, err
+	}
+	if err := json.Unmarshal(conditions, &rule.Conditions); err != nil {
+		return rule, err
+	}
+	return rule, json.Unmarshal(actions, &rule.Actions)
+}
+
+func encodeFilter(rule database.FilterRule) (conditions []byte, actions []byte, err error) {
+	if rule.Conditions == nil {
+		rule.Conditions = []database.FilterCondition{}
+	}
+	if rule.Actions == nil {
+		rule.Actions = []database.FilterAction{}
+	}
+	if conditions, err = json.Marshal(rule.Conditions); err != nil {
+		return nil, nil, err
+	}
+	actions, err = json.Marshal(rule.Actions)
+	return conditions, actions, err
+}
+
+func (r *FilterRepository) List(ctx context.Context, owner string) ([]database.FilterRule, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+filterColumns+` FROM filter_rules WHERE owner = $1 ORDER BY position, id`, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]database.FilterRule, 0)
+	for rows.Next() {
+		rule, err := scanFilter(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, rule)
+	}
+	return result, rows.Err()
+}
+
+func (r *FilterRepository) GetByID(ctx context.Context, owner string, id int64) (database.FilterRule, error) {
+	rule, err := scanFilter(r.db.QueryRowContext(ctx,
+		`SELECT `+filterColumns+` FROM filter_rules WHERE owner = $1 AND id = $2`, owner, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.FilterRule{}, database.ErrFilterNotFound
+	}
+	return rule, err
+}
+
+// queryRower - общее у *sql.DB и *sql.Tx, чтобы Replace создавал правила
+// в своей транзакции.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func createFilter(ctx context.Context, q queryRower, rule database.FilterRule) (int64, error) {
+	conditions, actions, err := encodeFilter(rule)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = q.QueryRowContext(ctx,
+		`INSERT INTO filter_rules (owner, name, position, enabled, match_all, conditions, actions)
+		SELECT $1, $2, COALESCE(MAX(position) + 1, 0), $3, $4, $5, $6
+		FROM filter_rules WHERE owner = $1
+		RETURNING id`,
+		rule.Owner, rule.Name, rule.Enabled, rule.MatchAll, conditions, actions).Scan(&id)
+	return id, err
+}
+
+func (r *FilterRepository) Create(ctx context.Context, rule database.FilterRule) (int64, error) {
+	return createFilter(ctx, r.db, rule)
+}
+
+func (r *FilterRepository) Update(ctx context.Context, rule database.FilterRule) error {
+	conditions, actions, err := encodeFilter(rule)
+	if err != nil {
+		return err
+	}
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE filter_rules SET name = $3, enabled = $4, match_all = $5, conditions = $6, actions = $7
+		WHERE owner = $1 AND id = $2`,
+		rule.Owner, rule.ID, rule.Name, rule.Enabled, rule.MatchAll, conditions, actions)
+	if err != nil {
+		return err
+	}
+	return expectRows(res, database.ErrFilterNotFound)
+}
+
+func (r *FilterRepository) Delete(ctx context.Context, owner string, id int64) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM filter_rules WHERE owner = $1 AND id = $2`, owner, id)
+	if err != nil {
+		return err
+	}
+	return expectRows(res, database.ErrFilterNotFound)
+}
+
+func (r *FilterRepository) Reorder(ctx context.Context, owner string, ids []int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE filter_rules f SET position = o.position - 1
+		FROM unnest($2::BIGINT[]) WITH ORDINALITY AS o(id, position)
+		WHERE f.owner = $1 AND f.id = o.id`, owner, ids)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != int64(len(ids)) {
+		return database.ErrFilterNotFound
+	}
+	return tx.Commit()
+}
+
+func (r *FilterRepository) Replace(ctx context.Context, owner string, rules []database.FilterRule) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM filter_rules WHERE owner = $1`, owner); err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		rule.Owner = owner
+		if _, err := createFilter(ctx, tx, rule); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
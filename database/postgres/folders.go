@@ -0,0 +1,169 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mail/database"
+)
+
+const folderSelect = `SELECT f.id, f.owner, COALESCE(f.parent_id, 0), f.name, f.system,
+	COUNT(m.id), COUNT(m.id) FILTER (WHERE NOT m.flags ? $2)
+	FROM folders f LEFT JOIN messages m ON m.folder_id = f.id
+	WHERE f.owner = $1`
+
+const folderOrder = ` GROUP BY f.id
+	ORDER BY array_position(ARRAY['inbox', 'sent', 'drafts', 'trash', 'spam'], NULLIF(f.system, '')) NULLS LAST,
+		f.name, f.id`
+
+type FolderRepository struct {
+	db *sql.DB
+}
+
+func NewFolderRepository(db *sql.DB) *FolderRepository {
+	return &FolderRepository{db: db}
+}
+
+func (r *FolderRepository) ensureSystemFolders(ctx context.Context, owner string) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO folders (owner, name, system)
+		SELECT $1, s.name, s.name FROM unnest($2::text[]) AS s (name)
+		ON CONFLICT DO NOTHING`, owner, database.SystemFolders)
+	return err
+}
+
+func scanFolder(scanner rowScanner) (database.Folder, error) {
+	var folder database.Folder
+	err := scanner.Scan(&folder.ID, &folder.Owner, &folder.ParentID, &folder.Name,
+		&folder.System, &folder.Total, &folder.Unread)
+	return folder, err
+}
+
+func (r *FolderRepository) List(ctx context.Context, owner string) ([]database.Folder, error) {
+	if err := r.ensureSystemFolders(ctx, owner); err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, folderSelect+folderOrder, owner, database.FlagSeen)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]database.Folder, 0)
+	for rows.Next() {
+		folder, err := scanFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, folder)
+	}
+	return result, rows.Err()
+}
+
+func (r *FolderRepository) GetByID(ctx context.Context, owner string, id int64) (database.Folder, error) {
+	folder, err := scanFolder(r.db.QueryRowContext(ctx,
+		folderSelect+` AND f.id = $3`+folderOrder, owner, database.FlagSeen, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.Folder{}, database.ErrFolderNotFound
+	}
+	return folder, err
+}
+
+func (r *FolderRepository) GetSystem(ctx context.Context, owner string, system string) (database.Folder, error) {
+	if err := r.ensureSystemFolders(ctx, owner); err != nil {
+		return database.Folder{}, err
+	}
+	folder, err := scanFolder(r.db.QueryRowContext(ctx,
+		folderSelect+` AND f.system = $3`+folderOrder, owner, database.FlagSeen, system))
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.Folder{}, database.ErrFolderNotFound
+	}
+	return folder, err
+}
+
+func (r *FolderRepository) Create(ctx context.Context, folder database.Folder) (int64, error) {
+	if err := r.ensureSystemFolders(ctx, folder.Owner); err != nil {
+		return 0, err
+	}
+	parentID := sql.NullInt64{Int64: folder.ParentID, Valid: folder.ParentID != 0}
+	if parentID.Valid {
+		var exists bool
+		err := r.db.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM folders WHERE owner = $1 AND id = $2)`,
+			folder.Owner, folder.ParentID).Scan(&exists)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			return 0, database.ErrFolderNotFound
+		}
+	}
+
+	var id int64
+	err := r.db.QueryRowContext(ctx,
+		`INSERT INTO folders (owner, parent_id, name) VALUES ($1, $2, $3) RETURNING id`,
+		folder.Owner, parentID, folder.Name).Scan(&id)
+	if isUniqueViolation(err) {
+		return 0, database.ErrFolderExists
+	}
+	return id, err
+}
+
+func (r *FolderRepository) Rename(ctx context.Context, owner string, id int64, name string) error {
+	folder, err := r.GetByID(ctx, owner, id)
+	if err != nil {
+		return err
+	}
+	if folder.IsSystem() {
+		return database.ErrSystemFolder
+	}
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE folders SET name = $3 WHERE owner = $1 AND id = $2`, owner, id, name)
+	if isUniqueViolation(err) {
+		return database.ErrFolderExists
+	}
+	if err != nil {
+		return err
+	}
+	return expectRows(res, database.ErrFolderNotFound)
+}
+
+func (r *FolderRepository) Delete(ctx context.Context, owner string, id int64) error {
+	folder, err := r.GetByID(ctx, owner, id)
+	if err != nil {
+		return err
+	}
+	if folder.IsSystem() {
+		return database.ErrSystemFolder
+	}
+	trash, err := r.GetSystem(ctx, owner, database.FolderTrash)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`WITH RECURSIVE subtree AS (
+			SELECT id FROM folders WHERE owner = $1 AND id = $2
+			UNION ALL
+			SELECT f.id FROM folders f JOIN subtree s ON f.parent_id = s.id
+		)
+		UPDATE messages SET folder_id = $3, version = version + 1, updated_at = now()
+		WHERE owner = $1 AND folder_id IN (SELECT id FROM subtree)`, owner, id, trash.ID)
+	if err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM folders WHERE owner = $1 AND id = $2`, owner, id)
+	if err != nil {
+		return err
+	}
+	if err := expectRows(res, database.ErrFolderNotFound); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,90 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mail/database"
+)
+
+const labelSelect = `SELECT l.id, l.owner, l.name, l.color,
+	COUNT(m.id), COUNT(m.id) FILTER (WHERE NOT m.flags ? $2)
+	FROM labels l
+	LEFT JOIN message_labels ml ON ml.label_id = l.id
+	LEFT JOIN messages m ON m.id = ml.message_id
+	WHERE l.owner = $1`
+
+const labelOrder = ` GROUP BY l.id ORDER BY l.name, l.id`
+
+type LabelRepository struct {
+	db *sql.DB
+}
+
+func NewLabelRepository(db *sql.DB) *LabelRepository {
+	return &LabelRepository{db: db}
+}
+
+func scanLabel(scanner rowScanner) (database.Label, error) {
+	var label database.Label
+	err := scanner.Scan(&label.ID, &label.Owner, &label.Name, &label.Color, &label.Total, &label.Unread)
+	return label, err
+}
+
+func (r *LabelRepository) List(ctx context.Context, owner string) ([]database.Label, error) {
+	rows, err := r.db.QueryContext(ctx, labelSelect+labelOrder, owner, database.FlagSeen)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]database.Label, 0)
+	for rows.Next() {
+		label, err := scanLabel(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, label)
+	}
+	return result, rows.Err()
+}
+
+func (r *LabelRepository) GetByID(ctx context.Context, owner string, id int64) (database.Label, error) {
+	label, err := scanLabel(r.db.QueryRowContext(ctx,
+		labelSelect+` AND l.id = $3`+labelOrder, owner, database.FlagSeen, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.Label{}, database.ErrLabelNotFound
+	}
+	return label, err
+}
+
+func (r *LabelRepository) Create(ctx context.Context, label database.Label) (int64, error) {
+	var id int64
+	err := r.db.QueryRowContext(ctx,
+		`INSERT INTO labels (owner, name, color) VALUES ($1, $2, $3) RETURNING id`,
+		label.Owner, label.Name, label.Color).Scan(&id)
+	if isUniqueViolation(err) {
+		return 0, database.ErrLabelExists
+	}
+	return id, err
+}
+
+func (r *LabelRepository) Update(ctx context.Context, label database.Label) error {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE labels SET name = $3, color = $4 WHERE owner = $1 AND id = $2`,
+		label.Owner, label.ID, label.Name, label.Color)
+	if isUniqueViolation(err) {
+		return database.ErrLabelExists
+	}
+	if err != nil {
+		return err
+	}
+	return expectRows(res, database.ErrLabelNotFound)
+}
+
+func (r *LabelRepository) Delete(ctx context.Context, owner string, id int64) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM labels WHERE owner = $1 AND id = $2`, owner, id)
+	if err != nil {
+		return err
+	}
+	return expectRows(res, database.ErrLabelNotFound)
+}
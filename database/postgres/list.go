@@ -0,0 +1,140 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"mail/database"
+	"strconv"
+	"strings"
+)
+
+// sortKeys - выражения для ключей сортировки, совпадающие с
+// database.ListQuery.CursorOf. Для даты ключ - сама колонка date.
+var sortKeys = map[string]string{
+	database.SortSender:  `lower(CASE WHEN from_name <> '' THEN from_name ELSE from_email END)`,
+	database.SortSubject: `base_subject`,
+}
+
+// listFilter собирает условие WHERE и его аргументы.
+type listFilter struct {
+	conditions []string
+	args       []interface{}
+}
+
+func (f *listFilter) add(condition string, args ...interface{}) {
+	for _, arg := range args {
+		f.args = append(f.args, arg)
+		condition = strings.Replace(condition, "?", "$"+strconv.Itoa(len(f.args)), 1)
+	}
+	f.conditions = append(f.conditions, condition)
+}
+
+func (f *listFilter) where() string {
+	return strings.Join(f.conditions, " AND ")
+}
+
+// likePattern экранирует спецсимволы LIKE в подстроке поиска.
+func likePattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+	return "%" + s + "%"
+}
+
+// keyScanner дочитывает ключ сортировки после колонок письма.
+type keyScanner struct {
+	rows *sql.Rows
+	key  *string
+}
+
+func (s keyScanner) Scan(dest ...interface{}) error {
+	return s.rows.Scan(append(dest, s.key)...)
+}
+
+func (r *MessageRepository) List(ctx context.Context, owner string, query database.ListQuery) (database.MessagePage, error) {
+	var f listFilter
+	// ? в jsonb-операторах заменился бы на параметр, поэтому флаги
+	// проверяются через jsonb_exists
+	f.add(`owner = ?`, owner)
+	if query.FolderID != 0 {
+		f.add(`folder_id = ?`, query.FolderID)
+	}
+	if query.LabelID != 0 {
+		f.add(`EXISTS (SELECT 1 FROM message_labels ml WHERE ml.message_id = messages.id AND ml.label_id = ?)`, query.LabelID)
+	}
+	if query.Unread {
+		f.add(`NOT jsonb_exists(flags, ?)`, database.FlagSeen)
+	}
+	if query.Flagged {
+		f.add(`jsonb_exists(flags, ?)`, database.FlagFlagged)
+	}
+	if query.HasAttachments {
+		f.add(`jsonb_array_length(attachments) > 0`)
+	}
+	if query.From != "" {
+		pattern := likePattern(query.From)
+		f.add(`(from_email ILIKE ? OR from_name ILIKE ?)`, pattern, pattern)
+	}
+	if !query.Since.IsZero() {
+		f.add(`date >= ?`, query.Since)
+	}
+	if !query.Until.IsZero() {
+		f.add(`date < ?`, query.Until)
+	}
+
+	var page database.MessagePage
+	err := r.db.QueryRowContext(ctx,
+		`SELECT count(*), count(*) FILTER (WHERE NOT jsonb_exists(flags, $`+strconv.Itoa(len(f.args)+1)+`))
+		FROM messages WHERE `+f.where(), append(f.args, database.FlagSeen)...).
+		Scan(&page.Total, &page.Unread)
+	if err != nil {
+		return page, err
+	}
+
+	key, textKey := "date", "''"
+	if expr, ok := sortKeys[query.Sort]; ok {
+		key, textKey = expr, expr
+	}
+	direction, compare := "ASC", ">"
+	if query.Desc {
+		direction, compare = "DESC", "<"
+	}
+	if query.After != nil {
+		if query.Sort == database.SortSender || query.Sort == database.SortSubject {
+			f.add(`(`+key+`, id) `+compare+` (?, ?)`, query.After.Text, query.After.ID)
+		} else {
+			f.add(`(date, id) `+compare+` (?, ?)`, query.After.Date, query.After.ID)
+		}
+	}
+	sqlQuery := `SELECT ` + messageColumns + `, ` + textKey + ` FROM messages WHERE ` + f.where() +
+		` ORDER BY ` + key + ` ` + direction + `, id ` + direction
+	if query.Limit > 0 {
+		// лишняя строка показывает, есть ли следующая страница
+		f.args = append(f.args, query.Limit+1)
+		sqlQuery += ` LIMIT $` + strconv.Itoa(len(f.args))
+	}
+	rows, err := r.db.QueryContext(ctx, sqlQuery, f.args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	page.Messages = make([]database.Message, 0)
+	var keys []string
+	for rows.Next() {
+		var text string
+		message, err := scanMessage(keyScanner{rows: rows, key: &text})
+		if err != nil {
+			return page, err
+		}
+		page.Messages = append(page.Messages, message)
+		keys = append(keys, text)
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+	if query.Limit > 0 && len(page.Messages) > query.Limit {
+		page.Messages = page.Messages[:query.Limit]
+		last := page.Messages[query.Limit-1]
+		page.Next = &database.Cursor{Date: last.Date, Text: keys[query.Limit-1], ID: last.ID}
+	}
+	return page, nil
+}
@@ -0,0 +1,376 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"mail/database"
+	"time"
+)
+
+const messageColumns = `id, owner, thread_id, message_id, from_name, from_email,
+	to_addrs, cc_addrs, bcc_addrs, subject, text_body, html_body, headers,
+	attachments, flags, folder_id, date, received_at, updated_at, version, refs,
+	(SELECT COALESCE(jsonb_agg(label_id ORDER BY label_id), '[]') FROM message_labels
+		WHERE message_id = messages.id)`
+
+type MessageRepository struct {
+	db *sql.DB
+}
+
+func NewMessageRepository(db *sql.DB) *MessageRepository {
+	return &MessageRepository{db: db}
+}
+
+// messageRow - письмо с полями JSONB в сыром виде.
+type messageRow struct {
+	to, cc, bcc, headers, attachments, flags, refs, labels []byte
+}
+
+func encodeMessage(message database.Message) (messageRow, error) {
+	var row messageRow
+	var err error
+	for _, field := range []struct {
+		dst *[]byte
+		src interface{}
+	}{
+		{&row.to, nonNilAddresses(message.To)},
+		{&row.cc, nonNilAddresses(message.Cc)},
+		{&row.bcc, nonNilAddresses(message.Bcc)},
+		{&row.headers, nonNilHeaders(message.Headers)},
+		{&row.attachments, nonNilAttachments(message.Attachments)},
+		{&row.flags, nonNilStrings(message.Flags)},
+		{&row.refs, nonNilStrings(message.References)},
+	} {
+		if *field.dst, err = json.Marshal(field.src); err != nil {
+			return row, err
+		}
+	}
+	return row, nil
+}
+
+func (row messageRow) decode(message *database.Message) error {
+	for _, field := range []struct {
+		src []byte
+		dst interface{}
+	}{
+		{row.to, &message.To},
+		{row.cc, &message.Cc},
+		{row.bcc, &message.Bcc},
+		{row.headers, &message.Headers},
+		{row.attachments, &message.Attachments},
+		{row.flags, &message.Flags},
+		{row.refs, &message.References},
+		{row.labels, &message.Labels},
+	} {
+		if err := json.Unmarshal(field.src, field.dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMessage(scanner rowScanner) (database.Message, error) {
+	var message database.Message
+	var row messageRow
+	err := scanner.Scan(&message.ID, &message.Owner, &message.ThreadID, &message.MessageID,
+		&message.From.Name, &message.From.Email, &row.to, &row.cc, &row.bcc,
+		&message.Subject, &message.TextBody, &message.HTMLBody, &row.headers,
+		&row.attachments, &row.flags, &message.FolderID, &message.Date,
+		&message.ReceivedAt, &message.UpdatedAt, &message.Version, &row.refs, &row.labels)
+	if err != nil {
+		return message, err
+	}
+	return message, row.decode(&message)
+}
+
+func scanMessages(rows *sql.Rows) ([]database.Message, error) {
+	defer rows.Close()
+	result := make([]database.Message, 0)
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, message)
+	}
+	return result, rows.Err()
+}
+
+func (r *MessageRepository) Create(ctx context.Context, message database.Message) (int64, error) {
+	row, err := encodeMessage(message)
+	if err != nil {
+		return 0, err
+	}
+	base, _ := database.BaseSubject(message.Subject)
+	var id int64
+	// id берётся заранее, чтобы новое письмо без цепочки начинало свою
+	err = r.db.QueryRowContext(ctx,
+		`WITH next AS (SELECT nextval(pg_get_serial_sequence('messages', 'id')) AS id),
+		inserted AS (
+			INSERT INTO messages (id, owner, thread_id, message_id, from_name, from_email,
+				to_addrs, cc_addrs, bcc_addrs, subject, text_body, html_body, headers,
+				attachments, flags, folder_id, date, raw, refs, base_subject)
+			SELECT next.id, $1, COALESCE(NULLIF($2::BIGINT, 0), next.id), $3, $4, $5, $6, $7, $8,
+				$9, $10, $11, $12, $13, $14, $15, COALESCE($16, now()), $17, $18, $19
+			FROM next
+			RETURNING id
+		),
+		labeled AS (
+			INSERT INTO message_labels (message_id, label_id)
+			SELECT inserted.id, l.id FROM inserted, labels l WHERE l.owner = $1 AND l.id = ANY($20)
+		)
+		SELECT id FROM inserted`,
+		message.Owner, message.ThreadID, message.MessageID, message.From.Name, message.From.Email,
+		row.to, row.cc, row.bcc, message.Subject, message.TextBody, message.HTMLBody, row.headers,
+		row.attachments, row.flags, message.FolderID, nullTime(message.Date), message.Raw,
+		row.refs, base, message.Labels).
+		Scan(&id)
+	return id, err
+}
+
+func (r *MessageRepository) GetByID(ctx context.Context, owner string, id int64) (database.Message, error) {
+	message, err := scanMessage(r.db.QueryRowContext(ctx,
+		`SELECT `+messageColumns+` FROM messages WHERE owner = $1 AND id = $2`, owner, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.Message{}, database.ErrMessageNotFound
+	}
+	return message, err
+}
+
+func (r *MessageRepository) GetRaw(ctx context.Context, owner string, id int64) ([]byte, error) {
+	var raw []byte
+	err := r.db.QueryRowContext(ctx,
+		`SELECT raw FROM messages WHERE owner = $1 AND id = $2`, owner, id).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, database.ErrMessageNotFound
+	}
+	return raw, err
+}
+
+func (r *MessageRepository) ListByFolder(ctx context.Context, owner string, folderID int64) ([]database.Message, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+messageColumns+` FROM messages
+		WHERE owner = $1 AND folder_id = $2 ORDER BY date DESC, id DESC`, owner, folderID)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+func (r *MessageRepository) Update(ctx context.Context, message database.Message) error {
+	row, err := encodeMessage(message)
+	if err != nil {
+		return err
+	}
+	base, _ := database.BaseSubject(message.Subject)
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE messages SET thread_id = $3, message_id = $4, from_name = $5, from_email = $6,
+			to_addrs = $7, cc_addrs = $8, bcc_addrs = $9, subject = $10, text_body = $11,
+			html_body = $12, headers = $13, attachments = $14, flags = $15, folder_id = $16,
+			date = $17, raw = COALESCE($18, raw), refs = $20, base_subject = $21,
+			version = version + 1, updated_at = now()
+		WHERE owner = $1 AND id = $2 AND version = $19`,
+		message.Owner, message.ID, message.ThreadID, message.MessageID, message.From.Name, message.From.Email,
+		row.to, row.cc, row.bcc, message.Subject, message.TextBody, message.HTMLBody, row.headers,
+		row.attachments, row.flags, message.FolderID, message.Date, message.Raw, message.Version,
+		row.refs, base)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+	// ничего не обновилось: либо письма нет, либо его уже изменили
+	var exists bool
+	err = r.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM messages WHERE owner = $1 AND id = $2)`, message.Owner, message.ID).
+		Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return database.ErrVersionConflict
+	}
+	return database.ErrMessageNotFound
+}
+
+func (r *MessageRepository) Delete(ctx context.Context, owner string, id int64) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM messages WHERE owner = $1 AND id = $2`, owner, id)
+	if err != nil {
+		return err
+	}
+	return expectRows(res, database.ErrMessageNotFound)
+}
+
+func (r *MessageRepository) Move(ctx context.Context, owner string, ids []int64, folderID int64) (int, error) {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE messages SET folder_id = $3, version = version + 1, updated_at = now()
+		WHERE owner = $1 AND id = ANY($2)`, owner, ids, folderID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (r *MessageRepository) ListByThread(ctx context.Context, owner string, threadID int64) ([]database.Message, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+messageColumns+` FROM messages
+		WHERE owner = $1 AND thread_id = $2 ORDER BY date, id`, owner, threadID)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+func (r *MessageRepository) ListThreadsByFolder(ctx context.Context, owner string, folderID int64) ([]database.Message, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+messageColumns+` FROM messages
+		WHERE owner = $1 AND thread_id IN (
+			SELECT thread_id FROM messages WHERE owner = $1 AND folder_id = $2)
+		ORDER BY date DESC, id DESC`, owner, folderID)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+func (r *MessageRepository) ListByMessageIDs(ctx context.Context, owner string, messageIDs []string) ([]database.Message, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+messageColumns+` FROM messages
+		WHERE owner = $1 AND message_id <> '' AND message_id = ANY($2)
+		ORDER BY date DESC, id DESC`, owner, messageIDs)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+func (r *MessageRepository) ListReferencing(ctx context.Context, owner string, messageID string) ([]database.Message, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+messageColumns+` FROM messages
+		WHERE owner = $1 AND refs ? $2 ORDER BY date DESC, id DESC`, owner, messageID)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+func (r *MessageRepository) FindThreadBySubject(ctx context.Context, owner string, baseSubject string, since time.Time) (int64, error) {
+	var threadID int64
+	err := r.db.QueryRowContext(ctx,
+		`SELECT thread_id FROM messages
+		WHERE owner = $1 AND base_subject = $2 AND date >= $3
+		ORDER BY date DESC, id DESC LIMIT 1`, owner, baseSubject, since).Scan(&threadID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return threadID, err
+}
+
+func (r *MessageRepository) MergeThreads(ctx context.Context, owner string, from []int64, to int64) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE messages SET thread_id = $3 WHERE owner = $1 AND thread_id = ANY($2)`, owner, from, to)
+	return err
+}
+
+// UpdateFlags блокирует письма на время изменения, чтобы параллельные
+// запросы не теряли флаги друг друга.
+func (r *MessageRepository) UpdateFlags(ctx context.Context, owner string, ids []int64, add []string, remove []string) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, flags FROM messages WHERE owner = $1 AND id = ANY($2) FOR UPDATE`, owner, ids)
+	if err != nil {
+		return 0, err
+	}
+	found := 0
+	var changedIDs []int64
+	var changedFlags []string
+	for rows.Next() {
+		var id int64
+		var raw []byte
+		var flags []string
+		if err := rows.Scan(&id, &raw); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if err := json.Unmarshal(raw, &flags); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		found++
+		flags, changed := database.ApplyFlags(flags, add, remove)
+		if !changed {
+			continue
+		}
+		encoded, err := json.Marshal(flags)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		changedIDs = append(changedIDs, id)
+		changedFlags = append(changedFlags, string(encoded))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(changedIDs) > 0 {
+		_, err = tx.ExecContext(ctx,
+			`UPDATE messages m SET flags = v.flags::JSONB, version = m.version + 1, updated_at = now()
+			FROM unnest($2::BIGINT[], $3::TEXT[]) AS v (id, flags)
+			WHERE m.owner = $1 AND m.id = v.id`, owner, changedIDs, changedFlags)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return found, tx.Commit()
+}
+
+func (r *MessageRepository) UpdateLabels(ctx context.Context, owner string, ids []int64, add []int64, remove []int64) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var found int
+	err = tx.QueryRowContext(ctx,
+		`SELECT count(*) FROM messages WHERE owner = $1 AND id = ANY($2)`, owner, ids).Scan(&found)
+	if err != nil {
+		return 0, err
+	}
+	if len(add) > 0 {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO message_labels (message_id, label_id)
+			SELECT m.id, l.id FROM messages m, labels l
+			WHERE m.owner = $1 AND m.id = ANY($2) AND l.owner = $1 AND l.id = ANY($3)
+			ON CONFLICT DO NOTHING`, owner, ids, add)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(remove) > 0 {
+		_, err = tx.ExecContext(ctx,
+			`DELETE FROM message_labels ml USING messages m
+			WHERE ml.message_id = m.id AND m.owner = $1 AND m.id = ANY($2) AND ml.label_id = ANY($3)`,
+			owner, ids, remove)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return found, tx.Commit()
+}
@@ -0,0 +1,40 @@
+package postgres
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrationsOrder(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0002_mails.up.sql":   {Data: []byte("CREATE TABLE b ();")},
+		"migrations/0002_mails.down.sql": {Data: []byte("DROP TABLE b;")},
+		"migrations/0001_init.up.sql":    {Data: []byte("CREATE TABLE a ();")},
+		"migrations/0001_init.down.sql":  {Data: []byte("DROP TABLE a;")},
+	}
+	migrations, err := loadMigrations(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("got %d migrations want 2", len(migrations))
+	}
+	if migrations[0].version != 1 || migrations[0].name != "init" || migrations[1].version != 2 {
+		t.Errorf("unexpected migrations order: %+v", migrations)
+	}
+}
+
+func TestLoadMigrationsMissingDown(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0001_init.up.sql": {Data: []byte("CREATE TABLE a ();")},
+	}
+	if _, err := loadMigrations(fsys); err == nil {
+		t.Error("expected error for migration without down file")
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	if _, err := loadMigrations(migrationsFS); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,25 @@
+CREATE TABLE users (
+    email      TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    password   TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE sessions (
+    hash       TEXT PRIMARY KEY,
+    email      TEXT NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE mails (
+    id          BIGSERIAL PRIMARY KEY,
+    owner       TEXT NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+    author      TEXT NOT NULL,
+    description TEXT NOT NULL,
+    text        TEXT NOT NULL,
+    badge_text  TEXT NOT NULL DEFAULT '',
+    badge_type  TEXT NOT NULL DEFAULT '',
+    date        TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX mails_owner_date_idx ON mails (owner, date DESC);
@@ -0,0 +1,17 @@
+CREATE TABLE mails (
+    id          BIGSERIAL PRIMARY KEY,
+    owner       TEXT NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+    author      TEXT NOT NULL,
+    description TEXT NOT NULL,
+    text        TEXT NOT NULL,
+    badge_text  TEXT NOT NULL DEFAULT '',
+    badge_type  TEXT NOT NULL DEFAULT '',
+    date        TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX mails_owner_date_idx ON mails (owner, date DESC);
+
+INSERT INTO mails (owner, author, description, text, date)
+SELECT owner, from_email, text_body, subject, date FROM messages WHERE folder = 'inbox';
+
+DROP TABLE messages;
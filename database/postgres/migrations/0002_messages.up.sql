@@ -0,0 +1,28 @@
+CREATE TABLE messages (
+    id          BIGSERIAL PRIMARY KEY,
+    owner       TEXT NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+    thread_id   BIGINT NOT NULL DEFAULT 0,
+    message_id  TEXT NOT NULL DEFAULT '',
+    from_name   TEXT NOT NULL DEFAULT '',
+    from_email  TEXT NOT NULL DEFAULT '',
+    to_addrs    JSONB NOT NULL DEFAULT '[]',
+    cc_addrs    JSONB NOT NULL DEFAULT '[]',
+    bcc_addrs   JSONB NOT NULL DEFAULT '[]',
+    subject     TEXT NOT NULL DEFAULT '',
+    text_body   TEXT NOT NULL DEFAULT '',
+    html_body   TEXT NOT NULL DEFAULT '',
+    headers     JSONB NOT NULL DEFAULT '{}',
+    attachments JSONB NOT NULL DEFAULT '[]',
+    flags       JSONB NOT NULL DEFAULT '[]',
+    folder      TEXT NOT NULL,
+    date        TIMESTAMPTZ NOT NULL DEFAULT now(),
+    received_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX messages_owner_folder_date_idx ON messages (owner, folder, date DESC, id DESC);
+
+INSERT INTO messages (owner, from_email, subject, text_body, folder, date, received_at)
+SELECT owner, author, text, description, 'inbox', date, date FROM mails;
+
+DROP TABLE mails;
@@ -0,0 +1,11 @@
+ALTER TABLE messages ADD COLUMN folder TEXT;
+
+UPDATE messages m SET folder = CASE WHEN f.system <> '' THEN f.system ELSE f.name END
+FROM folders f WHERE f.id = m.folder_id;
+
+ALTER TABLE messages ALTER COLUMN folder SET NOT NULL;
+DROP INDEX messages_owner_folder_date_idx;
+ALTER TABLE messages DROP COLUMN folder_id;
+CREATE INDEX messages_owner_folder_date_idx ON messages (owner, folder, date DESC, id DESC);
+
+DROP TABLE folders;
@@ -0,0 +1,32 @@
+CREATE TABLE folders (
+    id        BIGSERIAL PRIMARY KEY,
+    owner     TEXT NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+    parent_id BIGINT REFERENCES folders (id) ON DELETE CASCADE,
+    name      TEXT NOT NULL,
+    system    TEXT NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX folders_owner_system_idx ON folders (owner, system) WHERE system <> '';
+CREATE UNIQUE INDEX folders_owner_parent_name_idx ON folders (owner, COALESCE(parent_id, 0), name);
+
+INSERT INTO folders (owner, name, system)
+SELECT u.email, s.name, s.name
+FROM users u CROSS JOIN (VALUES ('inbox'), ('sent'), ('drafts'), ('trash'), ('spam')) AS s (name);
+
+ALTER TABLE messages ADD COLUMN folder_id BIGINT REFERENCES folders (id);
+
+UPDATE messages m SET folder_id = f.id
+FROM folders f WHERE f.owner = m.owner AND f.system = m.folder;
+
+INSERT INTO folders (owner, name)
+SELECT DISTINCT owner, folder FROM messages WHERE folder_id IS NULL;
+
+UPDATE messages m SET folder_id = f.id
+FROM folders f
+WHERE m.folder_id IS NULL AND f.owner = m.owner AND f.system = ''
+    AND f.parent_id IS NULL AND f.name = m.folder;
+
+ALTER TABLE messages ALTER COLUMN folder_id SET NOT NULL;
+DROP INDEX messages_owner_folder_date_idx;
+ALTER TABLE messages DROP COLUMN folder;
+CREATE INDEX messages_owner_folder_date_idx ON messages (owner, folder_id, date DESC, id DESC);
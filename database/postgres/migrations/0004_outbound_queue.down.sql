@@ -0,0 +1 @@
+DROP TABLE outbound_queue;
@@ -0,0 +1,14 @@
+CREATE TABLE outbound_queue (
+    id              BIGSERIAL PRIMARY KEY,
+    sender          TEXT NOT NULL DEFAULT '',
+    envelope_from   TEXT NOT NULL,
+    recipients      JSONB NOT NULL,
+    data            BYTEA NOT NULL,
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_error      TEXT NOT NULL DEFAULT '',
+    status          TEXT NOT NULL DEFAULT 'pending',
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX outbound_queue_due_idx ON outbound_queue (next_attempt_at) WHERE status = 'pending';
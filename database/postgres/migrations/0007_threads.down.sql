@@ -0,0 +1,6 @@
+DROP INDEX messages_refs_idx;
+DROP INDEX messages_owner_base_subject_idx;
+DROP INDEX messages_owner_thread_idx;
+DROP INDEX messages_owner_message_id_idx;
+ALTER TABLE messages DROP COLUMN base_subject;
+ALTER TABLE messages DROP COLUMN refs;
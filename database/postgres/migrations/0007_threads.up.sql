@@ -0,0 +1,12 @@
+ALTER TABLE messages ADD COLUMN refs JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE messages ADD COLUMN base_subject TEXT NOT NULL DEFAULT '';
+
+-- приближение database.BaseSubject для уже сохранённых писем
+UPDATE messages SET base_subject = lower(btrim(regexp_replace(subject,
+    '^(\s*((re|fwd?|aw|wg|ответ|отв|пересл)(\[\d+\]|\(\d+\))?\s*:|\[[^\]]*\])\s*)+', '', 'i')));
+UPDATE messages SET thread_id = id WHERE thread_id = 0;
+
+CREATE INDEX messages_owner_message_id_idx ON messages (owner, message_id);
+CREATE INDEX messages_owner_thread_idx ON messages (owner, thread_id);
+CREATE INDEX messages_owner_base_subject_idx ON messages (owner, base_subject, date DESC);
+CREATE INDEX messages_refs_idx ON messages USING GIN (refs);
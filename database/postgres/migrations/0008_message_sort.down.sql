@@ -0,0 +1,2 @@
+DROP INDEX messages_owner_folder_sender_idx;
+DROP INDEX messages_owner_folder_subject_idx;
@@ -0,0 +1,3 @@
+CREATE INDEX messages_owner_folder_subject_idx ON messages (owner, folder_id, base_subject, id);
+CREATE INDEX messages_owner_folder_sender_idx ON messages
+    (owner, folder_id, lower(CASE WHEN from_name <> '' THEN from_name ELSE from_email END), id);
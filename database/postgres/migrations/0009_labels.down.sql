@@ -0,0 +1,2 @@
+DROP TABLE message_labels;
+DROP TABLE labels;
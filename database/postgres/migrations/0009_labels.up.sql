@@ -0,0 +1,16 @@
+CREATE TABLE labels (
+    id    BIGSERIAL PRIMARY KEY,
+    owner TEXT NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+    name  TEXT NOT NULL,
+    color TEXT NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX labels_owner_name_idx ON labels (owner, name);
+
+CREATE TABLE message_labels (
+    message_id BIGINT NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+    label_id   BIGINT NOT NULL REFERENCES labels (id) ON DELETE CASCADE,
+    PRIMARY KEY (message_id, label_id)
+);
+
+CREATE INDEX message_labels_label_idx ON message_labels (label_id, message_id);
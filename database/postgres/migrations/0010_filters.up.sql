@@ -0,0 +1,12 @@
+CREATE TABLE filter_rules (
+    id         BIGSERIAL PRIMARY KEY,
+    owner      TEXT NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+    name       TEXT NOT NULL,
+    position   INTEGER NOT NULL,
+    enabled    BOOLEAN NOT NULL DEFAULT TRUE,
+    match_all  BOOLEAN NOT NULL DEFAULT TRUE,
+    conditions JSONB NOT NULL DEFAULT '[]',
+    actions    JSONB NOT NULL DEFAULT '[]'
+);
+
+CREATE INDEX filter_rules_owner_idx ON filter_rules (owner, position, id);
@@ -0,0 +1,2 @@
+DROP TABLE vacation_replies;
+DROP TABLE vacations;
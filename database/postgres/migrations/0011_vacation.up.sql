@@ -0,0 +1,16 @@
+CREATE TABLE vacations (
+    owner     TEXT PRIMARY KEY REFERENCES users (email) ON DELETE CASCADE,
+    enabled   BOOLEAN NOT NULL DEFAULT FALSE,
+    subject   TEXT NOT NULL DEFAULT '',
+    body      TEXT NOT NULL DEFAULT '',
+    starts_at TIMESTAMPTZ,
+    ends_at   TIMESTAMPTZ,
+    days      INTEGER NOT NULL
+);
+
+CREATE TABLE vacation_replies (
+    owner      TEXT NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+    sender     TEXT NOT NULL,
+    replied_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (owner, sender)
+);
@@ -0,0 +1,2 @@
+DROP TABLE spam_tokens;
+DROP TABLE spam_corpora;
@@ -0,0 +1,14 @@
+-- owner = '' - общий корпус, поэтому внешнего ключа на users нет
+CREATE TABLE spam_corpora (
+    owner TEXT PRIMARY KEY,
+    spam  INTEGER NOT NULL DEFAULT 0,
+    ham   INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE spam_tokens (
+    owner TEXT NOT NULL,
+    token TEXT NOT NULL,
+    spam  INTEGER NOT NULL DEFAULT 0,
+    ham   INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (owner, token)
+);
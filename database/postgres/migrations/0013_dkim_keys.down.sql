@@ -0,0 +1 @@
+DROP TABLE dkim_keys;
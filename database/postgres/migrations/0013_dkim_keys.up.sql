@@ -0,0 +1,12 @@
+CREATE TABLE dkim_keys (
+    id          BIGSERIAL PRIMARY KEY,
+    domain      TEXT NOT NULL,
+    selector    TEXT NOT NULL,
+    private_key BYTEA NOT NULL,
+    active      BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (domain, selector)
+);
+
+-- у домена не больше одного активного ключа
+CREATE UNIQUE INDEX dkim_keys_active_idx ON dkim_keys (domain) WHERE active;
@@ -0,0 +1,12 @@
+CREATE TABLE two_factor (
+    email     TEXT PRIMARY KEY REFERENCES users (email) ON DELETE CASCADE,
+    secret    TEXT NOT NULL,
+    enabled   BOOLEAN NOT NULL DEFAULT FALSE,
+    last_step BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE recovery_codes (
+    email     TEXT NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    PRIMARY KEY (email, code_hash)
+);
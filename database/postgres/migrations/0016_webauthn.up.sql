@@ -0,0 +1,11 @@
+CREATE TABLE webauthn_credentials (
+    id           BYTEA PRIMARY KEY,
+    email        TEXT NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+    user_handle  BYTEA NOT NULL,
+    name         TEXT NOT NULL,
+    data         JSONB NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX webauthn_credentials_email_idx ON webauthn_credentials (email, created_at);
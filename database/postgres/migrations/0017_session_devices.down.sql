@@ -0,0 +1,9 @@
+DROP INDEX sessions_expires_at_idx;
+DROP INDEX sessions_email_idx;
+
+ALTER TABLE sessions
+    DROP COLUMN expires_at,
+    DROP COLUMN last_seen_at,
+    DROP COLUMN ip,
+    DROP COLUMN user_agent,
+    DROP COLUMN id;
@@ -0,0 +1,10 @@
+-- старые сессии действуют ещё сутки, как и их куки
+ALTER TABLE sessions
+    ADD COLUMN id           BIGSERIAL UNIQUE,
+    ADD COLUMN user_agent   TEXT NOT NULL DEFAULT '',
+    ADD COLUMN ip           TEXT NOT NULL DEFAULT '',
+    ADD COLUMN last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN expires_at   TIMESTAMPTZ NOT NULL DEFAULT now() + INTERVAL '1 day';
+
+CREATE INDEX sessions_email_idx ON sessions (email);
+CREATE INDEX sessions_expires_at_idx ON sessions (expires_at);
@@ -0,0 +1,88 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"mail/database"
+	"time"
+)
+
+type OutboundRepository struct {
+	db *sql.DB
+}
+
+func NewOutboundRepository(db *sql.DB) *OutboundRepository {
+	return &OutboundRepository{db: db}
+}
+
+func (r *OutboundRepository) Enqueue(ctx context.Context, message database.OutboundMessage) (int64, error) {
+	recipients, err := json.Marshal(nonNilStrings(message.Recipients))
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = r.db.QueryRowContext(ctx,
+		`INSERT INTO outbound_queue (sender, envelope_from, recipients, data, next_attempt_at)
+		VALUES ($1, $2, $3, $4, COALESCE($5, now())) RETURNING id`,
+		message.Sender, message.From, recipients, message.Data, nullTime(message.NextAttemptAt)).Scan(&id)
+	return id, err
+}
+
+func (r *OutboundRepository) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]database.OutboundMessage, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`UPDATE outbound_queue SET attempts = attempts + 1, next_attempt_at = $2
+		WHERE id IN (
+			SELECT id FROM outbound_queue
+			WHERE status = 'pending' AND next_attempt_at <= $1
+			ORDER BY next_attempt_at, id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, sender, envelope_from, recipients, data, attempts,
+			next_attempt_at, last_error, status, created_at`,
+		now, now.Add(lease), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]database.OutboundMessage, 0)
+	for rows.Next() {
+		var message database.OutboundMessage
+		var recipients []byte
+		if err := rows.Scan(&message.ID, &message.Sender, &message.From, &recipients, &message.Data,
+			&message.Attempts, &message.NextAttemptAt, &message.LastError, &message.Status,
+			&message.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(recipients, &message.Recipients); err != nil {
+			return nil, err
+		}
+		result = append(result, message)
+	}
+	return result, rows.Err()
+}
+
+func (r *OutboundRepository) Reschedule(ctx context.Context, id int64, recipients []string, next time.Time, lastError string) error {
+	data, err := json.Marshal(nonNilStrings(recipients))
+	if err != nil {
+		return err
+	}
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE outbound_queue SET recipients = $2, next_attempt_at = $3, last_error = $4 WHERE id = $1`,
+		id, data, next, lastError)
+	if err != nil {
+		return err
+	}
+	return expectRows(res, database.ErrOutboundNotFound)
+}
+
+func (r *OutboundRepository) Complete(ctx context.Context, id int64, status string, lastError string) error {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE outbound_queue SET status = $2, last_error = $3 WHERE id = $1`, id, status, lastError)
+	if err != nil {
+		return err
+	}
+	return expectRows(res, database.ErrOutboundNotFound)
+}
@@ -0,0 +1,48 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mail/config"
+	"mail/database"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+const uniqueViolation = "23505"
+
+func Open(ctx context.Context, cfg config.PostgresConfig) (*sql.DB, error) {
+	db, err := sql.Open("pgx", cfg.DSN())
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func NewRepositories(db *sql.DB) *database.Repositories {
+	return &database.Repositories{
+		Users:     NewUserRepository(db),
+		Sessions:  NewSessionRepository(db),
+		Messages:  database.NewThreadedMessages(NewMessageRepository(db)),
+		Folders:   NewFolderRepository(db),
+		Labels:    NewLabelRepository(db),
+		Filters:   NewFilterRepository(db),
+		Vacation:  NewVacationRepository(db),
+		Spam:      NewSpamRepository(db),
+		DKIM:      NewDKIMRepository(db),
+		TwoFactor: NewTwoFactorRepository(db),
+		WebAuthn:  NewWebAuthnRepository(db),
+		Outbound:  NewOutboundRepository(db),
+	}
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
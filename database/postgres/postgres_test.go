@@ -0,0 +1,665 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mail/database"
+	"os"
+	"testing"
+	"time"
+)
+
+// openTestDB подключается к базе из TEST_POSTGRES_DSN, например к контейнеру
+// docker run -e POSTGRES_PASSWORD=postgres -p 5432:5432 postgres:16.
+// Без переменной тесты пропускаются.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := MigrateDown(ctx, db, 1<<30); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		MigrateDown(ctx, db, 1<<30)
+		db.Close()
+	})
+	return db
+}
+
+func TestRepositories(t *testing.T) {
+	db := openTestDB(t)
+	repo := NewRepositories(db)
+	ctx := context.Background()
+
+	user := database.User{Name: "nick", Email: "nick@giga-mail.ru", Password: "12345"}
+	if err := repo.Users.Create(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Users.Create(ctx, user); !errors.Is(err, database.ErrUserExists) {
+		t.Errorf("got %v want %v", err, database.ErrUserExists)
+	}
+	got, err := repo.Users.GetByEmail(ctx, user.Email)
+	if err != nil || got != user {
+		t.Errorf("got %v, %v want %v", got, err, user)
+	}
+	pending := database.User{Name: "new", Email: "new@giga-mail.ru", Password: "12345", Pending: true}
+	if err := repo.Users.Create(ctx, pending); err != nil {
+		t.Fatal(err)
+	}
+	pending.Pending = false
+	if err := repo.Users.Update(ctx, pending); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := repo.Users.GetByEmail(ctx, pending.Email); err != nil || got != pending {
+		t.Errorf("got %v, %v want %v", got, err, pending)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	newSession := func(hash string) database.Session {
+		return database.Session{Hash: hash, Email: user.Email, UserAgent: "curl", IP: "127.0.0.1",
+			CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(time.Hour)}
+	}
+	id, err := repo.Sessions.Create(ctx, newSession("hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session, err := repo.Sessions.Get(ctx, "hash"); err != nil || session.ID != id || session.Email != user.Email || session.UserAgent != "curl" {
+		t.Errorf("got %+v, %v", session, err)
+	}
+	if err := repo.Sessions.Touch(ctx, "hash", "10.0.0.1", now.Add(time.Minute), now.Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Sessions.Get(ctx, "hash"); !errors.Is(err, database.ErrSessionNotFound) {
+		t.Errorf("expired session: %v", err)
+	}
+	if n, err := repo.Sessions.DeleteExpired(ctx, time.Now()); err != nil || n != 1 {
+		t.Errorf("deleted %d, %v", n, err)
+	}
+	for _, hash := range []string{"current", "other"} {
+		if _, err := repo.Sessions.Create(ctx, newSession(hash)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sessions, err := repo.Sessions.List(ctx, user.Email); err != nil || len(sessions) != 2 {
+		t.Errorf("got %+v, %v", sessions, err)
+	}
+	if err := repo.Sessions.DeleteByEmail(ctx, user.Email, "current"); err != nil {
+		t.Fatal(err)
+	}
+	current, err := repo.Sessions.Get(ctx, "current")
+	if err != nil {
+		t.Errorf("current session: %v", err)
+	}
+	if _, err := repo.Sessions.Get(ctx, "other"); !errors.Is(err, database.ErrSessionNotFound) {
+		t.Errorf("got %v want %v", err, database.ErrSessionNotFound)
+	}
+	if err := repo.Sessions.DeleteByID(ctx, "other@giga-mail.ru", current.ID); !errors.Is(err, database.ErrSessionNotFound) {
+		t.Errorf("delete by other user: %v", err)
+	}
+	if err := repo.Sessions.DeleteByID(ctx, user.Email, current.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	inboxFolder, err := repo.Folders.GetSystem(ctx, user.Email, database.FolderInbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	older := database.Message{Owner: user.Email, From: database.Address{Email: "a@example.com"}, Subject: "old",
+		FolderID: inboxFolder.ID, Date: time.Now().Add(-time.Hour)}
+	newer := database.Message{Owner: user.Email, From: database.Address{Name: "B", Email: "b@example.com"}, Subject: "new",
+		To: []database.Address{{Email: user.Email}}, Flags: []string{database.FlagSeen},
+		Headers: map[string][]string{"X-Test": {"1"}}, FolderID: inboxFolder.ID, Date: time.Now(),
+		Raw: []byte("Subject: new\r\n\r\nbody\r\n")}
+	for _, m := range []database.Message{older, newer} {
+		if _, err := repo.Messages.Create(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inbox, err := repo.Messages.ListByFolder(ctx, user.Email, inboxFolder.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inbox) != 2 || inbox[0].Subject != "new" || !inbox[0].HasFlag(database.FlagSeen) ||
+		inbox[0].To[0].Email != user.Email || inbox[0].Headers["X-Test"][0] != "1" {
+		t.Errorf("unexpected inbox: %+v", inbox)
+	}
+	if _, err := repo.Messages.GetByID(ctx, "other@giga-mail.ru", inbox[0].ID); !errors.Is(err, database.ErrMessageNotFound) {
+		t.Errorf("got %v want %v", err, database.ErrMessageNotFound)
+	}
+	if raw, err := repo.Messages.GetRaw(ctx, user.Email, inbox[0].ID); err != nil || string(raw) != string(newer.Raw) {
+		t.Errorf("got raw %q, %v want %q", raw, err, newer.Raw)
+	}
+	if raw, err := repo.Messages.GetRaw(ctx, user.Email, inbox[1].ID); err != nil || raw != nil {
+		t.Errorf("got raw %q, %v want nil", raw, err)
+	}
+	edited := inbox[0]
+	edited.Subject = "edited"
+	if err := repo.Messages.Update(ctx, edited); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Messages.Update(ctx, inbox[0]); !errors.Is(err, database.ErrVersionConflict) {
+		t.Errorf("got %v want %v", err, database.ErrVersionConflict)
+	}
+	if got, _ := repo.Messages.GetByID(ctx, user.Email, edited.ID); got.Version != edited.Version+1 || got.Subject != "edited" {
+		t.Errorf("unexpected updated message: %+v", got)
+	}
+
+	if n, err := repo.Messages.UpdateFlags(ctx, user.Email, []int64{inbox[1].ID, inbox[0].ID},
+		[]string{database.FlagFlagged}, []string{database.FlagSeen}); err != nil || n != 2 {
+		t.Errorf("got %v, %v want 2", n, err)
+	}
+	if got, _ := repo.Messages.GetByID(ctx, user.Email, inbox[0].ID); got.HasFlag(database.FlagSeen) ||
+		!got.HasFlag(database.FlagFlagged) {
+		t.Errorf("unexpected flags: %q", got.Flags)
+	}
+	repo.Messages.UpdateFlags(ctx, user.Email, []int64{inbox[0].ID}, []string{database.FlagSeen}, nil)
+
+	folders, err := repo.Folders.List(ctx, user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(folders) != len(database.SystemFolders) || folders[0].System != database.FolderInbox ||
+		folders[0].Total != 2 || folders[0].Unread != 1 {
+		t.Errorf("unexpected folders: %+v", folders)
+	}
+
+	parentID, err := repo.Folders.Create(ctx, database.Folder{Owner: user.Email, Name: "Работа"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Folders.Create(ctx, database.Folder{Owner: user.Email, Name: "Работа"}); !errors.Is(err, database.ErrFolderExists) {
+		t.Errorf("got %v want %v", err, database.ErrFolderExists)
+	}
+	childID, err := repo.Folders.Create(ctx, database.Folder{Owner: user.Email, ParentID: parentID, Name: "Отчёты"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Folders.Rename(ctx, user.Email, inboxFolder.ID, "x"); !errors.Is(err, database.ErrSystemFolder) {
+		t.Errorf("got %v want %v", err, database.ErrSystemFolder)
+	}
+	if n, err := repo.Messages.Move(ctx, user.Email, []int64{inbox[1].ID}, childID); err != nil || n != 1 {
+		t.Errorf("got %v, %v want 1", n, err)
+	}
+	if err := repo.Folders.Delete(ctx, user.Email, parentID); err != nil {
+		t.Fatal(err)
+	}
+	trash, err := repo.Folders.GetSystem(ctx, user.Email, database.FolderTrash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trash.Total != 1 {
+		t.Errorf("message from deleted folder should be in trash: %+v", trash)
+	}
+	if _, err := repo.Folders.GetByID(ctx, user.Email, childID); !errors.Is(err, database.ErrFolderNotFound) {
+		t.Errorf("got %v want %v", err, database.ErrFolderNotFound)
+	}
+
+	if err := repo.Messages.Delete(ctx, user.Email, inbox[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if inbox, _ := repo.Messages.ListByFolder(ctx, user.Email, inboxFolder.ID); len(inbox) != 0 {
+		t.Errorf("inbox should be empty: %+v", inbox)
+	}
+}
+
+func TestThreads(t *testing.T) {
+	db := openTestDB(t)
+	repo := NewRepositories(db)
+	ctx := context.Background()
+	const owner = "nick@giga-mail.ru"
+	if err := repo.Users.Create(ctx, database.User{Name: "nick", Email: owner, Password: "12345"}); err != nil {
+		t.Fatal(err)
+	}
+	inbox, err := repo.Folders.GetSystem(ctx, owner, database.FolderInbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply := database.Message{Owner: owner, MessageID: "2@example.com", References: []string{"1@example.com"},
+		Subject: "Re: Релиз", FolderID: inbox.ID, Date: time.Now()}
+	replyID, err := repo.Messages.Create(ctx, reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := database.Message{Owner: owner, MessageID: "1@example.com", Subject: "Релиз",
+		FolderID: inbox.ID, Date: time.Now().Add(-time.Hour)}
+	rootID, err := repo.Messages.Create(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bySubjectID, err := repo.Messages.Create(ctx, database.Message{Owner: owner, Subject: "RE: [dev] релиз",
+		FolderID: inbox.ID, Date: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	thread, err := repo.Messages.ListByThread(ctx, owner, rootID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thread) != 3 || thread[0].ID != rootID || thread[1].ID != replyID || thread[2].ID != bySubjectID ||
+		thread[1].References[0] != "1@example.com" {
+		t.Errorf("unexpected thread: %+v", thread)
+	}
+	if messages, err := repo.Messages.ListThreadsByFolder(ctx, owner, inbox.ID); err != nil || len(messages) != 3 {
+		t.Errorf("got %d messages, %v want 3", len(messages), err)
+	}
+}
+
+func TestListMessages(t *testing.T) {
+	db := openTestDB(t)
+	repo := NewRepositories(db)
+	ctx := context.Background()
+	const owner = "nick@giga-mail.ru"
+	if err := repo.Users.Create(ctx, database.User{Name: "nick", Email: owner, Password: "12345"}); err != nil {
+		t.Fatal(err)
+	}
+	inbox, err := repo.Folders.GetSystem(ctx, owner, database.FolderInbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	for i, subject := range []string{"Re: b", "a", "c", "b_%"} {
+		message := database.Message{Owner: owner, From: database.Address{Email: "x@example.com"}, Subject: subject,
+			FolderID: inbox.ID, Date: date.Add(time.Duration(i) * time.Hour)}
+		if i%2 == 0 {
+			message.Flags = []string{database.FlagSeen}
+		}
+		if _, err := repo.Messages.Create(ctx, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query := database.ListQuery{FolderID: inbox.ID, Sort: database.SortSubject, Limit: 3}
+	var subjects []string
+	for {
+		page, err := repo.Messages.List(ctx, owner, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != 4 || page.Unread != 2 {
+			t.Errorf("got total %d unread %d want 4 and 2", page.Total, page.Unread)
+		}
+		for _, m := range page.Messages {
+			subjects = append(subjects, m.Subject)
+		}
+		if page.Next == nil {
+			break
+		}
+		query.After = page.Next
+	}
+	if len(subjects) != 4 || subjects[0] != "a" || subjects[3] != "c" {
+		t.Errorf("unexpected order: %q", subjects)
+	}
+
+	page, err := repo.Messages.List(ctx, owner, database.ListQuery{FolderID: inbox.ID, Sort: database.SortDate,
+		Desc: true, Unread: true, Since: date.Add(2 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages) != 1 || page.Messages[0].Subject != "b_%" || page.Total != 1 {
+		t.Errorf("unexpected filtered page: %+v", page)
+	}
+	page, err = repo.Messages.List(ctx, owner, database.ListQuery{FolderID: inbox.ID, Sort: database.SortDate, From: "_%"})
+	if err != nil || page.Total != 0 {
+		t.Errorf("LIKE pattern not escaped: %+v, %v", page, err)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	db := openTestDB(t)
+	repo := NewRepositories(db)
+	ctx := context.Background()
+	const owner = "nick@giga-mail.ru"
+	if err := repo.Users.Create(ctx, database.User{Name: "nick", Email: owner, Password: "12345"}); err != nil {
+		t.Fatal(err)
+	}
+	inbox, err := repo.Folders.GetSystem(ctx, owner, database.FolderInbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labelID, err := repo.Labels.Create(ctx, database.Label{Owner: owner, Name: "work", Color: "#ff0000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Labels.Create(ctx, database.Label{Owner: owner, Name: "work"}); !errors.Is(err, database.ErrLabelExists) {
+		t.Errorf("got %v want %v", err, database.ErrLabelExists)
+	}
+	id, err := repo.Messages.Create(ctx, database.Message{Owner: owner, FolderID: inbox.ID, Labels: []int64{labelID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := repo.Messages.Create(ctx, database.Message{Owner: owner, FolderID: inbox.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := repo.Messages.UpdateLabels(ctx, owner, []int64{id, other}, []int64{labelID}, nil); err != nil || n != 1 {
+		t.Errorf("got %d, %v want 1", n, err)
+	}
+
+	label, err := repo.Labels.GetByID(ctx, owner, labelID)
+	if err != nil || label.Total != 2 || label.Unread != 2 {
+		t.Errorf("unexpected label: %+v, %v", label, err)
+	}
+	page, err := repo.Messages.List(ctx, owner, database.ListQuery{LabelID: labelID, Sort: database.SortDate})
+	if err != nil || page.Total != 2 || len(page.Messages[0].Labels) != 1 {
+		t.Errorf("unexpected page: %+v, %v", page, err)
+	}
+
+	if err := repo.Labels.Delete(ctx, owner, labelID); err != nil {
+		t.Fatal(err)
+	}
+	message, err := repo.Messages.GetByID(ctx, owner, id)
+	if err != nil || len(message.Labels) != 0 {
+		t.Errorf("labels left after delete: %v, %v", message.Labels, err)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	db := openTestDB(t)
+	repo := NewRepositories(db)
+	ctx := context.Background()
+	const owner = "nick@giga-mail.ru"
+	if err := repo.Users.Create(ctx, database.User{Name: "nick", Email: owner, Password: "12345"}); err != nil {
+		t.Fatal(err)
+	}
+	rule := database.FilterRule{
+		Owner:      owner,
+		Name:       "spam",
+		Enabled:    true,
+		MatchAll:   true,
+		Conditions: []database.FilterCondition{{Field: database.FilterFieldSubject, Op: database.FilterOpContains, Value: "sale"}},
+		Actions:    []database.FilterAction{{Type: database.FilterActionDiscard}},
+	}
+	first, err := repo.Filters.Create(ctx, rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := repo.Filters.Create(ctx, database.FilterRule{Owner: owner, Name: "all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Filters.Reorder(ctx, owner, []int64{second, first}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Filters.Reorder(ctx, owner, []int64{first, 100500}); !errors.Is(err, database.ErrFilterNotFound) {
+		t.Errorf("got %v want %v", err, database.ErrFilterNotFound)
+	}
+	rules, err := repo.Filters.List(ctx, owner)
+	if err != nil || len(rules) != 2 || rules[0].ID != second || rules[1].Conditions[0].Value != "sale" {
+		t.Errorf("unexpected rules: %+v, %v", rules, err)
+	}
+
+	if err := repo.Filters.Replace(ctx, owner, []database.FilterRule{rule, rule}); err != nil {
+		t.Fatal(err)
+	}
+	rules, err = repo.Filters.List(ctx, owner)
+	if err != nil || len(rules) != 2 || rules[0].Position != 0 || rules[1].Position != 1 {
+		t.Errorf("unexpected replaced rules: %+v, %v", rules, err)
+	}
+	if _, err := repo.Filters.GetByID(ctx, owner, first); !errors.Is(err, database.ErrFilterNotFound) {
+		t.Errorf("got %v want %v", err, database.ErrFilterNotFound)
+	}
+}
+
+func TestVacation(t *testing.T) {
+	db := openTestDB(t)
+	repo := NewRepositories(db)
+	ctx := context.Background()
+	const owner = "nick@giga-mail.ru"
+	if err := repo.Users.Create(ctx, database.User{Name: "nick", Email: owner, Password: "12345"}); err != nil {
+		t.Fatal(err)
+	}
+	vacation, err := repo.Vacation.Get(ctx, owner)
+	if err != nil || vacation.Enabled || vacation.Days != database.DefaultVacationDays {
+		t.Errorf("unexpected default vacation: %+v, %v", vacation, err)
+	}
+	start := time.Date(2024, 12, 20, 0, 0, 0, 0, time.UTC)
+	saved := database.Vacation{Owner: owner, Enabled: true, Subject: "Отпуск", Body: "Вернусь", Start: start, Days: 3}
+	if err := repo.Vacation.Save(ctx, saved); err != nil {
+		t.Fatal(err)
+	}
+	vacation, err = repo.Vacation.Get(ctx, owner)
+	if err != nil || !vacation.Start.Equal(start) || !vacation.End.IsZero() || vacation.Body != "Вернусь" {
+		t.Errorf("unexpected vacation: %+v, %v", vacation, err)
+	}
+
+	now := start.Add(time.Hour)
+	since := now.Add(-72 * time.Hour)
+	for i, want := range []bool{true, false} {
+		if ok, err := repo.Vacation.MarkReplied(ctx, owner, "Mark@example.com", now, since); err != nil || ok != want {
+			t.Errorf("attempt %d: got %v, %v want %v", i, ok, err, want)
+		}
+	}
+	if ok, _ := repo.Vacation.MarkReplied(ctx, owner, "mark@example.com", now.Add(73*time.Hour), now.Add(time.Hour)); !ok {
+		t.Error("reply after interval was not allowed")
+	}
+}
+
+func TestSpam(t *testing.T) {
+	db := openTestDB(t)
+	repo := NewSpamRepository(db)
+	ctx := context.Background()
+	const owner = "nick@giga-mail.ru"
+	corpus, err := repo.Corpus(ctx, owner, []string{"casino"})
+	if err != nil || corpus.Spam != 0 || len(corpus.Tokens) != 0 {
+		t.Errorf("unexpected empty corpus: %+v, %v", corpus, err)
+	}
+
+	for _, step := range []struct {
+		tokens []string
+		spam   bool
+		delta  int
+	}{
+		{[]string{"casino", "bonus"}, true, 1},
+		{[]string{"casino", "bonus"}, true, 1},
+		{[]string{"report", "bonus"}, false, 1},
+		{[]string{"casino", "bonus"}, true, -1},
+		{[]string{"report"}, false, -1},
+		{[]string{"report"}, false, -1},
+	} {
+		if err := repo.Train(ctx, owner, step.tokens, step.spam, step.delta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	corpus, err = repo.Corpus(ctx, owner, []string{"casino", "bonus", "report"})
+	if err != nil || corpus.Spam != 1 || corpus.Ham != 0 {
+		t.Fatalf("unexpected corpus: %+v, %v", corpus, err)
+	}
+	if len(corpus.Tokens) != 2 || corpus.Tokens["casino"] != (database.TokenCounts{Spam: 1}) ||
+		corpus.Tokens["bonus"] != (database.TokenCounts{Spam: 1, Ham: 1}) {
+		t.Errorf("unexpected tokens: %+v", corpus.Tokens)
+	}
+	if global, _ := repo.Corpus(ctx, database.GlobalCorpus, []string{"casino"}); global.Spam != 0 {
+		t.Errorf("training leaked into global corpus: %+v", global)
+	}
+}
+
+func TestDKIMKeys(t *testing.T) {
+	db := openTestDB(t)
+	repo := NewDKIMRepository(db)
+	ctx := context.Background()
+	if _, err := repo.Active(ctx, "giga-mail.ru"); !errors.Is(err, database.ErrDKIMKeyNotFound) {
+		t.Errorf("got %v want %v", err, database.ErrDKIMKeyNotFound)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	first, err := repo.Rotate(ctx, database.DKIMKey{Domain: "Giga-Mail.ru", Selector: "s1", PrivateKey: []byte("one"), CreatedAt: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := repo.Rotate(ctx, database.DKIMKey{Domain: "giga-mail.ru", Selector: "s2", PrivateKey: []byte("two"), CreatedAt: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Rotate(ctx, database.DKIMKey{Domain: "giga-mail.ru", Selector: "s1", PrivateKey: []byte("x"), CreatedAt: now}); !errors.Is(err, database.ErrDKIMKeyExists) {
+		t.Errorf("got %v want %v", err, database.ErrDKIMKeyExists)
+	}
+
+	active, err := repo.Active(ctx, "GIGA-MAIL.RU")
+	if err != nil || active.ID != second || string(active.PrivateKey) != "two" || !active.CreatedAt.Equal(now) {
+		t.Errorf("unexpected active key: %+v, %v", active, err)
+	}
+	keys, err := repo.List(ctx, "giga-mail.ru")
+	if err != nil || len(keys) != 2 || keys[0].ID != second || keys[1].Active {
+		t.Errorf("unexpected keys: %+v, %v", keys, err)
+	}
+
+	if err := repo.Delete(ctx, "giga-mail.ru", second); !errors.Is(err, database.ErrDKIMKeyActive) {
+		t.Errorf("got %v want %v", err, database.ErrDKIMKeyActive)
+	}
+	if err := repo.Delete(ctx, "example.com", first); !errors.Is(err, database.ErrDKIMKeyNotFound) {
+		t.Errorf("got %v want %v", err, database.ErrDKIMKeyNotFound)
+	}
+	if err := repo.Delete(ctx, "giga-mail.ru", first); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTwoFactor(t *testing.T) {
+	db := openTestDB(t)
+	repos := NewRepositories(db)
+	repo := repos.TwoFactor
+	ctx := context.Background()
+	owner := "nick@giga-mail.ru"
+	if err := repos.Users.Create(ctx, database.User{Name: "nick", Email: owner, Password: "12345"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Get(ctx, owner); !errors.Is(err, database.ErrTwoFactorNotFound) {
+		t.Errorf("got %v want %v", err, database.ErrTwoFactorNotFound)
+	}
+	twoFactor := database.TwoFactor{Email: owner, Secret: "SECRET", Enabled: true, LastStep: 10}
+	if err := repo.Save(ctx, twoFactor); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := repo.Get(ctx, owner); err != nil || got != twoFactor {
+		t.Errorf("got %+v, %v want %+v", got, err, twoFactor)
+	}
+	if ok, err := repo.UseStep(ctx, owner, 10); err != nil || ok {
+		t.Errorf("same step: %v, %v", ok, err)
+	}
+	if ok, err := repo.UseStep(ctx, owner, 11); err != nil || !ok {
+		t.Errorf("next step: %v, %v", ok, err)
+	}
+
+	if err := repo.SetRecoveryCodes(ctx, owner, []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := repo.UseRecoveryCode(ctx, owner, "a"); err != nil || !ok {
+		t.Errorf("first use: %v, %v", ok, err)
+	}
+	if ok, err := repo.UseRecoveryCode(ctx, owner, "a"); err != nil || ok {
+		t.Errorf("second use: %v, %v", ok, err)
+	}
+	if n, err := repo.CountRecoveryCodes(ctx, owner); err != nil || n != 1 {
+		t.Errorf("got %d, %v want 1", n, err)
+	}
+
+	if err := repo.Delete(ctx, owner); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := repo.CountRecoveryCodes(ctx, owner); n != 0 {
+		t.Errorf("%d recovery codes left after delete", n)
+	}
+}
+
+func TestWebAuthnCredentials(t *testing.T) {
+	db := openTestDB(t)
+	repos := NewRepositories(db)
+	repo := repos.WebAuthn
+	ctx := context.Background()
+	owner := "nick@giga-mail.ru"
+	if err := repos.Users.Create(ctx, database.User{Name: "nick", Email: owner, Password: "12345"}); err != nil {
+		t.Fatal(err)
+	}
+	credential := database.WebAuthnCredential{
+		ID:         []byte{1, 2, 3},
+		Email:      owner,
+		UserHandle: []byte{9},
+		Name:       "YubiKey",
+		Data:       []byte(`{"id":"AQID"}`),
+		CreatedAt:  time.Now().UTC().Truncate(time.Second),
+	}
+	if err := repo.Create(ctx, credential); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Create(ctx, credential); !errors.Is(err, database.ErrCredentialExists) {
+		t.Errorf("got %v want %v", err, database.ErrCredentialExists)
+	}
+	got, err := repo.Get(ctx, credential.ID)
+	if err != nil || got.Name != "YubiKey" || !got.LastUsedAt.IsZero() {
+		t.Fatalf("got %+v, %v", got, err)
+	}
+
+	got.LastUsedAt = time.Now()
+	got.Data = []byte(`{"id":"AQID","authenticator":{"signCount":2}}`)
+	if err := repo.Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	list, err := repo.List(ctx, owner)
+	if err != nil || len(list) != 1 || list[0].LastUsedAt.IsZero() {
+		t.Fatalf("got %+v, %v", list, err)
+	}
+
+	if err := repo.Delete(ctx, "other@giga-mail.ru", credential.ID); !errors.Is(err, database.ErrCredentialNotFound) {
+		t.Errorf("delete by other user: %v", err)
+	}
+	if err := repo.Delete(ctx, owner, credential.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Get(ctx, credential.ID); !errors.Is(err, database.ErrCredentialNotFound) {
+		t.Errorf("got %v want %v", err, database.ErrCredentialNotFound)
+	}
+}
+
+func TestOutboundRepository(t *testing.T) {
+	db := openTestDB(t)
+	repo := NewOutboundRepository(db)
+	ctx := context.Background()
+	now := time.Now()
+
+	id, err := repo.Enqueue(ctx, database.OutboundMessage{
+		Sender:     "nick@giga-mail.ru",
+		From:       "nick@giga-mail.ru",
+		Recipients: []string{"a@example.com", "b@example.com"},
+		Data:       []byte("Subject: hi\r\n\r\nhello\r\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := repo.ClaimDue(ctx, now.Add(time.Second), time.Minute, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != id || claimed[0].Attempts != 1 || len(claimed[0].Recipients) != 2 {
+		t.Fatalf("unexpected claimed messages: %+v", claimed)
+	}
+	if again, _ := repo.ClaimDue(ctx, now.Add(time.Second), time.Minute, 10); len(again) != 0 {
+		t.Errorf("leased message claimed twice: %+v", again)
+	}
+
+	if err := repo.Reschedule(ctx, id, []string{"b@example.com"}, now, "451 try later"); err != nil {
+		t.Fatal(err)
+	}
+	claimed, _ = repo.ClaimDue(ctx, now.Add(time.Second), time.Minute, 10)
+	if len(claimed) != 1 || claimed[0].Attempts != 2 || claimed[0].Recipients[0] != "b@example.com" {
+		t.Fatalf("unexpected rescheduled message: %+v", claimed)
+	}
+	if err := repo.Complete(ctx, id, database.OutboundSent, ""); err != nil {
+		t.Fatal(err)
+	}
+	if done, _ := repo.ClaimDue(ctx, now.Add(time.Hour), time.Minute, 10); len(done) != 0 {
+		t.Errorf("completed message claimed: %+v", done)
+	}
+}
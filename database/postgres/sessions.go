@@ -0,0 +1,100 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mail/database"
+	"time"
+)
+
+const sessionColumns = `id, hash, email, user_agent, ip, created_at, last_seen_at, expires_at`
+
+type SessionRepository struct {
+	db *sql.DB
+}
+
+func NewSessionRepository(db *sql.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+func scanSession(row rowScanner) (database.Session, error) {
+	var session database.Session
+	err := row.Scan(&session.ID, &session.Hash, &session.Email, &session.UserAgent, &session.IP,
+		&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+	return session, err
+}
+
+func (r *SessionRepository) Create(ctx context.Context, session database.Session) (int64, error) {
+	var id int64
+	err := r.db.QueryRowContext(ctx,
+		`INSERT INTO sessions (hash, email, user_agent, ip, created_at, last_seen_at, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		session.Hash, session.Email, session.UserAgent, session.IP,
+		session.CreatedAt, session.LastSeenAt, session.ExpiresAt).Scan(&id)
+	return id, err
+}
+
+func (r *SessionRepository) Get(ctx context.Context, hash string) (database.Session, error) {
+	session, err := scanSession(r.db.QueryRowContext(ctx,
+		`SELECT `+sessionColumns+` FROM sessions WHERE hash = $1 AND expires_at > now()`, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.Session{}, database.ErrSessionNotFound
+	}
+	return session, err
+}
+
+func (r *SessionRepository) List(ctx context.Context, email string) ([]database.Session, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+sessionColumns+` FROM sessions WHERE email = $1 AND expires_at > now()
+		 ORDER BY last_seen_at DESC, id DESC`, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]database.Session, 0)
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, session)
+	}
+	return result, rows.Err()
+}
+
+func (r *SessionRepository) Touch(ctx context.Context, hash string, ip string, lastSeen time.Time, expiresAt time.Time) error {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE sessions SET ip = $2, last_seen_at = $3, expires_at = $4 WHERE hash = $1`,
+		hash, ip, lastSeen, expiresAt)
+	if err != nil {
+		return err
+	}
+	return expectRows(res, database.ErrSessionNotFound)
+}
+
+func (r *SessionRepository) Delete(ctx context.Context, hash string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM sessions WHERE hash = $1`, hash)
+	return err
+}
+
+func (r *SessionRepository) DeleteByID(ctx context.Context, email string, id int64) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM sessions WHERE id = $1 AND email = $2`, id, email)
+	if err != nil {
+		return err
+	}
+	return expectRows(res, database.ErrSessionNotFound)
+}
+
+func (r *SessionRepository) DeleteByEmail(ctx context.Context, email string, except string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM sessions WHERE email = $1 AND hash <> $2`, email, except)
+	return err
+}
+
+func (r *SessionRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
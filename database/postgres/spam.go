@@ -0,0 +1,82 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mail/database"
+)
+
+type SpamRepository struct {
+	db *sql.DB
+}
+
+func NewSpamRepository(db *sql.DB) *SpamRepository {
+	return &SpamRepository{db: db}
+}
+
+func (r *SpamRepository) Corpus(ctx context.Context, owner string, tokens []string) (database.SpamCorpus, error) {
+	corpus := database.SpamCorpus{Tokens: make(map[string]database.TokenCounts)}
+	err := r.db.QueryRowContext(ctx,
+		`SELECT spam, ham FROM spam_corpora WHERE owner = $1`, owner).Scan(&corpus.Spam, &corpus.Ham)
+	if errors.Is(err, sql.ErrNoRows) {
+		return corpus, nil
+	}
+	if err != nil {
+		return corpus, err
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT token, spam, ham FROM spam_tokens WHERE owner = $1 AND token = ANY($2)`, owner, tokens)
+	if err != nil {
+		return corpus, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var token string
+		var counts database.TokenCounts
+		if err := rows.Scan(&token, &counts.Spam, &counts.Ham); err != nil {
+			return corpus, err
+		}
+		corpus.Tokens[token] = counts
+	}
+	return corpus, rows.Err()
+}
+
+func (r *SpamRepository) Train(ctx context.Context, owner string, tokens []string, spam bool, delta int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	spamDelta, hamDelta := 0, delta
+	if spam {
+		spamDelta, hamDelta = delta, 0
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO spam_corpora (owner, spam, ham) VALUES ($1, GREATEST($2, 0), GREATEST($3, 0))
+		ON CONFLICT (owner) DO UPDATE SET
+			spam = GREATEST(spam_corpora.spam + $2, 0),
+			ham = GREATEST(spam_corpora.ham + $3, 0)`,
+		owner, spamDelta, hamDelta)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO spam_tokens (owner, token, spam, ham)
+		SELECT $1, token, GREATEST($3, 0), GREATEST($4, 0) FROM unnest($2::TEXT[]) AS token
+		ON CONFLICT (owner, token) DO UPDATE SET
+			spam = GREATEST(spam_tokens.spam + $3, 0),
+			ham = GREATEST(spam_tokens.ham + $4, 0)`,
+		owner, tokens, spamDelta, hamDelta)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`DELETE FROM spam_tokens WHERE owner = $1 AND token = ANY($2) AND spam = 0 AND ham = 0`, owner, tokens)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,95 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mail/database"
+)
+
+type TwoFactorRepository struct {
+	db *sql.DB
+}
+
+func NewTwoFactorRepository(db *sql.DB) *TwoFactorRepository {
+	return &TwoFactorRepository{db: db}
+}
+
+func (r *TwoFactorRepository) Get(ctx context.Context, email string) (database.TwoFactor, error) {
+	twoFactor := database.TwoFactor{Email: email}
+	err := r.db.QueryRowContext(ctx,
+		`SELECT secret, enabled, last_step FROM two_factor WHERE email = $1`, email).
+		Scan(&twoFactor.Secret, &twoFactor.Enabled, &twoFactor.LastStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.TwoFactor{}, database.ErrTwoFactorNotFound
+	}
+	return twoFactor, err
+}
+
+func (r *TwoFactorRepository) Save(ctx context.Context, twoFactor database.TwoFactor) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO two_factor (email, secret, enabled, last_step) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (email) DO UPDATE SET secret = $2, enabled = $3, last_step = $4`,
+		twoFactor.Email, twoFactor.Secret, twoFactor.Enabled, twoFactor.LastStep)
+	return err
+}
+
+func (r *TwoFactorRepository) Delete(ctx context.Context, email string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE email = $1`, email); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM two_factor WHERE email = $1`, email); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *TwoFactorRepository) UseStep(ctx context.Context, email string, step int64) (bool, error) {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE two_factor SET last_step = $2 WHERE email = $1 AND last_step < $2`, email, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (r *TwoFactorRepository) SetRecoveryCodes(ctx context.Context, email string, hashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE email = $1`, email); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO recovery_codes (email, code_hash) VALUES ($1, $2)`, email, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *TwoFactorRepository) UseRecoveryCode(ctx context.Context, email string, hash string) (bool, error) {
+	res, err := r.db.ExecContext(ctx,
+		`DELETE FROM recovery_codes WHERE email = $1 AND code_hash = $2`, email, hash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (r *TwoFactorRepository) CountRecoveryCodes(ctx context.Context, email string) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx,
+		`SELECT count(*) FROM recovery_codes WHERE email = $1`, email).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,59 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mail/database"
+)
+
+type UserRepository struct {
+	db *sql.DB
+}
+
+func NewUserRepository(db *sql.DB) *UserRepository {
+	return &UserRepository{db: db}
+}
+
+func (r *UserRepository) Create(ctx context.Context, user database.User) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO users (email, name, password, pending) VALUES ($1, $2, $3, $4)`,
+		user.Email, user.Name, user.Password, user.Pending)
+	if isUniqueViolation(err) {
+		return database.ErrUserExists
+	}
+	return err
+}
+
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (database.User, error) {
+	var user database.User
+	err := r.db.QueryRowContext(ctx,
+		`SELECT email, name, password, pending FROM users WHERE email = $1`, email).
+		Scan(&user.Email, &user.Name, &user.Password, &user.Pending)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, database.ErrUserNotFound
+	}
+	return user, err
+}
+
+func (r *UserRepository) Update(ctx context.Context, user database.User) error {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE users SET name = $2, password = $3, pending = $4 WHERE email = $1`,
+		user.Email, user.Name, user.Password, user.Pending)
+	if err != nil {
+		return err
+	}
+	return expectRows(res, database.ErrUserNotFound)
+}
+
+// expectRows возвращает notFound, если запрос не затронул ни одной строки.
+func expectRows(res sql.Result, notFound error) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
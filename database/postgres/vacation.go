@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mail/database"
+	"strings"
+	"time"
+)
+
+type VacationRepository struct {
+	db *sql.DB
+}
+
+func NewVacationRepository(db *sql.DB) *VacationRepository {
+	return &VacationRepository{db: db}
+}
+
+func (r *VacationRepository) Get(ctx context.Context, owner string) (database.Vacation, error) {
+	vacation := database.Vacation{Owner: owner}
+	var start, end sql.NullTime
+	err := r.db.QueryRowContext(ctx,
+		`SELECT enabled, subject, body, starts_at, ends_at, days FROM vacations WHERE owner = $1`, owner).
+		Scan(&vacation.Enabled, &vacation.Subject, &vacation.Body, &start, &end, &vacation.Days)
+	if errors.Is(err, sql.ErrNoRows) {
+		vacation.Days = database.DefaultVacationDays
+		return vacation, nil
+	}
+	vacation.Start, vacation.End = start.Time, end.Time
+	return vacation, err
+}
+
+func (r *VacationRepository) Save(ctx context.Context, vacation database.Vacation) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO vacations (owner, enabled, subject, body, starts_at, ends_at, days)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (owner) DO UPDATE SET enabled = $2, subject = $3, body = $4,
+			starts_at = $5, ends_at = $6, days = $7`,
+		vacation.Owner, vacation.Enabled, vacation.Subject, vacation.Body,
+		nullTime(vacation.Start), nullTime(vacation.End), vacation.Days)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM vacation_replies WHERE owner = $1`, vacation.Owner); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *VacationRepository) MarkReplied(ctx context.Context, owner string, sender string, now time.Time, since time.Time) (bool, error) {
+	// запись обновляется, только если прошлый ответ старше since:
+	// одновременные доставки не отправят ответ дважды
+	var replied time.Time
+	err := r.db.QueryRowContext(ctx,
+		`INSERT INTO vacation_replies (owner, sender, replied_at) VALUES ($1, $2, $3)
+		ON CONFLICT (owner, sender) DO UPDATE SET replied_at = $3
+		WHERE vacation_replies.replied_at < $4
+		RETURNING replied_at`,
+		owner, strings.ToLower(sender), now, since).Scan(&replied)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
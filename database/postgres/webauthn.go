@@ -0,0 +1,84 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mail/database"
+)
+
+const webauthnColumns = `id, email, user_handle, name, data, created_at, last_used_at`
+
+type WebAuthnRepository struct {
+	db *sql.DB
+}
+
+func NewWebAuthnRepository(db *sql.DB) *WebAuthnRepository {
+	return &WebAuthnRepository{db: db}
+}
+
+func scanCredential(row rowScanner) (database.WebAuthnCredential, error) {
+	var credential database.WebAuthnCredential
+	var lastUsed sql.NullTime
+	err := row.Scan(&credential.ID, &credential.Email, &credential.UserHandle, &credential.Name,
+		&credential.Data, &credential.CreatedAt, &lastUsed)
+	credential.LastUsedAt = lastUsed.Time
+	return credential, err
+}
+
+func (r *WebAuthnRepository) List(ctx context.Context, email string) ([]database.WebAuthnCredential, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+webauthnColumns+` FROM webauthn_credentials WHERE email = $1 ORDER BY created_at, id`, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]database.WebAuthnCredential, 0)
+	for rows.Next() {
+		credential, err := scanCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, credential)
+	}
+	return result, rows.Err()
+}
+
+func (r *WebAuthnRepository) Get(ctx context.Context, id []byte) (database.WebAuthnCredential, error) {
+	credential, err := scanCredential(r.db.QueryRowContext(ctx,
+		`SELECT `+webauthnColumns+` FROM webauthn_credentials WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.WebAuthnCredential{}, database.ErrCredentialNotFound
+	}
+	return credential, err
+}
+
+func (r *WebAuthnRepository) Create(ctx context.Context, credential database.WebAuthnCredential) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO webauthn_credentials (`+webauthnColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		credential.ID, credential.Email, credential.UserHandle, credential.Name, credential.Data,
+		credential.CreatedAt, nullTime(credential.LastUsedAt))
+	if isUniqueViolation(err) {
+		return database.ErrCredentialExists
+	}
+	return err
+}
+
+func (r *WebAuthnRepository) Update(ctx context.Context, credential database.WebAuthnCredential) error {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE webauthn_credentials SET data = $3, last_used_at = $4 WHERE id = $1 AND email = $2`,
+		credential.ID, credential.Email, credential.Data, nullTime(credential.LastUsedAt))
+	if err != nil {
+		return err
+	}
+	return expectRows(res, database.ErrCredentialNotFound)
+}
+
+func (r *WebAuthnRepository) Delete(ctx context.Context, email string, id []byte) error {
+	res, err := r.db.ExecContext(ctx,
+		`DELETE FROM webauthn_credentials WHERE id = $1 AND email = $2`, id, email)
+	if err != nil {
+		return err
+	}
+	return expectRows(res, database.ErrCredentialNotFound)
+}
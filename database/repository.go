@@ -0,0 +1,225 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserExists         = errors.New("user already exists")
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrMessageNotFound    = errors.New("message not found")
+	ErrFolderNotFound     = errors.New("folder not found")
+	ErrFolderExists       = errors.New("folder already exists")
+	ErrSystemFolder       = errors.New("system folder cannot be changed")
+	ErrOutboundNotFound   = errors.New("outbound message not found")
+	ErrVersionConflict    = errors.New("message was modified concurrently")
+	ErrLabelNotFound      = errors.New("label not found")
+	ErrLabelExists        = errors.New("label already exists")
+	ErrFilterNotFound     = errors.New("filter rule not found")
+	ErrDKIMKeyNotFound    = errors.New("dkim key not found")
+	ErrDKIMKeyExists      = errors.New("dkim selector already exists")
+	ErrDKIMKeyActive      = errors.New("active dkim key cannot be deleted")
+	ErrTwoFactorNotFound  = errors.New("two-factor authentication is not set up")
+	ErrCredentialNotFound = errors.New("webauthn credential not found")
+	ErrCredentialExists   = errors.New("webauthn credential already registered")
+)
+
+type UserRepository interface {
+	Create(ctx context.Context, user User) error
+	GetByEmail(ctx context.Context, email string) (User, error)
+	Update(ctx context.Context, user User) error
+}
+
+// MessageRepository работает только с письмами одного владельца: письмо
+// чужого ящика для него не существует и возвращается ErrMessageNotFound.
+type MessageRepository interface {
+	Create(ctx context.Context, message Message) (int64, error)
+	GetByID(ctx context.Context, owner string, id int64) (Message, error)
+	// GetRaw возвращает исходный текст письма, пустой, если он не сохранён.
+	GetRaw(ctx context.Context, owner string, id int64) ([]byte, error)
+	ListByFolder(ctx context.Context, owner string, folderID int64) ([]Message, error)
+	// List возвращает страницу писем папки с фильтрами и сортировкой.
+	List(ctx context.Context, owner string, query ListQuery) (MessagePage, error)
+	// Update сохраняет письмо, только если его Version совпадает с
+	// сохранённой, иначе возвращает ErrVersionConflict. Версия при этом
+	// увеличивается на единицу. Raw перезаписывается, только если задан.
+	Update(ctx context.Context, message Message) error
+	Delete(ctx context.Context, owner string, id int64) error
+	// Move переносит письма владельца в папку и возвращает число
+	// перенесённых писем, чужие и несуществующие ID пропускаются.
+	Move(ctx context.Context, owner string, ids []int64, folderID int64) (int, error)
+	// UpdateFlags добавляет и снимает флаги у писем владельца и возвращает
+	// число найденных писем. Версия растёт только у изменившихся писем.
+	UpdateFlags(ctx context.Context, owner string, ids []int64, add []string, remove []string) (int, error)
+	// UpdateLabels ставит и снимает метки у писем владельца и возвращает
+	// число найденных писем. Метки - не содержимое письма, поэтому версия
+	// не меняется. Чужие и несуществующие метки пропускаются.
+	UpdateLabels(ctx context.Context, owner string, ids []int64, add []int64, remove []int64) (int, error)
+
+	// ListByThread возвращает письма цепочки от старых к новым.
+	ListByThread(ctx context.Context, owner string, threadID int64) ([]Message, error)
+	// ListThreadsByFolder возвращает все письма цепочек, в которых есть
+	// хотя бы одно письмо из папки.
+	ListThreadsByFolder(ctx context.Context, owner string, folderID int64) ([]Message, error)
+	ListByMessageIDs(ctx context.Context, owner string, messageIDs []string) ([]Message, error)
+	// ListReferencing возвращает письма, у которых messageID есть в References.
+	ListReferencing(ctx context.Context, owner string, messageID string) ([]Message, error)
+	// FindThreadBySubject возвращает цепочку самого свежего письма не
+	// старше since с той же темой без префиксов (см. BaseSubject) или 0.
+	FindThreadBySubject(ctx context.Context, owner string, baseSubject string, since time.Time) (int64, error)
+	// MergeThreads переносит письма цепочек from в цепочку to.
+	MergeThreads(ctx context.Context, owner string, from []int64, to int64) error
+}
+
+// FolderRepository создаёт системные папки пользователя при первом
+// обращении, поэтому отдельной инициализации ящика не требуется.
+type FolderRepository interface {
+	List(ctx context.Context, owner string) ([]Folder, error)
+	GetByID(ctx context.Context, owner string, id int64) (Folder, error)
+	GetSystem(ctx context.Context, owner string, system string) (Folder, error)
+	Create(ctx context.Context, folder Folder) (int64, error)
+	Rename(ctx context.Context, owner string, id int64, name string) error
+	// Delete удаляет папку вместе с вложенными, письма из них попадают в корзину.
+	Delete(ctx context.Context, owner string, id int64) error
+}
+
+// LabelRepository - метки пользователя. При удалении метка снимается
+// со всех писем.
+type LabelRepository interface {
+	List(ctx context.Context, owner string) ([]Label, error)
+	GetByID(ctx context.Context, owner string, id int64) (Label, error)
+	Create(ctx context.Context, label Label) (int64, error)
+	// Update меняет имя и цвет метки.
+	Update(ctx context.Context, label Label) error
+	Delete(ctx context.Context, owner string, id int64) error
+}
+
+// FilterRepository - правила фильтрации входящей почты пользователя.
+// List отдаёт правила в порядке применения.
+type FilterRepository interface {
+	List(ctx context.Context, owner string) ([]FilterRule, error)
+	GetByID(ctx context.Context, owner string, id int64) (FilterRule, error)
+	// Create добавляет правило в конец списка.
+	Create(ctx context.Context, rule FilterRule) (int64, error)
+	// Update меняет всё, кроме позиции правила.
+	Update(ctx context.Context, rule FilterRule) error
+	Delete(ctx context.Context, owner string, id int64) error
+	// Reorder выставляет правилам ids позиции в порядке перечисления.
+	Reorder(ctx context.Context, owner string, ids []int64) error
+	// Replace заменяет все правила пользователя, например при импорте.
+	Replace(ctx context.Context, owner string, rules []FilterRule) error
+}
+
+// VacationRepository хранит настройки автоответа и адресатов, которым
+// он уже отправлен.
+type VacationRepository interface {
+	// Get возвращает выключенный автоответ, если пользователь его не настраивал.
+	Get(ctx context.Context, owner string) (Vacation, error)
+	// Save сохраняет настройки и забывает, кому уже был отправлен ответ.
+	Save(ctx context.Context, vacation Vacation) error
+	// MarkReplied отмечает ответ адресату sender в момент now, если
+	// предыдущий был раньше since, и сообщает, нужно ли отвечать.
+	MarkReplied(ctx context.Context, owner string, sender string, now time.Time, since time.Time) (bool, error)
+}
+
+// SpamRepository хранит корпуса спам-фильтра пользователей и общий
+// корпус с владельцем GlobalCorpus.
+type SpamRepository interface {
+	// Corpus возвращает размеры корпуса и счётчики токенов tokens,
+	// отсутствующих токенов в Tokens нет.
+	Corpus(ctx context.Context, owner string, tokens []string) (SpamCorpus, error)
+	// Train добавляет к корпусу письмо с токенами tokens (delta = 1) или
+	// убирает его (delta = -1). Счётчики не уходят ниже нуля.
+	Train(ctx context.Context, owner string, tokens []string, spam bool, delta int) error
+}
+
+// DKIMRepository хранит ключи DKIM доменов. Имя домена сравнивается без
+// учёта регистра.
+type DKIMRepository interface {
+	// List возвращает ключи домена, новые первыми.
+	List(ctx context.Context, domain string) ([]DKIMKey, error)
+	// Active возвращает ключ, которым подписываются письма домена.
+	Active(ctx context.Context, domain string) (DKIMKey, error)
+	// Rotate сохраняет новый ключ активным, прежний активный ключ
+	// остаётся в списке. Занятый селектор - ErrDKIMKeyExists.
+	Rotate(ctx context.Context, key DKIMKey) (int64, error)
+	// Delete удаляет неактивный ключ, для активного - ErrDKIMKeyActive.
+	Delete(ctx context.Context, domain string, id int64) error
+}
+
+// SessionRepository хранит сессии. Истёкшие сессии не возвращаются, как
+// будто их нет, и удаляются DeleteExpired.
+type SessionRepository interface {
+	// Create сохраняет сессию и возвращает её ID.
+	Create(ctx context.Context, session Session) (int64, error)
+	Get(ctx context.Context, hash string) (Session, error)
+	// List возвращает действующие сессии пользователя, недавние первыми.
+	List(ctx context.Context, email string) ([]Session, error)
+	// Touch отмечает запрос по сессии и продлевает её до expiresAt.
+	Touch(ctx context.Context, hash string, ip string, lastSeen time.Time, expiresAt time.Time) error
+	Delete(ctx context.Context, hash string) error
+	// DeleteByID удаляет сессию пользователя, чужая - ErrSessionNotFound.
+	DeleteByID(ctx context.Context, email string, id int64) error
+	// DeleteByEmail удаляет все сессии пользователя, кроме except.
+	DeleteByEmail(ctx context.Context, email string, except string) error
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+// TwoFactorRepository хранит TOTP и коды восстановления. Коды хранятся
+// хэшами и удаляются при использовании.
+type TwoFactorRepository interface {
+	Get(ctx context.Context, email string) (TwoFactor, error)
+	Save(ctx context.Context, twoFactor TwoFactor) error
+	// Delete отключает 2FA и удаляет коды восстановления.
+	Delete(ctx context.Context, email string) error
+	// UseStep принимает шаг TOTP, только если он позже последнего
+	// принятого: один код нельзя использовать дважды.
+	UseStep(ctx context.Context, email string, step int64) (bool, error)
+	// SetRecoveryCodes заменяет все коды восстановления новыми.
+	SetRecoveryCodes(ctx context.Context, email string, hashes []string) error
+	// UseRecoveryCode удаляет код и сообщает, был ли он.
+	UseRecoveryCode(ctx context.Context, email string, hash string) (bool, error)
+	CountRecoveryCodes(ctx context.Context, email string) (int, error)
+}
+
+// WebAuthnRepository хранит ключи WebAuthn. У пользователя их может
+// быть несколько.
+type WebAuthnRepository interface {
+	// List возвращает ключи пользователя в порядке добавления.
+	List(ctx context.Context, email string) ([]WebAuthnCredential, error)
+	Get(ctx context.Context, id []byte) (WebAuthnCredential, error)
+	Create(ctx context.Context, credential WebAuthnCredential) error
+	// Update сохраняет Data и LastUsedAt после входа.
+	Update(ctx context.Context, credential WebAuthnCredential) error
+	Delete(ctx context.Context, email string, id []byte) error
+}
+
+// OutboundRepository - персистентная очередь исходящей почты.
+type OutboundRepository interface {
+	Enqueue(ctx context.Context, message OutboundMessage) (int64, error)
+	// ClaimDue забирает готовые к отправке записи, увеличивает им счётчик
+	// попыток и откладывает следующую попытку на lease, чтобы запись не
+	// взял другой обработчик и не потерялась при падении процесса.
+	ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]OutboundMessage, error)
+	Reschedule(ctx context.Context, id int64, recipients []string, next time.Time, lastError string) error
+	Complete(ctx context.Context, id int64, status string, lastError string) error
+}
+
+// Repositories собирает все хранилища, которые нужны серверу.
+type Repositories struct {
+	Users     UserRepository
+	Sessions  SessionRepository
+	Messages  MessageRepository
+	Folders   FolderRepository
+	Labels    LabelRepository
+	Filters   FilterRepository
+	Vacation  VacationRepository
+	Spam      SpamRepository
+	DKIM      DKIMRepository
+	TwoFactor TwoFactorRepository
+	WebAuthn  WebAuthnRepository
+	Outbound  OutboundRepository
+}
@@ -0,0 +1,18 @@
+package database
+
+import "time"
+
+// Session - вход пользователя с одного устройства. Hash хранится в куке
+// и наружу не отдаётся, в списке сессий их различают по ID.
+type Session struct {
+	ID         int64
+	Hash       string
+	Email      string
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	// ExpiresAt сдвигается при каждом запросе, но не дальше срока жизни
+	// сессии от CreatedAt.
+	ExpiresAt time.Time
+}
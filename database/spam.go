@@ -0,0 +1,26 @@
+package database
+
+// Ключевые слова IMAP, которыми помечаются письма после ручной
+// классификации. По ним видно, на каком классе письмо уже обучало
+// фильтр, чтобы при смене решения его можно было разучить.
+const (
+	FlagJunk    = `$Junk`
+	FlagNotJunk = `$NotJunk`
+)
+
+// GlobalCorpus - владелец общего для всех пользователей корпуса спам-фильтра.
+const GlobalCorpus = ""
+
+// TokenCounts - в скольких спамных и нормальных письмах встречался токен.
+type TokenCounts struct {
+	Spam int
+	Ham  int
+}
+
+// SpamCorpus - статистика обучения байесовского фильтра: число писем
+// каждого класса и счётчики запрошенных токенов.
+type SpamCorpus struct {
+	Spam   int
+	Ham    int
+	Tokens map[string]TokenCounts
+}
@@ -1,11 +1,1381 @@
 package database
 
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
 type User struct {
-	Name     string 
-	Email    string 
+	Name     string
+	Email    string
 	Password string
+	// Pending - адрес ещё не подтверждён, доступ к ящику ограничен.
+	Pending bool
+}
+
+// UserStore хранит пользователей в памяти, ключ - email.
+type UserStore struct {
+	mu    sync.RWMutex
+	users map[string]User
+}
+
+func NewUserStore() *UserStore {
+	return &UserStore{users: make(map[string]User)}
+}
+
+func (s *UserStore) Create(ctx context.Context, user User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[user.Email]; ok {
+		return ErrUserExists
+	}
+	s.users[user.Email] = user
+	return nil
+}
+
+func (s *UserStore) GetByEmail(ctx context.Context, email string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.users[email]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (s *UserStore) Update(ctx context.Context, user User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[user.Email]; !ok {
+		return ErrUserNotFound
+	}
+	s.users[user.Email] = user
+	return nil
+}
+
+// SessionStore хранит сессии в памяти, ключ - хэш из куки.
+type SessionStore struct {
+	mu       sync.RWMutex
+	lastID   int64
+	sessions map[string]Session
+}
+
+func NewSessionStore() *SessionStore {
+	return &SessionStore{sessions: make(map[string]Session)}
+}
+
+func (s *SessionStore) Create(ctx context.Context, session Session) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	session.ID = s.lastID
+	s.sessions[session.Hash] = session
+	return session.ID, nil
+}
+
+func (s *SessionStore) Get(ctx context.Context, hash string) (Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session, ok := s.sessions[hash]
+	if !ok || !time.Now().Before(session.ExpiresAt) {
+		return Session{}, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+func (s *SessionStore) List(ctx context.Context, email string) ([]Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now()
+	result := make([]Session, 0)
+	for _, session := range s.sessions {
+		if session.Email == email && now.Before(session.ExpiresAt) {
+			result = append(result, session)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].LastSeenAt.Equal(result[j].LastSeenAt) {
+			return result[i].LastSeenAt.After(result[j].LastSeenAt)
+		}
+		return result[i].ID > result[j].ID
+	})
+	return result, nil
+}
+
+func (s *SessionStore) Touch(ctx context.Context, hash string, ip string, lastSeen time.Time, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[hash]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	session.IP = ip
+	session.LastSeenAt = lastSeen
+	session.ExpiresAt = expiresAt
+	s.sessions[hash] = session
+	return nil
+}
+
+func (s *SessionStore) Delete(ctx context.Context, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, hash)
+	return nil
+}
+
+func (s *SessionStore) DeleteByID(ctx context.Context, email string, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, session := range s.sessions {
+		if session.ID == id && session.Email == email {
+			delete(s.sessions, hash)
+			return nil
+		}
+	}
+	return ErrSessionNotFound
+}
+
+func (s *SessionStore) DeleteByEmail(ctx context.Context, email string, except string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, session := range s.sessions {
+		if session.Email == email && hash != except {
+			delete(s.sessions, hash)
+		}
+	}
+	return nil
+}
+
+func (s *SessionStore) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var deleted int64
+	for hash, session := range s.sessions {
+		if !now.Before(session.ExpiresAt) {
+			delete(s.sessions, hash)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// MessageStore хранит письма всех ящиков в памяти, ключ - ID письма.
+type MessageStore struct {
+	mu       sync.RWMutex
+	lastID   int64
+	messages map[int64]Message
+	raw      map[int64][]byte
+	// labels задаётся NewLabelStore и нужен, чтобы не ставить чужие метки
+	labels *LabelStore
+}
+
+func NewMessageStore() *MessageStore {
+	return &MessageStore{messages: make(map[int64]Message), raw: make(map[int64][]byte)}
+}
+
+func (s *MessageStore) Create(ctx context.Context, message Message) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	message.ID = s.lastID
+	now := time.Now()
+	if message.Date.IsZero() {
+		message.Date = now
+	}
+	if message.ReceivedAt.IsZero() {
+		message.ReceivedAt = now
+	}
+	message.UpdatedAt = now
+	message.Version = 1
+	if message.ThreadID == 0 {
+		message.ThreadID = message.ID
+	}
+	message.Labels, _ = s.applyLabels(message.Owner, nil, message.Labels, nil)
+	if message.Raw != nil {
+		s.raw[message.ID] = append([]byte(nil), message.Raw...)
+		message.Raw = nil
+	}
+	s.messages[message.ID] = message.clone()
+	return message.ID, nil
+}
+
+func (s *MessageStore) GetByID(ctx context.Context, owner string, id int64) (Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	message, ok := s.messages[id]
+	if !ok || message.Owner != owner {
+		return Message{}, ErrMessageNotFound
+	}
+	return message.clone(), nil
+}
+
+func (s *MessageStore) GetRaw(ctx context.Context, owner string, id int64) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	message, ok := s.messages[id]
+	if !ok || message.Owner != owner {
+		return nil, ErrMessageNotFound
+	}
+	return append([]byte(nil), s.raw[id]...), nil
+}
+
+func (s *MessageStore) ListByFolder(ctx context.Context, owner string, folderID int64) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]Message, 0)
+	for _, message := range s.messages {
+		if message.Owner == owner && message.FolderID == folderID {
+			result = append(result, message.clone())
+		}
+	}
+	sortByDate(result)
+	return result, nil
+}
+
+func (s *MessageStore) List(ctx context.Context, owner string, query ListQuery) (MessagePage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	messages := make([]Message, 0)
+	for _, message := range s.messages {
+		if message.Owner == owner {
+			messages = append(messages, message.clone())
+		}
+	}
+	return query.Page(messages), nil
+}
+
+func (s *MessageStore) Update(ctx context.Context, message Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.messages[message.ID]
+	if !ok || stored.Owner != message.Owner {
+		return ErrMessageNotFound
+	}
+	if stored.Version != message.Version {
+		return ErrVersionConflict
+	}
+	message.UpdatedAt = time.Now()
+	message.Version++
+	message.Labels = stored.Labels
+	if message.Raw != nil {
+		s.raw[message.ID] = append([]byte(nil), message.Raw...)
+		message.Raw = nil
+	}
+	s.messages[message.ID] = message.clone()
+	return nil
+}
+
+func (s *MessageStore) Delete(ctx context.Context, owner string, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.messages[id]
+	if !ok || stored.Owner != owner {
+		return ErrMessageNotFound
+	}
+	delete(s.messages, id)
+	delete(s.raw, id)
+	return nil
+}
+
+func (s *MessageStore) UpdateFlags(ctx context.Context, owner string, ids []int64, add []string, remove []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := 0
+	now := time.Now()
+	for _, id := range ids {
+		message, ok := s.messages[id]
+		if !ok || message.Owner != owner {
+			continue
+		}
+		found++
+		flags, changed := ApplyFlags(message.Flags, add, remove)
+		if !changed {
+			continue
+		}
+		message.Flags = flags
+		message.UpdatedAt = now
+		message.Version++
+		s.messages[id] = message
+	}
+	return found, nil
+}
+
+// applyLabels ставит и снимает метки, пропуская чужие и несуществующие.
+func (s *MessageStore) applyLabels(owner string, labels []int64, add []int64, remove []int64) ([]int64, bool) {
+	set := make(map[int64]bool, len(labels)+len(add))
+	for _, id := range labels {
+		set[id] = true
+	}
+	changed := false
+	for _, id := range add {
+		if !set[id] && s.labels != nil && s.labels.owns(owner, id) {
+			set[id] = true
+			changed = true
+		}
+	}
+	for _, id := range remove {
+		if set[id] {
+			delete(set, id)
+			changed = true
+		}
+	}
+	result := make([]int64, 0, len(set))
+	for id := range set {
+		result = append(result, id)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result, changed
+}
+
+func (s *MessageStore) UpdateLabels(ctx context.Context, owner string, ids []int64, add []int64, remove []int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := 0
+	for _, id := range ids {
+		message, ok := s.messages[id]
+		if !ok || message.Owner != owner {
+			continue
+		}
+		found++
+		if labels, changed := s.applyLabels(owner, message.Labels, add, remove); changed {
+			message.Labels = labels
+			s.messages[id] = message
+		}
+	}
+	return found, nil
+}
+
+// countLabel считает письма с меткой и непрочитанные среди них.
+func (s *MessageStore) countLabel(owner string, labelID int64) (total int, unread int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, message := range s.messages {
+		if message.Owner != owner || !message.HasLabel(labelID) {
+			continue
+		}
+		total++
+		if !message.HasFlag(FlagSeen) {
+			unread++
+		}
+	}
+	return total, unread
+}
+
+// dropLabel снимает удалённую метку со всех писем.
+func (s *MessageStore) dropLabel(owner string, labelID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, message := range s.messages {
+		if message.Owner == owner && message.HasLabel(labelID) {
+			message.Labels, _ = s.applyLabels(owner, message.Labels, nil, []int64{labelID})
+			s.messages[id] = message
+		}
+	}
+}
+
+func (s *MessageStore) Move(ctx context.Context, owner string, ids []int64, folderID int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	moved := 0
+	now := time.Now()
+	for _, id := range ids {
+		message, ok := s.messages[id]
+		if !ok || message.Owner != owner {
+			continue
+		}
+		message.FolderID = folderID
+		message.UpdatedAt = now
+		message.Version++
+		s.messages[id] = message
+		moved++
+	}
+	return moved, nil
+}
+
+func (s *MessageStore) ListByThread(ctx context.Context, owner string, threadID int64) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]Message, 0)
+	for _, message := range s.messages {
+		if message.Owner == owner && message.ThreadID == threadID {
+			result = append(result, message.clone())
+		}
+	}
+	sortByDate(result)
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result, nil
+}
+
+func (s *MessageStore) ListThreadsByFolder(ctx context.Context, owner string, folderID int64) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	threads := make(map[int64]bool)
+	for _, message := range s.messages {
+		if message.Owner == owner && message.FolderID == folderID {
+			threads[message.ThreadID] = true
+		}
+	}
+	result := make([]Message, 0)
+	for _, message := range s.messages {
+		if message.Owner == owner && threads[message.ThreadID] {
+			result = append(result, message.clone())
+		}
+	}
+	sortByDate(result)
+	return result, nil
+}
+
+func (s *MessageStore) ListByMessageIDs(ctx context.Context, owner string, messageIDs []string) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	wanted := make(map[string]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		wanted[id] = true
+	}
+	result := make([]Message, 0)
+	for _, message := range s.messages {
+		if message.Owner == owner && message.MessageID != "" && wanted[message.MessageID] {
+			result = append(result, message.clone())
+		}
+	}
+	sortByDate(result)
+	return result, nil
+}
+
+func (s *MessageStore) ListReferencing(ctx context.Context, owner string, messageID string) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]Message, 0)
+	for _, message := range s.messages {
+		if message.Owner != owner {
+			continue
+		}
+		for _, ref := range message.References {
+			if ref == messageID {
+				result = append(result, message.clone())
+				break
+			}
+		}
+	}
+	sortByDate(result)
+	return result, nil
+}
+
+func (s *MessageStore) FindThreadBySubject(ctx context.Context, owner string, baseSubject string, since time.Time) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var found Message
+	for _, message := range s.messages {
+		if message.Owner != owner || message.Date.Before(since) {
+			continue
+		}
+		if base, _ := BaseSubject(message.Subject); base != baseSubject {
+			continue
+		}
+		if found.ID == 0 || message.Date.After(found.Date) {
+			found = message
+		}
+	}
+	return found.ThreadID, nil
+}
+
+func (s *MessageStore) MergeThreads(ctx context.Context, owner string, from []int64, to int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	merged := make(map[int64]bool, len(from))
+	for _, id := range from {
+		merged[id] = true
+	}
+	for id, message := range s.messages {
+		if message.Owner == owner && merged[message.ThreadID] {
+			message.ThreadID = to
+			s.messages[id] = message
+		}
+	}
+	return nil
+}
+
+// countFolder возвращает общее число писем в папке и число непрочитанных.
+func (s *MessageStore) countFolder(owner string, folderID int64) (total int, unread int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, message := range s.messages {
+		if message.Owner != owner || message.FolderID != folderID {
+			continue
+		}
+		total++
+		if !message.HasFlag(FlagSeen) {
+			unread++
+		}
+	}
+	return total, unread
+}
+
+// moveFolders переносит все письма из папок from в папку to.
+func (s *MessageStore) moveFolders(owner string, from map[int64]bool, to int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, message := range s.messages {
+		if message.Owner == owner && from[message.FolderID] {
+			message.FolderID = to
+			message.UpdatedAt = now
+			message.Version++
+			s.messages[id] = message
+		}
+	}
+}
+
+// sortByDate упорядочивает письма от новых к старым, при равных датах
+// более позднее по ID идёт первым.
+func sortByDate(messages []Message) {
+	sort.Slice(messages, func(i, j int) bool {
+		if !messages[i].Date.Equal(messages[j].Date) {
+			return messages[i].Date.After(messages[j].Date)
+		}
+		return messages[i].ID > messages[j].ID
+	})
+}
+
+// FolderStore хранит папки в памяти. Счётчики писем берутся из MessageStore.
+type FolderStore struct {
+	mu       sync.RWMutex
+	lastID   int64
+	folders  map[int64]Folder
+	messages *MessageStore
+}
+
+func NewFolderStore(messages *MessageStore) *FolderStore {
+	return &FolderStore{folders: make(map[int64]Folder), messages: messages}
+}
+
+// ensureSystemFolders создаёт недостающие системные папки, вызывается под s.mu.
+func (s *FolderStore) ensureSystemFolders(owner string) {
+	existing := make(map[string]bool)
+	for _, folder := range s.folders {
+		if folder.Owner == owner && folder.IsSystem() {
+			existing[folder.System] = true
+		}
+	}
+	for _, system := range SystemFolders {
+		if existing[system] {
+			continue
+		}
+		s.lastID++
+		s.folders[s.lastID] = Folder{ID: s.lastID, Owner: owner, Name: system, System: system}
+	}
+}
+
+func (s *FolderStore) withCounters(folder Folder) Folder {
+	folder.Total, folder.Unread = s.messages.countFolder(folder.Owner, folder.ID)
+	return folder
+}
+
+func (s *FolderStore) List(ctx context.Context, owner string) ([]Folder, error) {
+	s.mu.Lock()
+	s.ensureSystemFolders(owner)
+	result := make([]Folder, 0)
+	for _, folder := range s.folders {
+		if folder.Owner == owner {
+			result = append(result, folder)
+		}
+	}
+	s.mu.Unlock()
+
+	for i := range result {
+		result[i] = s.withCounters(result[i])
+	}
+	sortFolders(result)
+	return result, nil
+}
+
+func (s *FolderStore) GetByID(ctx context.Context, owner string, id int64) (Folder, error) {
+	s.mu.Lock()
+	s.ensureSystemFolders(owner)
+	folder, ok := s.folders[id]
+	s.mu.Unlock()
+	if !ok || folder.Owner != owner {
+		return Folder{}, ErrFolderNotFound
+	}
+	return s.withCounters(folder), nil
+}
+
+func (s *FolderStore) GetSystem(ctx context.Context, owner string, system string) (Folder, error) {
+	s.mu.Lock()
+	s.ensureSystemFolders(owner)
+	var found *Folder
+	for _, folder := range s.folders {
+		if folder.Owner == owner && folder.System == system {
+			found = &folder
+			break
+		}
+	}
+	s.mu.Unlock()
+	if found == nil {
+		return Folder{}, ErrFolderNotFound
+	}
+	return s.withCounters(*found), nil
+}
+
+// nameTaken проверяет, есть ли у родителя папка с таким именем, вызывается под s.mu.
+func (s *FolderStore) nameTaken(owner string, parentID int64, name string, except int64) bool {
+	for _, folder := range s.folders {
+		if folder.Owner == owner && folder.ParentID == parentID && folder.Name == name && folder.ID != except {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *FolderStore) Create(ctx context.Context, folder Folder) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ensureSystemFolders(folder.Owner)
+	if folder.ParentID != 0 {
+		parent, ok := s.folders[folder.ParentID]
+		if !ok || parent.Owner != folder.Owner {
+			return 0, ErrFolderNotFound
+		}
+	}
+	if s.nameTaken(folder.Owner, folder.ParentID, folder.Name, 0) {
+		return 0, ErrFolderExists
+	}
+	s.lastID++
+	folder.ID = s.lastID
+	folder.System = ""
+	folder.Total, folder.Unread = 0, 0
+	s.folders[folder.ID] = folder
+	return folder.ID, nil
 }
 
-var UserDB = make(map[string]User) //найти user по email
+func (s *FolderStore) Rename(ctx context.Context, owner string, id int64, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	folder, ok := s.folders[id]
+	if !ok || folder.Owner != owner {
+		return ErrFolderNotFound
+	}
+	if folder.IsSystem() {
+		return ErrSystemFolder
+	}
+	if s.nameTaken(owner, folder.ParentID, name, id) {
+		return ErrFolderExists
+	}
+	folder.Name = name
+	s.folders[id] = folder
+	return nil
+}
+
+func (s *FolderStore) Delete(ctx context.Context, owner string, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ensureSystemFolders(owner)
+	folder, ok := s.folders[id]
+	if !ok || folder.Owner != owner {
+		return ErrFolderNotFound
+	}
+	if folder.IsSystem() {
+		return ErrSystemFolder
+	}
+
+	subtree := map[int64]bool{id: true}
+	for changed := true; changed; {
+		changed = false
+		for _, f := range s.folders {
+			if f.Owner == owner && subtree[f.ParentID] && !subtree[f.ID] {
+				subtree[f.ID] = true
+				changed = true
+			}
+		}
+	}
+
+	var trashID int64
+	for _, f := range s.folders {
+		if f.Owner == owner && f.System == FolderTrash {
+			trashID = f.ID
+		}
+	}
+	s.messages.moveFolders(owner, subtree, trashID)
+	for folderID := range subtree {
+		delete(s.folders, folderID)
+	}
+	return nil
+}
+
+// sortFolders ставит системные папки в фиксированном порядке,
+// затем пользовательские по имени.
+func sortFolders(folders []Folder) {
+	sort.Slice(folders, func(i, j int) bool {
+		oi, oj := systemFolderOrder(folders[i].System), systemFolderOrder(folders[j].System)
+		if oi != oj {
+			return oi < oj
+		}
+		if folders[i].Name != folders[j].Name {
+			return folders[i].Name < folders[j].Name
+		}
+		return folders[i].ID < folders[j].ID
+	})
+}
 
-var UserHash = make(map[string]string) //найти email gо хэшу
\ No newline at end of file
+// OutboundStore - очередь исходящей почты в памяти.
+type OutboundStore struct {
+	mu       sync.Mutex
+	lastID   int64
+	messages map[int64]OutboundMessage
+}
+
+func NewOutboundStore() *OutboundStore {
+	return &OutboundStore{messages: make(map[int64]OutboundMessage)}
+}
+
+func (s *OutboundStore) Enqueue(ctx context.Context, message OutboundMessage) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	message.ID = s.lastID
+	message.Status = OutboundPending
+	message.Recipients = append([]string(nil), message.Recipients...)
+	if message.CreatedAt.IsZero() {
+		message.CreatedAt = time.Now()
+	}
+	if message.NextAttemptAt.IsZero() {
+		message.NextAttemptAt = message.CreatedAt
+	}
+	s.messages[message.ID] = message
+	return message.ID, nil
+}
+
+func (s *OutboundStore) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]OutboundMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	due := make([]OutboundMessage, 0)
+	for _, message := range s.messages {
+		if message.Status == OutboundPending && !message.NextAttemptAt.After(now) {
+			due = append(due, message)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+		}
+		return due[i].ID < due[j].ID
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	for i := range due {
+		due[i].Attempts++
+		due[i].NextAttemptAt = now.Add(lease)
+		s.messages[due[i].ID] = due[i]
+		due[i].Recipients = append([]string(nil), due[i].Recipients...)
+	}
+	return due, nil
+}
+
+func (s *OutboundStore) Reschedule(ctx context.Context, id int64, recipients []string, next time.Time, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	message, ok := s.messages[id]
+	if !ok {
+		return ErrOutboundNotFound
+	}
+	message.Recipients = append([]string(nil), recipients...)
+	message.NextAttemptAt = next
+	message.LastError = lastError
+	s.messages[id] = message
+	return nil
+}
+
+func (s *OutboundStore) Complete(ctx context.Context, id int64, status string, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	message, ok := s.messages[id]
+	if !ok {
+		return ErrOutboundNotFound
+	}
+	message.Status = status
+	message.LastError = lastError
+	s.messages[id] = message
+	return nil
+}
+
+// Get возвращает запись очереди, нужен для проверок в тестах.
+func (s *OutboundStore) Get(id int64) (OutboundMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	message, ok := s.messages[id]
+	return message, ok
+}
+
+// LabelStore хранит метки в памяти, назначения меток хранятся в письмах
+// MessageStore. Чтобы не было взаимной блокировки, LabelStore обращается
+// к MessageStore, только отпустив свой мьютекс.
+type LabelStore struct {
+	mu       sync.RWMutex
+	lastID   int64
+	labels   map[int64]Label
+	messages *MessageStore
+}
+
+func NewLabelStore(messages *MessageStore) *LabelStore {
+	s := &LabelStore{labels: make(map[int64]Label), messages: messages}
+	messages.labels = s
+	return s
+}
+
+func (s *LabelStore) owns(owner string, id int64) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	label, ok := s.labels[id]
+	return ok && label.Owner == owner
+}
+
+func (s *LabelStore) withCounters(label Label) Label {
+	label.Total, label.Unread = s.messages.countLabel(label.Owner, label.ID)
+	return label
+}
+
+func (s *LabelStore) List(ctx context.Context, owner string) ([]Label, error) {
+	s.mu.RLock()
+	result := make([]Label, 0)
+	for _, label := range s.labels {
+		if label.Owner == owner {
+			result = append(result, label)
+		}
+	}
+	s.mu.RUnlock()
+
+	for i := range result {
+		result[i] = s.withCounters(result[i])
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+func (s *LabelStore) GetByID(ctx context.Context, owner string, id int64) (Label, error) {
+	s.mu.RLock()
+	label, ok := s.labels[id]
+	s.mu.RUnlock()
+	if !ok || label.Owner != owner {
+		return Label{}, ErrLabelNotFound
+	}
+	return s.withCounters(label), nil
+}
+
+// nameTaken проверяет, есть ли у владельца метка с таким именем, вызывается под s.mu.
+func (s *LabelStore) nameTaken(owner string, name string, except int64) bool {
+	for _, label := range s.labels {
+		if label.Owner == owner && label.Name == name && label.ID != except {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *LabelStore) Create(ctx context.Context, label Label) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.nameTaken(label.Owner, label.Name, 0) {
+		return 0, ErrLabelExists
+	}
+	s.lastID++
+	label.ID = s.lastID
+	label.Total, label.Unread = 0, 0
+	s.labels[label.ID] = label
+	return label.ID, nil
+}
+
+func (s *LabelStore) Update(ctx context.Context, label Label) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.labels[label.ID]
+	if !ok || stored.Owner != label.Owner {
+		return ErrLabelNotFound
+	}
+	if s.nameTaken(label.Owner, label.Name, label.ID) {
+		return ErrLabelExists
+	}
+	stored.Name = label.Name
+	stored.Color = label.Color
+	s.labels[label.ID] = stored
+	return nil
+}
+
+func (s *LabelStore) Delete(ctx context.Context, owner string, id int64) error {
+	s.mu.Lock()
+	label, ok := s.labels[id]
+	if ok && label.Owner == owner {
+		delete(s.labels, id)
+	}
+	s.mu.Unlock()
+	if !ok || label.Owner != owner {
+		return ErrLabelNotFound
+	}
+	s.messages.dropLabel(owner, id)
+	return nil
+}
+
+// FilterStore хранит правила фильтрации в памяти.
+type FilterStore struct {
+	mu     sync.RWMutex
+	lastID int64
+	rules  map[int64]FilterRule
+}
+
+func NewFilterStore() *FilterStore {
+	return &FilterStore{rules: make(map[int64]FilterRule)}
+}
+
+func (s *FilterStore) List(ctx context.Context, owner string) ([]FilterRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]FilterRule, 0)
+	for _, rule := range s.rules {
+		if rule.Owner == owner {
+			result = append(result, rule.clone())
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Position != result[j].Position {
+			return result[i].Position < result[j].Position
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+func (s *FilterStore) GetByID(ctx context.Context, owner string, id int64) (FilterRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rule, ok := s.rules[id]
+	if !ok || rule.Owner != owner {
+		return FilterRule{}, ErrFilterNotFound
+	}
+	return rule.clone(), nil
+}
+
+// create сохраняет правило в конец списка владельца, вызывается под s.mu.
+func (s *FilterStore) create(rule FilterRule) int64 {
+	rule.Position = 0
+	for _, stored := range s.rules {
+		if stored.Owner == rule.Owner && stored.Position >= rule.Position {
+			rule.Position = stored.Position + 1
+		}
+	}
+	s.lastID++
+	rule.ID = s.lastID
+	s.rules[rule.ID] = rule.clone()
+	return rule.ID
+}
+
+func (s *FilterStore) Create(ctx context.Context, rule FilterRule) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.create(rule), nil
+}
+
+func (s *FilterStore) Update(ctx context.Context, rule FilterRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.rules[rule.ID]
+	if !ok || stored.Owner != rule.Owner {
+		return ErrFilterNotFound
+	}
+	rule.Position = stored.Position
+	s.rules[rule.ID] = rule.clone()
+	return nil
+}
+
+func (s *FilterStore) Delete(ctx context.Context, owner string, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rule, ok := s.rules[id]
+	if !ok || rule.Owner != owner {
+		return ErrFilterNotFound
+	}
+	delete(s.rules, id)
+	return nil
+}
+
+func (s *FilterStore) Reorder(ctx context.Context, owner string, ids []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		if rule, ok := s.rules[id]; !ok || rule.Owner != owner {
+			return ErrFilterNotFound
+		}
+	}
+	for i, id := range ids {
+		rule := s.rules[id]
+		rule.Position = i
+		s.rules[id] = rule
+	}
+	return nil
+}
+
+func (s *FilterStore) Replace(ctx context.Context, owner string, rules []FilterRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, rule := range s.rules {
+		if rule.Owner == owner {
+			delete(s.rules, id)
+		}
+	}
+	for _, rule := range rules {
+		rule.Owner = owner
+		s.create(rule)
+	}
+	return nil
+}
+
+// VacationStore хранит автоответы в памяти. Адреса в replies приводятся
+// к нижнему регистру.
+type VacationStore struct {
+	mu        sync.Mutex
+	vacations map[string]Vacation
+	replies   map[string]map[string]time.Time
+}
+
+func NewVacationStore() *VacationStore {
+	return &VacationStore{
+		vacations: make(map[string]Vacation),
+		replies:   make(map[string]map[string]time.Time),
+	}
+}
+
+func (s *VacationStore) Get(ctx context.Context, owner string) (Vacation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vacation, ok := s.vacations[owner]
+	if !ok {
+		return Vacation{Owner: owner, Days: DefaultVacationDays}, nil
+	}
+	return vacation, nil
+}
+
+func (s *VacationStore) Save(ctx context.Context, vacation Vacation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vacations[vacation.Owner] = vacation
+	delete(s.replies, vacation.Owner)
+	return nil
+}
+
+func (s *VacationStore) MarkReplied(ctx context.Context, owner string, sender string, now time.Time, since time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sender = strings.ToLower(sender)
+	if last, ok := s.replies[owner][sender]; ok && !last.Before(since) {
+		return false, nil
+	}
+	if s.replies[owner] == nil {
+		s.replies[owner] = make(map[string]time.Time)
+	}
+	s.replies[owner][sender] = now
+	return true, nil
+}
+
+// SpamStore хранит корпуса спам-фильтра в памяти.
+type SpamStore struct {
+	mu      sync.RWMutex
+	corpora map[string]*SpamCorpus
+}
+
+func NewSpamStore() *SpamStore {
+	return &SpamStore{corpora: make(map[string]*SpamCorpus)}
+}
+
+func (s *SpamStore) Corpus(ctx context.Context, owner string, tokens []string) (SpamCorpus, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := SpamCorpus{Tokens: make(map[string]TokenCounts)}
+	corpus, ok := s.corpora[owner]
+	if !ok {
+		return result, nil
+	}
+	result.Spam, result.Ham = corpus.Spam, corpus.Ham
+	for _, token := range tokens {
+		if counts, ok := corpus.Tokens[token]; ok {
+			result.Tokens[token] = counts
+		}
+	}
+	return result, nil
+}
+
+func (s *SpamStore) Train(ctx context.Context, owner string, tokens []string, spam bool, delta int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	corpus, ok := s.corpora[owner]
+	if !ok {
+		corpus = &SpamCorpus{Tokens: make(map[string]TokenCounts)}
+		s.corpora[owner] = corpus
+	}
+	add := func(v int) int { return max(v+delta, 0) }
+	if spam {
+		corpus.Spam = add(corpus.Spam)
+	} else {
+		corpus.Ham = add(corpus.Ham)
+	}
+	for _, token := range tokens {
+		counts := corpus.Tokens[token]
+		if spam {
+			counts.Spam = add(counts.Spam)
+		} else {
+			counts.Ham = add(counts.Ham)
+		}
+		if counts.Spam == 0 && counts.Ham == 0 {
+			delete(corpus.Tokens, token)
+			continue
+		}
+		corpus.Tokens[token] = counts
+	}
+	return nil
+}
+
+// DKIMStore хранит ключи DKIM в памяти.
+type DKIMStore struct {
+	mu     sync.RWMutex
+	keys   []DKIMKey
+	nextID int64
+}
+
+func NewDKIMStore() *DKIMStore {
+	return &DKIMStore{nextID: 1}
+}
+
+func (s *DKIMStore) List(ctx context.Context, domain string) ([]DKIMKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]DKIMKey, 0)
+	for i := len(s.keys) - 1; i >= 0; i-- {
+		if strings.EqualFold(s.keys[i].Domain, domain) {
+			result = append(result, s.keys[i])
+		}
+	}
+	return result, nil
+}
+
+func (s *DKIMStore) Active(ctx context.Context, domain string) (DKIMKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range s.keys {
+		if key.Active && strings.EqualFold(key.Domain, domain) {
+			return key, nil
+		}
+	}
+	return DKIMKey{}, ErrDKIMKeyNotFound
+}
+
+func (s *DKIMStore) Rotate(ctx context.Context, key DKIMKey) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key.Domain = strings.ToLower(key.Domain)
+	for _, existing := range s.keys {
+		if existing.Domain == key.Domain && strings.EqualFold(existing.Selector, key.Selector) {
+			return 0, ErrDKIMKeyExists
+		}
+	}
+	for i := range s.keys {
+		if s.keys[i].Domain == key.Domain {
+			s.keys[i].Active = false
+		}
+	}
+	key.ID = s.nextID
+	key.Active = true
+	s.nextID++
+	s.keys = append(s.keys, key)
+	return key.ID, nil
+}
+
+func (s *DKIMStore) Delete(ctx context.Context, domain string, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, key := range s.keys {
+		if key.ID != id || !strings.EqualFold(key.Domain, domain) {
+			continue
+		}
+		if key.Active {
+			return ErrDKIMKeyActive
+		}
+		s.keys = append(s.keys[:i], s.keys[i+1:]...)
+		return nil
+	}
+	return ErrDKIMKeyNotFound
+}
+
+// TwoFactorStore хранит настройки 2FA в памяти, ключ - email.
+type TwoFactorStore struct {
+	mu       sync.Mutex
+	settings map[string]TwoFactor
+	codes    map[string]map[string]bool
+}
+
+func NewTwoFactorStore() *TwoFactorStore {
+	return &TwoFactorStore{settings: make(map[string]TwoFactor), codes: make(map[string]map[string]bool)}
+}
+
+func (s *TwoFactorStore) Get(ctx context.Context, email string) (TwoFactor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	twoFactor, ok := s.settings[email]
+	if !ok {
+		return TwoFactor{}, ErrTwoFactorNotFound
+	}
+	return twoFactor, nil
+}
+
+func (s *TwoFactorStore) Save(ctx context.Context, twoFactor TwoFactor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings[twoFactor.Email] = twoFactor
+	return nil
+}
+
+func (s *TwoFactorStore) Delete(ctx context.Context, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.settings, email)
+	delete(s.codes, email)
+	return nil
+}
+
+func (s *TwoFactorStore) UseStep(ctx context.Context, email string, step int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	twoFactor, ok := s.settings[email]
+	if !ok {
+		return false, ErrTwoFactorNotFound
+	}
+	if step <= twoFactor.LastStep {
+		return false, nil
+	}
+	twoFactor.LastStep = step
+	s.settings[email] = twoFactor
+	return true, nil
+}
+
+func (s *TwoFactorStore) SetRecoveryCodes(ctx context.Context, email string, hashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	codes := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		codes[hash] = true
+	}
+	s.codes[email] = codes
+	return nil
+}
+
+func (s *TwoFactorStore) UseRecoveryCode(ctx context.Context, email string, hash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.codes[email][hash] {
+		return false, nil
+	}
+	delete(s.codes[email], hash)
+	return true, nil
+}
+
+func (s *TwoFactorStore) CountRecoveryCodes(ctx context.Context, email string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.codes[email]), nil
+}
+
+// WebAuthnStore хранит ключи WebAuthn в памяти.
+type WebAuthnStore struct {
+	mu          sync.RWMutex
+	credentials []WebAuthnCredential
+}
+
+func NewWebAuthnStore() *WebAuthnStore {
+	return &WebAuthnStore{}
+}
+
+func (s *WebAuthnStore) List(ctx context.Context, email string) ([]WebAuthnCredential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]WebAuthnCredential, 0)
+	for _, credential := range s.credentials {
+		if credential.Email == email {
+			result = append(result, credential)
+		}
+	}
+	return result, nil
+}
+
+func (s *WebAuthnStore) Get(ctx context.Context, id []byte) (WebAuthnCredential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, credential := range s.credentials {
+		if bytes.Equal(credential.ID, id) {
+			return credential, nil
+		}
+	}
+	return WebAuthnCredential{}, ErrCredentialNotFound
+}
+
+func (s *WebAuthnStore) Create(ctx context.Context, credential WebAuthnCredential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.credentials {
+		if bytes.Equal(existing.ID, credential.ID) {
+			return ErrCredentialExists
+		}
+	}
+	s.credentials = append(s.credentials, credential)
+	return nil
+}
+
+func (s *WebAuthnStore) Update(ctx context.Context, credential WebAuthnCredential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, existing := range s.credentials {
+		if bytes.Equal(existing.ID, credential.ID) && existing.Email == credential.Email {
+			s.credentials[i].Data = credential.Data
+			s.credentials[i].LastUsedAt = credential.LastUsedAt
+			return nil
+		}
+	}
+	return ErrCredentialNotFound
+}
+
+func (s *WebAuthnStore) Delete(ctx context.Context, email string, id []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, existing := range s.credentials {
+		if bytes.Equal(existing.ID, id) && existing.Email == email {
+			s.credentials = append(s.credentials[:i], s.credentials[i+1:]...)
+			return nil
+		}
+	}
+	return ErrCredentialNotFound
+}
+
+func NewInMemoryRepositories() *Repositories {
+	messages := NewMessageStore()
+	return &Repositories{
+		Users:     NewUserStore(),
+		Sessions:  NewSessionStore(),
+		Messages:  NewThreadedMessages(messages),
+		Folders:   NewFolderStore(messages),
+		Labels:    NewLabelStore(messages),
+		Filters:   NewFilterStore(),
+		Vacation:  NewVacationStore(),
+		Spam:      NewSpamStore(),
+		DKIM:      NewDKIMStore(),
+		TwoFactor: NewTwoFactorStore(),
+		WebAuthn:  NewWebAuthnStore(),
+		Outbound:  NewOutboundStore(),
+	}
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUserStoreCreateTwice(t *testing.T) {
+	store := NewUserStore()
+	user := User{Name: "nick", Email: "nick@giga-mail.ru", Password: "12345"}
+	if err := store.Create(context.Background(), user); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.Create(context.Background(), user); !errors.Is(err, ErrUserExists) {
+		t.Errorf("got %v want %v", err, ErrUserExists)
+	}
+}
+
+func TestSessionStoreConcurrentAccess(t *testing.T) {
+	store := NewSessionStore()
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			hash := fmt.Sprintf("hash-%d", i)
+			store.Create(ctx, Session{Hash: hash, Email: "nick@giga-mail.ru", ExpiresAt: time.Now().Add(time.Hour)})
+			store.Get(ctx, hash)
+			store.Delete(ctx, hash)
+		}(i)
+	}
+	wg.Wait()
+	if _, err := store.Get(ctx, "hash-0"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("got %v want %v", err, ErrSessionNotFound)
+	}
+}
+
+func TestSessionStoreExpiry(t *testing.T) {
+	store := NewSessionStore()
+	ctx := context.Background()
+	now := time.Now()
+	owner := "nick@giga-mail.ru"
+	store.Create(ctx, Session{Hash: "old", Email: owner, ExpiresAt: now.Add(-time.Second)})
+	id, _ := store.Create(ctx, Session{Hash: "new", Email: owner, LastSeenAt: now, ExpiresAt: now.Add(time.Hour)})
+	if _, err := store.Get(ctx, "old"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expired session: %v", err)
+	}
+	if sessions, _ := store.List(ctx, owner); len(sessions) != 1 || sessions[0].ID != id {
+		t.Errorf("got %+v", sessions)
+	}
+	if n, err := store.DeleteExpired(ctx, now); err != nil || n != 1 {
+		t.Errorf("deleted %d, %v", n, err)
+	}
+	if err := store.DeleteByID(ctx, "other@giga-mail.ru", id); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("delete by other user: %v", err)
+	}
+}
+
+func TestMessageStoreVersionConflict(t *testing.T) {
+	ctx := context.Background()
+	store := NewMessageStore()
+	id, err := store.Create(ctx, Message{Owner: "nick@giga-mail.ru", Subject: "draft"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := store.GetByID(ctx, "nick@giga-mail.ru", id)
+	second := first
+
+	first.Subject = "first"
+	if err := store.Update(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	second.Subject = "second"
+	if err := store.Update(ctx, second); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("got %v want %v", err, ErrVersionConflict)
+	}
+	if stored, _ := store.GetByID(ctx, "nick@giga-mail.ru", id); stored.Version != 2 || stored.Subject != "first" {
+		t.Errorf("unexpected stored message: %+v", stored)
+	}
+}
+
+func TestLabelStoreDeleteDropsAssignments(t *testing.T) {
+	repo := NewInMemoryRepositories()
+	ctx := context.Background()
+	const owner = "nick@giga-mail.ru"
+	labelID, err := repo.Labels.Create(ctx, Label{Owner: owner, Name: "work"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Labels.Create(ctx, Label{Owner: owner, Name: "work"}); !errors.Is(err, ErrLabelExists) {
+		t.Errorf("got %v want %v", err, ErrLabelExists)
+	}
+	id, err := repo.Messages.Create(ctx, Message{Owner: owner, FolderID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := repo.Messages.UpdateLabels(ctx, owner, []int64{id}, []int64{labelID, 100500}, nil); err != nil || n != 1 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	message, _ := repo.Messages.GetByID(ctx, owner, id)
+	if len(message.Labels) != 1 || message.Labels[0] != labelID {
+		t.Errorf("unexpected labels: %v", message.Labels)
+	}
+
+	if err := repo.Labels.Delete(ctx, owner, labelID); err != nil {
+		t.Fatal(err)
+	}
+	message, _ = repo.Messages.GetByID(ctx, owner, id)
+	if len(message.Labels) != 0 {
+		t.Errorf("labels left after delete: %v", message.Labels)
+	}
+}
@@ -0,0 +1,204 @@
+package database
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// subjectFallbackWindow - насколько давнюю цепочку можно найти по теме,
+// если у ответа нет ни In-Reply-To, ни References.
+const subjectFallbackWindow = 30 * 24 * time.Hour
+
+var (
+	replyPrefix = regexp.MustCompile(`(?i)^(re|fwd?|aw|wg|ответ|отв|пересл)(\[\d+\]|\(\d+\))?\s*:\s*`)
+	listTag     = regexp.MustCompile(`^\[[^\]]*\]\s*`)
+)
+
+// BaseSubject убирает из темы префиксы ответа и пересылки (Re:, Fwd:,
+// Ответ: и т.п.) и метки рассылок в квадратных скобках. isReply
+// сообщает, был ли среди убранного префикс ответа или пересылки.
+func BaseSubject(subject string) (base string, isReply bool) {
+	base = strings.TrimSpace(subject)
+	for {
+		if loc := replyPrefix.FindStringIndex(base); loc != nil {
+			base = strings.TrimSpace(base[loc[1]:])
+			isReply = true
+			continue
+		}
+		if loc := listTag.FindStringIndex(base); loc != nil && loc[1] < len(base) {
+			base = strings.TrimSpace(base[loc[1]:])
+			continue
+		}
+		break
+	}
+	return strings.ToLower(strings.Join(strings.Fields(base), " ")), isReply
+}
+
+// Thread - сводка по цепочке писем для списка цепочек.
+type Thread struct {
+	ID             int64
+	Subject        string
+	Participants   []Address
+	Total          int
+	Unread         int
+	HasAttachments bool
+	Latest         Message
+}
+
+// GroupThreads собирает сводки по цепочкам из писем. Цепочки
+// упорядочены по дате последнего письма, новые первыми.
+func GroupThreads(messages []Message) []Thread {
+	byID := make(map[int64]*Thread)
+	order := make([]int64, 0)
+	sorted := append([]Message(nil), messages...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if !sorted[i].Date.Equal(sorted[j].Date) {
+			return sorted[i].Date.Before(sorted[j].Date)
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	seen := make(map[int64]map[string]bool)
+	for _, message := range sorted {
+		thread, ok := byID[message.ThreadID]
+		if !ok {
+			thread = &Thread{ID: message.ThreadID, Subject: message.Subject}
+			byID[message.ThreadID] = thread
+			seen[message.ThreadID] = make(map[string]bool)
+			order = append(order, message.ThreadID)
+		}
+		thread.Total++
+		if !message.HasFlag(FlagSeen) {
+			thread.Unread++
+		}
+		if len(message.Attachments) > 0 {
+			thread.HasAttachments = true
+		}
+		email := strings.ToLower(message.From.Email)
+		if email != "" && !seen[message.ThreadID][email] {
+			seen[message.ThreadID][email] = true
+			thread.Participants = append(thread.Participants, message.From)
+		}
+		thread.Latest = message
+	}
+
+	result := make([]Thread, 0, len(order))
+	for _, id := range order {
+		result = append(result, *byID[id])
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if !result[i].Latest.Date.Equal(result[j].Latest.Date) {
+			return result[i].Latest.Date.After(result[j].Latest.Date)
+		}
+		return result[i].Latest.ID > result[j].Latest.ID
+	})
+	return result
+}
+
+// threadedMessages раскладывает письма по цепочкам при сохранении:
+// сначала по References и In-Reply-To, затем по теме ответа. Письма,
+// которые пришли раньше своего родителя, переносятся в его цепочку.
+type threadedMessages struct {
+	MessageRepository
+}
+
+// NewThreadedMessages добавляет к хранилищу писем построение цепочек.
+func NewThreadedMessages(messages MessageRepository) MessageRepository {
+	return &threadedMessages{MessageRepository: messages}
+}
+
+func (r *threadedMessages) Create(ctx context.Context, message Message) (int64, error) {
+	var merge []int64
+	if message.ThreadID == 0 {
+		threadID, others, err := r.findThread(ctx, message)
+		if err != nil {
+			return 0, err
+		}
+		message.ThreadID = threadID
+		merge = others
+	}
+
+	id, err := r.MessageRepository.Create(ctx, message)
+	if err != nil {
+		return 0, err
+	}
+	threadID := message.ThreadID
+	if threadID == 0 {
+		threadID = id
+	}
+
+	if message.MessageID != "" {
+		children, err := r.ListReferencing(ctx, message.Owner, message.MessageID)
+		if err != nil {
+			return id, err
+		}
+		for _, child := range children {
+			merge = append(merge, child.ThreadID)
+		}
+	}
+	merge = withoutThread(merge, threadID)
+	if len(merge) > 0 {
+		if err := r.MergeThreads(ctx, message.Owner, merge, threadID); err != nil {
+			return id, err
+		}
+	}
+	return id, nil
+}
+
+// findThread ищет цепочку для нового письма. others - другие цепочки,
+// на письма из которых ссылается это письмо: их нужно объединить.
+func (r *threadedMessages) findThread(ctx context.Context, message Message) (threadID int64, others []int64, err error) {
+	refs := append([]string(nil), message.References...)
+	if message.MessageID != "" {
+		// копия того же письма (например, отправленного самому себе)
+		refs = append(refs, message.MessageID)
+	}
+	if len(refs) > 0 {
+		parents, err := r.ListByMessageIDs(ctx, message.Owner, refs)
+		if err != nil {
+			return 0, nil, err
+		}
+		// ближайший родитель - тот, что стоит в References последним
+		position := make(map[string]int, len(refs))
+		for i, ref := range refs {
+			position[ref] = i
+		}
+		best := -1
+		for _, parent := range parents {
+			if p := position[parent.MessageID]; p > best {
+				best = p
+				threadID = parent.ThreadID
+			}
+			others = append(others, parent.ThreadID)
+		}
+		if threadID != 0 {
+			return threadID, withoutThread(others, threadID), nil
+		}
+	}
+
+	base, isReply := BaseSubject(message.Subject)
+	if !isReply || base == "" {
+		return 0, nil, nil
+	}
+	date := message.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	threadID, err = r.FindThreadBySubject(ctx, message.Owner, base, date.Add(-subjectFallbackWindow))
+	return threadID, nil, err
+}
+
+func withoutThread(ids []int64, threadID int64) []int64 {
+	seen := map[int64]bool{threadID: true, 0: true}
+	result := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBaseSubject(t *testing.T) {
+	for _, tt := range []struct {
+		subject string
+		base    string
+		isReply bool
+	}{
+		{"Отчёт за квартал", "отчёт за квартал", false},
+		{"Re: Отчёт за квартал", "отчёт за квартал", true},
+		{"RE[2]: Fwd:  Отчёт   за квартал", "отчёт за квартал", true},
+		{"Ответ: [giga-dev] Re: Релиз", "релиз", true},
+		{"[giga-dev] Релиз", "релиз", false},
+		{"[важно]", "[важно]", false},
+		{"Re:", "", true},
+	} {
+		base, isReply := BaseSubject(tt.subject)
+		if base != tt.base || isReply != tt.isReply {
+			t.Errorf("%q: got %q, %v want %q, %v", tt.subject, base, isReply, tt.base, tt.isReply)
+		}
+	}
+}
+
+func TestThreading(t *testing.T) {
+	ctx := context.Background()
+	const owner = "nick@giga-mail.ru"
+	messages := NewThreadedMessages(NewMessageStore())
+	date := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	create := func(message Message) Message {
+		t.Helper()
+		message.Owner = owner
+		id, err := messages.Create(ctx, message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		message, err = messages.GetByID(ctx, owner, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return message
+	}
+
+	root := create(Message{MessageID: "1@example.com", Subject: "Релиз", Date: date})
+	if root.ThreadID != root.ID {
+		t.Errorf("new message got thread %d want %d", root.ThreadID, root.ID)
+	}
+	// ответ на ещё не пришедшее письмо 2 начинает свою цепочку, а когда
+	// письмо 2 приходит, обе цепочки объединяются
+	early := create(Message{MessageID: "3@example.com", References: []string{"1@example.com", "2@example.com"},
+		Subject: "Re: Релиз", Date: date.Add(2 * time.Hour)})
+	if early.ThreadID != root.ID {
+		t.Errorf("reply got thread %d want %d", early.ThreadID, root.ID)
+	}
+	orphan := create(Message{MessageID: "5@example.com", References: []string{"4@example.com"},
+		Subject: "Другая тема", Date: date})
+	late := create(Message{MessageID: "4@example.com", References: []string{"1@example.com"},
+		Subject: "Re: Релиз", Date: date.Add(time.Hour)})
+	if late.ThreadID != root.ID {
+		t.Errorf("late parent got thread %d want %d", late.ThreadID, root.ID)
+	}
+	if orphan, _ = messages.GetByID(ctx, owner, orphan.ID); orphan.ThreadID != root.ID {
+		t.Errorf("orphan not merged: got thread %d want %d", orphan.ThreadID, root.ID)
+	}
+
+	bySubject := create(Message{Subject: "RE: релиз", Date: date.Add(3 * time.Hour)})
+	if bySubject.ThreadID != root.ID {
+		t.Errorf("subject fallback got thread %d want %d", bySubject.ThreadID, root.ID)
+	}
+	sameSubject := create(Message{Subject: "Релиз", Date: date.Add(4 * time.Hour)})
+	if sameSubject.ThreadID == root.ID {
+		t.Error("message without reply prefix joined thread by subject")
+	}
+	stale := create(Message{Subject: "Re: Релиз", Date: date.Add(60 * 24 * time.Hour)})
+	if stale.ThreadID == root.ID {
+		t.Error("subject fallback joined a stale thread")
+	}
+
+	thread, err := messages.ListByThread(ctx, owner, root.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thread) != 5 || thread[0].ID != root.ID {
+		t.Fatalf("unexpected thread: %+v", thread)
+	}
+	threads := GroupThreads(thread)
+	if len(threads) != 1 || threads[0].Total != 5 || threads[0].Subject != "Релиз" ||
+		threads[0].Latest.ID != bySubject.ID {
+		t.Errorf("unexpected summary: %+v", threads)
+	}
+}
@@ -0,0 +1,10 @@
+package database
+
+// TwoFactor - настройки TOTP пользователя. Пока Enabled ложно, секрет
+// ждёт подтверждения первым кодом и при входе не спрашивается.
+type TwoFactor struct {
+	Email    string
+	Secret   string // base32
+	Enabled  bool
+	LastStep int64 // последний принятый шаг TOTP, повторный код отклоняется
+}
@@ -0,0 +1,24 @@
+package database
+
+import "time"
+
+// DefaultVacationDays - через сколько дней автоответ можно снова
+// отправить тому же адресату, как :days по умолчанию в RFC 5230.
+const DefaultVacationDays = 7
+
+// Vacation - автоответ пользователя на время отсутствия. Нулевые Start
+// и End не ограничивают период, End в него не включается.
+type Vacation struct {
+	Owner   string
+	Enabled bool
+	Subject string
+	Body    string
+	Start   time.Time
+	End     time.Time
+	Days    int
+}
+
+// Active сообщает, нужно ли отвечать на письма в момент now.
+func (v Vacation) Active(now time.Time) bool {
+	return v.Enabled && (v.Start.IsZero() || !now.Before(v.Start)) && (v.End.IsZero() || now.Before(v.End))
+}
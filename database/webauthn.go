@@ -0,0 +1,18 @@
+package database
+
+import "time"
+
+// WebAuthnCredential - ключ доступа (passkey) или аппаратный ключ
+// пользователя. Data - данные ключа библиотеки WebAuthn в JSON: открытый
+// ключ, счётчик подписей и флаги. UserHandle одинаков у всех ключей
+// пользователя и по нему ключ связывается с аккаунтом при входе без
+// пароля.
+type WebAuthnCredential struct {
+	ID         []byte
+	Email      string
+	UserHandle []byte
+	Name       string
+	Data       []byte
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
@@ -0,0 +1,307 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"mail/database"
+	"mail/pkg/mailauth"
+	"mail/pkg/mimemsg"
+	"mail/pkg/spam"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-msgauth/dmarc"
+	"github.com/emersion/go-smtp"
+)
+
+// maxRedirects ограничивает число адресов, на которые правила могут
+// переслать одно письмо.
+const maxRedirects = 5
+
+var (
+	errRelayDenied = &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+		Message:      "Relaying denied",
+	}
+	errNoSuchUser = &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+		Message:      "No such user here",
+	}
+	errMalformed = &smtp.SMTPError{
+		Code:         554,
+		EnhancedCode: smtp.EnhancedCode{5, 6, 0},
+		Message:      "Malformed message",
+	}
+	errDMARCReject = &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+		Message:      "Rejected by sender's DMARC policy",
+	}
+	errTemporary = &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+		Message:      "Temporary local problem, try again later",
+	}
+)
+
+// Deliverer раскладывает письма по ящикам локальных пользователей.
+// Ошибки возвращаются как *smtp.SMTPError, чтобы их можно было сразу
+// отдать SMTP-клиенту и отличить постоянные от временных.
+type Deliverer struct {
+	repo    *database.Repositories
+	domains map[string]bool
+	spam    *spam.Pipeline
+	auth    *mailauth.Verifier
+
+	now func() time.Time
+}
+
+// NewDeliverer создаёт доставку в ящики localDomains. Если filter не
+// nil, письма проверяются на спам, если auth не nil - письма от
+// SMTP-клиентов проверяются по SPF, DKIM и DMARC.
+func NewDeliverer(repo *database.Repositories, localDomains []string, filter *spam.Pipeline, auth *mailauth.Verifier) *Deliverer {
+	d := &Deliverer{
+		repo:    repo,
+		domains: make(map[string]bool, len(localDomains)),
+		spam:    filter,
+		auth:    auth,
+		now:     time.Now,
+	}
+	for _, domain := range localDomains {
+		d.domains[strings.ToLower(domain)] = true
+	}
+	return d
+}
+
+// Client - SMTP-клиент, передавший письмо: адрес для DNSBL и SPF
+// и имя из HELO.
+type Client struct {
+	IP   net.IP
+	Helo string
+}
+
+type clientKey struct{}
+
+// WithClient запоминает в контексте SMTP-клиента, от которого получено
+// письмо. У писем из очереди исходящей почты клиента нет, и они не
+// проверяются.
+func WithClient(ctx context.Context, client Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+func clientFrom(ctx context.Context) (Client, bool) {
+	client, ok := ctx.Value(clientKey{}).(Client)
+	return client, ok
+}
+
+// IsLocal сообщает, обслуживается ли домен адреса этим сервером.
+func (d *Deliverer) IsLocal(address string) bool {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return false
+	}
+	return d.domains[strings.ToLower(address[at+1:])]
+}
+
+// CheckRecipient проверяет, что адрес локальный и такой пользователь есть.
+func (d *Deliverer) CheckRecipient(ctx context.Context, address string) error {
+	if !d.IsLocal(address) {
+		return errRelayDenied
+	}
+	_, err := d.repo.Users.GetByEmail(ctx, address)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return errNoSuchUser
+	}
+	if err != nil {
+		slog.Error("failed to look up recipient", "rcpt", address, "error", err)
+		return errTemporary
+	}
+	return nil
+}
+
+// Defer ставит письмо в очередь исходящей почты для получателей, которым
+// его не удалось доставить сразу. Очередь повторит доставку через Send.
+// Обратный адрес пустой: отправитель внешний, и уведомление о недоставке
+// некому положить во входящие.
+func (d *Deliverer) Defer(ctx context.Context, from string, recipients []string, data []byte) error {
+	_, err := d.repo.Outbound.Enqueue(ctx, database.OutboundMessage{
+		From:       from,
+		Recipients: recipients,
+		Data:       data,
+		CreatedAt:  d.now(),
+	})
+	if err != nil {
+		slog.Error("failed to queue inbound message", "error", err)
+		return errTemporary
+	}
+	return nil
+}
+
+// Send кладёт письмо во входящие каждого получателя. Сигнатура совпадает
+// с outbound.Sender, поэтому очередь может доставлять локальную почту
+// без внешнего релея.
+func (d *Deliverer) Send(ctx context.Context, from string, recipients []string, data []byte) (map[string]error, error) {
+	quarantine := false
+	if client, ok := clientFrom(ctx); ok && d.auth != nil {
+		data = mailauth.StripResults(data, d.auth.Hostname())
+		results := d.auth.Verify(ctx, client.IP, client.Helo, from, data)
+		switch results.Policy {
+		case dmarc.PolicyReject:
+			slog.Info("message rejected by DMARC", "from", results.FromDomain, "ip", client.IP)
+			return nil, errDMARCReject
+		case dmarc.PolicyQuarantine:
+			quarantine = true
+		}
+		data = append([]byte(results.Header(d.auth.Hostname())), data...)
+	}
+	data = append([]byte("Return-Path: <"+from+">\r\n"), data...)
+	message, _, err := mimemsg.Parse(data)
+	if err != nil {
+		return nil, errMalformed
+	}
+	message.Raw = data
+	message.ReceivedAt = d.now()
+	if message.Date.IsZero() {
+		message.Date = message.ReceivedAt
+	}
+
+	failed := make(map[string]error)
+	for _, rcpt := range recipients {
+		if err := d.deliver(ctx, from, rcpt, message, quarantine); err != nil {
+			failed[rcpt] = err
+		}
+	}
+	return failed, nil
+}
+
+// deliver кладёт письмо в ящик rcpt. quarantine - политика DMARC
+// отправителя требует считать письмо спамом.
+func (d *Deliverer) deliver(ctx context.Context, from string, rcpt string, message database.Message, quarantine bool) error {
+	if err := d.CheckRecipient(ctx, rcpt); err != nil {
+		return err
+	}
+	inbox, err := d.repo.Folders.GetSystem(ctx, rcpt, database.FolderInbox)
+	if err != nil {
+		slog.Error("failed to get inbox", "rcpt", rcpt, "error", err)
+		return errTemporary
+	}
+	message.Owner = rcpt
+	message.FolderID = inbox.ID
+	verdict := d.classify(ctx, &message, quarantine)
+
+	rules, err := d.repo.Filters.List(ctx, rcpt)
+	if err != nil {
+		slog.Error("failed to get filter rules", "rcpt", rcpt, "error", err)
+		return errTemporary
+	}
+	outcome := database.RunFilters(rules, message)
+	// спам не пересылаем, чтобы не портить репутацию сервера
+	if !verdict.IsSpam() {
+		d.redirect(ctx, rcpt, message, outcome.Redirects)
+	}
+	if outcome.Dropped() {
+		return nil
+	}
+	if verdict.IsSpam() {
+		folder, err := d.repo.Folders.GetSystem(ctx, rcpt, database.FolderSpam)
+		if err != nil {
+			slog.Error("failed to get spam folder", "rcpt", rcpt, "error", err)
+			return errTemporary
+		}
+		message.FolderID = folder.ID
+	}
+	// явное правило пользователя важнее решения спам-фильтра
+	if outcome.FolderID != 0 {
+		// папку могли удалить после того, как правило было сохранено
+		if _, err := d.repo.Folders.GetByID(ctx, rcpt, outcome.FolderID); err == nil {
+			message.FolderID = outcome.FolderID
+		}
+	}
+	message.Flags, _ = database.ApplyFlags(message.Flags, outcome.Flags, nil)
+	message.Labels = outcome.Labels
+
+	if _, err := d.repo.Messages.Create(ctx, message); err != nil {
+		slog.Error("failed to store message", "rcpt", rcpt, "error", err)
+		return errTemporary
+	}
+	if !verdict.IsSpam() {
+		d.autoReply(ctx, from, rcpt, message)
+	}
+	return nil
+}
+
+// classify проверяет письмо на спам для получателя и записывает итог
+// в заголовки X-Spam-Status и X-Spam-Score, чтобы по ним можно было
+// писать правила фильтрации. Такие же заголовки от отправителя
+// удаляются: им нельзя доверять.
+func (d *Deliverer) classify(ctx context.Context, message *database.Message, quarantine bool) spam.Result {
+	headers := make(map[string][]string, len(message.Headers)+2)
+	for key, values := range message.Headers {
+		if !strings.HasPrefix(strings.ToLower(key), "x-spam-") {
+			headers[key] = values
+		}
+	}
+	message.Headers = headers
+	if d.spam == nil && !quarantine {
+		return spam.Result{Threshold: math.Inf(1)}
+	}
+
+	result := spam.Result{Threshold: spam.DefaultThreshold}
+	if d.spam != nil {
+		client, _ := clientFrom(ctx)
+		result = d.spam.Classify(ctx, spam.Input{Owner: message.Owner, ClientIP: client.IP, Message: *message})
+	}
+	if quarantine {
+		result.Hits = append(result.Hits, spam.Hit{Rule: "DMARC_QUARANTINE", Score: result.Threshold, Detail: "sender's DMARC policy is quarantine"})
+		result.Score = math.Max(result.Score+result.Threshold, result.Threshold)
+	}
+	headers["X-Spam-Status"] = []string{result.Status()}
+	headers["X-Spam-Score"] = []string{strconv.FormatFloat(result.Score, 'f', 1, 64)}
+	return result
+}
+
+// redirect пересылает письмо по правилам через очередь исходящей почты.
+// Заголовок Delivered-To защищает от петель: письмо, уже прошедшее через
+// этот ящик, повторно не пересылается.
+func (d *Deliverer) redirect(ctx context.Context, rcpt string, message database.Message, addresses []string) {
+	if len(addresses) == 0 {
+		return
+	}
+	for key, values := range message.Headers {
+		if !strings.EqualFold(key, "Delivered-To") {
+			continue
+		}
+		for _, value := range values {
+			if strings.EqualFold(strings.TrimSpace(value), rcpt) {
+				slog.Warn("redirect loop detected", "rcpt", rcpt)
+				return
+			}
+		}
+	}
+	if len(addresses) > maxRedirects {
+		addresses = addresses[:maxRedirects]
+	}
+	// Return-Path добавил Send, при пересылке его выставит получатель
+	data := message.Raw
+	if end := bytes.Index(data, []byte("\r\n")); bytes.HasPrefix(data, []byte("Return-Path:")) && end >= 0 {
+		data = data[end+2:]
+	}
+	data = append([]byte("Delivered-To: "+rcpt+"\r\n"), data...)
+	_, err := d.repo.Outbound.Enqueue(ctx, database.OutboundMessage{
+		Sender:     rcpt,
+		From:       rcpt,
+		Recipients: addresses,
+		Data:       data,
+		CreatedAt:  d.now(),
+	})
+	if err != nil {
+		slog.Error("failed to redirect message", "rcpt", rcpt, "error", err)
+	}
+}
@@ -0,0 +1,126 @@
+package delivery
+
+import (
+	"context"
+	"log/slog"
+	"mail/database"
+	"mail/pkg/mimemsg"
+	"strings"
+	"time"
+)
+
+// listHeaders есть у писем рассылок (RFC 2369, RFC 2919).
+var listHeaders = []string{"List-Id", "List-Unsubscribe", "List-Post", "List-Help", "List-Owner", "List-Archive"}
+
+// autoReply отправляет автоответ об отсутствии, если он включён, письмо
+// подходит по RFC 3834 и этому отправителю давно не отвечали. Ошибки
+// только пишутся в лог: письмо уже доставлено.
+func (d *Deliverer) autoReply(ctx context.Context, from string, rcpt string, message database.Message) {
+	if !needsAutoReply(from, rcpt, message) {
+		return
+	}
+	vacation, err := d.repo.Vacation.Get(ctx, rcpt)
+	if err != nil {
+		slog.Error("failed to get vacation", "rcpt", rcpt, "error", err)
+		return
+	}
+	now := d.now()
+	if !vacation.Active(now) {
+		return
+	}
+	days := vacation.Days
+	if days <= 0 {
+		days = database.DefaultVacationDays
+	}
+	reply, err := d.repo.Vacation.MarkReplied(ctx, rcpt, from, now, now.Add(-time.Duration(days)*24*time.Hour))
+	if err != nil {
+		slog.Error("failed to check vacation replies", "rcpt", rcpt, "error", err)
+		return
+	}
+	if !reply {
+		return
+	}
+
+	data, err := mimemsg.Compose(vacationReply(vacation, from, rcpt, message, now))
+	if err != nil {
+		slog.Error("failed to compose vacation reply", "rcpt", rcpt, "error", err)
+		return
+	}
+	// автоответ уходит с пустым обратным адресом, чтобы на него не
+	// пришло ни ответа, ни уведомления о недоставке (RFC 3834, 3.3);
+	// без Sender очередь не кладёт уведомление и во входящие rcpt
+	_, err = d.repo.Outbound.Enqueue(ctx, database.OutboundMessage{
+		Recipients: []string{from},
+		Data:       data,
+		CreatedAt:  now,
+	})
+	if err != nil {
+		slog.Error("failed to enqueue vacation reply", "rcpt", rcpt, "error", err)
+	}
+}
+
+func vacationReply(vacation database.Vacation, from string, rcpt string, message database.Message, now time.Time) database.Message {
+	subject := vacation.Subject
+	if subject == "" {
+		subject = "Auto: " + message.Subject
+	}
+	references := append([]string(nil), message.References...)
+	if message.MessageID != "" {
+		references = append(references, message.MessageID)
+	}
+	return database.Message{
+		MessageID:  mimemsg.GenerateMessageID(rcpt[strings.LastIndex(rcpt, "@")+1:]),
+		References: references,
+		From:       database.Address{Email: rcpt},
+		To:         []database.Address{{Email: from}},
+		Subject:    subject,
+		TextBody:   vacation.Body,
+		Date:       now,
+		Headers: map[string][]string{
+			"Auto-Submitted":           {"auto-replied"},
+			"X-Auto-Response-Suppress": {"All"},
+		},
+	}
+}
+
+// needsAutoReply проверяет письмо по RFC 3834 и RFC 5230: не отвечать
+// на уведомления с пустым обратным адресом, автоматические письма,
+// рассылки и письма, где получателя нет в To и Cc.
+func needsAutoReply(from string, rcpt string, message database.Message) bool {
+	if from == "" || strings.EqualFold(from, rcpt) {
+		return false
+	}
+	local := strings.ToLower(from[:max(strings.LastIndex(from, "@"), 0)])
+	if local == "mailer-daemon" || local == "postmaster" || local == "listserv" || local == "majordomo" ||
+		strings.HasPrefix(local, "owner-") || strings.HasSuffix(local, "-request") ||
+		strings.HasPrefix(local, "noreply") || strings.HasPrefix(local, "no-reply") {
+		return false
+	}
+	if value := headerValue(message, "Auto-Submitted"); value != "" && !strings.EqualFold(value, "no") {
+		return false
+	}
+	switch strings.ToLower(headerValue(message, "Precedence")) {
+	case "bulk", "list", "junk":
+		return false
+	}
+	for _, header := range listHeaders {
+		if headerValue(message, header) != "" {
+			return false
+		}
+	}
+	for _, address := range append(append([]database.Address(nil), message.To...), message.Cc...) {
+		if strings.EqualFold(address.Email, rcpt) {
+			return true
+		}
+	}
+	return false
+}
+
+func headerValue(message database.Message, key string) string {
+	for k, values := range message.Headers {
+		if strings.EqualFold(k, key) && len(values) > 0 {
+			return strings.TrimSpace(values[0])
+		}
+	}
+	return ""
+}
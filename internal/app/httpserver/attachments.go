@@ -0,0 +1,266 @@
+package httpserver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"mail/database"
+	"mail/pkg/blobstore"
+	"mail/pkg/mimemsg"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultMaxFileBytes    = 20 << 20
+	defaultMaxMessageBytes = 25 << 20
+
+	// multipartOverhead - запас на заголовки и границы multipart-запроса.
+	multipartOverhead = 1 << 20
+)
+
+func (s *HTTPServer) listAttachments(w http.ResponseWriter, r *http.Request) {
+	message, ok := s.messageFromPath(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, toAttachmentsJSON(message.Attachments))
+}
+
+// uploadAttachment принимает файл из поля file формы multipart/form-data
+// и прикрепляет его к черновику.
+func (s *HTTPServer) uploadAttachment(w http.ResponseWriter, r *http.Request) {
+	message, ok := s.draftFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.attachments.MaxFileBytes+multipartOverhead)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				ErrorResponseWithStatus(w, r, http.StatusRequestEntityTooLarge, "attachment_too_large")
+				return
+			}
+			ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+			return
+		}
+		if part.FormName() == "file" {
+			s.storeAttachment(w, r, message, part.FileName(), part.Header.Get("Content-Type"), part)
+			return
+		}
+	}
+}
+
+func (s *HTTPServer) storeAttachment(w http.ResponseWriter, r *http.Request, message database.Message,
+	filename string, contentType string, body io.Reader) {
+	filename = attachmentFilename(filename)
+	if filename == "" {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(body, s.attachments.MaxFileBytes+1))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) || int64(len(data)) > s.attachments.MaxFileBytes {
+		ErrorResponseWithStatus(w, r, http.StatusRequestEntityTooLarge, "attachment_too_large")
+		return
+	}
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+
+	total := int64(len(data))
+	var lastID int64
+	for _, a := range message.Attachments {
+		total += a.Size
+		if a.ID > lastID {
+			lastID = a.ID
+		}
+	}
+	if total > s.attachments.MaxMessageBytes {
+		ErrorResponseWithStatus(w, r, http.StatusRequestEntityTooLarge, "attachments_too_large")
+		return
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType == "application/octet-stream" {
+		contentType = http.DetectContentType(data)
+	}
+	attachment := database.AttachmentMeta{
+		ID:          lastID + 1,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		StorageKey:  blobstore.NewKey(),
+	}
+	if err := s.blobs.Put(r.Context(), attachment.StorageKey, bytes.NewReader(data), attachment.Size, contentType); err != nil {
+		slog.Error("failed to store attachment", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+
+	message.Attachments = append(message.Attachments, attachment)
+	if err := s.repo.Messages.Update(r.Context(), message); err != nil {
+		if delErr := s.blobs.Delete(r.Context(), attachment.StorageKey); delErr != nil {
+			slog.Error("failed to remove orphan attachment", "error", delErr)
+		}
+		if errors.Is(err, database.ErrVersionConflict) {
+			ErrorResponseWithStatus(w, r, http.StatusConflict, "version_conflict")
+			return
+		}
+		slog.Error("failed to attach file", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	writeJSON(w, http.StatusCreated, toAttachmentJSON(attachment))
+}
+
+func (s *HTTPServer) downloadAttachment(w http.ResponseWriter, r *http.Request) {
+	message, ok := s.messageFromPath(w, r)
+	if !ok {
+		return
+	}
+	attachment, ok := attachmentFromPath(w, r, message)
+	if !ok {
+		return
+	}
+
+	var body io.Reader
+	if attachment.StorageKey != "" {
+		blob, err := s.blobs.Get(r.Context(), attachment.StorageKey)
+		if err != nil {
+			slog.Error("failed to read attachment", "key", attachment.StorageKey, "error", err)
+			ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+			return
+		}
+		defer blob.Close()
+		body = blob
+	} else {
+		raw, err := s.messageSource(r.Context(), message)
+		if err != nil {
+			slog.Error("failed to get message source", "error", err)
+			ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+			return
+		}
+		_, data, err := mimemsg.ExtractAttachment(raw, attachment.ID)
+		if err != nil {
+			slog.Error("failed to extract attachment", "id", message.ID, "error", err)
+			ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+			return
+		}
+		body = bytes.NewReader(data)
+	}
+
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": attachment.Filename,
+	}))
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, body)
+}
+
+func (s *HTTPServer) deleteAttachment(w http.ResponseWriter, r *http.Request) {
+	message, ok := s.draftFromPath(w, r)
+	if !ok {
+		return
+	}
+	attachment, ok := attachmentFromPath(w, r, message)
+	if !ok {
+		return
+	}
+
+	kept := make([]database.AttachmentMeta, 0, len(message.Attachments))
+	for _, a := range message.Attachments {
+		if a.ID != attachment.ID {
+			kept = append(kept, a)
+		}
+	}
+	message.Attachments = kept
+	err := s.repo.Messages.Update(r.Context(), message)
+	if errors.Is(err, database.ErrVersionConflict) {
+		ErrorResponseWithStatus(w, r, http.StatusConflict, "version_conflict")
+		return
+	}
+	if err != nil {
+		slog.Error("failed to detach file", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	if attachment.StorageKey != "" {
+		if err := s.blobs.Delete(r.Context(), attachment.StorageKey); err != nil {
+			slog.Error("failed to remove attachment", "key", attachment.StorageKey, "error", err)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// draftFromPath как messageFromPath, но разрешает только черновики
+// текущего пользователя: у отправленных и полученных писем, даже
+// перенесённых в папку черновиков, вложения не меняются.
+func (s *HTTPServer) draftFromPath(w http.ResponseWriter, r *http.Request) (database.Message, bool) {
+	message, ok := s.messageFromPath(w, r)
+	if !ok {
+		return message, false
+	}
+	drafts, err := s.repo.Folders.GetSystem(r.Context(), message.Owner, database.FolderDrafts)
+	if err != nil {
+		slog.Error("failed to get drafts folder", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return message, false
+	}
+	if message.FolderID != drafts.ID || !message.HasFlag(database.FlagDraft) || message.Owner != currentUser(r) {
+		ErrorResponseWithStatus(w, r, http.StatusConflict, "not_a_draft")
+		return message, false
+	}
+	return message, true
+}
+
+func attachmentFromPath(w http.ResponseWriter, r *http.Request, message database.Message) (database.AttachmentMeta, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["attachment"], 10, 64)
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return database.AttachmentMeta{}, false
+	}
+	for _, a := range message.Attachments {
+		if a.ID == id {
+			return a, true
+		}
+	}
+	ErrorResponseWithStatus(w, r, http.StatusNotFound, "attachment_not_found")
+	return database.AttachmentMeta{}, false
+}
+
+// attachmentFilename отбрасывает путь, который присылают некоторые
+// браузеры, и управляющие символы.
+func attachmentFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "." || name == "/" {
+		return ""
+	}
+	return strings.TrimSpace(name)
+}
@@ -0,0 +1,143 @@
+package httpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"mail/config"
+	"mail/database"
+	"mail/pkg/blobstore"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// uploadFile отправляет файл полем file формы multipart/form-data.
+func uploadFile(t *testing.T, handler http.Handler, url string, filename string, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	form.Close()
+
+	req, err := http.NewRequest("POST", url, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	addTestSession(req)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func seedDraft(s *HTTPServer) string {
+	id := seedMessage(s, database.Message{
+		Owner:    testUserEmail,
+		From:     database.Address{Email: testUserEmail},
+		Subject:  "Draft",
+		FolderID: systemFolderID(s, testUserEmail, database.FolderDrafts),
+		Flags:    []string{database.FlagDraft},
+	})
+	return "/mail/messages/" + strconv.FormatInt(id, 10)
+}
+
+func TestAttachmentsLifecycle(t *testing.T) {
+	s := newTestServer()
+	blobs := s.blobs.(*blobstore.MemoryStore)
+	router := newTestRouter(s, testUserEmail)
+	draft := seedDraft(s)
+
+	rr := uploadFile(t, router, draft+"/attachments", `C:\Users\jane\отчёт.pdf`, []byte("%PDF-1.4 report"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("upload: got %v want %v: %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+	var uploaded AttachmentJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &uploaded); err != nil {
+		t.Fatal(err)
+	}
+	if uploaded.ID != 1 || uploaded.Filename != "отчёт.pdf" || uploaded.ContentType != "application/pdf" || uploaded.Size != 15 {
+		t.Errorf("unexpected attachment: %+v", uploaded)
+	}
+
+	rr = doJSON(t, router, "GET", draft+"/attachments", nil)
+	var list []AttachmentJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || len(list) != 1 {
+		t.Fatalf("unexpected list: %s", rr.Body.String())
+	}
+
+	rr = doJSON(t, router, "GET", draft+"/attachments/1", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "%PDF-1.4 report" {
+		t.Errorf("download: %d %q", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Content-Type") != "application/pdf" ||
+		rr.Header().Get("Content-Disposition") != `attachment; filename*=utf-8''%D0%BE%D1%82%D1%87%D1%91%D1%82.pdf` {
+		t.Errorf("unexpected download headers: %v", rr.Header())
+	}
+
+	rr = doJSON(t, router, "DELETE", draft+"/attachments/1", nil)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("delete: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if blobs.Len() != 0 {
+		t.Error("blob was not removed")
+	}
+	if rr := doJSON(t, router, "GET", draft+"/attachments/1", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("download deleted: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestUploadAttachmentLimits(t *testing.T) {
+	s := newTestServer()
+	s.attachments = config.AttachmentsConfig{MaxFileBytes: 10, MaxMessageBytes: 15}
+	router := newTestRouter(s, testUserEmail)
+	draft := seedDraft(s)
+
+	if rr := uploadFile(t, router, draft+"/attachments", "big.txt", bytes.Repeat([]byte("x"), 11)); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("file limit: got %v want %v", rr.Code, http.StatusRequestEntityTooLarge)
+	}
+	if rr := uploadFile(t, router, draft+"/attachments", "a.txt", bytes.Repeat([]byte("x"), 10)); rr.Code != http.StatusCreated {
+		t.Fatalf("first file: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	if rr := uploadFile(t, router, draft+"/attachments", "b.txt", bytes.Repeat([]byte("x"), 10)); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("message limit: got %v want %v", rr.Code, http.StatusRequestEntityTooLarge)
+	}
+	if n := s.blobs.(*blobstore.MemoryStore).Len(); n != 1 {
+		t.Errorf("got %d stored blobs want 1", n)
+	}
+}
+
+func TestUploadAttachmentToNonDraft(t *testing.T) {
+	s := newTestServer()
+	id := seedMessage(s, database.Message{Owner: testUserEmail, FolderID: systemFolderID(s, testUserEmail, database.FolderInbox)})
+	rr := uploadFile(t, newTestRouter(s, testUserEmail), "/mail/messages/"+strconv.FormatInt(id, 10)+"/attachments", "a.txt", []byte("x"))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("got %v want %v", rr.Code, http.StatusConflict)
+	}
+}
+
+func TestDownloadReceivedAttachment(t *testing.T) {
+	s := newTestServer()
+	raw := "From: mark@example.com\r\nSubject: Report\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nSee attached.\r\n" +
+		"--b\r\nContent-Type: text/csv\r\nContent-Disposition: attachment; filename=q3.csv\r\n" +
+		"Content-Transfer-Encoding: base64\r\n\r\ncTEscTIscTMK\r\n" +
+		"--b--\r\n"
+	id := seedMessage(s, database.Message{
+		Owner:       testUserEmail,
+		FolderID:    systemFolderID(s, testUserEmail, database.FolderInbox),
+		Attachments: []database.AttachmentMeta{{ID: 1, Filename: "q3.csv", ContentType: "text/csv", Size: 9}},
+		Raw:         []byte(raw),
+	})
+
+	rr := doJSON(t, newTestRouter(s, testUserEmail), "GET", "/mail/messages/"+strconv.FormatInt(id, 10)+"/attachments/1", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "q1,q2,q3\n" || rr.Header().Get("Content-Type") != "text/csv" {
+		t.Errorf("unexpected download: %d %q %v", rr.Code, rr.Body.String(), rr.Header())
+	}
+}
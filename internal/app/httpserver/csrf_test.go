@@ -0,0 +1,143 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"mail/config"
+	"mail/pkg/middleware"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// doRaw выполняет запрос без тестовой сессии.
+func doRaw(router http.Handler, method string, url string, body string, setup func(*http.Request)) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	setup(req)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCSRF(t *testing.T) {
+	s := newTestServer()
+	createTestUser(s, "nick", testUserEmail, "12345")
+	router := newTestRouter(s, testUserEmail)
+
+	rr := doJSON(t, router, "POST", "/login", UserLogin{Email: testUserEmail, Password: "12345"})
+	var login LoginResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &login); err != nil || login.CSRFToken == "" {
+		t.Fatalf("login %s, %v", rr.Body.String(), err)
+	}
+	hash := rr.Result().Cookies()[0].Value
+	withSession := func(token string) func(*http.Request) {
+		return func(req *http.Request) {
+			req.AddCookie(&http.Cookie{Name: "session", Value: hash})
+			if token != "" {
+				req.Header.Set(middleware.CSRFHeader, token)
+			}
+		}
+	}
+
+	rr = doRaw(router, "GET", "/csrf", "", withSession(""))
+	var csrf CSRFResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &csrf); err != nil || csrf.CSRFToken != login.CSRFToken {
+		t.Errorf("got %s, %v want %q", rr.Body.String(), err, login.CSRFToken)
+	}
+
+	folder := `{"name":"Work"}`
+	if rr := doRaw(router, "POST", "/mail/folders", folder, withSession("")); rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "csrf_failed") {
+		t.Errorf("no token: %v %s", rr.Code, rr.Body.String())
+	}
+	// токен другой сессии не подходит
+	if rr := doRaw(router, "POST", "/mail/folders", folder, withSession(middleware.CSRFToken(middleware.CSRFKey(testSecret), testUserID))); rr.Code != http.StatusForbidden {
+		t.Errorf("token of other session: %v", rr.Code)
+	}
+	if rr := doRaw(router, "POST", "/mail/folders", folder, withSession(login.CSRFToken)); rr.Code != http.StatusOK {
+		t.Errorf("valid token: %v %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRaw(router, "GET", "/mail/folders", "", withSession("")); rr.Code != http.StatusOK {
+		t.Errorf("GET without token: %v", rr.Code)
+	}
+}
+
+func TestLogoutRequiresPost(t *testing.T) {
+	s := newTestServer()
+	createTestUser(s, "nick", testUserEmail, "12345")
+	router := newTestRouter(s, testUserEmail)
+	session := func(token string) func(*http.Request) {
+		return func(req *http.Request) {
+			req.AddCookie(&http.Cookie{Name: "session", Value: testUserID})
+			if token != "" {
+				req.Header.Set(middleware.CSRFHeader, token)
+			}
+		}
+	}
+
+	if rr := doRaw(router, "GET", "/logout", "", session("")); rr.Code == http.StatusOK {
+		t.Errorf("GET: %v", rr.Code)
+	}
+	if rr := doRaw(router, "POST", "/logout", "", session("")); rr.Code != http.StatusForbidden {
+		t.Errorf("POST without token: %v", rr.Code)
+	}
+	if _, err := s.repo.Sessions.Get(context.Background(), testUserID); err != nil {
+		t.Fatalf("session ended without token: %v", err)
+	}
+	if rr := doRaw(router, "POST", "/logout", "", session(middleware.CSRFToken(middleware.CSRFKey(testSecret), testUserID))); rr.Code != http.StatusOK {
+		t.Errorf("POST: %v %s", rr.Code, rr.Body.String())
+	}
+	if _, err := s.repo.Sessions.Get(context.Background(), testUserID); err == nil {
+		t.Error("session survived logout")
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	s := newTestServer()
+	createTestUser(s, "nick", testUserEmail, "12345")
+	router := newTestRouter(s, testUserEmail)
+	body := `{"email":"` + testUserEmail + `","password":"12345"}`
+
+	rr := doRaw(router, "POST", "/login", body, func(req *http.Request) { req.Header.Set("Origin", "https://evil.example") })
+	if rr.Code != http.StatusForbidden || hasSessionCookie(rr) {
+		t.Errorf("foreign origin: %v", rr.Code)
+	}
+	rr = doRaw(router, "POST", "/login", body, func(req *http.Request) { req.Header.Set("Origin", "http://localhost:4201") })
+	if rr.Code != http.StatusOK || !hasSessionCookie(rr) {
+		t.Errorf("allowed origin: %v %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestSecureCookie(t *testing.T) {
+	s := newTestServer()
+	createTestUser(s, "nick", testUserEmail, "12345")
+	cfg := new(config.Config)
+	cfg.HTTPServer.AllowedIPsByCORS = []string{"http://localhost:4201"}
+	cfg.Session = config.SessionConfig{Secure: true, SameSite: "strict"}
+	s.server = new(http.Server)
+	s.configureRouter(cfg)
+	router := s.server.Handler
+
+	data, _ := json.Marshal(UserLogin{Email: testUserEmail, Password: "12345"})
+	rr := doRaw(router, "POST", "/login", string(data), func(*http.Request) {})
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies", len(cookies))
+	}
+	cookie := cookies[0]
+	if cookie.Name != "__Host-session" || !cookie.Secure || !cookie.HttpOnly || cookie.Path != "/" || cookie.SameSite != http.SameSiteStrictMode || cookie.Domain != "" {
+		t.Errorf("got %+v", cookie)
+	}
+
+	get := func(name string) int {
+		return doRaw(router, "GET", "/sessions", "", func(req *http.Request) {
+			req.AddCookie(&http.Cookie{Name: name, Value: cookie.Value})
+		}).Code
+	}
+	if code := get("session"); code != http.StatusUnauthorized {
+		t.Errorf("cookie without prefix: %v", code)
+	}
+	if code := get("__Host-session"); code != http.StatusOK {
+		t.Errorf("cookie with prefix: %v", code)
+	}
+}
@@ -0,0 +1,165 @@
+package httpserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"mail/database"
+	"mail/pkg/mailauth"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var domainName = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// DKIMKeyJSON - ключ DKIM без закрытой части. DNSRecord нужно
+// опубликовать как TXT-запись с именем DNSName.
+type DKIMKeyJSON struct {
+	ID        int64     `json:"id"`
+	Domain    string    `json:"domain"`
+	Selector  string    `json:"selector"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	DNSName   string    `json:"dns_name"`
+	DNSRecord string    `json:"dns_record"`
+}
+
+func toDKIMKeyJSON(key database.DKIMKey) (DKIMKeyJSON, error) {
+	record, err := mailauth.DNSRecord(key.PrivateKey)
+	if err != nil {
+		return DKIMKeyJSON{}, err
+	}
+	return DKIMKeyJSON{
+		ID:        key.ID,
+		Domain:    key.Domain,
+		Selector:  key.Selector,
+		Active:    key.Active,
+		CreatedAt: key.CreatedAt,
+		DNSName:   mailauth.DNSName(key.Domain, key.Selector),
+		DNSRecord: record,
+	}, nil
+}
+
+// adminOnly пропускает только пользователей из списка admins.
+func adminOnly(admins []string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			for _, admin := range admins {
+				if strings.EqualFold(admin, currentUser(r)) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			ErrorResponseWithStatus(w, r, http.StatusForbidden, "forbidden")
+		})
+	}
+}
+
+func dkimErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, database.ErrDKIMKeyNotFound):
+		ErrorResponseWithStatus(w, r, http.StatusNotFound, "dkim_key_not_found")
+	case errors.Is(err, database.ErrDKIMKeyExists):
+		ErrorResponseWithStatus(w, r, http.StatusConflict, "dkim_key_exists")
+	case errors.Is(err, database.ErrDKIMKeyActive):
+		ErrorResponseWithStatus(w, r, http.StatusConflict, "dkim_key_active")
+	default:
+		slog.Error("dkim storage error", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+	}
+}
+
+func domainParam(r *http.Request) (string, bool) {
+	domain := strings.ToLower(mux.Vars(r)["domain"])
+	return domain, domainName.MatchString(domain)
+}
+
+func (s *HTTPServer) listDKIMKeys(w http.ResponseWriter, r *http.Request) {
+	domain, ok := domainParam(r)
+	if !ok {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+	keys, err := s.repo.DKIM.List(r.Context(), domain)
+	if err != nil {
+		dkimErrorResponse(w, r, err)
+		return
+	}
+	result := make([]DKIMKeyJSON, 0, len(keys))
+	for _, key := range keys {
+		item, err := toDKIMKeyJSON(key)
+		if err != nil {
+			dkimErrorResponse(w, r, err)
+			return
+		}
+		result = append(result, item)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// rotateDKIMKey создаёт новый ключ домена и сразу начинает подписывать
+// им письма. Прежний ключ остаётся опубликованным: его удаляют, когда
+// новая запись появилась в DNS и письма со старой подписью дошли.
+func (s *HTTPServer) rotateDKIMKey(w http.ResponseWriter, r *http.Request) {
+	domain, ok := domainParam(r)
+	if !ok {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+	privateKey, err := mailauth.GenerateKey()
+	if err != nil {
+		slog.Error("failed to generate dkim key", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	now := time.Now().UTC()
+	key := database.DKIMKey{
+		Domain:     domain,
+		Selector:   dkimSelector(now),
+		PrivateKey: privateKey,
+		CreatedAt:  now,
+	}
+	if key.ID, err = s.repo.DKIM.Rotate(r.Context(), key); err != nil {
+		dkimErrorResponse(w, r, err)
+		return
+	}
+	key.Active = true
+	result, err := toDKIMKeyJSON(key)
+	if err != nil {
+		dkimErrorResponse(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, result)
+}
+
+// dkimSelector - селектор из даты создания и случайного суффикса, чтобы
+// по нему было видно возраст ключа и две ротации в один день не совпали.
+func dkimSelector(now time.Time) string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return "g" + now.Format("20060102") + hex.EncodeToString(suffix)
+}
+
+func (s *HTTPServer) deleteDKIMKey(w http.ResponseWriter, r *http.Request) {
+	domain, ok := domainParam(r)
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if !ok || err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+	if err := s.repo.DKIM.Delete(r.Context(), domain, id); err != nil {
+		dkimErrorResponse(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
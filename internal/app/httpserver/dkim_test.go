@@ -0,0 +1,69 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"mail/config"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newAdminRouter - как newTestRouter, но testUserEmail - администратор.
+func newAdminRouter(s *HTTPServer) http.Handler {
+	cfg := new(config.Config)
+	cfg.HTTPServer.AllowedIPsByCORS = []string{"http://localhost:4201"}
+	cfg.Account.Secret = testSecret
+	cfg.Admins = []string{testUserEmail}
+	s.server = new(http.Server)
+	s.configureRouter(cfg)
+	createTestSession(s, testUserID, testUserEmail)
+	return s.server.Handler
+}
+
+func TestDKIMKeys(t *testing.T) {
+	s := newTestServer()
+	if rr := doJSON(t, newTestRouter(s, testUserEmail), "POST", "/admin/dkim/giga-mail.ru/rotate", nil); rr.Code != http.StatusForbidden {
+		t.Fatalf("non-admin got %v", rr.Code)
+	}
+	router := newAdminRouter(s)
+
+	rr := doJSON(t, router, "POST", "/admin/dkim/Giga-Mail.ru/rotate", nil)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("got %v: %s", rr.Code, rr.Body.String())
+	}
+	var first DKIMKeyJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &first); err != nil {
+		t.Fatal(err)
+	}
+	if !first.Active || first.Domain != "giga-mail.ru" || first.DNSName != first.Selector+"._domainkey.giga-mail.ru" ||
+		!strings.HasPrefix(first.DNSRecord, "v=DKIM1; k=rsa; p=") {
+		t.Errorf("unexpected key: %+v", first)
+	}
+
+	if rr := doJSON(t, router, "POST", "/admin/dkim/giga-mail.ru/rotate", nil); rr.Code != http.StatusCreated {
+		t.Fatalf("got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = doJSON(t, router, "GET", "/admin/dkim/giga-mail.ru", nil)
+	var keys []DKIMKeyJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &keys); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || !keys[0].Active || keys[1].Active || keys[1].ID != first.ID || keys[0].Selector == first.Selector {
+		t.Fatalf("unexpected keys: %+v", keys)
+	}
+
+	if rr := doJSON(t, router, "DELETE", "/admin/dkim/giga-mail.ru/keys/"+strconv.FormatInt(keys[0].ID, 10), nil); rr.Code != http.StatusConflict {
+		t.Errorf("deleting active key: got %v", rr.Code)
+	}
+	if rr := doJSON(t, router, "DELETE", "/admin/dkim/giga-mail.ru/keys/"+strconv.FormatInt(keys[1].ID, 10), nil); rr.Code != http.StatusOK {
+		t.Errorf("deleting old key: got %v", rr.Code)
+	}
+	if rr := doJSON(t, router, "DELETE", "/admin/dkim/example.com/keys/"+strconv.FormatInt(keys[0].ID, 10), nil); rr.Code != http.StatusNotFound {
+		t.Errorf("deleting key of other domain: got %v", rr.Code)
+	}
+	if rr := doJSON(t, router, "GET", "/admin/dkim/not_a_domain", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid domain: got %v", rr.Code)
+	}
+}
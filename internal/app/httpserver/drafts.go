@@ -0,0 +1,217 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mail/database"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DraftRequest - состояние черновика от клиента. Version должна совпадать
+// с последней полученной от сервера, иначе запись отклоняется с 409.
+// ReplyTo учитывается только при создании черновика.
+type DraftRequest struct {
+	Version  int64    `json:"version"`
+	ReplyTo  int64    `json:"reply_to,omitempty"`
+	To       []string `json:"to"`
+	Cc       []string `json:"cc"`
+	Bcc      []string `json:"bcc"`
+	Subject  string   `json:"subject"`
+	TextBody string   `json:"text_body"`
+	HTMLBody string   `json:"html_body"`
+}
+
+type SendDraftRequest struct {
+	Version int64 `json:"version"`
+}
+
+// draftAddresses разбирает адреса черновика без проверки: пользователь
+// может ещё не дописать адрес, проверка будет при отправке.
+func draftAddresses(list []string) []database.Address {
+	result := make([]database.Address, 0, len(list))
+	for _, raw := range list {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		if address, err := mail.ParseAddress(raw); err == nil {
+			result = append(result, database.Address{Name: address.Name, Email: address.Address})
+			continue
+		}
+		result = append(result, database.Address{Email: raw})
+	}
+	return result
+}
+
+func (input DraftRequest) apply(message *database.Message) {
+	message.To = draftAddresses(input.To)
+	message.Cc = draftAddresses(input.Cc)
+	message.Bcc = draftAddresses(input.Bcc)
+	message.Subject = input.Subject
+	message.TextBody = input.TextBody
+	message.HTMLBody = input.HTMLBody
+	message.Date = time.Now()
+}
+
+func (s *HTTPServer) createDraft(w http.ResponseWriter, r *http.Request) {
+	var input DraftRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	message, err := s.composeFromUser(r.Context(), currentUser(r))
+	if err != nil {
+		slog.Error("failed to get sender", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	drafts, err := s.repo.Folders.GetSystem(r.Context(), message.Owner, database.FolderDrafts)
+	if err != nil {
+		slog.Error("failed to get drafts folder", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	input.apply(&message)
+	if input.ReplyTo != 0 {
+		if err := s.setReplyTo(r.Context(), &message, input.ReplyTo); err != nil {
+			replyErrorResponse(w, r, err)
+			return
+		}
+	}
+	message.FolderID = drafts.ID
+	message.Flags = []string{database.FlagSeen, database.FlagDraft}
+
+	id, err := s.repo.Messages.Create(r.Context(), message)
+	if err != nil {
+		slog.Error("failed to create draft", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	s.writeMessage(w, r, http.StatusCreated, id)
+}
+
+func (s *HTTPServer) updateDraft(w http.ResponseWriter, r *http.Request) {
+	message, ok := s.draftFromPath(w, r)
+	if !ok {
+		return
+	}
+	var input DraftRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	input.apply(&message)
+	message.Version = input.Version
+	err := s.repo.Messages.Update(r.Context(), message)
+	if errors.Is(err, database.ErrVersionConflict) {
+		ErrorResponseWithStatus(w, r, http.StatusConflict, "version_conflict")
+		return
+	}
+	if err != nil {
+		slog.Error("failed to update draft", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	s.writeMessage(w, r, http.StatusOK, message.ID)
+}
+
+// deleteDraft удаляет черновик вместе с загруженными вложениями. Если
+// передан параметр version, удаление устаревшей версии отклоняется.
+func (s *HTTPServer) deleteDraft(w http.ResponseWriter, r *http.Request) {
+	message, ok := s.draftFromPath(w, r)
+	if !ok {
+		return
+	}
+	if raw := r.URL.Query().Get("version"); raw != "" {
+		version, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+			return
+		}
+		if version != message.Version {
+			ErrorResponseWithStatus(w, r, http.StatusConflict, "version_conflict")
+			return
+		}
+	}
+
+	if err := s.repo.Messages.Delete(r.Context(), message.Owner, message.ID); err != nil {
+		slog.Error("failed to delete draft", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	for _, attachment := range message.Attachments {
+		if attachment.StorageKey == "" {
+			continue
+		}
+		if err := s.blobs.Delete(r.Context(), attachment.StorageKey); err != nil {
+			slog.Error("failed to remove attachment", "key", attachment.StorageKey, "error", err)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sendDraft отправляет черновик, перенося его в «Отправленные».
+func (s *HTTPServer) sendDraft(w http.ResponseWriter, r *http.Request) {
+	message, ok := s.draftFromPath(w, r)
+	if !ok {
+		return
+	}
+	var input SendDraftRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if input.Version != message.Version {
+		ErrorResponseWithStatus(w, r, http.StatusConflict, "version_conflict")
+		return
+	}
+
+	total := 0
+	for _, list := range [][]database.Address{message.To, message.Cc, message.Bcc} {
+		for _, address := range list {
+			if !emailIsValid(address.Email) {
+				ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_email")
+				return
+			}
+			total++
+		}
+	}
+	if total == 0 || total > maxRecipients {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_recipients")
+		return
+	}
+	if !s.senderIsLocal(currentUser(r)) {
+		ErrorResponse(w, r, "sender_not_local")
+		return
+	}
+
+	sent, err := s.sendMessage(r.Context(), message)
+	if errors.Is(err, database.ErrVersionConflict) {
+		ErrorResponseWithStatus(w, r, http.StatusConflict, "version_conflict")
+		return
+	}
+	if err != nil {
+		slog.Error("failed to send draft", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, toMessageJSON(sent))
+}
+
+// writeMessage отвечает свежей версией письма из хранилища.
+func (s *HTTPServer) writeMessage(w http.ResponseWriter, r *http.Request, status int, id int64) {
+	message, err := s.repo.Messages.GetByID(r.Context(), currentUser(r), id)
+	if err != nil {
+		slog.Error("failed to get message", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	writeJSON(w, status, toMessageJSON(message))
+}
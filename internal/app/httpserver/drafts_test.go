@@ -0,0 +1,136 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"mail/database"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func decodeMessage(t *testing.T, body []byte) MessageJSON {
+	t.Helper()
+	var message MessageJSON
+	if err := json.Unmarshal(body, &message); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	return message
+}
+
+func TestDraftAutosave(t *testing.T) {
+	s := newTestServer()
+	createTestUser(s, "jane", testUserEmail, "12345")
+	router := newTestRouter(s, testUserEmail)
+
+	rr := doJSON(t, router, "POST", "/mail/drafts", DraftRequest{To: []string{"mark@exa"}, Subject: "Rep"})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: got %v want %v: %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+	draft := decodeMessage(t, rr.Body.Bytes())
+	if draft.Version != 1 || draft.To[0].Email != "mark@exa" || draft.FolderID != systemFolderID(s, testUserEmail, database.FolderDrafts) {
+		t.Errorf("unexpected draft: %+v", draft)
+	}
+	url := "/mail/drafts/" + strconv.FormatInt(draft.ID, 10)
+
+	rr = doJSON(t, router, "PUT", url, DraftRequest{Version: 1, To: []string{"mark@example.com"}, Subject: "Report"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("update: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	if updated := decodeMessage(t, rr.Body.Bytes()); updated.Version != 2 || updated.Subject != "Report" {
+		t.Errorf("unexpected updated draft: %+v", updated)
+	}
+
+	if rr := doJSON(t, router, "PUT", url, DraftRequest{Version: 1, Subject: "Stale"}); rr.Code != http.StatusConflict {
+		t.Errorf("stale update: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if rr := doJSON(t, router, "DELETE", url+"?version=1", nil); rr.Code != http.StatusConflict {
+		t.Errorf("stale delete: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if rr := doJSON(t, router, "DELETE", url+"?version=2", nil); rr.Code != http.StatusNoContent {
+		t.Errorf("delete: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if rr := doJSON(t, router, "PUT", url, DraftRequest{Version: 2}); rr.Code != http.StatusNotFound {
+		t.Errorf("update deleted: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestSendDraft(t *testing.T) {
+	s := newTestServer()
+	createTestUser(s, "jane", testUserEmail, "12345")
+	router := newTestRouter(s, testUserEmail)
+
+	rr := doJSON(t, router, "POST", "/mail/drafts", DraftRequest{To: []string{"mark@"}, Subject: "Report", TextBody: "See attached"})
+	draft := decodeMessage(t, rr.Body.Bytes())
+	url := "/mail/drafts/" + strconv.FormatInt(draft.ID, 10)
+	if rr := uploadFile(t, router, "/mail/messages/"+strconv.FormatInt(draft.ID, 10)+"/attachments", "q3.csv", []byte("q1,q2,q3\n")); rr.Code != http.StatusCreated {
+		t.Fatalf("upload: got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := doJSON(t, router, "POST", url+"/send", SendDraftRequest{Version: 2}); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid recipient: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	doJSON(t, router, "PUT", url, DraftRequest{Version: 2, To: []string{"mark@example.com"}, Subject: "Report", TextBody: "See attached"})
+	if rr := doJSON(t, router, "POST", url+"/send", SendDraftRequest{Version: 2}); rr.Code != http.StatusConflict {
+		t.Errorf("stale send: got %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	rr = doJSON(t, router, "POST", url+"/send", SendDraftRequest{Version: 3})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("send: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	sent := decodeMessage(t, rr.Body.Bytes())
+	if sent.ID != draft.ID || sent.FolderID != systemFolderID(s, testUserEmail, database.FolderSent) ||
+		sent.MessageID == "" || len(sent.Attachments) != 1 {
+		t.Errorf("draft was not converted into a sent message: %+v", sent)
+	}
+	if rr := doJSON(t, router, "PUT", url, DraftRequest{Version: sent.Version}); rr.Code != http.StatusConflict {
+		t.Errorf("sent message must not be editable as a draft: got %v", rr.Code)
+	}
+
+	due, err := s.repo.Outbound.ClaimDue(context.Background(), time.Now().Add(time.Second), time.Minute, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || !strings.Contains(string(due[0].Data), `filename=q3.csv`) {
+		t.Fatalf("unexpected queue: %+v", due)
+	}
+}
+
+func TestSendDraftUsesOwnAddress(t *testing.T) {
+	s := newTestServer()
+	createTestUser(s, "jane", testUserEmail, "12345")
+	router := newTestRouter(s, testUserEmail)
+	drafts := systemFolderID(s, testUserEmail, database.FolderDrafts)
+	foreign := database.Address{Name: "Boss", Email: "boss@example.com"}
+
+	// полученное письмо, перенесённое в черновики, черновиком не становится
+	received := seedMessage(s, database.Message{Owner: testUserEmail, From: foreign, To: []database.Address{{Email: "mark@example.com"}}, FolderID: drafts})
+	if rr := doJSON(t, router, "POST", "/mail/drafts/"+strconv.FormatInt(received, 10)+"/send", SendDraftRequest{Version: 1}); rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), "not_a_draft") {
+		t.Errorf("received message: got %v %s", rr.Code, rr.Body.String())
+	}
+
+	id := seedMessage(s, database.Message{
+		Owner: testUserEmail, From: foreign, To: []database.Address{{Email: "mark@example.com"}},
+		Subject: "Report", FolderID: drafts, Flags: []string{database.FlagDraft},
+	})
+	rr := doJSON(t, router, "POST", "/mail/drafts/"+strconv.FormatInt(id, 10)+"/send", SendDraftRequest{Version: 1})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("send: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	if sent := decodeMessage(t, rr.Body.Bytes()); sent.From.Email != testUserEmail {
+		t.Errorf("sent copy from %+v", sent.From)
+	}
+	due, err := s.repo.Outbound.ClaimDue(context.Background(), time.Now().Add(time.Second), time.Minute, 10)
+	if err != nil || len(due) != 1 {
+		t.Fatalf("unexpected queue: %+v, %v", due, err)
+	}
+	if due[0].From != testUserEmail || due[0].Sender != testUserEmail {
+		t.Errorf("envelope from %q sender %q", due[0].From, due[0].Sender)
+	}
+	if data := string(due[0].Data); !strings.Contains(data, "From: \"jane\" <"+testUserEmail+">") || strings.Contains(data, foreign.Email) {
+		t.Errorf("unexpected header:\n%s", data)
+	}
+}
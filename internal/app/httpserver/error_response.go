@@ -12,10 +12,14 @@ type errorResponse struct {
 }
 
 func ErrorResponse(w http.ResponseWriter, r *http.Request, message string) {
+	ErrorResponseWithStatus(w, r, http.StatusForbidden, message)
+}
+
+func ErrorResponseWithStatus(w http.ResponseWriter, r *http.Request, status int, message string) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusForbidden)
+	w.WriteHeader(status)
 	response := errorResponse{
-		Status: http.StatusForbidden,
+		Status: status,
 		Body:   message,
 	}
 	jsonResponse, _ := json.Marshal(response)
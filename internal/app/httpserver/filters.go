@@ -0,0 +1,522 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mail/database"
+	"mail/pkg/sieve"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxFilterNameLength   = 100
+	maxFilterValueLength  = 256
+	maxFilterConditions   = 20
+	maxFilterActions      = 20
+	maxFiltersPerUser     = 200
+	maxSieveScriptBytes   = 256 << 10
+	defaultDryRunMessages = 100
+	maxDryRunMessages     = 1000
+)
+
+// headerName - имя поля заголовка по RFC 5322.
+var headerName = regexp.MustCompile(`^[!-9;-~]{1,76}$`)
+
+type FilterConditionJSON struct {
+	Field  string `json:"field"`
+	Header string `json:"header,omitempty"`
+	Op     string `json:"op"`
+	Value  string `json:"value"`
+	Not    bool   `json:"not"`
+}
+
+type FilterActionJSON struct {
+	Type     string `json:"type"`
+	FolderID int64  `json:"folder_id,omitempty"`
+	LabelID  int64  `json:"label_id,omitempty"`
+	Flag     string `json:"flag,omitempty"`
+	Address  string `json:"address,omitempty"`
+}
+
+// FilterRequest - правило в запросе. Match - "all" (по умолчанию) или
+// "any", Enabled по умолчанию true.
+type FilterRequest struct {
+	Name       string                `json:"name"`
+	Enabled    *bool                 `json:"enabled"`
+	Match      string                `json:"match"`
+	Conditions []FilterConditionJSON `json:"conditions"`
+	Actions    []FilterActionJSON    `json:"actions"`
+}
+
+type FilterJSON struct {
+	ID         int64                 `json:"id"`
+	Name       string                `json:"name"`
+	Position   int                   `json:"position"`
+	Enabled    bool                  `json:"enabled"`
+	Match      string                `json:"match"`
+	Conditions []FilterConditionJSON `json:"conditions"`
+	Actions    []FilterActionJSON    `json:"actions"`
+}
+
+type ReorderFiltersRequest struct {
+	IDs []int64 `json:"ids"`
+}
+
+// DryRunRequest проверяет правило Rule, а если оно не задано, все
+// сохранённые правила на последних Limit письмах папки Folder.
+type DryRunRequest struct {
+	Rule   *FilterRequest `json:"rule"`
+	Folder string         `json:"folder"`
+	Limit  int            `json:"limit"`
+}
+
+type DryRunMatchJSON struct {
+	Message   MessageSummaryJSON `json:"message"`
+	Rules     []int64            `json:"rules"`
+	FolderID  int64              `json:"folder_id,omitempty"`
+	Labels    []int64            `json:"labels"`
+	Flags     []string           `json:"flags"`
+	Redirects []string           `json:"redirects"`
+	Discard   bool               `json:"discard"`
+}
+
+type DryRunResponse struct {
+	Checked int               `json:"checked"`
+	Matches []DryRunMatchJSON `json:"matches"`
+}
+
+// SieveErrorJSON - ошибка импорта скрипта с указанием строки.
+type SieveErrorJSON struct {
+	Status int    `json:"status"`
+	Body   string `json:"body"`
+	Line   int    `json:"line"`
+	Error  string `json:"error"`
+}
+
+func toFilterJSON(rule database.FilterRule) FilterJSON {
+	result := FilterJSON{
+		ID:         rule.ID,
+		Name:       rule.Name,
+		Position:   rule.Position,
+		Enabled:    rule.Enabled,
+		Match:      "any",
+		Conditions: make([]FilterConditionJSON, 0, len(rule.Conditions)),
+		Actions:    make([]FilterActionJSON, 0, len(rule.Actions)),
+	}
+	if rule.MatchAll {
+		result.Match = "all"
+	}
+	for _, c := range rule.Conditions {
+		result.Conditions = append(result.Conditions, FilterConditionJSON{
+			Field: c.Field, Header: c.Header, Op: c.Op, Value: c.Value, Not: c.Not,
+		})
+	}
+	for _, a := range rule.Actions {
+		result.Actions = append(result.Actions, FilterActionJSON{
+			Type: a.Type, FolderID: a.FolderID, LabelID: a.LabelID, Flag: a.Flag, Address: a.Address,
+		})
+	}
+	return result
+}
+
+func toFiltersJSON(rules []database.FilterRule) []FilterJSON {
+	result := make([]FilterJSON, 0, len(rules))
+	for _, rule := range rules {
+		result = append(result, toFilterJSON(rule))
+	}
+	return result
+}
+
+// filterErrorResponse отвечает на ошибки хранилищ, которые встречаются
+// при работе с правилами.
+func filterErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, database.ErrFilterNotFound):
+		ErrorResponseWithStatus(w, r, http.StatusNotFound, "filter_not_found")
+	case errors.Is(err, database.ErrFolderNotFound):
+		ErrorResponseWithStatus(w, r, http.StatusNotFound, "folder_not_found")
+	case errors.Is(err, database.ErrLabelNotFound):
+		ErrorResponseWithStatus(w, r, http.StatusNotFound, "label_not_found")
+	default:
+		slog.Error("filter storage error", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+	}
+}
+
+// toFilterRule проверяет правило из запроса. Папки и метки из действий
+// должны существовать, ошибка хранилища возвращается как есть, а
+// неверные поля - как ok == false.
+func (s *HTTPServer) toFilterRule(r *http.Request, input FilterRequest) (rule database.FilterRule, ok bool, err error) {
+	rule = database.FilterRule{
+		Owner:    currentUser(r),
+		Name:     strings.TrimSpace(input.Name),
+		Enabled:  input.Enabled == nil || *input.Enabled,
+		MatchAll: input.Match == "" || input.Match == "all",
+	}
+	if utf8.RuneCountInString(rule.Name) > maxFilterNameLength || input.Match != "" && input.Match != "all" && input.Match != "any" {
+		return rule, false, nil
+	}
+	if len(input.Conditions) > maxFilterConditions || len(input.Actions) == 0 || len(input.Actions) > maxFilterActions {
+		return rule, false, nil
+	}
+	for _, c := range input.Conditions {
+		condition := database.FilterCondition{Field: c.Field, Header: c.Header, Op: c.Op, Value: c.Value, Not: c.Not}
+		if !filterConditionIsValid(condition) {
+			return rule, false, nil
+		}
+		rule.Conditions = append(rule.Conditions, condition)
+	}
+	for _, a := range input.Actions {
+		action := database.FilterAction{Type: a.Type}
+		switch a.Type {
+		case database.FilterActionFileInto:
+			if _, err := s.repo.Folders.GetByID(r.Context(), rule.Owner, a.FolderID); err != nil {
+				return rule, false, err
+			}
+			action.FolderID = a.FolderID
+		case database.FilterActionLabel:
+			if _, err := s.repo.Labels.GetByID(r.Context(), rule.Owner, a.LabelID); err != nil {
+				return rule, false, err
+			}
+			action.LabelID = a.LabelID
+		case database.FilterActionFlag:
+			if !database.ValidFlag(a.Flag) {
+				return rule, false, nil
+			}
+			action.Flag = a.Flag
+		case database.FilterActionRedirect:
+			address, err := mail.ParseAddress(a.Address)
+			if err != nil || address.Address != a.Address || strings.EqualFold(a.Address, rule.Owner) {
+				return rule, false, nil
+			}
+			action.Address = a.Address
+		case database.FilterActionDiscard, database.FilterActionStop:
+		default:
+			return rule, false, nil
+		}
+		rule.Actions = append(rule.Actions, action)
+	}
+	return rule, true, nil
+}
+
+func filterConditionIsValid(c database.FilterCondition) bool {
+	if c.Field == database.FilterFieldSize {
+		size, err := strconv.ParseInt(c.Value, 10, 64)
+		return err == nil && size >= 0 && (c.Op == database.FilterOpOver || c.Op == database.FilterOpUnder)
+	}
+	switch c.Field {
+	case database.FilterFieldFrom, database.FilterFieldTo, database.FilterFieldSubject:
+		if c.Header != "" {
+			return false
+		}
+	case database.FilterFieldHeader:
+		if !headerName.MatchString(c.Header) {
+			return false
+		}
+	default:
+		return false
+	}
+	return (c.Op == database.FilterOpContains || c.Op == database.FilterOpIs || c.Op == database.FilterOpMatches) &&
+		c.Value != "" && utf8.RuneCountInString(c.Value) <= maxFilterValueLength
+}
+
+// decodeFilter читает и проверяет правило из тела запроса, отвечая на
+// ошибки сам.
+func (s *HTTPServer) decodeFilter(w http.ResponseWriter, r *http.Request) (database.FilterRule, bool) {
+	var input FilterRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return database.FilterRule{}, false
+	}
+	return s.checkFilter(w, r, input)
+}
+
+func (s *HTTPServer) checkFilter(w http.ResponseWriter, r *http.Request, input FilterRequest) (database.FilterRule, bool) {
+	rule, ok, err := s.toFilterRule(r, input)
+	if err != nil {
+		filterErrorResponse(w, r, err)
+		return rule, false
+	}
+	if !ok {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return rule, false
+	}
+	return rule, true
+}
+
+func (s *HTTPServer) listFilters(w http.ResponseWriter, r *http.Request) {
+	rules, err := s.repo.Filters.List(r.Context(), currentUser(r))
+	if err != nil {
+		filterErrorResponse(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toFiltersJSON(rules))
+}
+
+func (s *HTTPServer) createFilter(w http.ResponseWriter, r *http.Request) {
+	rule, ok := s.decodeFilter(w, r)
+	if !ok {
+		return
+	}
+	rules, err := s.repo.Filters.List(r.Context(), rule.Owner)
+	if err != nil {
+		filterErrorResponse(w, r, err)
+		return
+	}
+	if len(rules) >= maxFiltersPerUser {
+		ErrorResponseWithStatus(w, r, http.StatusConflict, "too_many_filters")
+		return
+	}
+	id, err := s.repo.Filters.Create(r.Context(), rule)
+	if err != nil {
+		filterErrorResponse(w, r, err)
+		return
+	}
+	s.writeFilter(w, r, id)
+}
+
+func (s *HTTPServer) getFilter(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+	s.writeFilter(w, r, id)
+}
+
+func (s *HTTPServer) updateFilter(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+	rule, ok := s.decodeFilter(w, r)
+	if !ok {
+		return
+	}
+	rule.ID = id
+	if err := s.repo.Filters.Update(r.Context(), rule); err != nil {
+		filterErrorResponse(w, r, err)
+		return
+	}
+	s.writeFilter(w, r, id)
+}
+
+func (s *HTTPServer) deleteFilter(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+	if err := s.repo.Filters.Delete(r.Context(), currentUser(r), id); err != nil {
+		filterErrorResponse(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *HTTPServer) writeFilter(w http.ResponseWriter, r *http.Request, id int64) {
+	rule, err := s.repo.Filters.GetByID(r.Context(), currentUser(r), id)
+	if err != nil {
+		filterErrorResponse(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toFilterJSON(rule))
+}
+
+// reorderFilters принимает ID всех правил пользователя в новом порядке.
+func (s *HTTPServer) reorderFilters(w http.ResponseWriter, r *http.Request) {
+	var input ReorderFiltersRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	rules, err := s.repo.Filters.List(r.Context(), currentUser(r))
+	if err != nil {
+		filterErrorResponse(w, r, err)
+		return
+	}
+	ids := uniqueIDs(input.IDs)
+	if len(ids) != len(input.IDs) || len(ids) != len(rules) {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+	if err := s.repo.Filters.Reorder(r.Context(), currentUser(r), ids); err != nil {
+		filterErrorResponse(w, r, err)
+		return
+	}
+	s.listFilters(w, r)
+}
+
+func (s *HTTPServer) mailbox(r *http.Request) (sieve.Mailbox, error) {
+	folders, err := s.repo.Folders.List(r.Context(), currentUser(r))
+	if err != nil {
+		return sieve.Mailbox{}, err
+	}
+	labels, err := s.repo.Labels.List(r.Context(), currentUser(r))
+	if err != nil {
+		return sieve.Mailbox{}, err
+	}
+	return sieve.Mailbox{Folders: folders, Labels: labels}, nil
+}
+
+// exportSieve отдаёт правила скриптом Sieve (RFC 5228).
+func (s *HTTPServer) exportSieve(w http.ResponseWriter, r *http.Request) {
+	rules, err := s.repo.Filters.List(r.Context(), currentUser(r))
+	if err != nil {
+		filterErrorResponse(w, r, err)
+		return
+	}
+	mailbox, err := s.mailbox(r)
+	if err != nil {
+		filterErrorResponse(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/sieve; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, sieve.Format(rules, mailbox))
+}
+
+// importSieve заменяет все правила пользователя правилами из скрипта.
+// Папки и метки, на которые ссылается скрипт, должны существовать.
+func (s *HTTPServer) importSieve(w http.ResponseWriter, r *http.Request) {
+	script, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSieveScriptBytes))
+	if err != nil || !utf8.Valid(script) {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+	mailbox, err := s.mailbox(r)
+	if err != nil {
+		filterErrorResponse(w, r, err)
+		return
+	}
+	rules, err := sieve.Parse(string(script), mailbox)
+	var parseErr *sieve.Error
+	if errors.As(err, &parseErr) {
+		writeJSON(w, http.StatusBadRequest, SieveErrorJSON{
+			Status: http.StatusBadRequest,
+			Body:   "invalid_sieve",
+			Line:   parseErr.Line,
+			Error:  parseErr.Message,
+		})
+		return
+	}
+	if err != nil {
+		filterErrorResponse(w, r, err)
+		return
+	}
+	// правила из одного keep ничего не делают
+	kept := make([]database.FilterRule, 0, len(rules))
+	for _, rule := range rules {
+		if len(rule.Actions) > 0 {
+			kept = append(kept, rule)
+		}
+	}
+	if len(kept) > maxFiltersPerUser {
+		ErrorResponseWithStatus(w, r, http.StatusConflict, "too_many_filters")
+		return
+	}
+	for _, rule := range kept {
+		if _, ok := s.checkFilter(w, r, toFilterRequest(rule)); !ok {
+			return
+		}
+	}
+	if err := s.repo.Filters.Replace(r.Context(), currentUser(r), kept); err != nil {
+		filterErrorResponse(w, r, err)
+		return
+	}
+	s.listFilters(w, r)
+}
+
+// toFilterRequest нужен, чтобы импортированные правила проходили те же
+// проверки, что и созданные через API.
+func toFilterRequest(rule database.FilterRule) FilterRequest {
+	rule.Position = 0
+	result := toFilterJSON(rule)
+	return FilterRequest{
+		Name:       result.Name,
+		Enabled:    &result.Enabled,
+		Match:      result.Match,
+		Conditions: result.Conditions,
+		Actions:    result.Actions,
+	}
+}
+
+// dryRunFilters показывает, что сделали бы правила с уже полученными
+// письмами, ничего не меняя.
+func (s *HTTPServer) dryRunFilters(w http.ResponseWriter, r *http.Request) {
+	var input DryRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if input.Limit == 0 {
+		input.Limit = defaultDryRunMessages
+	}
+	if input.Folder == "" {
+		input.Folder = database.FolderInbox
+	}
+	if input.Limit < 0 || input.Limit > maxDryRunMessages {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+
+	var rules []database.FilterRule
+	if input.Rule != nil {
+		rule, ok := s.checkFilter(w, r, *input.Rule)
+		if !ok {
+			return
+		}
+		rule.Enabled = true
+		rules = []database.FilterRule{rule}
+	} else {
+		var err error
+		if rules, err = s.repo.Filters.List(r.Context(), currentUser(r)); err != nil {
+			filterErrorResponse(w, r, err)
+			return
+		}
+	}
+	folder, err := s.resolveFolder(r, input.Folder)
+	if err != nil {
+		folderErrorResponse(w, r, err)
+		return
+	}
+	page, err := s.repo.Messages.List(r.Context(), currentUser(r), database.ListQuery{
+		FolderID: folder.ID,
+		Sort:     database.SortDate,
+		Desc:     true,
+		Limit:    input.Limit,
+	})
+	if err != nil {
+		slog.Error("failed to list messages", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+
+	result := DryRunResponse{Checked: len(page.Messages), Matches: make([]DryRunMatchJSON, 0)}
+	for _, message := range page.Messages {
+		outcome := database.RunFilters(rules, message)
+		if len(outcome.Rules) == 0 {
+			continue
+		}
+		result.Matches = append(result.Matches, DryRunMatchJSON{
+			Message:   toMessageSummaryJSON(message),
+			Rules:     outcome.Rules,
+			FolderID:  outcome.FolderID,
+			Labels:    nonNilLabels(outcome.Labels),
+			Flags:     nonNilFlags(outcome.Flags),
+			Redirects: append([]string{}, outcome.Redirects...),
+			Discard:   outcome.Discard,
+		})
+	}
+	writeJSON(w, http.StatusOK, result)
+}
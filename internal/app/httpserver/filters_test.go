@@ -0,0 +1,198 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mail/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func createTestFilter(t *testing.T, handler http.Handler, input FilterRequest) FilterJSON {
+	t.Helper()
+	rr := doJSON(t, handler, "POST", "/mail/filters", input)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %v: %s", rr.Code, rr.Body.String())
+	}
+	var rule FilterJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &rule); err != nil {
+		t.Fatal(err)
+	}
+	return rule
+}
+
+func TestFiltersCRUD(t *testing.T) {
+	s := newTestServer()
+	router := newTestRouter(s, testUserEmail)
+	spam := systemFolderID(s, testUserEmail, database.FolderSpam)
+
+	first := createTestFilter(t, router, FilterRequest{
+		Name:       "Рассылки",
+		Match:      "any",
+		Conditions: []FilterConditionJSON{{Field: "header", Header: "List-Id", Op: "contains", Value: "news"}},
+		Actions:    []FilterActionJSON{{Type: "fileinto", FolderID: spam}, {Type: "stop"}},
+	})
+	if !first.Enabled || first.Match != "any" || first.Position != 0 {
+		t.Errorf("unexpected filter: %+v", first)
+	}
+	second := createTestFilter(t, router, FilterRequest{
+		Conditions: []FilterConditionJSON{{Field: "size", Op: "over", Value: "1048576"}},
+		Actions:    []FilterActionJSON{{Type: "flag", Flag: database.FlagFlagged}},
+	})
+
+	for _, input := range []FilterRequest{
+		{Actions: nil},
+		{Match: "some", Actions: []FilterActionJSON{{Type: "stop"}}},
+		{Conditions: []FilterConditionJSON{{Field: "size", Op: "contains", Value: "1"}}, Actions: []FilterActionJSON{{Type: "stop"}}},
+		{Conditions: []FilterConditionJSON{{Field: "header", Header: "Bad Header", Op: "is", Value: "x"}}, Actions: []FilterActionJSON{{Type: "stop"}}},
+		{Conditions: []FilterConditionJSON{{Field: "subject", Op: "is"}}, Actions: []FilterActionJSON{{Type: "stop"}}},
+		{Actions: []FilterActionJSON{{Type: "redirect", Address: "Boss <boss@example.com>"}}},
+		{Actions: []FilterActionJSON{{Type: "redirect", Address: testUserEmail}}},
+		{Actions: []FilterActionJSON{{Type: "flag", Flag: "two words"}}},
+		{Actions: []FilterActionJSON{{Type: "vacation"}}},
+	} {
+		if rr := doJSON(t, router, "POST", "/mail/filters", input); rr.Code != http.StatusBadRequest {
+			t.Errorf("%+v: got %v want %v", input, rr.Code, http.StatusBadRequest)
+		}
+	}
+	if rr := doJSON(t, router, "POST", "/mail/filters", FilterRequest{
+		Actions: []FilterActionJSON{{Type: "fileinto", FolderID: 100500}},
+	}); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown folder: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	disabled := false
+	rr := doJSON(t, router, "PUT", fmt.Sprintf("/mail/filters/%d", first.ID), FilterRequest{
+		Name:    "Выключено",
+		Enabled: &disabled,
+		Actions: []FilterActionJSON{{Type: "discard"}},
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = doJSON(t, router, "PUT", "/mail/filters/order", ReorderFiltersRequest{IDs: []int64{second.ID, first.ID}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %v: %s", rr.Code, rr.Body.String())
+	}
+	var rules []FilterJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &rules); err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0].ID != second.ID || rules[1].Name != "Выключено" || rules[1].Enabled {
+		t.Errorf("unexpected filters: %+v", rules)
+	}
+	if rr := doJSON(t, router, "PUT", "/mail/filters/order", ReorderFiltersRequest{IDs: []int64{second.ID}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("partial order: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	url := fmt.Sprintf("/mail/filters/%d", second.ID)
+	if rr := doJSON(t, router, "DELETE", url, nil); rr.Code != http.StatusOK {
+		t.Errorf("delete: got %v", rr.Code)
+	}
+	if rr := doJSON(t, router, "GET", url, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("deleted filter: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func doSieve(t *testing.T, handler http.Handler, method string, script string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, "/mail/filters/sieve", strings.NewReader(script))
+	addTestSession(req)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestSieveImportExport(t *testing.T) {
+	s := newTestServer()
+	router := newTestRouter(s, testUserEmail)
+	if _, err := s.repo.Folders.Create(context.Background(), database.Folder{Owner: testUserEmail, Name: "Работа"}); err != nil {
+		t.Fatal(err)
+	}
+	createTestLabel(t, router, "важное")
+
+	script := `require ["fileinto", "vnd.gigamail.label"];
+
+# rule:[Начальник]
+if header :is "from" "boss@example.com" {
+    fileinto "Работа";
+    label "важное";
+}
+`
+	rr := doSieve(t, router, "PUT", script)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %v: %s", rr.Code, rr.Body.String())
+	}
+	var rules []FilterJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &rules); err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].Name != "Начальник" || len(rules[0].Actions) != 2 {
+		t.Errorf("unexpected filters: %+v", rules)
+	}
+
+	rr = doSieve(t, router, "GET", "")
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "application/sieve") {
+		t.Fatalf("got %v %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if rr.Body.String() != script {
+		t.Errorf("export differs from import:\n%s", rr.Body.String())
+	}
+
+	rr = doSieve(t, router, "PUT", "require \"fileinto\";\nfileinto \"Нет такой\";")
+	var sieveErr SieveErrorJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &sieveErr); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusBadRequest || sieveErr.Body != "invalid_sieve" || sieveErr.Line != 2 {
+		t.Errorf("got %v %+v", rr.Code, sieveErr)
+	}
+	if rules, _ := s.repo.Filters.List(context.Background(), testUserEmail); len(rules) != 1 {
+		t.Errorf("failed import replaced filters: %+v", rules)
+	}
+}
+
+func TestDryRunFilters(t *testing.T) {
+	s := newTestServer()
+	router := newTestRouter(s, testUserEmail)
+	inbox := systemFolderID(s, testUserEmail, database.FolderInbox)
+	spam := systemFolderID(s, testUserEmail, database.FolderSpam)
+	matched := seedMessage(s, database.Message{Owner: testUserEmail, FolderID: inbox, Subject: "Big SALE today"})
+	seedMessage(s, database.Message{Owner: testUserEmail, FolderID: inbox, Subject: "Report"})
+
+	rule := FilterRequest{
+		Conditions: []FilterConditionJSON{{Field: "subject", Op: "matches", Value: "*sale*"}},
+		Actions:    []FilterActionJSON{{Type: "fileinto", FolderID: spam}},
+	}
+	rr := doJSON(t, router, "POST", "/mail/filters/dry-run", DryRunRequest{Rule: &rule})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %v: %s", rr.Code, rr.Body.String())
+	}
+	var result DryRunResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Checked != 2 || len(result.Matches) != 1 || result.Matches[0].Message.ID != matched ||
+		result.Matches[0].FolderID != spam {
+		t.Errorf("unexpected dry run: %+v", result)
+	}
+	message, _ := s.repo.Messages.GetByID(context.Background(), testUserEmail, matched)
+	if message.FolderID != inbox {
+		t.Errorf("dry run moved message to %d", message.FolderID)
+	}
+
+	rr = doJSON(t, router, "POST", "/mail/filters/dry-run", DryRunRequest{})
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusOK || len(result.Matches) != 0 {
+		t.Errorf("saved filters: got %v %+v", rr.Code, result)
+	}
+	if rr := doJSON(t, router, "POST", "/mail/filters/dry-run", DryRunRequest{Folder: "nope"}); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown folder: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
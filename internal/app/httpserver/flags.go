@@ -0,0 +1,85 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"log/slog"
+	"mail/database"
+	"net/http"
+)
+
+const maxFlagsPerRequest = 20
+
+// UpdateFlagsRequest - флаги в терминах IMAP: \Seen, \Flagged,
+// \Answered, \Draft, \Deleted или ключевые слова вроде $Important.
+type UpdateFlagsRequest struct {
+	IDs    []int64  `json:"ids"`
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+// UpdateFlagsResponse возвращает вместе с числом найденных писем
+// папки с пересчитанными счётчиками.
+type UpdateFlagsResponse struct {
+	Updated int          `json:"updated"`
+	Folders []FolderJSON `json:"folders"`
+}
+
+func flagsAreValid(add []string, remove []string) bool {
+	if len(add)+len(remove) == 0 || len(add)+len(remove) > maxFlagsPerRequest {
+		return false
+	}
+	adding := make(map[string]bool, len(add))
+	for _, flag := range add {
+		if !database.ValidFlag(flag) {
+			return false
+		}
+		adding[flag] = true
+	}
+	for _, flag := range remove {
+		if !database.ValidFlag(flag) || adding[flag] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *HTTPServer) updateFlags(w http.ResponseWriter, r *http.Request) {
+	var input UpdateFlagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(input.IDs) == 0 || len(input.IDs) > maxBulkMessages || !flagsAreValid(input.Add, input.Remove) {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+
+	updated, err := s.repo.Messages.UpdateFlags(r.Context(), currentUser(r), uniqueIDs(input.IDs), input.Add, input.Remove)
+	if err != nil {
+		slog.Error("failed to update flags", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	folders, err := s.repo.Folders.List(r.Context(), currentUser(r))
+	if err != nil {
+		folderErrorResponse(w, r, err)
+		return
+	}
+	result := UpdateFlagsResponse{Updated: updated, Folders: make([]FolderJSON, 0, len(folders))}
+	for _, folder := range folders {
+		result.Folders = append(result.Folders, toFolderJSON(folder))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func uniqueIDs(ids []int64) []int64 {
+	seen := make(map[int64]bool, len(ids))
+	result := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
@@ -0,0 +1,52 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"mail/database"
+	"net/http"
+	"testing"
+)
+
+func TestUpdateFlags(t *testing.T) {
+	s := newTestServer()
+	router := newTestRouter(s, testUserEmail)
+	inbox := systemFolderID(s, testUserEmail, database.FolderInbox)
+	first := seedMessage(s, database.Message{Owner: testUserEmail, FolderID: inbox})
+	second := seedMessage(s, database.Message{Owner: testUserEmail, FolderID: inbox, Flags: []string{database.FlagSeen}})
+
+	rr := doJSON(t, router, "PATCH", "/mail/messages", UpdateFlagsRequest{
+		IDs: []int64{first, second, first, 100500},
+		Add: []string{database.FlagSeen, database.FlagImportant},
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %v: %s", rr.Code, rr.Body.String())
+	}
+	var result UpdateFlagsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Updated != 2 || result.Folders[0].ID != inbox || result.Folders[0].Unread != 0 {
+		t.Errorf("unexpected response: %+v", result)
+	}
+
+	doJSON(t, router, "PATCH", "/mail/messages", UpdateFlagsRequest{IDs: []int64{second}, Remove: []string{database.FlagSeen}})
+	list := getMessageList(t, router, "/mail/inbox?unread=true")
+	if len(list.Messages) != 1 || list.Messages[0].ID != second || list.Meta.Unread != 1 {
+		t.Errorf("unexpected unread list: %+v", list)
+	}
+	if flags := list.Messages[0].Flags; len(flags) != 1 || flags[0] != database.FlagImportant {
+		t.Errorf("unexpected flags: %q", flags)
+	}
+
+	for _, input := range []UpdateFlagsRequest{
+		{IDs: []int64{first}},
+		{Add: []string{database.FlagSeen}},
+		{IDs: []int64{first}, Add: []string{`\Recent`}},
+		{IDs: []int64{first}, Add: []string{"two words"}},
+		{IDs: []int64{first}, Add: []string{database.FlagSeen}, Remove: []string{database.FlagSeen}},
+	} {
+		if rr := doJSON(t, router, "PATCH", "/mail/messages", input); rr.Code != http.StatusBadRequest {
+			t.Errorf("%+v: got %v want %v", input, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
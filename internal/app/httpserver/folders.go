@@ -0,0 +1,169 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mail/database"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+const maxFolderNameLength = 64
+
+type FolderJSON struct {
+	ID       int64  `json:"id"`
+	ParentID int64  `json:"parent_id"`
+	Name     string `json:"name"`
+	System   string `json:"system,omitempty"`
+	Total    int    `json:"total"`
+	Unread   int    `json:"unread"`
+}
+
+type FolderRequest struct {
+	Name     string `json:"name"`
+	ParentID int64  `json:"parent_id"`
+}
+
+func toFolderJSON(folder database.Folder) FolderJSON {
+	return FolderJSON{
+		ID:       folder.ID,
+		ParentID: folder.ParentID,
+		Name:     folder.Name,
+		System:   folder.System,
+		Total:    folder.Total,
+		Unread:   folder.Unread,
+	}
+}
+
+// folderNameIsValid допускает любые буквы, в том числе кириллицу,
+// но не пустые имена и не разделитель пути.
+func folderNameIsValid(name string) bool {
+	return strings.TrimSpace(name) != "" && !strings.Contains(name, "/") &&
+		utf8.RuneCountInString(name) <= maxFolderNameLength
+}
+
+// folderErrorResponse отвечает на ошибки хранилища папок.
+func folderErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, database.ErrFolderNotFound):
+		ErrorResponseWithStatus(w, r, http.StatusNotFound, "folder_not_found")
+	case errors.Is(err, database.ErrFolderExists):
+		ErrorResponseWithStatus(w, r, http.StatusConflict, "folder_exists")
+	case errors.Is(err, database.ErrSystemFolder):
+		ErrorResponse(w, r, "system_folder")
+	default:
+		slog.Error("folder storage error", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+	}
+}
+
+// resolveFolder ищет папку по числовому ID или по имени системной папки.
+func (s *HTTPServer) resolveFolder(r *http.Request, param string) (database.Folder, error) {
+	if id, err := strconv.ParseInt(param, 10, 64); err == nil {
+		return s.repo.Folders.GetByID(r.Context(), currentUser(r), id)
+	}
+	for _, system := range database.SystemFolders {
+		if param == system {
+			return s.repo.Folders.GetSystem(r.Context(), currentUser(r), system)
+		}
+	}
+	return database.Folder{}, database.ErrFolderNotFound
+}
+
+func (s *HTTPServer) listFolders(w http.ResponseWriter, r *http.Request) {
+	folders, err := s.repo.Folders.List(r.Context(), currentUser(r))
+	if err != nil {
+		folderErrorResponse(w, r, err)
+		return
+	}
+	result := make([]FolderJSON, 0, len(folders))
+	for _, folder := range folders {
+		result = append(result, toFolderJSON(folder))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *HTTPServer) createFolder(w http.ResponseWriter, r *http.Request) {
+	var input FolderRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if !folderNameIsValid(input.Name) {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+
+	id, err := s.repo.Folders.Create(r.Context(), database.Folder{
+		Owner:    currentUser(r),
+		ParentID: input.ParentID,
+		Name:     input.Name,
+	})
+	if err != nil {
+		folderErrorResponse(w, r, err)
+		return
+	}
+	folder, err := s.repo.Folders.GetByID(r.Context(), currentUser(r), id)
+	if err != nil {
+		folderErrorResponse(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toFolderJSON(folder))
+}
+
+func (s *HTTPServer) renameFolder(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+	var input FolderRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if !folderNameIsValid(input.Name) {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+
+	if err := s.repo.Folders.Rename(r.Context(), currentUser(r), id, input.Name); err != nil {
+		folderErrorResponse(w, r, err)
+		return
+	}
+	folder, err := s.repo.Folders.GetByID(r.Context(), currentUser(r), id)
+	if err != nil {
+		folderErrorResponse(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toFolderJSON(folder))
+}
+
+func (s *HTTPServer) deleteFolder(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+	if err := s.repo.Folders.Delete(r.Context(), currentUser(r), id); err != nil {
+		folderErrorResponse(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *HTTPServer) getFolderMessages(w http.ResponseWriter, r *http.Request) {
+	folder, err := s.resolveFolder(r, mux.Vars(r)["folder"])
+	if err != nil {
+		folderErrorResponse(w, r, err)
+		return
+	}
+	s.writeMessageList(w, r, folder.ID, 0)
+}
@@ -0,0 +1,140 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"mail/database"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestFoldersCRUD(t *testing.T) {
+	s := newTestServer()
+	router := newTestRouter(s, testUserEmail)
+
+	rr := doJSON(t, router, "GET", "/mail/folders", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var folders []FolderJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &folders); err != nil {
+		t.Fatal(err)
+	}
+	if len(folders) != len(database.SystemFolders) || folders[0].System != database.FolderInbox {
+		t.Fatalf("unexpected system folders: %+v", folders)
+	}
+
+	rr = doJSON(t, router, "POST", "/mail/folders", FolderRequest{Name: "Работа"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var parent FolderJSON
+	json.Unmarshal(rr.Body.Bytes(), &parent)
+
+	rr = doJSON(t, router, "POST", "/mail/folders", FolderRequest{Name: "Работа"})
+	if rr.Code != http.StatusConflict {
+		t.Errorf("duplicate: got %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	rr = doJSON(t, router, "POST", "/mail/folders", FolderRequest{Name: "Отчёты", ParentID: parent.ID})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create nested: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var child FolderJSON
+	json.Unmarshal(rr.Body.Bytes(), &child)
+	if child.ParentID != parent.ID {
+		t.Errorf("nested folder has wrong parent: %+v", child)
+	}
+
+	rr = doJSON(t, router, "PUT", "/mail/folders/"+strconv.FormatInt(child.ID, 10), FolderRequest{Name: "Квартальные"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("rename: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	inboxID := strconv.FormatInt(folders[0].ID, 10)
+	rr = doJSON(t, router, "PUT", "/mail/folders/"+inboxID, FolderRequest{Name: "Входящие"})
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("rename system: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	rr = doJSON(t, router, "DELETE", "/mail/folders/"+inboxID, nil)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("delete system: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+}
+
+func TestMoveMessagesAndDeleteFolder(t *testing.T) {
+	s := newTestServer()
+	router := newTestRouter(s, testUserEmail)
+	id := seedMessage(s, database.Message{
+		Owner:    testUserEmail,
+		Subject:  "Report Update",
+		FolderID: systemFolderID(s, testUserEmail, database.FolderInbox),
+	})
+
+	rr := doJSON(t, router, "POST", "/mail/folders", FolderRequest{Name: "Архив"})
+	var archive FolderJSON
+	json.Unmarshal(rr.Body.Bytes(), &archive)
+
+	rr = doJSON(t, router, "POST", "/mail/messages/move", MoveMessagesRequest{IDs: []int64{id, 100500}, FolderID: archive.ID})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("move: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var moved MoveMessagesResponse
+	json.Unmarshal(rr.Body.Bytes(), &moved)
+	if moved.Moved != 1 {
+		t.Errorf("moved %d messages want 1", moved.Moved)
+	}
+
+	rr = doJSON(t, router, "GET", "/mail/"+strconv.FormatInt(archive.ID, 10), nil)
+	var list MessageListJSON
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if rr.Code != http.StatusOK || len(list.Messages) != 1 || list.Messages[0].ID != id {
+		t.Errorf("archive listing: code %v body %+v", rr.Code, list)
+	}
+
+	rr = doJSON(t, router, "DELETE", "/mail/folders/"+strconv.FormatInt(archive.ID, 10), nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("delete: got %v want %v", rr.Code, http.StatusOK)
+	}
+	rr = doJSON(t, router, "GET", "/mail/trash", nil)
+	list = MessageListJSON{}
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list.Messages) != 1 || list.Messages[0].ID != id {
+		t.Errorf("message should be moved to trash: %+v", list)
+	}
+
+	rr = doJSON(t, router, "GET", "/mail/unknown", nil)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown folder: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestMoveMessagesToForeignFolder(t *testing.T) {
+	s := newTestServer()
+	router := newTestRouter(s, testUserEmail)
+	id := seedMessage(s, database.Message{Owner: testUserEmail, FolderID: systemFolderID(s, testUserEmail, database.FolderInbox)})
+	foreign := systemFolderID(s, "someone.else@giga-mail.ru", database.FolderInbox)
+
+	rr := doJSON(t, router, "POST", "/mail/messages/move", MoveMessagesRequest{IDs: []int64{id}, FolderID: foreign})
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestMoveMessagesToDraftsOrSent(t *testing.T) {
+	s := newTestServer()
+	router := newTestRouter(s, testUserEmail)
+	id := seedMessage(s, database.Message{Owner: testUserEmail, FolderID: systemFolderID(s, testUserEmail, database.FolderInbox)})
+
+	for _, system := range []string{database.FolderDrafts, database.FolderSent} {
+		rr := doJSON(t, router, "POST", "/mail/messages/move", MoveMessagesRequest{IDs: []int64{id}, FolderID: systemFolderID(s, testUserEmail, system)})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %v want %v", system, rr.Code, http.StatusBadRequest)
+		}
+	}
+	message, _ := s.repo.Messages.GetByID(context.Background(), testUserEmail, id)
+	if message.FolderID != systemFolderID(s, testUserEmail, database.FolderInbox) {
+		t.Errorf("message was moved to folder %d", message.FolderID)
+	}
+}
@@ -1,40 +1,77 @@
 package httpserver
 
 import (
+	"context"
 	"encoding/json"
+	"mail/database"
+	"mail/pkg/middleware"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 var testUserID = "test-uuid"
 
 func TestGetAllMails(t *testing.T) {
+	s := newTestServer()
+	older := seedMessage(s, database.Message{
+		Owner:    testUserEmail,
+		From:     database.Address{Email: "john.doe@example.com"},
+		Subject:  "Project Status Check-In",
+		TextBody: "Hi Jane, just wanted to check in on the project status.",
+		FolderID: systemFolderID(s, testUserEmail, database.FolderInbox),
+		Date:     time.Now().Add(-48 * time.Hour),
+	})
+	newer := seedMessage(s, database.Message{
+		Owner:    testUserEmail,
+		From:     database.Address{Email: "mark.brown@example.com"},
+		Subject:  "Meeting Reminder",
+		TextBody: "Hey Jane, just a reminder about our meeting tomorrow at 10 AM.",
+		FolderID: systemFolderID(s, testUserEmail, database.FolderInbox),
+		Date:     time.Now().Add(-24 * time.Hour),
+	})
+	seedMessage(s, database.Message{
+		Owner:    "someone.else@giga-mail.ru",
+		From:     database.Address{Email: "lisa.white@example.com"},
+		Subject:  "Report Update",
+		FolderID: systemFolderID(s, "someone.else@giga-mail.ru", database.FolderInbox),
+	})
+
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(getAllMails)
+	handler := http.HandlerFunc(s.getAllMails)
 	req, err := http.NewRequest("GET", "/mail/inbox", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	req.AddCookie(&http.Cookie{Name: "user_id", Value: testUserID})
+	req.AddCookie(&http.Cookie{Name: "session", Value: testUserID})
+	req = req.WithContext(context.WithValue(req.Context(), middleware.Key, testUserEmail))
 	handler.ServeHTTP(rr, req)
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
-	var unmarshaledMails Mails
-	err = json.Unmarshal(rr.Body.Bytes(), &unmarshaledMails)
+	var list MessageListJSON
+	err = json.Unmarshal(rr.Body.Bytes(), &list)
 	if err != nil {
 		t.Errorf("cannot convert response body to struct: %v", err)
 		return
 	}
-	if !unmarshaledMails.compare(mockedMails) {
-		t.Errorf("handler returned unexpected body: got %v want %v", unmarshaledMails, mockedMails)
+	result := list.Messages
+	if list.Meta.Total != 2 || list.Meta.Unread != 2 || list.Meta.NextCursor != "" {
+		t.Errorf("handler returned unexpected meta: %+v", list.Meta)
+	}
+	if len(result) != 2 || result[0].ID != newer || result[1].ID != older {
+		t.Errorf("handler returned unexpected body: %+v", result)
+	}
+	if result[0].Snippet == "" || result[0].From.Email != "mark.brown@example.com" {
+		t.Errorf("handler returned unexpected summary: %+v", result[0])
 	}
 }
 
 func TestGetAllMails_Error(t *testing.T) {
+	s := newTestServer()
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(getAllMails)
+	handler := http.HandlerFunc(s.getAllMails)
 	req, err := http.NewRequest("GET", "/mail/inbox", nil)
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,104 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"mail/database"
+	"mail/pkg/mimemsg"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+func (s *HTTPServer) getMessage(w http.ResponseWriter, r *http.Request) {
+	message, ok := s.messageFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	result := toMessageJSON(message)
+	raw, err := s.messageSource(r.Context(), message)
+	if err != nil {
+		slog.Error("failed to get message source", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	if _, structure, err := mimemsg.Parse(raw); err == nil {
+		part := toPartJSON(structure)
+		result.Structure = &part
+	} else {
+		slog.Warn("failed to parse message source", "id", message.ID, "error", err)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// downloadMessage отдаёт исходный текст письма файлом .eml.
+func (s *HTTPServer) downloadMessage(w http.ResponseWriter, r *http.Request) {
+	message, ok := s.messageFromPath(w, r)
+	if !ok {
+		return
+	}
+	raw, err := s.messageSource(r.Context(), message)
+	if err != nil {
+		slog.Error("failed to get message source", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "message/rfc822")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": emlFilename(message),
+	}))
+	w.Header().Set("Content-Length", strconv.Itoa(len(raw)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(raw)
+}
+
+// messageFromPath загружает письмо текущего пользователя по {id} из пути
+// и сам отвечает клиенту, если это не удалось.
+func (s *HTTPServer) messageFromPath(w http.ResponseWriter, r *http.Request) (database.Message, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return database.Message{}, false
+	}
+
+	message, err := s.repo.Messages.GetByID(r.Context(), currentUser(r), id)
+	if errors.Is(err, database.ErrMessageNotFound) {
+		ErrorResponseWithStatus(w, r, http.StatusNotFound, "message_not_found")
+		return database.Message{}, false
+	}
+	if err != nil {
+		slog.Error("failed to get message", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return database.Message{}, false
+	}
+	return message, true
+}
+
+// messageSource возвращает исходный текст письма. Для писем, сохранённых
+// без него, текст собирается из полей.
+func (s *HTTPServer) messageSource(ctx context.Context, message database.Message) ([]byte, error) {
+	raw, err := s.repo.Messages.GetRaw(ctx, message.Owner, message.ID)
+	if err != nil || len(raw) > 0 {
+		return raw, err
+	}
+	return mimemsg.Compose(message)
+}
+
+func emlFilename(message database.Message) string {
+	name := strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(message.Subject))
+	if name == "" {
+		name = "message-" + strconv.FormatInt(message.ID, 10)
+	}
+	return name + ".eml"
+}
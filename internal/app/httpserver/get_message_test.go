@@ -0,0 +1,114 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"mail/database"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestGetMessage(t *testing.T) {
+	s := newTestServer()
+	id := seedMessage(s, database.Message{
+		Owner:    testUserEmail,
+		From:     database.Address{Name: "John", Email: "john.doe@example.com"},
+		To:       []database.Address{{Email: testUserEmail}},
+		Subject:  "Project Status Check-In",
+		TextBody: "Hi Jane",
+		HTMLBody: "<p>Hi Jane</p>",
+		FolderID: systemFolderID(s, testUserEmail, database.FolderInbox),
+	})
+
+	req := authorizedRequest(t, "GET", "/mail/messages/"+strconv.FormatInt(id, 10), testUserEmail)
+	req = mux.SetURLVars(req, map[string]string{"id": strconv.FormatInt(id, 10)})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(s.getMessage).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var result MessageJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.ID != id || result.HTMLBody != "<p>Hi Jane</p>" || result.To[0].Email != testUserEmail {
+		t.Errorf("handler returned unexpected body: %+v", result)
+	}
+}
+
+func TestGetMessageOfOtherUser(t *testing.T) {
+	s := newTestServer()
+	id := seedMessage(s, database.Message{Owner: "someone.else@giga-mail.ru", FolderID: systemFolderID(s, "someone.else@giga-mail.ru", database.FolderInbox)})
+
+	req := authorizedRequest(t, "GET", "/mail/messages/"+strconv.FormatInt(id, 10), testUserEmail)
+	req = mux.SetURLVars(req, map[string]string{"id": strconv.FormatInt(id, 10)})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(s.getMessage).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestDownloadMessage(t *testing.T) {
+	s := newTestServer()
+	raw := "From: mark@example.com\r\nTo: " + testUserEmail + "\r\nSubject: Квартальный отчёт\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nSee attached.\r\n" +
+		"--b\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=report.pdf\r\n\r\n%PDF\r\n" +
+		"--b--\r\n"
+	id := seedMessage(s, database.Message{
+		Owner:    testUserEmail,
+		Subject:  "Квартальный отчёт",
+		FolderID: systemFolderID(s, testUserEmail, database.FolderInbox),
+		Raw:      []byte(raw),
+	})
+	router := newTestRouter(s, testUserEmail)
+
+	rr := doJSON(t, router, "GET", "/mail/messages/"+strconv.FormatInt(id, 10)+"/raw", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr.Body.String() != raw || rr.Header().Get("Content-Type") != "message/rfc822" {
+		t.Errorf("unexpected download: %q %q", rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+	if disposition := rr.Header().Get("Content-Disposition"); !strings.HasPrefix(disposition, "attachment;") ||
+		!strings.Contains(disposition, "filename*=utf-8''") {
+		t.Errorf("unexpected Content-Disposition: %q", disposition)
+	}
+
+	rr = doJSON(t, router, "GET", "/mail/messages/"+strconv.FormatInt(id, 10), nil)
+	var result MessageJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Structure == nil || result.Structure.ContentType != "multipart/mixed" ||
+		len(result.Structure.Parts) != 2 || result.Structure.Parts[1].Filename != "report.pdf" {
+		t.Errorf("unexpected structure: %+v", result.Structure)
+	}
+}
+
+func TestDownloadMessageWithoutSource(t *testing.T) {
+	s := newTestServer()
+	id := seedMessage(s, database.Message{
+		Owner:    testUserEmail,
+		From:     database.Address{Email: "john.doe@example.com"},
+		Subject:  "Legacy",
+		TextBody: "Hi Jane",
+		FolderID: systemFolderID(s, testUserEmail, database.FolderInbox),
+	})
+
+	rr := doJSON(t, newTestRouter(s, testUserEmail), "GET", "/mail/messages/"+strconv.FormatInt(id, 10)+"/raw", nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Subject: Legacy") ||
+		!strings.Contains(rr.Body.String(), "Hi Jane") {
+		t.Errorf("unexpected download: %d %q", rr.Code, rr.Body.String())
+	}
+	if disposition := rr.Header().Get("Content-Disposition"); disposition != `attachment; filename=Legacy.eml` {
+		t.Errorf("unexpected Content-Disposition: %q", disposition)
+	}
+}
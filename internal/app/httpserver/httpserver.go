@@ -1,22 +1,76 @@
 package httpserver
 
 import (
+	"context"
 	"log/slog"
 	config "mail/config"
+	"mail/database"
+	"mail/internal/app/outbound"
+	"mail/pkg/blobstore"
+	"mail/pkg/linktoken"
 	"mail/pkg/middleware"
+	"mail/pkg/password"
+	"mail/pkg/search"
 	"net/http"
+	"strings"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/gorilla/mux"
 )
 
 type HTTPServer struct {
-	server *http.Server
+	server      *http.Server
+	repo        *database.Repositories
+	passwords   *password.Hasher
+	outbound    *outbound.Queue
+	blobs       blobstore.Store
+	attachments config.AttachmentsConfig
+	search      *search.Index
+	account     config.AccountConfig
+	links       *linktoken.Signer
+	session     config.SessionConfig
+	csrfKey     []byte
+	// localDomains - домены из smtp.local_domains, только с них можно
+	// отправлять почту
+	localDomains map[string]bool
+	// webauthn равен nil, если ключи доступа не настроены
+	webauthn *webauthn.WebAuthn
+	// ceremonies - начатые церемонии WebAuthn
+	ceremonies *ceremonyStore
+	// twoFactorAttempts считает ошибки второго шага входа
+	twoFactorAttempts *attemptLimiter
+	// resetByAddress и resetByIP считают запросы ссылок сброса пароля
+	resetByAddress *rateLimiter
+	resetByIP      *rateLimiter
+}
+
+func NewHTTPServer(repo *database.Repositories, passwords *password.Hasher, queue *outbound.Queue,
+	blobs blobstore.Store, attachments config.AttachmentsConfig, index *search.Index) *HTTPServer {
+	if attachments.MaxFileBytes <= 0 {
+		attachments.MaxFileBytes = defaultMaxFileBytes
+	}
+	if attachments.MaxMessageBytes <= 0 {
+		attachments.MaxMessageBytes = defaultMaxMessageBytes
+	}
+	return &HTTPServer{
+		repo:              repo,
+		passwords:         passwords,
+		outbound:          queue,
+		blobs:             blobs,
+		attachments:       attachments,
+		search:            index,
+		ceremonies:        newCeremonyStore(),
+		twoFactorAttempts: newAttemptLimiter(maxTwoFactorFailed, twoFactorLockout),
+		resetByAddress:    newRateLimiter(maxResetRequests, resetRequestsWindow),
+		resetByIP:         newRateLimiter(maxResetRequestsPerIP, resetRequestsWindow),
+	}
 }
 
 func (s *HTTPServer) Start(cfg *config.Config) error {
 	s.server = new(http.Server)
 	s.server.Addr = cfg.HTTPServer.IP + ":" + cfg.HTTPServer.Port
 	s.configureRouter(cfg)
+	go s.cleanupSessions(context.Background())
 	slog.Info("Server is running on", "port", cfg.HTTPServer.Port)
 	if err := s.server.ListenAndServe(); err != nil {
 		return err
@@ -26,20 +80,108 @@ func (s *HTTPServer) Start(cfg *config.Config) error {
 
 func (s *HTTPServer) configureRouter(cfg *config.Config) {
 	router := mux.NewRouter()
+	s.account = cfg.Account
+	s.session = cfg.Session
+	s.localDomains = make(map[string]bool, len(cfg.SMTPServer.LocalDomains))
+	for _, domain := range cfg.SMTPServer.LocalDomains {
+		s.localDomains[strings.ToLower(domain)] = true
+	}
+	s.csrfKey = middleware.CSRFKey(cfg.Account.Secret)
+	s.links = linktoken.NewSigner(cfg.Account.Secret)
+	s.webauthn = newWebAuthn(cfg.WebAuthn)
 
 	public := router.PathPrefix("/").Subrouter()
 	public.HandleFunc("/hello", HelloHandler).Methods("GET")
-	public.HandleFunc("/signup", SignUpHandler).Methods("POST", "OPTIONS")
-	public.HandleFunc("/login", LogInHandler).Methods("POST", "OPTIONS")
+	public.HandleFunc("/signup", s.SignUpHandler).Methods("POST", "OPTIONS")
+	public.HandleFunc("/login", s.LogInHandler).Methods("POST", "OPTIONS")
+	public.HandleFunc("/login/2fa", s.loginTwoFactor).Methods("POST", "OPTIONS")
+	public.HandleFunc("/login/2fa/webauthn/begin", s.beginSecondFactor).Methods("POST", "OPTIONS")
+	public.HandleFunc("/login/2fa/webauthn/finish", s.finishSecondFactor).Methods("POST", "OPTIONS")
+	public.HandleFunc("/login/webauthn/begin", s.beginPasskeyLogin).Methods("POST", "OPTIONS")
+	public.HandleFunc("/login/webauthn/finish", s.finishPasskeyLogin).Methods("POST", "OPTIONS")
+	public.HandleFunc("/verify-email", s.verifyEmail).Methods("POST", "OPTIONS")
+	public.HandleFunc("/password/forgot", s.forgotPassword).Methods("POST", "OPTIONS")
+	public.HandleFunc("/password/reset", s.resetPassword).Methods("POST", "OPTIONS")
+
+	// доступны и аккаунтам с неподтверждённым адресом
+	account := router.PathPrefix("/").Subrouter()
+	// только POST: выход проходит проверку CSRF, и чужой сайт не завершит
+	// сессию ссылкой или картинкой
+	account.HandleFunc("/logout", s.LogOutHandler).Methods("POST", "OPTIONS")
+	account.HandleFunc("/verify-email/resend", s.resendVerification).Methods("POST", "OPTIONS")
+	account.HandleFunc("/password/change", s.changePassword).Methods("POST", "OPTIONS")
+	account.HandleFunc("/csrf", s.getCSRFToken).Methods("GET", "OPTIONS")
+	account.HandleFunc("/sessions", s.listSessions).Methods("GET", "OPTIONS")
+	account.HandleFunc("/sessions", s.deleteOtherSessions).Methods("DELETE", "OPTIONS")
+	account.HandleFunc("/sessions/{id:[0-9]+}", s.deleteSession).Methods("DELETE", "OPTIONS")
+	account.Use(middleware.AuthMiddleware(s.repo.Sessions, cfg.Session), middleware.CSRF(s.csrfKey, cfg.Session))
 
 	private := router.PathPrefix("/").Subrouter()
-	private.HandleFunc("/mail/inbox", getAllMails).Methods("GET", "OPTIONS")
-	private.HandleFunc("/logout", LogOutHandler).Methods("GET", "OPTIONS")
-	private.Use(middleware.AuthMiddleware)
+	private.HandleFunc("/mail/inbox", s.getAllMails).Methods("GET", "OPTIONS")
+	private.HandleFunc("/mail/messages", s.updateFlags).Methods("PATCH", "OPTIONS")
+	private.HandleFunc("/mail/messages/{id:[0-9]+}", s.getMessage).Methods("GET", "OPTIONS")
+	private.HandleFunc("/mail/messages/{id:[0-9]+}/raw", s.downloadMessage).Methods("GET", "OPTIONS")
+	private.HandleFunc("/mail/messages/{id:[0-9]+}/attachments", s.listAttachments).Methods("GET", "OPTIONS")
+	private.HandleFunc("/mail/messages/{id:[0-9]+}/attachments", s.uploadAttachment).Methods("POST", "OPTIONS")
+	private.HandleFunc("/mail/messages/{id:[0-9]+}/attachments/{attachment:[0-9]+}", s.downloadAttachment).Methods("GET", "OPTIONS")
+	private.HandleFunc("/mail/messages/{id:[0-9]+}/attachments/{attachment:[0-9]+}", s.deleteAttachment).Methods("DELETE", "OPTIONS")
+	private.HandleFunc("/mail/messages/move", s.moveMessages).Methods("POST", "OPTIONS")
+	private.HandleFunc("/mail/messages/labels", s.updateLabels).Methods("POST", "OPTIONS")
+	private.HandleFunc("/mail/messages/spam", s.reportSpam).Methods("POST", "OPTIONS")
+	private.HandleFunc("/mail/messages/ham", s.reportHam).Methods("POST", "OPTIONS")
+	private.HandleFunc("/mail/send", s.sendMail).Methods("POST", "OPTIONS")
+	private.HandleFunc("/mail/drafts", s.createDraft).Methods("POST", "OPTIONS")
+	private.HandleFunc("/mail/drafts/{id:[0-9]+}", s.updateDraft).Methods("PUT", "OPTIONS")
+	private.HandleFunc("/mail/drafts/{id:[0-9]+}", s.deleteDraft).Methods("DELETE", "OPTIONS")
+	private.HandleFunc("/mail/drafts/{id:[0-9]+}/send", s.sendDraft).Methods("POST", "OPTIONS")
+	private.HandleFunc("/mail/search", s.searchMessages).Methods("GET", "OPTIONS")
+	private.HandleFunc("/mail/threads", s.listThreads).Methods("GET", "OPTIONS")
+	private.HandleFunc("/mail/threads/{id:[0-9]+}", s.getThread).Methods("GET", "OPTIONS")
+	private.HandleFunc("/mail/folders", s.listFolders).Methods("GET", "OPTIONS")
+	private.HandleFunc("/mail/folders", s.createFolder).Methods("POST", "OPTIONS")
+	private.HandleFunc("/mail/folders/{id:[0-9]+}", s.renameFolder).Methods("PUT", "OPTIONS")
+	private.HandleFunc("/mail/folders/{id:[0-9]+}", s.deleteFolder).Methods("DELETE", "OPTIONS")
+	private.HandleFunc("/mail/labels", s.listLabels).Methods("GET", "OPTIONS")
+	private.HandleFunc("/mail/labels", s.createLabel).Methods("POST", "OPTIONS")
+	private.HandleFunc("/mail/labels/{id:[0-9]+}", s.updateLabel).Methods("PUT", "OPTIONS")
+	private.HandleFunc("/mail/labels/{id:[0-9]+}", s.deleteLabel).Methods("DELETE", "OPTIONS")
+	private.HandleFunc("/mail/labels/{id:[0-9]+}/messages", s.getLabelMessages).Methods("GET", "OPTIONS")
+	private.HandleFunc("/mail/filters", s.listFilters).Methods("GET", "OPTIONS")
+	private.HandleFunc("/mail/filters", s.createFilter).Methods("POST", "OPTIONS")
+	private.HandleFunc("/mail/filters/order", s.reorderFilters).Methods("PUT", "OPTIONS")
+	private.HandleFunc("/mail/filters/sieve", s.exportSieve).Methods("GET", "OPTIONS")
+	private.HandleFunc("/mail/filters/sieve", s.importSieve).Methods("PUT", "OPTIONS")
+	private.HandleFunc("/mail/filters/dry-run", s.dryRunFilters).Methods("POST", "OPTIONS")
+	private.HandleFunc("/mail/filters/{id:[0-9]+}", s.getFilter).Methods("GET", "OPTIONS")
+	private.HandleFunc("/mail/filters/{id:[0-9]+}", s.updateFilter).Methods("PUT", "OPTIONS")
+	private.HandleFunc("/mail/filters/{id:[0-9]+}", s.deleteFilter).Methods("DELETE", "OPTIONS")
+	private.HandleFunc("/mail/vacation", s.getVacation).Methods("GET", "OPTIONS")
+	private.HandleFunc("/mail/vacation", s.updateVacation).Methods("PUT", "OPTIONS")
+	private.HandleFunc("/2fa", s.getTwoFactor).Methods("GET", "OPTIONS")
+	private.HandleFunc("/2fa/totp/setup", s.setupTOTP).Methods("POST", "OPTIONS")
+	private.HandleFunc("/2fa/totp/enable", s.enableTOTP).Methods("POST", "OPTIONS")
+	private.HandleFunc("/2fa/disable", s.disableTwoFactor).Methods("POST", "OPTIONS")
+	private.HandleFunc("/2fa/recovery-codes", s.regenerateRecoveryCodes).Methods("POST", "OPTIONS")
+	private.HandleFunc("/webauthn/credentials", s.listCredentials).Methods("GET", "OPTIONS")
+	private.HandleFunc("/webauthn/credentials/{id}", s.deleteCredential).Methods("DELETE", "OPTIONS")
+	private.HandleFunc("/webauthn/register/begin", s.beginRegistration).Methods("POST", "OPTIONS")
+	private.HandleFunc("/webauthn/register/finish", s.finishRegistration).Methods("POST", "OPTIONS")
+	// должен идти последним, иначе перехватит остальные пути /mail/...
+	private.HandleFunc("/mail/{folder}", s.getFolderMessages).Methods("GET", "OPTIONS")
+	private.Use(middleware.AuthMiddleware(s.repo.Sessions, cfg.Session), middleware.CSRF(s.csrfKey, cfg.Session))
+	if cfg.Account.VerifyEmail {
+		private.Use(s.verifiedOnly())
+	}
+
+	admin := router.PathPrefix("/admin").Subrouter()
+	admin.HandleFunc("/dkim/{domain}", s.listDKIMKeys).Methods("GET", "OPTIONS")
+	admin.HandleFunc("/dkim/{domain}/rotate", s.rotateDKIMKey).Methods("POST", "OPTIONS")
+	admin.HandleFunc("/dkim/{domain}/keys/{id:[0-9]+}", s.deleteDKIMKey).Methods("DELETE", "OPTIONS")
+	admin.Use(middleware.AuthMiddleware(s.repo.Sessions, cfg.Session), middleware.CSRF(s.csrfKey, cfg.Session), adminOnly(cfg.Admins))
 
 	router.Use(func(next http.Handler) http.Handler {
 		return middleware.CORS(next, cfg)
-	})
+	}, middleware.CheckOrigin(cfg.HTTPServer.AllowedIPsByCORS))
 
 	s.server.Handler = router
 }
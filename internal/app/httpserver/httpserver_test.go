@@ -1,11 +1,55 @@
 package httpserver
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mail/config"
+	"mail/database"
+	"mail/internal/app/outbound"
+	"mail/pkg/blobstore"
+	"mail/pkg/middleware"
+	"mail/pkg/password"
+	"mail/pkg/search"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
+// testSecret - account.secret тестовых роутеров, от него зависят CSRF-токены.
+const testSecret = "test-secret-at-least-32-bytes-long"
+
+var testUserEmail = "jane@giga-mail.ru"
+
+func seedMessage(s *HTTPServer, message database.Message) int64 {
+	id, err := s.repo.Messages.Create(context.Background(), message)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+func systemFolderID(s *HTTPServer, owner string, system string) int64 {
+	folder, err := s.repo.Folders.GetSystem(context.Background(), owner, system)
+	if err != nil {
+		panic(err)
+	}
+	return folder.ID
+}
+
+// authorizedRequest имитирует запрос, прошедший AuthMiddleware.
+func authorizedRequest(t *testing.T, method string, url string, email string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: "session", Value: testUserID})
+	return req.WithContext(context.WithValue(req.Context(), middleware.Key, email))
+}
+
 func TestServer(t *testing.T) {
 	req, err := http.NewRequest("GET", "/hello", nil)
 	if err != nil {
@@ -25,3 +69,76 @@ func TestServer(t *testing.T) {
 		t.Errorf("Expected response body '%s', got '%s'", expected, w.Body.String())
 	}
 }
+
+func newTestServer() *HTTPServer {
+	var cfg config.PasswordConfig
+	cfg.Argon2.Memory = 1024
+	cfg.Argon2.Threads = 1
+	passwords, err := password.NewHasher(cfg)
+	if err != nil {
+		panic(err)
+	}
+	repo := database.NewInMemoryRepositories()
+	index := search.NewIndex(repo)
+	repo.Messages = search.Indexed(repo.Messages, index)
+	queue := outbound.NewQueue(repo, nil, config.OutboundConfig{Hostname: "giga-mail.ru"})
+	return NewHTTPServer(repo, passwords, queue, blobstore.NewMemoryStore(), config.AttachmentsConfig{}, index)
+}
+
+// createTestUser сохраняет пользователя с захэшированным паролем.
+func createTestUser(s *HTTPServer, name string, email string, plainPassword string) {
+	hash, err := s.passwords.Hash(plainPassword)
+	if err != nil {
+		panic(err)
+	}
+	s.repo.Users.Create(context.Background(), database.User{Name: name, Email: email, Password: hash})
+}
+
+// newTestRouter собирает полный роутер с middleware и открывает сессию
+// testUserID для пользователя email.
+func newTestRouter(s *HTTPServer, email string) http.Handler {
+	cfg := new(config.Config)
+	cfg.HTTPServer.AllowedIPsByCORS = []string{"http://localhost:4201"}
+	cfg.Account.Secret = testSecret
+	cfg.SMTPServer.LocalDomains = []string{"giga-mail.ru"}
+	s.server = new(http.Server)
+	s.configureRouter(cfg)
+	createTestSession(s, testUserID, email)
+	return s.server.Handler
+}
+
+// createTestSession открывает сессию hash для пользователя email.
+func createTestSession(s *HTTPServer, hash string, email string) {
+	now := time.Now()
+	s.repo.Sessions.Create(context.Background(), database.Session{
+		Hash: hash, Email: email, CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(time.Hour),
+	})
+}
+
+// addTestSession добавляет к запросу куку сессии testUserID и её
+// CSRF-токен.
+func addTestSession(req *http.Request) {
+	req.AddCookie(&http.Cookie{Name: "session", Value: testUserID})
+	req.Header.Set(middleware.CSRFHeader, middleware.CSRFToken(middleware.CSRFKey(testSecret), testUserID))
+}
+
+// doJSON выполняет запрос через роутер с сессионной кукой и телом в JSON.
+func doJSON(t *testing.T, handler http.Handler, method string, url string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestSession(req)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
@@ -0,0 +1,216 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mail/database"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxLabelNameLength  = 64
+	maxLabelsPerRequest = 20
+)
+
+var labelColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+type LabelJSON struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Color  string `json:"color"`
+	Total  int    `json:"total"`
+	Unread int    `json:"unread"`
+}
+
+type LabelRequest struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// UpdateLabelsRequest ставит метки add и снимает метки remove у писем ids.
+type UpdateLabelsRequest struct {
+	IDs    []int64 `json:"ids"`
+	Add    []int64 `json:"add"`
+	Remove []int64 `json:"remove"`
+}
+
+type UpdateLabelsResponse struct {
+	Updated int         `json:"updated"`
+	Labels  []LabelJSON `json:"labels"`
+}
+
+func toLabelJSON(label database.Label) LabelJSON {
+	return LabelJSON{
+		ID:     label.ID,
+		Name:   label.Name,
+		Color:  label.Color,
+		Total:  label.Total,
+		Unread: label.Unread,
+	}
+}
+
+func toLabelsJSON(labels []database.Label) []LabelJSON {
+	result := make([]LabelJSON, 0, len(labels))
+	for _, label := range labels {
+		result = append(result, toLabelJSON(label))
+	}
+	return result
+}
+
+// valid нормализует имя и проверяет запрос. Цвет можно не указывать.
+func (input *LabelRequest) valid() bool {
+	input.Name = strings.TrimSpace(input.Name)
+	input.Color = strings.ToLower(input.Color)
+	return input.Name != "" && utf8.RuneCountInString(input.Name) <= maxLabelNameLength &&
+		(input.Color == "" || labelColor.MatchString(input.Color))
+}
+
+// labelErrorResponse отвечает на ошибки хранилища меток.
+func labelErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, database.ErrLabelNotFound):
+		ErrorResponseWithStatus(w, r, http.StatusNotFound, "label_not_found")
+	case errors.Is(err, database.ErrLabelExists):
+		ErrorResponseWithStatus(w, r, http.StatusConflict, "label_exists")
+	default:
+		slog.Error("label storage error", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+	}
+}
+
+func (s *HTTPServer) listLabels(w http.ResponseWriter, r *http.Request) {
+	labels, err := s.repo.Labels.List(r.Context(), currentUser(r))
+	if err != nil {
+		labelErrorResponse(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toLabelsJSON(labels))
+}
+
+func (s *HTTPServer) createLabel(w http.ResponseWriter, r *http.Request) {
+	var input LabelRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !input.valid() {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+
+	id, err := s.repo.Labels.Create(r.Context(), database.Label{
+		Owner: currentUser(r),
+		Name:  input.Name,
+		Color: input.Color,
+	})
+	if err != nil {
+		labelErrorResponse(w, r, err)
+		return
+	}
+	s.writeLabel(w, r, id)
+}
+
+func (s *HTTPServer) updateLabel(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+	var input LabelRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !input.valid() {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+
+	err = s.repo.Labels.Update(r.Context(), database.Label{
+		ID:    id,
+		Owner: currentUser(r),
+		Name:  input.Name,
+		Color: input.Color,
+	})
+	if err != nil {
+		labelErrorResponse(w, r, err)
+		return
+	}
+	s.writeLabel(w, r, id)
+}
+
+func (s *HTTPServer) deleteLabel(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+	if err := s.repo.Labels.Delete(r.Context(), currentUser(r), id); err != nil {
+		labelErrorResponse(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *HTTPServer) writeLabel(w http.ResponseWriter, r *http.Request, id int64) {
+	label, err := s.repo.Labels.GetByID(r.Context(), currentUser(r), id)
+	if err != nil {
+		labelErrorResponse(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toLabelJSON(label))
+}
+
+// getLabelMessages отдаёт письма с меткой из всех папок, параметры те же,
+// что у списка писем папки.
+func (s *HTTPServer) getLabelMessages(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+	if _, err := s.repo.Labels.GetByID(r.Context(), currentUser(r), id); err != nil {
+		labelErrorResponse(w, r, err)
+		return
+	}
+	s.writeMessageList(w, r, 0, id)
+}
+
+func (s *HTTPServer) updateLabels(w http.ResponseWriter, r *http.Request) {
+	var input UpdateLabelsRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	changes := len(input.Add) + len(input.Remove)
+	if len(input.IDs) == 0 || len(input.IDs) > maxBulkMessages || changes == 0 || changes > maxLabelsPerRequest {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+	for _, id := range append(append([]int64(nil), input.Add...), input.Remove...) {
+		if _, err := s.repo.Labels.GetByID(r.Context(), currentUser(r), id); err != nil {
+			labelErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	updated, err := s.repo.Messages.UpdateLabels(r.Context(), currentUser(r), uniqueIDs(input.IDs), input.Add, input.Remove)
+	if err != nil {
+		slog.Error("failed to update labels", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	labels, err := s.repo.Labels.List(r.Context(), currentUser(r))
+	if err != nil {
+		labelErrorResponse(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, UpdateLabelsResponse{Updated: updated, Labels: toLabelsJSON(labels)})
+}
@@ -0,0 +1,116 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"mail/database"
+	"net/http"
+	"testing"
+)
+
+func createTestLabel(t *testing.T, handler http.Handler, name string) LabelJSON {
+	t.Helper()
+	rr := doJSON(t, handler, "POST", "/mail/labels", LabelRequest{Name: name, Color: "#FF0000"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %v: %s", rr.Code, rr.Body.String())
+	}
+	var label LabelJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &label); err != nil {
+		t.Fatal(err)
+	}
+	return label
+}
+
+func TestLabelsCRUD(t *testing.T) {
+	s := newTestServer()
+	router := newTestRouter(s, testUserEmail)
+
+	work := createTestLabel(t, router, " work ")
+	if work.Name != "work" || work.Color != "#ff0000" {
+		t.Errorf("unexpected label: %+v", work)
+	}
+	if rr := doJSON(t, router, "POST", "/mail/labels", LabelRequest{Name: "work"}); rr.Code != http.StatusConflict {
+		t.Errorf("duplicate: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	for _, input := range []LabelRequest{{Name: " "}, {Name: "x", Color: "red"}} {
+		if rr := doJSON(t, router, "POST", "/mail/labels", input); rr.Code != http.StatusBadRequest {
+			t.Errorf("%+v: got %v want %v", input, rr.Code, http.StatusBadRequest)
+		}
+	}
+
+	url := fmt.Sprintf("/mail/labels/%d", work.ID)
+	rr := doJSON(t, router, "PUT", url, LabelRequest{Name: "job"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = doJSON(t, router, "GET", "/mail/labels", nil)
+	var labels []LabelJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &labels); err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 1 || labels[0].Name != "job" || labels[0].Color != "" {
+		t.Errorf("unexpected labels: %+v", labels)
+	}
+
+	if rr := doJSON(t, router, "DELETE", url, nil); rr.Code != http.StatusOK {
+		t.Errorf("delete: got %v", rr.Code)
+	}
+	if rr := doJSON(t, router, "DELETE", url, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("second delete: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestUpdateLabelsAndFilter(t *testing.T) {
+	s := newTestServer()
+	router := newTestRouter(s, testUserEmail)
+	inbox := systemFolderID(s, testUserEmail, database.FolderInbox)
+	sent := systemFolderID(s, testUserEmail, database.FolderSent)
+	first := seedMessage(s, database.Message{Owner: testUserEmail, FolderID: inbox})
+	second := seedMessage(s, database.Message{Owner: testUserEmail, FolderID: sent, Flags: []string{database.FlagSeen}})
+	seedMessage(s, database.Message{Owner: testUserEmail, FolderID: inbox})
+	work := createTestLabel(t, router, "work")
+
+	rr := doJSON(t, router, "POST", "/mail/messages/labels", UpdateLabelsRequest{
+		IDs: []int64{first, second, 100500},
+		Add: []int64{work.ID},
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %v: %s", rr.Code, rr.Body.String())
+	}
+	var result UpdateLabelsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Updated != 2 || len(result.Labels) != 1 || result.Labels[0].Total != 2 || result.Labels[0].Unread != 1 {
+		t.Errorf("unexpected response: %+v", result)
+	}
+
+	list := getMessageList(t, router, fmt.Sprintf("/mail/labels/%d/messages", work.ID))
+	if len(list.Messages) != 2 || list.Meta.Total != 2 {
+		t.Errorf("unexpected label list: %+v", list)
+	}
+	list = getMessageList(t, router, fmt.Sprintf("/mail/inbox?label=%d", work.ID))
+	if len(list.Messages) != 1 || list.Messages[0].ID != first || len(list.Messages[0].Labels) != 1 {
+		t.Errorf("unexpected filtered inbox: %+v", list)
+	}
+
+	doJSON(t, router, "POST", "/mail/messages/labels", UpdateLabelsRequest{IDs: []int64{first}, Remove: []int64{work.ID}})
+	list = getMessageList(t, router, fmt.Sprintf("/mail/inbox?label=%d", work.ID))
+	if len(list.Messages) != 0 {
+		t.Errorf("label was not removed: %+v", list)
+	}
+
+	if rr := doJSON(t, router, "POST", "/mail/messages/labels", UpdateLabelsRequest{IDs: []int64{first}, Add: []int64{100500}}); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown label: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := doJSON(t, router, "POST", "/mail/messages/labels", UpdateLabelsRequest{IDs: []int64{first}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("empty change: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := doJSON(t, router, "GET", "/mail/inbox?label=abc", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("bad label filter: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := doJSON(t, router, "GET", "/mail/labels/100500/messages", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown label list: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
@@ -6,7 +6,10 @@ TODO:
 */
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"log/slog"
 	"mail/database"
 	"net/http"
 	"time"
@@ -18,9 +21,18 @@ type UserLogin struct {
 	Password string `json:"password"`
 }
 
-func LogInHandler(w http.ResponseWriter, r *http.Request) {
+// LoginResponse - если TwoFactorRequired, сессия не создана: код нужно
+// отправить в POST /login/2fa вместе с Token. Methods перечисляет
+// доступные вторые факторы: "totp" и "webauthn". CSRFToken выдаётся
+// вместе с сессией, его нужно передавать в заголовке X-CSRF-Token.
+type LoginResponse struct {
+	TwoFactorRequired bool     `json:"two_factor_required"`
+	Token             string   `json:"token,omitempty"`
+	Methods           []string `json:"methods,omitempty"`
+	CSRFToken         string   `json:"csrf_token,omitempty"`
+}
 
-	//database.UserDB["nick@giga-mail.ru"] = User{ Name: "nick", Email: "nick@giga-mail.ru", Password: "12345"} //убрать, когда будет бд
+func (s *HTTPServer) LogInHandler(w http.ResponseWriter, r *http.Request) {
 
 	var user UserLogin
 
@@ -34,8 +46,6 @@ func LogInHandler(w http.ResponseWriter, r *http.Request) {
 	inputLogin := user.Email
 	inputPassword := user.Password
 
-	_, ok := database.UserDB[inputLogin]
-
 	if !emailIsValid(inputLogin) {
 		ErrorResponse(w, r, "invalid_input")
 		return
@@ -46,28 +56,87 @@ func LogInHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !ok {
+	storedUser, err := s.repo.Users.GetByEmail(r.Context(), inputLogin)
+	if errors.Is(err, database.ErrUserNotFound) {
 		ErrorResponse(w, r, "user_does_not_exist")
 		return
 	}
+	if err != nil {
+		slog.Error("failed to get user", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
 
-	if database.UserDB[inputLogin].Password != inputPassword {
+	ok, needsRehash, err := s.passwords.Verify(inputPassword, storedUser.Password)
+	if err != nil {
+		slog.Error("failed to verify password", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	if !ok {
 		ErrorResponse(w, r, "invalid_password")
 		return
 	}
+	if needsRehash {
+		s.rehashPassword(r.Context(), storedUser, inputPassword)
+	}
+
+	twoFactor, err := s.repo.TwoFactor.Get(r.Context(), storedUser.Email)
+	if err != nil && !errors.Is(err, database.ErrTwoFactorNotFound) {
+		slog.Error("failed to get two-factor settings", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	var methods []string
+	if twoFactor.Enabled {
+		methods = append(methods, "totp")
+	}
+	if s.webauthn != nil {
+		credentials, err := s.repo.WebAuthn.List(r.Context(), storedUser.Email)
+		if err != nil {
+			slog.Error("failed to list webauthn credentials", "error", err)
+			ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+			return
+		}
+		if len(credentials) > 0 {
+			methods = append(methods, "webauthn")
+		}
+	}
+	if len(methods) > 0 {
+		// пароль мог быть перехэширован выше
+		if storedUser, err = s.repo.Users.GetByEmail(r.Context(), storedUser.Email); err != nil {
+			slog.Error("failed to get user", "error", err)
+			ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+			return
+		}
+		token := s.links.Sign(loginTwoFactorPurpose, fingerprintSubject(storedUser), time.Now().Add(loginTwoFactorTTL))
+		writeJSON(w, http.StatusOK, LoginResponse{TwoFactorRequired: true, Token: token, Methods: methods})
+		return
+	}
+	s.startSession(w, r, storedUser.Email)
+}
 
-	hash := GenerateHash()
-	current_user := database.UserDB[user.Email]
-	database.UserDB[user.Email] = current_user
-	database.UserHash[hash] = user.Email
+// startSession создаёт сессию и ставит куку.
+func (s *HTTPServer) startSession(w http.ResponseWriter, r *http.Request, email string) {
+	csrfToken, err := s.createSession(w, r, email)
+	if err != nil {
+		slog.Error("failed to create session", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, LoginResponse{CSRFToken: csrfToken})
+}
 
-	expiration := time.Now().Add(24 * time.Hour)
-	cookie := http.Cookie{
-		Name:     "session",
-		Value:    hash,
-		Expires:  expiration,
-		HttpOnly: true,
+// rehashPassword пересчитывает хэш по текущим параметрам из конфига.
+// Ошибка не мешает входу, хэш обновится при следующей попытке.
+func (s *HTTPServer) rehashPassword(ctx context.Context, user database.User, inputPassword string) {
+	hash, err := s.passwords.Hash(inputPassword)
+	if err != nil {
+		slog.Error("failed to rehash password", "error", err)
+		return
+	}
+	user.Password = hash
+	if err := s.repo.Users.Update(ctx, user); err != nil {
+		slog.Error("failed to save rehashed password", "error", err)
 	}
-	http.SetCookie(w, &cookie)
-	w.WriteHeader(http.StatusOK)
 }
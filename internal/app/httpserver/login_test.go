@@ -2,16 +2,21 @@ package httpserver
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"mail/config"
 	"mail/database"
+	"mail/pkg/password"
 )
 
 func TestLogInOK(t *testing.T) {
 
-	database.UserDB["nick@giga-mail.ru"] = database.User{ Name: "nick", Email: "nick@giga-mail.ru", Password: "12345"} //убрать, когда будет бд
+	s := newTestServer()
+	createTestUser(s, "nick", "nick@giga-mail.ru", "12345")
 
 	todo := UserLogin{
 	Email: "nick@giga-mail.ru",
@@ -19,7 +24,7 @@ func TestLogInOK(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(LogInHandler)
+	handler := http.HandlerFunc(s.LogInHandler)
 
 	jsonReq, err := json.Marshal(todo)
 	if err != nil {
@@ -42,13 +47,14 @@ func TestLogInOK(t *testing.T) {
 
 func TestLogInFailLogin(t *testing.T) {
 
+	s := newTestServer()
 	todo := UserLogin{
 	Email: "vasia@giga-mail.ru",
 	Password: "12345",
 	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(LogInHandler)
+	handler := http.HandlerFunc(s.LogInHandler)
 
 	jsonReq, err := json.Marshal(todo)
 	if err != nil {
@@ -71,7 +77,8 @@ func TestLogInFailLogin(t *testing.T) {
 
 func TestLogInFailPassword(t *testing.T) {
 
-	database.UserDB["nick@giga-mail.ru"] = database.User{ Name: "nick", Email: "nick@giga-mail.ru", Password: "12345"} //убрать, когда будет бд
+	s := newTestServer()
+	createTestUser(s, "nick", "nick@giga-mail.ru", "12345")
 
 	todo := UserLogin{
 	Email: "nick@giga-mail.ru",
@@ -79,7 +86,7 @@ func TestLogInFailPassword(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(LogInHandler)
+	handler := http.HandlerFunc(s.LogInHandler)
 
 	jsonReq, err := json.Marshal(todo)
 	if err != nil {
@@ -98,4 +105,37 @@ func TestLogInFailPassword(t *testing.T) {
 			status, http.StatusForbidden)
 	}
 
-}
\ No newline at end of file
+}
+func TestLogInRehashesLegacyPassword(t *testing.T) {
+
+	s := newTestServer()
+	var cfg config.PasswordConfig
+	cfg.Argon2.Memory = 1024
+	cfg.Argon2.Threads = 1
+	cfg.AllowPlaintext = true
+	s.passwords, _ = password.NewHasher(cfg)
+	s.repo.Users.Create(context.Background(), database.User{Name: "nick", Email: "nick@giga-mail.ru", Password: "12345"})
+
+	jsonReq, err := json.Marshal(UserLogin{Email: "nick@giga-mail.ru", Password: "12345"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", "/login", bytes.NewBuffer(jsonReq))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(s.LogInHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	user, err := s.repo.Users.GetByEmail(context.Background(), "nick@giga-mail.ru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(user.Password, "$argon2id$") {
+		t.Errorf("password was not rehashed: %s", user.Password)
+	}
+}
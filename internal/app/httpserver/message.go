@@ -0,0 +1,198 @@
+package httpserver
+
+import (
+	"mail/database"
+	"mail/pkg/middleware"
+	"mail/pkg/mimemsg"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const snippetLength = 100
+
+type AddressJSON struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email"`
+}
+
+type AttachmentJSON struct {
+	ID          int64  `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	ContentID   string `json:"content_id,omitempty"`
+}
+
+// MessageJSON - письмо целиком, отдаётся при открытии письма.
+type MessageJSON struct {
+	ID          int64               `json:"id"`
+	ThreadID    int64               `json:"thread_id"`
+	MessageID   string              `json:"message_id"`
+	From        AddressJSON         `json:"from"`
+	To          []AddressJSON       `json:"to"`
+	Cc          []AddressJSON       `json:"cc"`
+	Bcc         []AddressJSON       `json:"bcc,omitempty"`
+	Subject     string              `json:"subject"`
+	TextBody    string              `json:"text_body"`
+	HTMLBody    string              `json:"html_body"`
+	Headers     map[string][]string `json:"headers,omitempty"`
+	Attachments []AttachmentJSON    `json:"attachments"`
+	Flags       []string            `json:"flags"`
+	Labels      []int64             `json:"labels"`
+	FolderID    int64               `json:"folder_id"`
+	Date        time.Time           `json:"date"`
+	ReceivedAt  time.Time           `json:"received_at"`
+	Version     int64               `json:"version"`
+	Structure   *PartJSON           `json:"structure,omitempty"`
+}
+
+// PartJSON - узел MIME-структуры письма.
+type PartJSON struct {
+	ContentType string     `json:"content_type"`
+	Charset     string     `json:"charset,omitempty"`
+	Disposition string     `json:"disposition,omitempty"`
+	Filename    string     `json:"filename,omitempty"`
+	ContentID   string     `json:"content_id,omitempty"`
+	Subject     string     `json:"subject,omitempty"`
+	Size        int64      `json:"size"`
+	Parts       []PartJSON `json:"parts,omitempty"`
+}
+
+// MessageSummaryJSON - строка в списке писем, без тел и заголовков.
+type MessageSummaryJSON struct {
+	ID             int64         `json:"id"`
+	ThreadID       int64         `json:"thread_id"`
+	From           AddressJSON   `json:"from"`
+	To             []AddressJSON `json:"to"`
+	Subject        string        `json:"subject"`
+	Snippet        string        `json:"snippet"`
+	Flags          []string      `json:"flags"`
+	Labels         []int64       `json:"labels"`
+	FolderID       int64         `json:"folder_id"`
+	HasAttachments bool          `json:"has_attachments"`
+	Date           time.Time     `json:"date"`
+}
+
+// currentUser возвращает email пользователя, положенный в контекст AuthMiddleware.
+func currentUser(r *http.Request) string {
+	email, _ := r.Context().Value(middleware.Key).(string)
+	return email
+}
+
+func toAddressJSON(address database.Address) AddressJSON {
+	return AddressJSON{Name: address.Name, Email: address.Email}
+}
+
+func toAddressesJSON(addresses []database.Address) []AddressJSON {
+	result := make([]AddressJSON, 0, len(addresses))
+	for _, address := range addresses {
+		result = append(result, toAddressJSON(address))
+	}
+	return result
+}
+
+func toAttachmentJSON(a database.AttachmentMeta) AttachmentJSON {
+	return AttachmentJSON{
+		ID:          a.ID,
+		Filename:    a.Filename,
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		ContentID:   a.ContentID,
+	}
+}
+
+func toAttachmentsJSON(attachments []database.AttachmentMeta) []AttachmentJSON {
+	result := make([]AttachmentJSON, 0, len(attachments))
+	for _, a := range attachments {
+		result = append(result, toAttachmentJSON(a))
+	}
+	return result
+}
+
+func toMessageJSON(message database.Message) MessageJSON {
+	return MessageJSON{
+		ID:          message.ID,
+		ThreadID:    message.ThreadID,
+		MessageID:   message.MessageID,
+		From:        toAddressJSON(message.From),
+		To:          toAddressesJSON(message.To),
+		Cc:          toAddressesJSON(message.Cc),
+		Bcc:         toAddressesJSON(message.Bcc),
+		Subject:     message.Subject,
+		TextBody:    message.TextBody,
+		HTMLBody:    message.HTMLBody,
+		Headers:     message.Headers,
+		Attachments: toAttachmentsJSON(message.Attachments),
+		Flags:       nonNilFlags(message.Flags),
+		Labels:      nonNilLabels(message.Labels),
+		FolderID:    message.FolderID,
+		Date:        message.Date,
+		ReceivedAt:  message.ReceivedAt,
+		Version:     message.Version,
+	}
+}
+
+func toPartJSON(part mimemsg.Part) PartJSON {
+	result := PartJSON{
+		ContentType: part.ContentType,
+		Charset:     part.Charset,
+		Disposition: part.Disposition,
+		Filename:    part.Filename,
+		ContentID:   part.ContentID,
+		Subject:     part.Subject,
+		Size:        part.Size,
+	}
+	for _, child := range part.Parts {
+		result.Parts = append(result.Parts, toPartJSON(child))
+	}
+	return result
+}
+
+func toMessageSummaryJSON(message database.Message) MessageSummaryJSON {
+	return MessageSummaryJSON{
+		ID:             message.ID,
+		ThreadID:       message.ThreadID,
+		From:           toAddressJSON(message.From),
+		To:             toAddressesJSON(message.To),
+		Subject:        message.Subject,
+		Snippet:        snippet(message.TextBody),
+		Flags:          nonNilFlags(message.Flags),
+		Labels:         nonNilLabels(message.Labels),
+		FolderID:       message.FolderID,
+		HasAttachments: len(message.Attachments) > 0,
+		Date:           message.Date,
+	}
+}
+
+func toMessageSummariesJSON(messages []database.Message) []MessageSummaryJSON {
+	result := make([]MessageSummaryJSON, 0, len(messages))
+	for _, message := range messages {
+		result = append(result, toMessageSummaryJSON(message))
+	}
+	return result
+}
+
+func nonNilFlags(flags []string) []string {
+	if flags == nil {
+		return []string{}
+	}
+	return flags
+}
+
+func nonNilLabels(labels []int64) []int64 {
+	if labels == nil {
+		return []int64{}
+	}
+	return labels
+}
+
+// snippet схлопывает пробелы и обрезает текст до snippetLength символов.
+func snippet(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= snippetLength {
+		return text
+	}
+	return string([]rune(text)[:snippetLength]) + "…"
+}
@@ -0,0 +1,178 @@
+package httpserver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"mail/database"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// MessageListJSON - страница списка писем.
+type MessageListJSON struct {
+	Messages []MessageSummaryJSON `json:"messages"`
+	Meta     ListMetaJSON         `json:"meta"`
+}
+
+// ListMetaJSON - счётчики по всем письмам, подходящим под фильтры.
+// NextCursor передаётся в параметре cursor, чтобы получить следующую
+// страницу, на последней странице он пуст.
+type ListMetaJSON struct {
+	Total      int    `json:"total"`
+	Unread     int    `json:"unread"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// cursorJSON - содержимое курсора. Вместе с позицией в нём лежит
+// сортировка, чтобы курсор нельзя было применить к другому порядку.
+type cursorJSON struct {
+	Sort string    `json:"s"`
+	Desc bool      `json:"o,omitempty"`
+	Date time.Time `json:"d,omitempty"`
+	Text string    `json:"t,omitempty"`
+	ID   int64     `json:"i"`
+}
+
+func encodeCursor(query database.ListQuery, cursor database.Cursor) string {
+	data, _ := json.Marshal(cursorJSON{
+		Sort: query.Sort,
+		Desc: query.Desc,
+		Date: cursor.Date,
+		Text: cursor.Text,
+		ID:   cursor.ID,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(query database.ListQuery, value string) (*database.Cursor, bool) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, false
+	}
+	var cursor cursorJSON
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Sort != query.Sort || cursor.Desc != query.Desc {
+		return nil, false
+	}
+	return &database.Cursor{Date: cursor.Date, Text: cursor.Text, ID: cursor.ID}, true
+}
+
+// parseListTime принимает дату в RFC 3339 или просто день: 2024-10-01.
+func parseListTime(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, true
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	return t, err == nil
+}
+
+func parseListFlag(params url.Values, name string) (bool, bool) {
+	value := params.Get(name)
+	if value == "" {
+		return false, true
+	}
+	flag, err := strconv.ParseBool(value)
+	return flag, err == nil
+}
+
+// parseListQuery разбирает параметры списка писем: limit, cursor,
+// sort (date, from, subject), order (asc, desc), фильтры unread, flagged,
+// has_attachments, label, from и интервал дат since/until. По умолчанию новые
+// письма идут первыми, а по отправителю и теме - по алфавиту.
+func parseListQuery(params url.Values, folderID int64, labelID int64) (database.ListQuery, bool) {
+	query := database.ListQuery{
+		FolderID: folderID,
+		LabelID:  labelID,
+		Sort:     params.Get("sort"),
+		From:     params.Get("from"),
+		Limit:    defaultPageSize,
+	}
+	switch query.Sort {
+	case "", database.SortDate:
+		query.Sort = database.SortDate
+		query.Desc = true
+	case database.SortSender, database.SortSubject:
+	default:
+		return query, false
+	}
+	switch params.Get("order") {
+	case "":
+	case "asc":
+		query.Desc = false
+	case "desc":
+		query.Desc = true
+	default:
+		return query, false
+	}
+
+	if value := params.Get("label"); value != "" && labelID == 0 {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id < 1 {
+			return query, false
+		}
+		query.LabelID = id
+	}
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return query, false
+		}
+		query.Limit = limit
+	}
+	var okUnread, okFlagged, okAttachments, okSince, okUntil bool
+	query.Unread, okUnread = parseListFlag(params, "unread")
+	query.Flagged, okFlagged = parseListFlag(params, "flagged")
+	query.HasAttachments, okAttachments = parseListFlag(params, "has_attachments")
+	query.Since, okSince = parseListTime(params.Get("since"))
+	query.Until, okUntil = parseListTime(params.Get("until"))
+	if !okUnread || !okFlagged || !okAttachments || !okSince || !okUntil {
+		return query, false
+	}
+	if value := params.Get("cursor"); value != "" {
+		cursor, ok := decodeCursor(query, value)
+		if !ok {
+			return query, false
+		}
+		query.After = cursor
+	}
+	return query, true
+}
+
+// writeMessageList отдаёт страницу писем папки по параметрам запроса.
+// folderID == 0 - письма всех папок, labelID != 0 - только с этой меткой.
+func (s *HTTPServer) writeMessageList(w http.ResponseWriter, r *http.Request, folderID int64, labelID int64) {
+	query, ok := parseListQuery(r.URL.Query(), folderID, labelID)
+	if !ok {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+	page, err := s.repo.Messages.List(r.Context(), currentUser(r), query)
+	if err != nil {
+		slog.Error("failed to list messages", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	result := MessageListJSON{
+		Messages: toMessageSummariesJSON(page.Messages),
+		Meta: ListMetaJSON{
+			Total:  page.Total,
+			Unread: page.Unread,
+			Limit:  query.Limit,
+		},
+	}
+	if page.Next != nil {
+		result.Meta.NextCursor = encodeCursor(query, *page.Next)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,123 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"mail/database"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func getMessageList(t *testing.T, handler http.Handler, target string) MessageListJSON {
+	t.Helper()
+	rr := doJSON(t, handler, "GET", target, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("%s: got %v: %s", target, rr.Code, rr.Body.String())
+	}
+	var list MessageListJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestMessageListPagination(t *testing.T) {
+	s := newTestServer()
+	router := newTestRouter(s, testUserEmail)
+	inbox := systemFolderID(s, testUserEmail, database.FolderInbox)
+	start := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	var ids []int64
+	for i := 0; i < 5; i++ {
+		ids = append(ids, seedMessage(s, database.Message{
+			Owner:    testUserEmail,
+			From:     database.Address{Email: "john@example.com"},
+			FolderID: inbox,
+			Date:     start.Add(time.Duration(i) * time.Hour),
+		}))
+	}
+
+	first := getMessageList(t, router, "/mail/inbox?limit=2")
+	if len(first.Messages) != 2 || first.Messages[0].ID != ids[4] || first.Meta.Total != 5 || first.Meta.NextCursor == "" {
+		t.Fatalf("unexpected first page: %+v", first)
+	}
+	// новое письмо не должно сдвигать следующие страницы
+	seedMessage(s, database.Message{Owner: testUserEmail, FolderID: inbox, Date: start.Add(24 * time.Hour)})
+
+	var got []int64
+	cursor := first.Meta.NextCursor
+	for cursor != "" {
+		page := getMessageList(t, router, "/mail/inbox?limit=2&cursor="+cursor)
+		for _, m := range page.Messages {
+			got = append(got, m.ID)
+		}
+		cursor = page.Meta.NextCursor
+	}
+	if len(got) != 3 || got[0] != ids[2] || got[2] != ids[0] {
+		t.Errorf("unexpected rest of the list: %v", got)
+	}
+
+	for _, target := range []string{
+		"/mail/inbox?limit=0",
+		"/mail/inbox?limit=1000",
+		"/mail/inbox?sort=size",
+		"/mail/inbox?unread=maybe",
+		"/mail/inbox?since=yesterday",
+		"/mail/inbox?cursor=garbage",
+		"/mail/inbox?sort=subject&cursor=" + first.Meta.NextCursor,
+	} {
+		if rr := doJSON(t, router, "GET", target, nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %v want %v", target, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestMessageListSortAndFilter(t *testing.T) {
+	s := newTestServer()
+	router := newTestRouter(s, testUserEmail)
+	inbox := systemFolderID(s, testUserEmail, database.FolderInbox)
+	date := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	report := seedMessage(s, database.Message{
+		Owner:       testUserEmail,
+		From:        database.Address{Name: "Mark", Email: "mark@example.com"},
+		Subject:     "Re: Report",
+		Flags:       []string{database.FlagFlagged},
+		Attachments: []database.AttachmentMeta{{ID: 1, Filename: "q3.csv"}},
+		FolderID:    inbox,
+		Date:        date,
+	})
+	agenda := seedMessage(s, database.Message{
+		Owner:    testUserEmail,
+		From:     database.Address{Email: "anna@example.com"},
+		Subject:  "Agenda",
+		Flags:    []string{database.FlagSeen},
+		FolderID: inbox,
+		Date:     date.Add(24 * time.Hour),
+	})
+
+	for _, tt := range []struct {
+		query string
+		ids   []int64
+	}{
+		{"sort=subject", []int64{agenda, report}},
+		{"sort=subject&order=desc", []int64{report, agenda}},
+		{"sort=from", []int64{agenda, report}},
+		{"order=asc", []int64{report, agenda}},
+		{"unread=true", []int64{report}},
+		{"flagged=true", []int64{report}},
+		{"has_attachments=true", []int64{report}},
+		{"from=ANNA", []int64{agenda}},
+		{"from=mark", []int64{report}},
+		{"since=2024-10-02", []int64{agenda}},
+		{"until=" + url.QueryEscape(date.Add(time.Hour).Format(time.RFC3339)), []int64{report}},
+	} {
+		list := getMessageList(t, router, "/mail/inbox?"+tt.query)
+		var got []int64
+		for _, m := range list.Messages {
+			got = append(got, m.ID)
+		}
+		if len(got) != len(tt.ids) || got[0] != tt.ids[0] || list.Meta.Total != len(tt.ids) {
+			t.Errorf("%s: got %v (total %d) want %v", tt.query, got, list.Meta.Total, tt.ids)
+		}
+	}
+}
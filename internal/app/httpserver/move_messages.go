@@ -0,0 +1,50 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"log/slog"
+	"mail/database"
+	"net/http"
+)
+
+const maxBulkMessages = 1000
+
+type MoveMessagesRequest struct {
+	IDs      []int64 `json:"ids"`
+	FolderID int64   `json:"folder_id"`
+}
+
+type MoveMessagesResponse struct {
+	Moved int `json:"moved"`
+}
+
+func (s *HTTPServer) moveMessages(w http.ResponseWriter, r *http.Request) {
+	var input MoveMessagesRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(input.IDs) == 0 || len(input.IDs) > maxBulkMessages {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+
+	folder, err := s.repo.Folders.GetByID(r.Context(), currentUser(r), input.FolderID)
+	if err != nil {
+		folderErrorResponse(w, r, err)
+		return
+	}
+	// в черновики и отправленные письма попадают только через отправку
+	// и сохранение черновика
+	if folder.System == database.FolderDrafts || folder.System == database.FolderSent {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_folder")
+		return
+	}
+	moved, err := s.repo.Messages.Move(r.Context(), currentUser(r), input.IDs, folder.ID)
+	if err != nil {
+		slog.Error("failed to move messages", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, MoveMessagesResponse{Moved: moved})
+}
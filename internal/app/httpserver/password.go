@@ -0,0 +1,293 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mail/database"
+	"mail/pkg/linktoken"
+	"mail/pkg/middleware"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const resetPasswordPurpose = "reset-password"
+
+const (
+	defaultResetTTL = time.Hour
+	// не больше maxResetRequests писем на адрес и maxResetRequestsPerIP
+	// запросов с одного IP за resetRequestsWindow, остальные молча
+	// пропускаются
+	maxResetRequests      = 3
+	maxResetRequestsPerIP = 10
+	resetRequestsWindow   = time.Hour
+)
+
+// rateLimiter пропускает не больше max запросов по ключу за окно window.
+// Окно отсчитывается от первого запроса, ключи с истёкшим окном
+// удаляются, чтобы карта не росла от разовых запросов.
+type rateLimiter struct {
+	mu        sync.Mutex
+	max       int
+	window    time.Duration
+	windows   map[string]rateWindow
+	lastPrune time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(max int, window time.Duration) *rateLimiter {
+	return &rateLimiter{max: max, window: window, windows: make(map[string]rateWindow)}
+}
+
+func (l *rateLimiter) allowed(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now)
+	w, ok := l.windows[key]
+	return !ok || now.Sub(w.start) >= l.window || w.count < l.max
+}
+
+func (l *rateLimiter) add(key string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = rateWindow{start: now}
+	}
+	w.count++
+	l.windows[key] = w
+}
+
+// prune раз в окно удаляет ключи, окно которых уже закончилось.
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < l.window {
+		return
+	}
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= l.window {
+			delete(l.windows, key)
+		}
+	}
+	l.lastPrune = now
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token      string `json:"token"`
+	Password   string `json:"password"`
+	RePassword string `json:"repassword"`
+}
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	Password    string `json:"password"`
+	RePassword  string `json:"repassword"`
+}
+
+// passwordFingerprint - отпечаток хэша пароля в токене сброса. После
+// смены пароля отпечаток перестаёт совпадать, поэтому токен
+// срабатывает один раз.
+func passwordFingerprint(hash string) string {
+	sum := sha256.Sum256([]byte(hash))
+	return hex.EncodeToString(sum[:8])
+}
+
+// fingerprintSubject - subject токена, который перестаёт действовать при
+// смене пароля.
+func fingerprintSubject(user database.User) string {
+	return user.Email + ":" + passwordFingerprint(user.Password)
+}
+
+func splitFingerprint(subject string) (string, string, bool) {
+	i := strings.LastIndex(subject, ":")
+	if i < 0 {
+		return "", "", false
+	}
+	return subject[:i], subject[i+1:], true
+}
+
+// forgotPassword отправляет ссылку для сброса пароля. Ответ не зависит
+// от того, есть ли такой пользователь, превышен ли лимит и удалось ли
+// отправить письмо, чтобы по нему нельзя было перебирать адреса.
+func (s *HTTPServer) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var input ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !emailIsValid(input.Email) {
+		ErrorResponse(w, r, "invalid_email")
+		return
+	}
+	s.requestPasswordReset(r, input.Email)
+	w.WriteHeader(http.StatusOK)
+}
+
+// requestPasswordReset отправляет письмо со ссылкой, если пользователь
+// есть и лимит запросов не превышен. Ошибки только пишутся в лог.
+func (s *HTTPServer) requestPasswordReset(r *http.Request, email string) {
+	now := time.Now()
+	address, ip := strings.ToLower(email), middleware.ClientIP(r)
+	if !s.resetByAddress.allowed(address, now) || !s.resetByIP.allowed(ip, now) {
+		slog.Warn("too many password reset requests", "ip", ip)
+		return
+	}
+	s.resetByAddress.add(address, now)
+	s.resetByIP.add(ip, now)
+
+	user, err := s.repo.Users.GetByEmail(r.Context(), email)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return
+	}
+	if err != nil {
+		slog.Error("failed to get user", "error", err)
+		return
+	}
+	if err := s.sendPasswordReset(r.Context(), user); err != nil {
+		slog.Error("failed to send password reset email", "error", err)
+	}
+}
+
+func (s *HTTPServer) sendPasswordReset(ctx context.Context, user database.User) error {
+	ttl := s.account.ResetTTL
+	if ttl <= 0 {
+		ttl = defaultResetTTL
+	}
+	expires := time.Now().Add(ttl)
+	token := s.links.Sign(resetPasswordPurpose, fingerprintSubject(user), expires)
+	return s.sendAccountMail(ctx, user, "Восстановление пароля",
+		"Чтобы задать новый пароль, перейдите по ссылке:\n"+
+			s.account.ResetURL+url.QueryEscape(token)+"\n\n"+
+			"Ссылка действует до "+expires.Format("02.01.2006 15:04 MST")+" и только один раз.\n"+
+			"Если вы не запрашивали сброс пароля, просто удалите это письмо.\n")
+}
+
+// resetPassword задаёт новый пароль по токену из письма и завершает все
+// сессии пользователя. Переход по ссылке доказывает владение адресом,
+// поэтому неподтверждённый адрес становится подтверждённым.
+func (s *HTTPServer) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var input ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !inputIsValid(input.Password) || !inputIsValid(input.RePassword) {
+		ErrorResponse(w, r, "invalid_input")
+		return
+	}
+	if input.Password != input.RePassword {
+		ErrorResponse(w, r, "invalid_password")
+		return
+	}
+
+	subject, err := s.links.Verify(resetPasswordPurpose, input.Token, time.Now())
+	if errors.Is(err, linktoken.ErrExpired) {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "token_expired")
+		return
+	}
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_token")
+		return
+	}
+	email, fingerprint, ok := splitFingerprint(subject)
+	if !ok {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_token")
+		return
+	}
+	user, err := s.repo.Users.GetByEmail(r.Context(), email)
+	if errors.Is(err, database.ErrUserNotFound) {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_token")
+		return
+	}
+	if err != nil {
+		slog.Error("failed to get user", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	if fingerprint != passwordFingerprint(user.Password) {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_token")
+		return
+	}
+
+	user.Pending = false
+	if !s.setPassword(w, r, user, input.Password, "") {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// changePassword меняет пароль по старому паролю. Остальные сессии
+// пользователя завершаются, текущая остаётся.
+func (s *HTTPServer) changePassword(w http.ResponseWriter, r *http.Request) {
+	var input ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !inputIsValid(input.OldPassword) || !inputIsValid(input.Password) || !inputIsValid(input.RePassword) {
+		ErrorResponse(w, r, "invalid_input")
+		return
+	}
+	if input.Password != input.RePassword {
+		ErrorResponse(w, r, "invalid_password")
+		return
+	}
+
+	user, err := s.repo.Users.GetByEmail(r.Context(), currentUser(r))
+	if err != nil {
+		slog.Error("failed to get user", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	ok, _, err := s.passwords.Verify(input.OldPassword, user.Password)
+	if err != nil {
+		slog.Error("failed to verify password", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	if !ok {
+		ErrorResponse(w, r, "invalid_password")
+		return
+	}
+
+	if !s.setPassword(w, r, user, input.Password, s.currentSession(r)) {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// setPassword сохраняет новый пароль и удаляет сессии пользователя,
+// кроме keepSession. При ошибке пишет ответ и возвращает false.
+func (s *HTTPServer) setPassword(w http.ResponseWriter, r *http.Request, user database.User, plainPassword string, keepSession string) bool {
+	hash, err := s.passwords.Hash(plainPassword)
+	if err != nil {
+		slog.Error("failed to hash password", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return false
+	}
+	user.Password = hash
+	if err := s.repo.Users.Update(r.Context(), user); err != nil {
+		slog.Error("failed to update user", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return false
+	}
+	if err := s.repo.Sessions.DeleteByEmail(r.Context(), user.Email, keepSession); err != nil {
+		slog.Error("failed to delete sessions", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"mail/database"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkPassword проверяет, что у пользователя сохранён пароль plain.
+func checkPassword(t *testing.T, s *HTTPServer, email string, plain string) {
+	t.Helper()
+	user, err := s.repo.Users.GetByEmail(context.Background(), email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := s.passwords.Verify(plain, user.Password); err != nil || !ok {
+		t.Errorf("password %q does not match: %v", plain, err)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	s := newTestServer()
+	createTestUser(s, "nick", testUserEmail, "12345")
+	router := newAccountRouter(s, testUserEmail, false)
+	ctx := context.Background()
+	createTestSession(s, "other", testUserEmail)
+
+	if rr := doJSON(t, router, "POST", "/password/forgot", ForgotPasswordRequest{Email: "nobody@giga-mail.ru"}); rr.Code != http.StatusOK {
+		t.Errorf("unknown user: %v", rr.Code)
+	}
+	if queued, _ := s.repo.Outbound.ClaimDue(ctx, time.Now().Add(time.Hour), time.Minute, 10); len(queued) != 0 {
+		t.Fatalf("queued %d messages for unknown user", len(queued))
+	}
+	if rr := doJSON(t, router, "POST", "/password/forgot", ForgotPasswordRequest{Email: testUserEmail}); rr.Code != http.StatusOK {
+		t.Fatalf("forgot: %v", rr.Code)
+	}
+	token := queuedToken(t, s, testUserEmail, testResetURL)
+
+	rr := doJSON(t, router, "POST", "/password/reset", ResetPasswordRequest{Token: token, Password: "new", RePassword: "other"})
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("mismatched passwords: %v", rr.Code)
+	}
+	rr = doJSON(t, router, "POST", "/password/reset", ResetPasswordRequest{Token: token + "x", Password: "new", RePassword: "new"})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid_token") {
+		t.Errorf("invalid token: %v %s", rr.Code, rr.Body.String())
+	}
+	rr = doJSON(t, router, "POST", "/password/reset", ResetPasswordRequest{Token: token, Password: "new", RePassword: "new"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("reset: %v %s", rr.Code, rr.Body.String())
+	}
+	checkPassword(t, s, testUserEmail, "new")
+	for _, hash := range []string{testUserID, "other"} {
+		if _, err := s.repo.Sessions.Get(ctx, hash); !errors.Is(err, database.ErrSessionNotFound) {
+			t.Errorf("session %s survived reset: %v", hash, err)
+		}
+	}
+
+	// токен одноразовый
+	rr = doJSON(t, router, "POST", "/password/reset", ResetPasswordRequest{Token: token, Password: "again", RePassword: "again"})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid_token") {
+		t.Errorf("reused token: %v %s", rr.Code, rr.Body.String())
+	}
+	checkPassword(t, s, testUserEmail, "new")
+}
+
+func TestChangePassword(t *testing.T) {
+	s := newTestServer()
+	createTestUser(s, "nick", testUserEmail, "12345")
+	router := newAccountRouter(s, testUserEmail, false)
+	ctx := context.Background()
+	createTestSession(s, "other", testUserEmail)
+
+	rr := doJSON(t, router, "POST", "/password/change", ChangePasswordRequest{OldPassword: "wrong", Password: "new", RePassword: "new"})
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "invalid_password") {
+		t.Errorf("wrong old password: %v %s", rr.Code, rr.Body.String())
+	}
+	rr = doJSON(t, router, "POST", "/password/change", ChangePasswordRequest{OldPassword: "12345", Password: "new", RePassword: "new"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("change: %v %s", rr.Code, rr.Body.String())
+	}
+	checkPassword(t, s, testUserEmail, "new")
+	if _, err := s.repo.Sessions.Get(ctx, testUserID); err != nil {
+		t.Errorf("current session was deleted: %v", err)
+	}
+	if _, err := s.repo.Sessions.Get(ctx, "other"); !errors.Is(err, database.ErrSessionNotFound) {
+		t.Errorf("other session survived: %v", err)
+	}
+}
+
+func TestForgotPasswordLimit(t *testing.T) {
+	s := newTestServer()
+	createTestUser(s, "nick", testUserEmail, "12345")
+	router := newAccountRouter(s, testUserEmail, false)
+	queued := func() int {
+		due, err := s.repo.Outbound.ClaimDue(context.Background(), time.Now().Add(time.Hour), time.Minute, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(due)
+	}
+
+	for i := 0; i < maxResetRequests+1; i++ {
+		if rr := doJSON(t, router, "POST", "/password/forgot", ForgotPasswordRequest{Email: testUserEmail}); rr.Code != http.StatusOK {
+			t.Fatalf("request %d: %v", i, rr.Code)
+		}
+	}
+	if n := queued(); n != maxResetRequests {
+		t.Errorf("address limit: queued %d messages", n)
+	}
+
+	// другие адреса с того же IP
+	s.resetByAddress = newRateLimiter(maxResetRequests, resetRequestsWindow)
+	for i := maxResetRequests; i < maxResetRequestsPerIP; i++ {
+		doJSON(t, router, "POST", "/password/forgot", ForgotPasswordRequest{Email: "nobody" + strconv.Itoa(i) + "@giga-mail.ru"})
+	}
+	if rr := doJSON(t, router, "POST", "/password/forgot", ForgotPasswordRequest{Email: testUserEmail}); rr.Code != http.StatusOK {
+		t.Fatalf("ip limit: %v", rr.Code)
+	}
+	if n := queued(); n != 0 {
+		t.Errorf("ip limit: queued %d messages", n)
+	}
+}
+
+func TestRateLimiterWindow(t *testing.T) {
+	limiter := newRateLimiter(2, time.Hour)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if !limiter.allowed("a", start) {
+			t.Fatalf("request %d denied", i)
+		}
+		limiter.add("a", start)
+	}
+	if limiter.allowed("a", start.Add(59*time.Minute)) {
+		t.Error("third request in the window allowed")
+	}
+	// запросы из прошлого окна не копятся
+	if !limiter.allowed("a", start.Add(time.Hour)) {
+		t.Error("request after the window denied")
+	}
+
+	limiter.add("b", start.Add(30*time.Minute))
+	limiter.allowed("c", start.Add(2*time.Hour))
+	if len(limiter.windows) != 0 {
+		t.Errorf("stale keys are kept: %v", limiter.windows)
+	}
+}
@@ -4,11 +4,12 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"log/slog"
 	"mail/database"
 	"net/http"
 	"net/mail"
 	"regexp"
-	"time"
 	//"fmt"
 )
 
@@ -19,7 +20,7 @@ type UserJSON struct {
 	RePassword string `json:"repassword"`
 }
 
-func SignUpHandler(w http.ResponseWriter, r *http.Request) {
+func (s *HTTPServer) SignUpHandler(w http.ResponseWriter, r *http.Request) {
 
 	var user UserJSON
 
@@ -55,24 +56,40 @@ func SignUpHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, ok := database.UserDB[user.Email]; ok {
+	passwordHash, err := s.passwords.Hash(user.Password)
+	if err != nil {
+		slog.Error("failed to hash password", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+
+	account := database.User{Email: user.Email, Name: user.Name, Password: passwordHash, Pending: s.account.VerifyEmail}
+	err = s.repo.Users.Create(r.Context(), account)
+	if errors.Is(err, database.ErrUserExists) {
 		ErrorResponse(w, r, "login_taken")
 		return
 	}
+	if err != nil {
+		slog.Error("failed to create user", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
 
-	hash := GenerateHash()
-	database.UserDB[user.Email] = database.User{Email: user.Email, Name: user.Name, Password: user.Password}
-	database.UserHash[hash] = user.Email
-	//w.Header().Set("Content-Type", "application/json")
-	expiration := time.Now().Add(24 * time.Hour)
-	cookie := http.Cookie{
-		Name:     "session",
-		Value:    hash,
-		Expires:  expiration,
-		HttpOnly: true,
+	csrfToken, err := s.createSession(w, r, user.Email)
+	if err != nil {
+		slog.Error("failed to create session", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	// письмо можно запросить повторно, поэтому сбой отправки не отменяет
+	// регистрацию
+	if account.Pending {
+		if err := s.sendVerification(r.Context(), account); err != nil {
+			slog.Error("failed to send verification email", "error", err)
+		}
 	}
-	http.SetCookie(w, &cookie)
-	w.WriteHeader(http.StatusOK)
+	//w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, http.StatusOK, SignUpResponse{Pending: account.Pending, CSRFToken: csrfToken})
 }
 
 func emailIsValid(email string) bool {
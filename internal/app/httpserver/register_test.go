@@ -2,15 +2,16 @@ package httpserver
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
-	"mail/database"
 )
 
 func TestSignUpOK(t *testing.T) {
 
+	s := newTestServer()
 	todo := UserJSON{
 	Name: "aaaa",
 	Email: "petia@giga-mail.ru",
@@ -19,7 +20,7 @@ func TestSignUpOK(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(SignUpHandler)
+	handler := http.HandlerFunc(s.SignUpHandler)
 
 	jsonReq, err := json.Marshal(todo)
 	if err != nil {
@@ -42,7 +43,8 @@ func TestSignUpOK(t *testing.T) {
 
 func TestSignUpFailLogin(t *testing.T) {
 
-	database.UserDB["nick@giga-mail.ru"] = database.User{ Name: "nick", Email: "nick@giga-mail.ru", Password: "12345"} //убрать, когда будет бд
+	s := newTestServer()
+	createTestUser(s, "nick", "nick@giga-mail.ru", "12345")
 
 	todo := UserJSON{
 	Name: "aaaa",
@@ -52,7 +54,7 @@ func TestSignUpFailLogin(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(SignUpHandler)
+	handler := http.HandlerFunc(s.SignUpHandler)
 
 	jsonReq, err := json.Marshal(todo)
 	if err != nil {
@@ -75,6 +77,7 @@ func TestSignUpFailLogin(t *testing.T) {
 
 func TestSignUpFailPassword(t *testing.T) {
 
+	s := newTestServer()
 	todo := UserJSON{
 	Name: "aaaa",
 	Email: "nick@giga-mail.ru",
@@ -83,7 +86,7 @@ func TestSignUpFailPassword(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(SignUpHandler)
+	handler := http.HandlerFunc(s.SignUpHandler)
 
 	jsonReq, err := json.Marshal(todo)
 	if err != nil {
@@ -102,4 +105,30 @@ func TestSignUpFailPassword(t *testing.T) {
 			status, http.StatusForbidden)
 	}
 
-}
\ No newline at end of file
+}
+func TestSignUpStoresPasswordHash(t *testing.T) {
+
+	s := newTestServer()
+
+	jsonReq, err := json.Marshal(UserJSON{Name: "aaaa", Email: "petia@giga-mail.ru", Password: "cccc", RePassword: "cccc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", "/signup", bytes.NewBuffer(jsonReq))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(s.SignUpHandler).ServeHTTP(rr, req)
+
+	user, err := s.repo.Users.GetByEmail(context.Background(), "petia@giga-mail.ru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Password == "cccc" {
+		t.Error("password stored in plaintext")
+	}
+	if ok, _, _ := s.passwords.Verify("cccc", user.Password); !ok {
+		t.Error("stored hash does not match password")
+	}
+}
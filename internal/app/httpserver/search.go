@@ -0,0 +1,67 @@
+package httpserver
+
+import (
+	"log/slog"
+	"mail/database"
+	"mail/pkg/search"
+	"net/http"
+	"strconv"
+)
+
+// SearchResultJSON - страница результатов поиска, новые письма первыми.
+type SearchResultJSON struct {
+	Messages []MessageSummaryJSON `json:"messages"`
+	Meta     SearchMetaJSON       `json:"meta"`
+}
+
+type SearchMetaJSON struct {
+	Total      int    `json:"total"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// searchOrder - порядок результатов поиска, для него выдаются курсоры.
+var searchOrder = database.ListQuery{Sort: database.SortDate, Desc: true}
+
+// searchMessages ищет по запросу из параметра q, синтаксис описан
+// в search.Parse. limit и cursor работают как в списках писем.
+func (s *HTTPServer) searchMessages(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query, err := search.Parse(params.Get("q"))
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_query")
+		return
+	}
+	limit := defaultPageSize
+	if value := params.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+			return
+		}
+	}
+	var after *database.Cursor
+	if value := params.Get("cursor"); value != "" {
+		cursor, ok := decodeCursor(searchOrder, value)
+		if !ok {
+			ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+			return
+		}
+		after = cursor
+	}
+
+	found, err := s.search.Search(r.Context(), currentUser(r), query, after, limit)
+	if err != nil {
+		slog.Error("failed to search messages", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	result := SearchResultJSON{
+		Messages: toMessageSummariesJSON(found.Messages),
+		Meta:     SearchMetaJSON{Total: found.Total, Limit: limit},
+	}
+	if found.Next != nil {
+		result.Meta.NextCursor = encodeCursor(searchOrder, *found.Next)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,59 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"mail/database"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSearchMessages(t *testing.T) {
+	s := newTestServer()
+	router := newTestRouter(s, testUserEmail)
+	inbox := systemFolderID(s, testUserEmail, database.FolderInbox)
+	date := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		ids = append(ids, seedMessage(s, database.Message{
+			Owner:    testUserEmail,
+			From:     database.Address{Email: "john@example.com"},
+			Subject:  "Отчёт за квартал",
+			FolderID: inbox,
+			Date:     date.Add(time.Duration(i) * time.Hour),
+		}))
+	}
+	seedMessage(s, database.Message{Owner: testUserEmail, Subject: "Отпуск", FolderID: inbox, Date: date})
+
+	var got []int64
+	cursor := ""
+	for {
+		rr := doJSON(t, router, "GET", "/mail/search?limit=2&q="+url.QueryEscape("from:john отчеты")+"&cursor="+cursor, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got %v: %s", rr.Code, rr.Body.String())
+		}
+		var result SearchResultJSON
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Meta.Total != 3 {
+			t.Errorf("got total %d want 3", result.Meta.Total)
+		}
+		for _, m := range result.Messages {
+			got = append(got, m.ID)
+		}
+		if cursor = result.Meta.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if len(got) != 3 || got[0] != ids[2] || got[2] != ids[0] {
+		t.Errorf("unexpected results: %v", got)
+	}
+
+	for _, target := range []string{"/mail/search", "/mail/search?q=has:pictures", "/mail/search?q=a&limit=0"} {
+		if rr := doJSON(t, router, "GET", target, nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %v want %v", target, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
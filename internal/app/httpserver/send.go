@@ -0,0 +1,205 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mail/database"
+	"mail/pkg/mimemsg"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+const maxRecipients = 100
+
+// SendMailRequest - новое письмо. ReplyTo - ID письма, на которое это
+// письмо отвечает.
+type SendMailRequest struct {
+	ReplyTo  int64    `json:"reply_to,omitempty"`
+	To       []string `json:"to"`
+	Cc       []string `json:"cc"`
+	Bcc      []string `json:"bcc"`
+	Subject  string   `json:"subject"`
+	TextBody string   `json:"text_body"`
+	HTMLBody string   `json:"html_body"`
+}
+
+// parseRecipients проверяет адреса через emailIsValid и приводит их
+// к database.Address. Допускается как "a@b.ru", так и "Имя <a@b.ru>".
+func parseRecipients(list []string) ([]database.Address, bool) {
+	result := make([]database.Address, 0, len(list))
+	for _, raw := range list {
+		if !emailIsValid(raw) {
+			return nil, false
+		}
+		address, _ := mail.ParseAddress(raw)
+		result = append(result, database.Address{Name: address.Name, Email: address.Address})
+	}
+	return result, true
+}
+
+func (s *HTTPServer) sendMail(w http.ResponseWriter, r *http.Request) {
+	var input SendMailRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	to, okTo := parseRecipients(input.To)
+	cc, okCc := parseRecipients(input.Cc)
+	bcc, okBcc := parseRecipients(input.Bcc)
+	if !okTo || !okCc || !okBcc {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_email")
+		return
+	}
+	total := len(to) + len(cc) + len(bcc)
+	if total == 0 || total > maxRecipients {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_recipients")
+		return
+	}
+	if !s.senderIsLocal(currentUser(r)) {
+		ErrorResponse(w, r, "sender_not_local")
+		return
+	}
+
+	message, err := s.composeFromUser(r.Context(), currentUser(r))
+	if err != nil {
+		slog.Error("failed to get sender", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	message.To = to
+	message.Cc = cc
+	message.Bcc = bcc
+	message.Subject = input.Subject
+	message.TextBody = input.TextBody
+	message.HTMLBody = input.HTMLBody
+	if input.ReplyTo != 0 {
+		if err := s.setReplyTo(r.Context(), &message, input.ReplyTo); err != nil {
+			replyErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	sent, err := s.sendMessage(r.Context(), message)
+	if err != nil {
+		slog.Error("failed to send message", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	if input.ReplyTo != 0 {
+		_, err := s.repo.Messages.UpdateFlags(r.Context(), sent.Owner, []int64{input.ReplyTo}, []string{database.FlagAnswered}, nil)
+		if err != nil {
+			slog.Error("failed to mark message as answered", "error", err)
+		}
+	}
+	writeJSON(w, http.StatusOK, toMessageJSON(sent))
+}
+
+// senderIsLocal проверяет, что адрес отправителя в домене этого сервера.
+// Регистрация не ограничена доменами, а письма от чужих доменов
+// превратили бы очередь в открытый релей.
+func (s *HTTPServer) senderIsLocal(address string) bool {
+	at := strings.LastIndex(address, "@")
+	return at >= 0 && s.localDomains[strings.ToLower(address[at+1:])]
+}
+
+// composeFromUser заготавливает письмо от имени пользователя.
+func (s *HTTPServer) composeFromUser(ctx context.Context, email string) (database.Message, error) {
+	user, err := s.repo.Users.GetByEmail(ctx, email)
+	if err != nil {
+		return database.Message{}, err
+	}
+	return database.Message{
+		Owner: user.Email,
+		From:  database.Address{Name: user.Name, Email: user.Email},
+	}, nil
+}
+
+// sendMessage собирает письмо вместе с вложениями, кладёт его
+// в «Отправленные» и ставит в очередь исходящей почты. Черновик (письмо
+// с ID) переносится одним Update с проверкой версии, так что
+// параллельное автосохранение получит конфликт. Если поставить письмо
+// в очередь не удалось, всё возвращается как было. Отправитель всегда
+// берётся из учётной записи владельца, а не из сохранённого письма.
+func (s *HTTPServer) sendMessage(ctx context.Context, message database.Message) (database.Message, error) {
+	sender, err := s.composeFromUser(ctx, message.Owner)
+	if err != nil {
+		return database.Message{}, err
+	}
+	sentFolder, err := s.repo.Folders.GetSystem(ctx, message.Owner, database.FolderSent)
+	if err != nil {
+		return database.Message{}, err
+	}
+	draft := message
+	message.From = sender.From
+	message.FolderID = sentFolder.ID
+	message.MessageID = mimemsg.GenerateMessageID(s.outbound.Hostname())
+	message.Date = time.Now()
+	message.Flags = []string{database.FlagSeen}
+	attachments, err := s.loadAttachments(ctx, message.Attachments)
+	if err != nil {
+		return database.Message{}, err
+	}
+	if message.Raw, err = mimemsg.Compose(message, attachments...); err != nil {
+		return database.Message{}, err
+	}
+
+	if message.ID == 0 {
+		if message.ID, err = s.repo.Messages.Create(ctx, message); err != nil {
+			return database.Message{}, err
+		}
+	} else {
+		if err := s.repo.Messages.Update(ctx, message); err != nil {
+			return database.Message{}, err
+		}
+		message.Version++
+	}
+
+	if err := s.outbound.Enqueue(ctx, message); err != nil {
+		s.rollbackSend(ctx, draft, message)
+		return database.Message{}, err
+	}
+	return s.repo.Messages.GetByID(ctx, message.Owner, message.ID)
+}
+
+// rollbackSend удаляет письмо, созданное sendMessage, или возвращает
+// черновик в прежнее состояние.
+func (s *HTTPServer) rollbackSend(ctx context.Context, draft database.Message, sent database.Message) {
+	if draft.ID == 0 {
+		if err := s.repo.Messages.Delete(ctx, sent.Owner, sent.ID); err != nil {
+			slog.Error("failed to remove unsent message", "error", err)
+		}
+		return
+	}
+	draft.Version = sent.Version
+	// у черновика нет собранного текста, затираем только что записанный
+	draft.Raw = []byte{}
+	if err := s.repo.Messages.Update(ctx, draft); err != nil {
+		slog.Error("failed to restore unsent draft", "error", err)
+	}
+}
+
+// loadAttachments читает содержимое загруженных вложений из хранилища.
+func (s *HTTPServer) loadAttachments(ctx context.Context, metas []database.AttachmentMeta) ([]mimemsg.Attachment, error) {
+	result := make([]mimemsg.Attachment, 0, len(metas))
+	for _, meta := range metas {
+		if meta.StorageKey == "" {
+			continue
+		}
+		blob, err := s.blobs.Get(ctx, meta.StorageKey)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(blob)
+		blob.Close()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, mimemsg.Attachment{Meta: meta, Data: data})
+	}
+	return result, nil
+}
@@ -0,0 +1,85 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"mail/database"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendMail(t *testing.T) {
+	s := newTestServer()
+	createTestUser(s, "jane", testUserEmail, "12345")
+	router := newTestRouter(s, testUserEmail)
+
+	rr := doJSON(t, router, "POST", "/mail/send", SendMailRequest{
+		To:       []string{"Mark <mark.brown@example.com>"},
+		Bcc:      []string{"boss@example.com"},
+		Subject:  "Meeting Reminder",
+		TextBody: "See you tomorrow at 10 AM.",
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	var sent MessageJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent.From.Email != testUserEmail || sent.From.Name != "jane" || sent.To[0].Name != "Mark" || sent.MessageID == "" {
+		t.Errorf("unexpected sent message: %+v", sent)
+	}
+	if sent.FolderID != systemFolderID(s, testUserEmail, database.FolderSent) {
+		t.Errorf("message should be stored in Sent: %+v", sent)
+	}
+
+	due, err := s.repo.Outbound.ClaimDue(context.Background(), time.Now().Add(time.Second), time.Minute, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || len(due[0].Recipients) != 2 || due[0].Sender != testUserEmail {
+		t.Fatalf("unexpected queue: %+v", due)
+	}
+	if strings.Contains(string(due[0].Data), "boss@example.com") {
+		t.Error("Bcc recipient leaked into message headers")
+	}
+}
+
+func TestSendMailInvalidRecipient(t *testing.T) {
+	s := newTestServer()
+	createTestUser(s, "jane", testUserEmail, "12345")
+	router := newTestRouter(s, testUserEmail)
+
+	for _, input := range []SendMailRequest{
+		{To: []string{"not an email"}, Subject: "Hi"},
+		{Subject: "No recipients"},
+	} {
+		rr := doJSON(t, router, "POST", "/mail/send", input)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%+v: got %v want %v", input, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestSendMailFromForeignDomain(t *testing.T) {
+	s := newTestServer()
+	createTestUser(s, "ceo", "ceo@bank.com", "12345")
+	router := newTestRouter(s, "ceo@bank.com")
+
+	rr := doJSON(t, router, "POST", "/mail/send", SendMailRequest{To: []string{"client@example.com"}, Subject: "Transfer"})
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "sender_not_local") {
+		t.Errorf("send: got %v %s", rr.Code, rr.Body.String())
+	}
+	rr = doJSON(t, router, "POST", "/mail/drafts", DraftRequest{To: []string{"client@example.com"}, Subject: "Transfer"})
+	draft := decodeMessage(t, rr.Body.Bytes())
+	rr = doJSON(t, router, "POST", "/mail/drafts/"+strconv.FormatInt(draft.ID, 10)+"/send", SendDraftRequest{Version: draft.Version})
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("send draft: got %v %s", rr.Code, rr.Body.String())
+	}
+	if due, _ := s.repo.Outbound.ClaimDue(context.Background(), time.Now().Add(time.Second), time.Minute, 10); len(due) != 0 {
+		t.Errorf("queued %d messages", len(due))
+	}
+}
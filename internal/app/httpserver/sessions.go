@@ -0,0 +1,143 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"mail/database"
+	"mail/pkg/middleware"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	sessionCleanupInterval = time.Hour
+	maxUserAgent           = 256
+)
+
+type SessionJSON struct {
+	ID         int64     `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"` // сессия, с которой пришёл запрос
+}
+
+// createSession создаёт сессию для устройства из запроса, ставит куку и
+// возвращает CSRF-токен новой сессии.
+func (s *HTTPServer) createSession(w http.ResponseWriter, r *http.Request, email string) (string, error) {
+	userAgent := []rune(r.UserAgent())
+	if len(userAgent) > maxUserAgent {
+		userAgent = userAgent[:maxUserAgent]
+	}
+	now := time.Now()
+	session := database.Session{
+		Hash:       GenerateHash(),
+		Email:      email,
+		UserAgent:  string(userAgent),
+		IP:         middleware.ClientIP(r),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  middleware.SessionExpiry(s.session, now, now),
+	}
+	if _, err := s.repo.Sessions.Create(r.Context(), session); err != nil {
+		return "", err
+	}
+	middleware.SetSessionCookie(w, s.session, session.Hash, session.ExpiresAt)
+	return middleware.CSRFToken(s.csrfKey, session.Hash), nil
+}
+
+// cleanupSessions периодически удаляет истёкшие сессии.
+func (s *HTTPServer) cleanupSessions(ctx context.Context) {
+	ticker := time.NewTicker(sessionCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := s.repo.Sessions.DeleteExpired(ctx, now); err != nil {
+				slog.Error("failed to delete expired sessions", "error", err)
+			}
+		}
+	}
+}
+
+// currentSession возвращает хэш сессии из куки запроса.
+func (s *HTTPServer) currentSession(r *http.Request) string {
+	return middleware.SessionHash(r, s.session)
+}
+
+type CSRFResponse struct {
+	CSRFToken string `json:"csrf_token"`
+}
+
+// getCSRFToken отдаёт токен текущей сессии, например после перезагрузки
+// страницы фронтенда. Чужой сайт не прочитает ответ из-за CORS.
+func (s *HTTPServer) getCSRFToken(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, CSRFResponse{CSRFToken: middleware.CSRFToken(s.csrfKey, s.currentSession(r))})
+}
+
+func (s *HTTPServer) listSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := s.repo.Sessions.List(r.Context(), currentUser(r))
+	if err != nil {
+		slog.Error("failed to list sessions", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	current := s.currentSession(r)
+	result := make([]SessionJSON, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, SessionJSON{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.Hash == current,
+		})
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// deleteSession завершает одну сессию. Если это текущая сессия, кука
+// тоже удаляется, как при выходе.
+func (s *HTTPServer) deleteSession(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	current, err := s.repo.Sessions.Get(r.Context(), s.currentSession(r))
+	if err != nil && !errors.Is(err, database.ErrSessionNotFound) {
+		slog.Error("failed to get session", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	err = s.repo.Sessions.DeleteByID(r.Context(), currentUser(r), id)
+	if errors.Is(err, database.ErrSessionNotFound) {
+		ErrorResponseWithStatus(w, r, http.StatusNotFound, "session_not_found")
+		return
+	}
+	if err != nil {
+		slog.Error("failed to delete session", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	if current.ID == id {
+		middleware.ClearSessionCookie(w, s.session)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// deleteOtherSessions завершает все сессии пользователя, кроме текущей.
+func (s *HTTPServer) deleteOtherSessions(w http.ResponseWriter, r *http.Request) {
+	if err := s.repo.Sessions.DeleteByEmail(r.Context(), currentUser(r), s.currentSession(r)); err != nil {
+		slog.Error("failed to delete sessions", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,114 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"mail/config"
+	"mail/database"
+	"mail/pkg/middleware"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// doWithSession выполняет запрос с кукой hash.
+func doWithSession(router http.Handler, method string, url string, hash string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, nil)
+	req.Header.Set("User-Agent", "Firefox")
+	req.AddCookie(&http.Cookie{Name: "session", Value: hash})
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestSessionExpiry(t *testing.T) {
+	cfg := config.SessionConfig{TTL: 10 * time.Hour, IdleTimeout: time.Hour}
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got := middleware.SessionExpiry(cfg, created, created.Add(time.Hour)); !got.Equal(created.Add(2 * time.Hour)) {
+		t.Errorf("idle: got %v", got)
+	}
+	if got := middleware.SessionExpiry(cfg, created, created.Add(9*time.Hour+30*time.Minute)); !got.Equal(created.Add(10 * time.Hour)) {
+		t.Errorf("ttl: got %v", got)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	s := newTestServer()
+	createTestUser(s, "nick", testUserEmail, "12345")
+	router := newTestRouter(s, testUserEmail)
+	ctx := context.Background()
+
+	rr := doJSON(t, router, "POST", "/login", UserLogin{Email: testUserEmail, Password: "12345"})
+	if !hasSessionCookie(rr) {
+		t.Fatalf("login: %v", rr.Code)
+	}
+	phone := rr.Result().Cookies()[0].Value
+	created, err := s.repo.Sessions.Get(ctx, phone)
+	if err != nil || !created.ExpiresAt.After(time.Now().Add(23*time.Hour)) {
+		t.Fatalf("got %+v, %v", created, err)
+	}
+
+	rr = doJSON(t, router, "GET", "/sessions", nil)
+	var sessions []SessionJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &sessions); err != nil || len(sessions) != 2 {
+		t.Fatalf("got %s, %v", rr.Body.String(), err)
+	}
+	for _, session := range sessions {
+		if session.Current != (session.ID != created.ID) {
+			t.Errorf("session %d: current %v", session.ID, session.Current)
+		}
+	}
+
+	if rr := doJSON(t, router, "DELETE", "/sessions/999", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown session: %v", rr.Code)
+	}
+	if rr := doJSON(t, router, "DELETE", "/sessions/"+strconv.FormatInt(created.ID, 10), nil); rr.Code != http.StatusOK {
+		t.Fatalf("delete: %v", rr.Code)
+	}
+	if rr := doWithSession(router, "GET", "/sessions", phone); rr.Code != http.StatusUnauthorized {
+		t.Errorf("deleted session: %v", rr.Code)
+	}
+
+	createTestSession(s, "laptop", testUserEmail)
+	if rr := doJSON(t, router, "DELETE", "/sessions", nil); rr.Code != http.StatusOK {
+		t.Fatalf("delete others: %v", rr.Code)
+	}
+	if rr := doWithSession(router, "GET", "/sessions", "laptop"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("other session: %v", rr.Code)
+	}
+	if rr := doJSON(t, router, "GET", "/sessions", nil); rr.Code != http.StatusOK {
+		t.Errorf("current session: %v", rr.Code)
+	}
+}
+
+func TestSessionRenewal(t *testing.T) {
+	s := newTestServer()
+	createTestUser(s, "nick", testUserEmail, "12345")
+	router := newTestRouter(s, testUserEmail)
+	ctx := context.Background()
+
+	now := time.Now()
+	s.repo.Sessions.Create(ctx, database.Session{
+		Hash: "idle", Email: testUserEmail, CreatedAt: now.Add(-time.Hour),
+		LastSeenAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Minute),
+	})
+	rr := doWithSession(router, "GET", "/mail/inbox", "idle")
+	if rr.Code != http.StatusOK || !hasSessionCookie(rr) {
+		t.Fatalf("got %v, cookie %v", rr.Code, hasSessionCookie(rr))
+	}
+	session, _ := s.repo.Sessions.Get(ctx, "idle")
+	if !session.ExpiresAt.After(now.Add(23*time.Hour)) || session.LastSeenAt.Before(now) || session.IP != "192.0.2.1" {
+		t.Errorf("not renewed: %+v", session)
+	}
+	// продление не чаще раза в минуту
+	if rr := doWithSession(router, "GET", "/mail/inbox", "idle"); hasSessionCookie(rr) {
+		t.Error("renewed twice")
+	}
+
+	s.repo.Sessions.Touch(ctx, "idle", "", now.Add(-25*time.Hour), now.Add(-time.Hour))
+	if rr := doWithSession(router, "GET", "/mail/inbox", "idle"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("idle session: %v", rr.Code)
+	}
+}
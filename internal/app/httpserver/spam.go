@@ -0,0 +1,119 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mail/database"
+	"mail/pkg/spam"
+	"net/http"
+)
+
+type SpamReportRequest struct {
+	IDs []int64 `json:"ids"`
+}
+
+func (s *HTTPServer) reportSpam(w http.ResponseWriter, r *http.Request) {
+	s.reportMessages(w, r, true)
+}
+
+func (s *HTTPServer) reportHam(w http.ResponseWriter, r *http.Request) {
+	s.reportMessages(w, r, false)
+}
+
+// reportMessages отмечает письма как спам или не спам: переносит их
+// в «Спам» или из него во входящие и обучает спам-фильтр. Флаги $Junk
+// и $NotJunk запоминают, на каком классе письмо уже учило фильтр:
+// повторная отметка ничего не меняет, а смена решения сначала отменяет
+// прежнее обучение.
+func (s *HTTPServer) reportMessages(w http.ResponseWriter, r *http.Request, junk bool) {
+	var input SpamReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(input.IDs) == 0 || len(input.IDs) > maxBulkMessages {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+
+	ctx := r.Context()
+	owner := currentUser(r)
+	spamFolder, err := s.repo.Folders.GetSystem(ctx, owner, database.FolderSpam)
+	if err != nil {
+		folderErrorResponse(w, r, err)
+		return
+	}
+	target := spamFolder
+	flag, opposite := database.FlagJunk, database.FlagNotJunk
+	if !junk {
+		if target, err = s.repo.Folders.GetSystem(ctx, owner, database.FolderInbox); err != nil {
+			folderErrorResponse(w, r, err)
+			return
+		}
+		flag, opposite = opposite, flag
+	}
+
+	updated := 0
+	var move []int64
+	for _, id := range uniqueIDs(input.IDs) {
+		message, err := s.repo.Messages.GetByID(ctx, owner, id)
+		if errors.Is(err, database.ErrMessageNotFound) {
+			continue
+		}
+		if err != nil {
+			slog.Error("failed to get message", "error", err)
+			ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+			return
+		}
+		updated++
+		if junk || message.FolderID == spamFolder.ID {
+			move = append(move, id)
+		}
+		if message.HasFlag(flag) {
+			continue
+		}
+		if err := s.trainSpam(r, message, junk); err != nil {
+			slog.Error("failed to train spam filter", "error", err)
+			ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+			return
+		}
+	}
+
+	if len(move) > 0 {
+		if _, err := s.repo.Messages.Move(ctx, owner, move, target.ID); err != nil {
+			slog.Error("failed to move messages", "error", err)
+			ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+			return
+		}
+	}
+	folders, err := s.repo.Folders.List(ctx, owner)
+	if err != nil {
+		folderErrorResponse(w, r, err)
+		return
+	}
+	result := UpdateFlagsResponse{Updated: updated, Folders: make([]FolderJSON, 0, len(folders))}
+	for _, folder := range folders {
+		result.Folders = append(result.Folders, toFolderJSON(folder))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// trainSpam учит фильтр на письме и сразу ставит флаг, чтобы при сбое
+// посреди пачки уже учтённые письма не попали в корпус дважды.
+func (s *HTTPServer) trainSpam(r *http.Request, message database.Message, junk bool) error {
+	flag, opposite := database.FlagJunk, database.FlagNotJunk
+	if !junk {
+		flag, opposite = opposite, flag
+	}
+	if message.HasFlag(opposite) {
+		if err := spam.Train(r.Context(), s.repo.Spam, message.Owner, message, !junk, -1); err != nil {
+			return err
+		}
+	}
+	if err := spam.Train(r.Context(), s.repo.Spam, message.Owner, message, junk, 1); err != nil {
+		return err
+	}
+	_, err := s.repo.Messages.UpdateFlags(r.Context(), message.Owner, []int64{message.ID}, []string{flag}, []string{opposite})
+	return err
+}
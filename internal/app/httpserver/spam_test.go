@@ -0,0 +1,56 @@
+package httpserver
+
+import (
+	"context"
+	"mail/database"
+	"mail/pkg/spam"
+	"net/http"
+	"testing"
+)
+
+func TestReportSpam(t *testing.T) {
+	s := newTestServer()
+	router := newTestRouter(s, testUserEmail)
+	ctx := context.Background()
+	inbox := systemFolderID(s, testUserEmail, database.FolderInbox)
+	spamFolder := systemFolderID(s, testUserEmail, database.FolderSpam)
+	id := seedMessage(s, database.Message{
+		Owner:    testUserEmail,
+		FolderID: inbox,
+		From:     database.Address{Email: "promo@casino.example"},
+		Subject:  "Free casino bonus",
+	})
+	tokens := spam.Tokens(database.Message{Subject: "Free casino bonus"})
+
+	for i := 0; i < 2; i++ {
+		if rr := doJSON(t, router, "POST", "/mail/messages/spam", SpamReportRequest{IDs: []int64{id, 100500}}); rr.Code != http.StatusOK {
+			t.Fatalf("got %v: %s", rr.Code, rr.Body.String())
+		}
+	}
+	message, _ := s.repo.Messages.GetByID(ctx, testUserEmail, id)
+	if message.FolderID != spamFolder || !message.HasFlag(database.FlagJunk) {
+		t.Errorf("unexpected message after spam report: %+v", message)
+	}
+	for _, owner := range []string{testUserEmail, database.GlobalCorpus} {
+		corpus, _ := s.repo.Spam.Corpus(ctx, owner, tokens)
+		if corpus.Spam != 1 || corpus.Ham != 0 || corpus.Tokens[tokens[0]].Spam != 1 {
+			t.Errorf("%q: unexpected corpus after spam report: %+v", owner, corpus)
+		}
+	}
+
+	if rr := doJSON(t, router, "POST", "/mail/messages/ham", SpamReportRequest{IDs: []int64{id}}); rr.Code != http.StatusOK {
+		t.Fatalf("got %v: %s", rr.Code, rr.Body.String())
+	}
+	message, _ = s.repo.Messages.GetByID(ctx, testUserEmail, id)
+	if message.FolderID != inbox || !message.HasFlag(database.FlagNotJunk) || message.HasFlag(database.FlagJunk) {
+		t.Errorf("unexpected message after ham report: %+v", message)
+	}
+	corpus, _ := s.repo.Spam.Corpus(ctx, testUserEmail, tokens)
+	if corpus.Spam != 0 || corpus.Ham != 1 || corpus.Tokens[tokens[0]] != (database.TokenCounts{Ham: 1}) {
+		t.Errorf("unexpected corpus after ham report: %+v", corpus)
+	}
+
+	if rr := doJSON(t, router, "POST", "/mail/messages/ham", SpamReportRequest{}); rr.Code != http.StatusBadRequest {
+		t.Errorf("got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,125 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"mail/database"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ThreadJSON - строка в списке цепочек. Subject берётся у первого
+// письма, Snippet и Date - у последнего.
+type ThreadJSON struct {
+	ID             int64         `json:"id"`
+	Subject        string        `json:"subject"`
+	Participants   []AddressJSON `json:"participants"`
+	Total          int           `json:"total"`
+	Unread         int           `json:"unread"`
+	HasAttachments bool          `json:"has_attachments"`
+	LatestID       int64         `json:"latest_id"`
+	Snippet        string        `json:"snippet"`
+	Date           time.Time     `json:"date"`
+}
+
+// ThreadMessagesJSON - цепочка целиком, письма от старых к новым.
+type ThreadMessagesJSON struct {
+	ThreadJSON
+	Messages []MessageJSON `json:"messages"`
+}
+
+func toThreadJSON(thread database.Thread) ThreadJSON {
+	return ThreadJSON{
+		ID:             thread.ID,
+		Subject:        thread.Subject,
+		Participants:   toAddressesJSON(thread.Participants),
+		Total:          thread.Total,
+		Unread:         thread.Unread,
+		HasAttachments: thread.HasAttachments,
+		LatestID:       thread.Latest.ID,
+		Snippet:        snippet(thread.Latest.TextBody),
+		Date:           thread.Latest.Date,
+	}
+}
+
+// listThreads отдаёт цепочки, в которых есть письма из папки folder
+// (по умолчанию «Входящие»). Счётчики учитывают все письма цепочки.
+func (s *HTTPServer) listThreads(w http.ResponseWriter, r *http.Request) {
+	param := r.URL.Query().Get("folder")
+	if param == "" {
+		param = database.FolderInbox
+	}
+	folder, err := s.resolveFolder(r, param)
+	if err != nil {
+		folderErrorResponse(w, r, err)
+		return
+	}
+	messages, err := s.repo.Messages.ListThreadsByFolder(r.Context(), currentUser(r), folder.ID)
+	if err != nil {
+		slog.Error("failed to list threads", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	threads := database.GroupThreads(messages)
+	result := make([]ThreadJSON, 0, len(threads))
+	for _, thread := range threads {
+		result = append(result, toThreadJSON(thread))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *HTTPServer) getThread(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+	messages, err := s.repo.Messages.ListByThread(r.Context(), currentUser(r), id)
+	if err != nil {
+		slog.Error("failed to get thread", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	threads := database.GroupThreads(messages)
+	if len(threads) == 0 {
+		ErrorResponseWithStatus(w, r, http.StatusNotFound, "thread_not_found")
+		return
+	}
+	result := ThreadMessagesJSON{
+		ThreadJSON: toThreadJSON(threads[0]),
+		Messages:   make([]MessageJSON, 0, len(messages)),
+	}
+	for _, message := range messages {
+		result.Messages = append(result.Messages, toMessageJSON(message))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// setReplyTo делает письмо ответом на письмо пользователя с ID replyTo:
+// переносит цепочку и References, добавляя Message-ID исходного письма.
+func (s *HTTPServer) setReplyTo(ctx context.Context, message *database.Message, replyTo int64) error {
+	original, err := s.repo.Messages.GetByID(ctx, message.Owner, replyTo)
+	if err != nil {
+		return err
+	}
+	message.ThreadID = original.ThreadID
+	message.References = append([]string(nil), original.References...)
+	if original.MessageID != "" {
+		message.References = append(message.References, original.MessageID)
+	}
+	return nil
+}
+
+// replyErrorResponse отвечает на ошибку setReplyTo.
+func replyErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, database.ErrMessageNotFound) {
+		ErrorResponseWithStatus(w, r, http.StatusNotFound, "message_not_found")
+		return
+	}
+	slog.Error("failed to get original message", "error", err)
+	ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+}
@@ -0,0 +1,91 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"mail/database"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestThreads(t *testing.T) {
+	s := newTestServer()
+	createTestUser(s, "jane", testUserEmail, "12345")
+	router := newTestRouter(s, testUserEmail)
+	inbox := systemFolderID(s, testUserEmail, database.FolderInbox)
+	date := time.Now().Add(-time.Hour)
+	first := seedMessage(s, database.Message{
+		Owner:     testUserEmail,
+		MessageID: "1@example.com",
+		From:      database.Address{Name: "John", Email: "john@example.com"},
+		Subject:   "Project status",
+		TextBody:  "How is it going?",
+		FolderID:  inbox,
+		Date:      date,
+	})
+	seedMessage(s, database.Message{
+		Owner:    testUserEmail,
+		From:     database.Address{Email: "news@example.com"},
+		Subject:  "Newsletter",
+		Flags:    []string{database.FlagSeen},
+		FolderID: inbox,
+		Date:     date.Add(-time.Hour),
+	})
+
+	rr := doJSON(t, router, "POST", "/mail/send", SendMailRequest{
+		ReplyTo:  first,
+		To:       []string{"john@example.com"},
+		Subject:  "Re: Project status",
+		TextBody: "All good",
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("send reply: got %v: %s", rr.Code, rr.Body.String())
+	}
+	reply := decodeMessage(t, rr.Body.Bytes())
+	if reply.ThreadID != first {
+		t.Errorf("reply got thread %d want %d", reply.ThreadID, first)
+	}
+	if original, _ := s.repo.Messages.GetByID(context.Background(), testUserEmail, first); !original.HasFlag(database.FlagAnswered) {
+		t.Errorf("original message not marked as answered: %q", original.Flags)
+	}
+	if rr := doJSON(t, router, "POST", "/mail/send", SendMailRequest{ReplyTo: 999, To: []string{"john@example.com"}}); rr.Code != http.StatusNotFound {
+		t.Errorf("reply to unknown: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	rr = doJSON(t, router, "GET", "/mail/threads", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list: got %v: %s", rr.Code, rr.Body.String())
+	}
+	var threads []ThreadJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &threads); err != nil {
+		t.Fatal(err)
+	}
+	if len(threads) != 2 {
+		t.Fatalf("got %d threads want 2: %s", len(threads), rr.Body.String())
+	}
+	if got := threads[0]; got.ID != first || got.Total != 2 || got.Unread != 1 || got.Snippet != "All good" ||
+		got.Subject != "Project status" || len(got.Participants) != 2 {
+		t.Errorf("unexpected thread: %+v", got)
+	}
+
+	rr = doJSON(t, router, "GET", "/mail/threads/"+strconv.FormatInt(first, 10), nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("get: got %v: %s", rr.Code, rr.Body.String())
+	}
+	var thread ThreadMessagesJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &thread); err != nil {
+		t.Fatal(err)
+	}
+	if len(thread.Messages) != 2 || thread.Messages[0].ID != first || thread.Messages[1].ID != reply.ID {
+		t.Errorf("unexpected thread messages: %+v", thread.Messages)
+	}
+
+	if rr := doJSON(t, router, "GET", "/mail/threads/999", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown thread: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := doJSON(t, router, "GET", "/mail/threads?folder=nope", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown folder: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
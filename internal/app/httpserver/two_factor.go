@@ -0,0 +1,370 @@
+package httpserver
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mail/database"
+	"mail/pkg/linktoken"
+	"mail/pkg/totp"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	loginTwoFactorPurpose = "login-2fa"
+	// loginTwoFactorTTL - сколько ждать код после правильного пароля.
+	loginTwoFactorTTL  = 5 * time.Minute
+	recoveryCodeCount  = 10
+	maxTwoFactorFailed = 5
+	twoFactorLockout   = 15 * time.Minute
+)
+
+type TwoFactorStatus struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+type TOTPSetupResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type PasswordRequest struct {
+	Password string `json:"password"`
+}
+
+type RecoveryCodesResponse struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// LoginTwoFactorRequest - второй шаг входа: токен из ответа /login и код
+// из приложения или код восстановления.
+type LoginTwoFactorRequest struct {
+	Token        string `json:"token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// attemptLimiter блокирует ключ на lockout после max неудачных попыток
+// подряд, например чтобы шесть цифр кода нельзя было перебрать.
+type attemptLimiter struct {
+	mu       sync.Mutex
+	max      int
+	lockout  time.Duration
+	failures map[string]int
+	lockedAt map[string]time.Time
+}
+
+func newAttemptLimiter(max int, lockout time.Duration) *attemptLimiter {
+	return &attemptLimiter{max: max, lockout: lockout, failures: make(map[string]int), lockedAt: make(map[string]time.Time)}
+}
+
+func (l *attemptLimiter) allowed(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lockedAt, ok := l.lockedAt[key]
+	if !ok {
+		return true
+	}
+	if now.Sub(lockedAt) < l.lockout {
+		return false
+	}
+	delete(l.lockedAt, key)
+	delete(l.failures, key)
+	return true
+}
+
+func (l *attemptLimiter) fail(key string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.failures[key]++
+	if l.failures[key] >= l.max {
+		l.lockedAt[key] = now
+	}
+}
+
+func (l *attemptLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, key)
+	delete(l.lockedAt, key)
+}
+
+// hashRecoveryCode хэширует код без учёта регистра и дефисов. Коды
+// случайные, поэтому медленный хэш паролей им не нужен.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateRecoveryCodes() ([]string, []string) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			panic(err)
+		}
+		code := hex.EncodeToString(raw)
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes
+}
+
+func (s *HTTPServer) getTwoFactor(w http.ResponseWriter, r *http.Request) {
+	owner := currentUser(r)
+	twoFactor, err := s.repo.TwoFactor.Get(r.Context(), owner)
+	if err != nil && !errors.Is(err, database.ErrTwoFactorNotFound) {
+		slog.Error("failed to get two-factor settings", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	left, err := s.repo.TwoFactor.CountRecoveryCodes(r.Context(), owner)
+	if err != nil {
+		slog.Error("failed to count recovery codes", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, TwoFactorStatus{Enabled: twoFactor.Enabled, RecoveryCodesLeft: left})
+}
+
+// setupTOTP создаёт новый секрет. 2FA включится, когда пользователь
+// подтвердит его кодом из приложения.
+func (s *HTTPServer) setupTOTP(w http.ResponseWriter, r *http.Request) {
+	owner := currentUser(r)
+	current, err := s.repo.TwoFactor.Get(r.Context(), owner)
+	if err != nil && !errors.Is(err, database.ErrTwoFactorNotFound) {
+		slog.Error("failed to get two-factor settings", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	if current.Enabled {
+		ErrorResponseWithStatus(w, r, http.StatusConflict, "two_factor_enabled")
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		slog.Error("failed to generate totp secret", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	if err := s.repo.TwoFactor.Save(r.Context(), database.TwoFactor{Email: owner, Secret: secret}); err != nil {
+		slog.Error("failed to save two-factor settings", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, TOTPSetupResponse{Secret: secret, URI: totp.URI(s.outbound.Hostname(), owner, secret)})
+}
+
+// enableTOTP включает 2FA по первому коду и выдаёт коды восстановления.
+func (s *HTTPServer) enableTOTP(w http.ResponseWriter, r *http.Request) {
+	var input TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	owner := currentUser(r)
+	twoFactor, err := s.repo.TwoFactor.Get(r.Context(), owner)
+	if errors.Is(err, database.ErrTwoFactorNotFound) {
+		ErrorResponseWithStatus(w, r, http.StatusConflict, "two_factor_not_set_up")
+		return
+	}
+	if err != nil {
+		slog.Error("failed to get two-factor settings", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	if twoFactor.Enabled {
+		ErrorResponseWithStatus(w, r, http.StatusConflict, "two_factor_enabled")
+		return
+	}
+	step, ok := totp.Validate(twoFactor.Secret, input.Code, time.Now())
+	if !ok {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_code")
+		return
+	}
+
+	codes, hashes := generateRecoveryCodes()
+	if err := s.repo.TwoFactor.SetRecoveryCodes(r.Context(), owner, hashes); err != nil {
+		slog.Error("failed to save recovery codes", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	twoFactor.Enabled, twoFactor.LastStep = true, step
+	if err := s.repo.TwoFactor.Save(r.Context(), twoFactor); err != nil {
+		slog.Error("failed to save two-factor settings", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, RecoveryCodesResponse{Codes: codes})
+}
+
+// disableTwoFactor отключает 2FA после проверки пароля.
+func (s *HTTPServer) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if !s.checkPassword(w, r) {
+		return
+	}
+	if err := s.repo.TwoFactor.Delete(r.Context(), currentUser(r)); err != nil {
+		slog.Error("failed to delete two-factor settings", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// regenerateRecoveryCodes заменяет коды восстановления, старые перестают
+// действовать.
+func (s *HTTPServer) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if !s.checkPassword(w, r) {
+		return
+	}
+	owner := currentUser(r)
+	twoFactor, err := s.repo.TwoFactor.Get(r.Context(), owner)
+	if err != nil && !errors.Is(err, database.ErrTwoFactorNotFound) {
+		slog.Error("failed to get two-factor settings", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	if !twoFactor.Enabled {
+		ErrorResponseWithStatus(w, r, http.StatusConflict, "two_factor_disabled")
+		return
+	}
+	codes, hashes := generateRecoveryCodes()
+	if err := s.repo.TwoFactor.SetRecoveryCodes(r.Context(), owner, hashes); err != nil {
+		slog.Error("failed to save recovery codes", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, RecoveryCodesResponse{Codes: codes})
+}
+
+// checkPassword сверяет пароль из тела запроса с паролем текущего
+// пользователя. При ошибке пишет ответ и возвращает false.
+func (s *HTTPServer) checkPassword(w http.ResponseWriter, r *http.Request) bool {
+	var input PasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return false
+	}
+	user, err := s.repo.Users.GetByEmail(r.Context(), currentUser(r))
+	if err != nil {
+		slog.Error("failed to get user", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return false
+	}
+	ok, _, err := s.passwords.Verify(input.Password, user.Password)
+	if err != nil {
+		slog.Error("failed to verify password", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return false
+	}
+	if !ok {
+		ErrorResponse(w, r, "invalid_password")
+		return false
+	}
+	return true
+}
+
+// loginUser проверяет токен первого шага входа и возвращает пользователя.
+// При ошибке пишет ответ и возвращает false.
+func (s *HTTPServer) loginUser(w http.ResponseWriter, r *http.Request, purpose string, token string) (database.User, bool) {
+	subject, err := s.links.Verify(purpose, token, time.Now())
+	if errors.Is(err, linktoken.ErrExpired) {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "token_expired")
+		return database.User{}, false
+	}
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_token")
+		return database.User{}, false
+	}
+	return s.fingerprintUser(w, r, subject)
+}
+
+// fingerprintUser находит пользователя по subject из fingerprintSubject.
+// Если пароль с тех пор сменился, отвечает invalid_token.
+func (s *HTTPServer) fingerprintUser(w http.ResponseWriter, r *http.Request, subject string) (database.User, bool) {
+	email, fingerprint, ok := splitFingerprint(subject)
+	if !ok {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_token")
+		return database.User{}, false
+	}
+	user, err := s.repo.Users.GetByEmail(r.Context(), email)
+	if errors.Is(err, database.ErrUserNotFound) {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_token")
+		return database.User{}, false
+	}
+	if err != nil {
+		slog.Error("failed to get user", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return database.User{}, false
+	}
+	if fingerprint != passwordFingerprint(user.Password) {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_token")
+		return database.User{}, false
+	}
+	return user, true
+}
+
+// loginTwoFactor - второй шаг входа с включённой 2FA. Сессия создаётся
+// только после правильного кода.
+func (s *HTTPServer) loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var input LoginTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	user, ok := s.loginUser(w, r, loginTwoFactorPurpose, input.Token)
+	if !ok {
+		return
+	}
+	email := user.Email
+
+	now := time.Now()
+	if !s.twoFactorAttempts.allowed(email, now) {
+		ErrorResponseWithStatus(w, r, http.StatusTooManyRequests, "too_many_attempts")
+		return
+	}
+	twoFactor, err := s.repo.TwoFactor.Get(r.Context(), email)
+	if errors.Is(err, database.ErrTwoFactorNotFound) {
+		err = nil
+	}
+	if err != nil {
+		slog.Error("failed to get two-factor settings", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	// без включённого TOTP код не подходит: вход идёт через ключ доступа
+	valid := false
+	if twoFactor.Enabled {
+		if input.RecoveryCode != "" {
+			valid, err = s.repo.TwoFactor.UseRecoveryCode(r.Context(), email, hashRecoveryCode(input.RecoveryCode))
+		} else if step, ok := totp.Validate(twoFactor.Secret, input.Code, now); ok {
+			valid, err = s.repo.TwoFactor.UseStep(r.Context(), email, step)
+		}
+	}
+	if err != nil {
+		slog.Error("failed to check two-factor code", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	if !valid {
+		s.twoFactorAttempts.fail(email, now)
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_code")
+		return
+	}
+	s.twoFactorAttempts.reset(email)
+	s.startSession(w, r, email)
+}
@@ -0,0 +1,152 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"mail/pkg/totp"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loginFirstStep входит по паролю и возвращает токен второго шага.
+func loginFirstStep(t *testing.T, router http.Handler, password string) string {
+	t.Helper()
+	rr := doJSON(t, router, "POST", "/login", UserLogin{Email: testUserEmail, Password: password})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("login: %v %s", rr.Code, rr.Body.String())
+	}
+	var response LoginResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if !response.TwoFactorRequired || response.Token == "" {
+		t.Fatalf("got %s", rr.Body.String())
+	}
+	if hasSessionCookie(rr) {
+		t.Fatal("session issued before the second step")
+	}
+	return response.Token
+}
+
+func hasSessionCookie(rr *httptest.ResponseRecorder) bool {
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == "session" && cookie.Value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTwoFactor(t *testing.T) {
+	s := newTestServer()
+	createTestUser(s, "nick", testUserEmail, "12345")
+	router := newTestRouter(s, testUserEmail)
+
+	rr := doJSON(t, router, "POST", "/2fa/totp/setup", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("setup: %v %s", rr.Code, rr.Body.String())
+	}
+	var setup TOTPSetupResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &setup); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(setup.URI, "otpauth://totp/") || !strings.Contains(setup.URI, setup.Secret) {
+		t.Errorf("uri %q", setup.URI)
+	}
+	if rr := doJSON(t, router, "POST", "/2fa/totp/enable", TOTPCodeRequest{Code: "000000"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong code: %v", rr.Code)
+	}
+	// до включения вход работает по паролю
+	if rr := doJSON(t, router, "POST", "/login", UserLogin{Email: testUserEmail, Password: "12345"}); !hasSessionCookie(rr) {
+		t.Error("no session before 2fa is enabled")
+	}
+
+	now := time.Now()
+	code, _ := totp.Code(setup.Secret, totp.Step(now)-1)
+	rr = doJSON(t, router, "POST", "/2fa/totp/enable", TOTPCodeRequest{Code: code})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("enable: %v %s", rr.Code, rr.Body.String())
+	}
+	var recovery RecoveryCodesResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &recovery); err != nil || len(recovery.Codes) != recoveryCodeCount {
+		t.Fatalf("got %s, %v", rr.Body.String(), err)
+	}
+	if rr := doJSON(t, router, "POST", "/2fa/totp/setup", nil); rr.Code != http.StatusConflict {
+		t.Errorf("setup when enabled: %v", rr.Code)
+	}
+
+	// код, которым включали 2FA, повторно не принимается
+	token := loginFirstStep(t, router, "12345")
+	rr = doJSON(t, router, "POST", "/login/2fa", LoginTwoFactorRequest{Token: token, Code: code})
+	if rr.Code != http.StatusBadRequest || hasSessionCookie(rr) {
+		t.Errorf("replayed code: %v", rr.Code)
+	}
+	code, _ = totp.Code(setup.Secret, totp.Step(now))
+	rr = doJSON(t, router, "POST", "/login/2fa", LoginTwoFactorRequest{Token: token, Code: code})
+	if rr.Code != http.StatusOK || !hasSessionCookie(rr) {
+		t.Fatalf("login with code: %v %s", rr.Code, rr.Body.String())
+	}
+
+	rr = doJSON(t, router, "POST", "/login/2fa", LoginTwoFactorRequest{Token: token, RecoveryCode: strings.ToUpper(recovery.Codes[0])})
+	if rr.Code != http.StatusOK || !hasSessionCookie(rr) {
+		t.Fatalf("login with recovery code: %v %s", rr.Code, rr.Body.String())
+	}
+	if rr := doJSON(t, router, "POST", "/login/2fa", LoginTwoFactorRequest{Token: token, RecoveryCode: recovery.Codes[0]}); rr.Code != http.StatusBadRequest {
+		t.Errorf("reused recovery code: %v", rr.Code)
+	}
+	rr = doJSON(t, router, "GET", "/2fa", nil)
+	var status TwoFactorStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil || !status.Enabled || status.RecoveryCodesLeft != recoveryCodeCount-1 {
+		t.Errorf("status %s, %v", rr.Body.String(), err)
+	}
+
+	if rr := doJSON(t, router, "POST", "/2fa/recovery-codes", PasswordRequest{Password: "wrong"}); rr.Code != http.StatusForbidden {
+		t.Errorf("regenerate with wrong password: %v", rr.Code)
+	}
+	rr = doJSON(t, router, "POST", "/2fa/recovery-codes", PasswordRequest{Password: "12345"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("regenerate: %v", rr.Code)
+	}
+	if rr := doJSON(t, router, "POST", "/login/2fa", LoginTwoFactorRequest{Token: token, RecoveryCode: recovery.Codes[1]}); rr.Code != http.StatusBadRequest {
+		t.Errorf("old recovery code after regenerate: %v", rr.Code)
+	}
+
+	if rr := doJSON(t, router, "POST", "/2fa/disable", PasswordRequest{Password: "12345"}); rr.Code != http.StatusOK {
+		t.Fatalf("disable: %v", rr.Code)
+	}
+	if rr := doJSON(t, router, "POST", "/login", UserLogin{Email: testUserEmail, Password: "12345"}); !hasSessionCookie(rr) {
+		t.Error("no session after 2fa is disabled")
+	}
+}
+
+func TestTwoFactorLockout(t *testing.T) {
+	s := newTestServer()
+	createTestUser(s, "nick", testUserEmail, "12345")
+	router := newTestRouter(s, testUserEmail)
+	doJSON(t, router, "POST", "/2fa/totp/setup", nil)
+	twoFactor, _ := s.repo.TwoFactor.Get(context.Background(), testUserEmail)
+	code, _ := totp.Code(twoFactor.Secret, totp.Step(time.Now()))
+	if rr := doJSON(t, router, "POST", "/2fa/totp/enable", TOTPCodeRequest{Code: code}); rr.Code != http.StatusOK {
+		t.Fatalf("enable: %v", rr.Code)
+	}
+
+	token := loginFirstStep(t, router, "12345")
+	for i := 0; i < maxTwoFactorFailed; i++ {
+		doJSON(t, router, "POST", "/login/2fa", LoginTwoFactorRequest{Token: token, RecoveryCode: "00000-00000"})
+	}
+	rr := doJSON(t, router, "POST", "/login/2fa", LoginTwoFactorRequest{Token: token, RecoveryCode: "00000-00000"})
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("got %v want %v", rr.Code, http.StatusTooManyRequests)
+	}
+
+	// после смены пароля токен первого шага недействителен
+	s.twoFactorAttempts.reset(testUserEmail)
+	doJSON(t, router, "POST", "/password/change", ChangePasswordRequest{OldPassword: "12345", Password: "new", RePassword: "new"})
+	rr = doJSON(t, router, "POST", "/login/2fa", LoginTwoFactorRequest{Token: token, Code: code})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid_token") {
+		t.Errorf("token after password change: %v %s", rr.Code, rr.Body.String())
+	}
+}
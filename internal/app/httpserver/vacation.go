@@ -0,0 +1,104 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"log/slog"
+	"mail/database"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxVacationSubjectLength = 255
+	maxVacationBodyLength    = 10000
+	maxVacationDays          = 30
+)
+
+// VacationJSON - настройки автоответа. Start и End необязательны,
+// Days - через сколько дней можно снова ответить тому же отправителю.
+type VacationJSON struct {
+	Enabled bool       `json:"enabled"`
+	Subject string     `json:"subject"`
+	Body    string     `json:"body"`
+	Start   *time.Time `json:"start"`
+	End     *time.Time `json:"end"`
+	Days    int        `json:"days"`
+}
+
+func toVacationJSON(vacation database.Vacation) VacationJSON {
+	result := VacationJSON{
+		Enabled: vacation.Enabled,
+		Subject: vacation.Subject,
+		Body:    vacation.Body,
+		Days:    vacation.Days,
+	}
+	if !vacation.Start.IsZero() {
+		result.Start = &vacation.Start
+	}
+	if !vacation.End.IsZero() {
+		result.End = &vacation.End
+	}
+	return result
+}
+
+func (s *HTTPServer) getVacation(w http.ResponseWriter, r *http.Request) {
+	vacation, err := s.repo.Vacation.Get(r.Context(), currentUser(r))
+	if err != nil {
+		slog.Error("failed to get vacation", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, toVacationJSON(vacation))
+}
+
+func (s *HTTPServer) updateVacation(w http.ResponseWriter, r *http.Request) {
+	var input VacationJSON
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	vacation := database.Vacation{
+		Owner:   currentUser(r),
+		Enabled: input.Enabled,
+		Subject: strings.TrimSpace(input.Subject),
+		Body:    input.Body,
+		Days:    input.Days,
+	}
+	if vacation.Days == 0 {
+		vacation.Days = database.DefaultVacationDays
+	}
+	if input.Start != nil {
+		vacation.Start = input.Start.UTC()
+	}
+	if input.End != nil {
+		vacation.End = input.End.UTC()
+	}
+	if !vacationIsValid(vacation) {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+	if err := s.repo.Vacation.Save(r.Context(), vacation); err != nil {
+		slog.Error("failed to save vacation", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, toVacationJSON(vacation))
+}
+
+// vacationIsValid требует текст у включённого автоответа, а в теме не
+// допускает переводов строк, чтобы её нельзя было превратить в заголовки.
+func vacationIsValid(v database.Vacation) bool {
+	if v.Enabled && strings.TrimSpace(v.Body) == "" {
+		return false
+	}
+	if strings.ContainsAny(v.Subject, "\r\n") || utf8.RuneCountInString(v.Subject) > maxVacationSubjectLength ||
+		utf8.RuneCountInString(v.Body) > maxVacationBodyLength {
+		return false
+	}
+	if v.Days < 1 || v.Days > maxVacationDays {
+		return false
+	}
+	return v.Start.IsZero() || v.End.IsZero() || v.End.After(v.Start)
+}
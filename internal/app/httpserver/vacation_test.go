@@ -0,0 +1,55 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"mail/database"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestVacation(t *testing.T) {
+	s := newTestServer()
+	router := newTestRouter(s, testUserEmail)
+
+	rr := doJSON(t, router, "GET", "/mail/vacation", nil)
+	var vacation VacationJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &vacation); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusOK || vacation.Enabled || vacation.Days != database.DefaultVacationDays {
+		t.Errorf("unexpected default vacation: %v %+v", rr.Code, vacation)
+	}
+
+	start := time.Date(2024, 12, 20, 0, 0, 0, 0, time.UTC)
+	end := start.Add(14 * 24 * time.Hour)
+	rr = doJSON(t, router, "PUT", "/mail/vacation", VacationJSON{
+		Enabled: true,
+		Subject: " В отпуске ",
+		Body:    "Вернусь после праздников.",
+		Start:   &start,
+		End:     &end,
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %v: %s", rr.Code, rr.Body.String())
+	}
+	stored, _ := s.repo.Vacation.Get(context.Background(), testUserEmail)
+	if !stored.Enabled || stored.Subject != "В отпуске" || !stored.End.Equal(end) || stored.Days != database.DefaultVacationDays {
+		t.Errorf("unexpected stored vacation: %+v", stored)
+	}
+	if !stored.Active(start.Add(time.Hour)) || stored.Active(end) {
+		t.Errorf("unexpected vacation period: %+v", stored)
+	}
+
+	for _, input := range []VacationJSON{
+		{Enabled: true},
+		{Body: "x", Subject: "a\r\nBcc: x@example.com"},
+		{Body: "x", Days: 31},
+		{Body: "x", Start: &end, End: &start},
+	} {
+		if rr := doJSON(t, router, "PUT", "/mail/vacation", input); rr.Code != http.StatusBadRequest {
+			t.Errorf("%+v: got %v want %v", input, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mail/database"
+	"mail/pkg/linktoken"
+	"mail/pkg/mimemsg"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// verifyEmailPurpose отделяет токены подтверждения адреса от других
+// подписанных ссылок.
+const verifyEmailPurpose = "verify-email"
+
+const defaultVerifyTTL = 48 * time.Hour
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type SignUpResponse struct {
+	Pending   bool   `json:"pending"`
+	CSRFToken string `json:"csrf_token"`
+}
+
+// verifyEmail подтверждает адрес по токену из ссылки. Повторное
+// подтверждение ничего не меняет.
+func (s *HTTPServer) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	var input VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	email, err := s.links.Verify(verifyEmailPurpose, input.Token, time.Now())
+	if errors.Is(err, linktoken.ErrExpired) {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "token_expired")
+		return
+	}
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_token")
+		return
+	}
+
+	user, err := s.repo.Users.GetByEmail(r.Context(), email)
+	if errors.Is(err, database.ErrUserNotFound) {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_token")
+		return
+	}
+	if err != nil {
+		slog.Error("failed to get user", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	if user.Pending {
+		user.Pending = false
+		if err := s.repo.Users.Update(r.Context(), user); err != nil {
+			slog.Error("failed to update user", "error", err)
+			ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// resendVerification повторно отправляет ссылку, например если прежняя
+// истекла.
+func (s *HTTPServer) resendVerification(w http.ResponseWriter, r *http.Request) {
+	user, err := s.repo.Users.GetByEmail(r.Context(), currentUser(r))
+	if err != nil {
+		slog.Error("failed to get user", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	if !user.Pending {
+		ErrorResponseWithStatus(w, r, http.StatusConflict, "already_verified")
+		return
+	}
+	if err := s.sendVerification(r.Context(), user); err != nil {
+		slog.Error("failed to send verification email", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// sendVerification ставит в очередь письмо со ссылкой подтверждения.
+func (s *HTTPServer) sendVerification(ctx context.Context, user database.User) error {
+	ttl := s.account.VerifyTTL
+	if ttl <= 0 {
+		ttl = defaultVerifyTTL
+	}
+	expires := time.Now().Add(ttl)
+	token := s.links.Sign(verifyEmailPurpose, user.Email, expires)
+	return s.sendAccountMail(ctx, user, "Подтвердите адрес электронной почты",
+		"Чтобы завершить регистрацию, перейдите по ссылке:\n"+
+			s.account.VerifyURL+url.QueryEscape(token)+"\n\n"+
+			"Ссылка действует до "+expires.Format("02.01.2006 15:04 MST")+".\n"+
+			"Если вы не регистрировались, просто удалите это письмо.\n")
+}
+
+// sendAccountMail ставит в очередь служебное письмо пользователю.
+// Отправитель пустой, чтобы недоставка не порождала отчёт в ящике.
+func (s *HTTPServer) sendAccountMail(ctx context.Context, user database.User, subject string, text string) error {
+	hostname := s.outbound.Hostname()
+	return s.outbound.Enqueue(ctx, database.Message{
+		MessageID: mimemsg.GenerateMessageID(hostname),
+		From:      database.Address{Email: "noreply@" + hostname},
+		To:        []database.Address{{Name: user.Name, Email: user.Email}},
+		Subject:   subject,
+		TextBody:  "Здравствуйте, " + user.Name + "!\n\n" + text,
+		Date:      time.Now(),
+	})
+}
+
+// verifiedOnly закрывает ящик для неподтверждённых аккаунтов, включая
+// чтение: ссылка на локальный адрес приходит в этот же ящик, и иначе
+// аккаунт подтвердил бы сам себя.
+func (s *HTTPServer) verifiedOnly() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			user, err := s.repo.Users.GetByEmail(r.Context(), currentUser(r))
+			if err != nil {
+				slog.Error("failed to get user", "error", err)
+				ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+				return
+			}
+			if user.Pending {
+				ErrorResponseWithStatus(w, r, http.StatusForbidden, "email_not_verified")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"mail/config"
+	"mail/database"
+	"mail/pkg/mimemsg"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testVerifyURL = "https://giga-mail.ru/verify?token="
+	testResetURL  = "https://giga-mail.ru/reset?token="
+)
+
+// newAccountRouter - как newTestRouter, но с настройками аккаунтов.
+func newAccountRouter(s *HTTPServer, email string, verifyEmail bool) http.Handler {
+	cfg := new(config.Config)
+	cfg.HTTPServer.AllowedIPsByCORS = []string{"http://localhost:4201"}
+	cfg.Account = config.AccountConfig{VerifyEmail: verifyEmail, Secret: testSecret, VerifyURL: testVerifyURL, ResetURL: testResetURL}
+	s.server = new(http.Server)
+	s.configureRouter(cfg)
+	createTestSession(s, testUserID, email)
+	return s.server.Handler
+}
+
+// queuedToken достаёт токен из единственного письма в очереди со ссылкой
+// link.
+func queuedToken(t *testing.T, s *HTTPServer, email string, link string) string {
+	t.Helper()
+	queued, err := s.repo.Outbound.ClaimDue(context.Background(), time.Now().Add(time.Hour), time.Minute, 10)
+	if err != nil || len(queued) != 1 || queued[0].Recipients[0] != email || queued[0].Sender != "" {
+		t.Fatalf("queued %+v, %v", queued, err)
+	}
+	message, _, err := mimemsg.Parse(queued[0].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, rest, ok := strings.Cut(message.TextBody, link)
+	if !ok {
+		t.Fatalf("no link in %q", message.TextBody)
+	}
+	token, err := url.QueryUnescape(strings.Fields(rest)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestVerifyEmail(t *testing.T) {
+	s := newTestServer()
+	router := newAccountRouter(s, testUserEmail, true)
+
+	rr := doJSON(t, router, "POST", "/signup", UserJSON{Name: "nick", Email: testUserEmail, Password: "12345", RePassword: "12345"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("signup: %v %s", rr.Code, rr.Body.String())
+	}
+	var signup SignUpResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &signup); err != nil || !signup.Pending {
+		t.Fatalf("got %s, %v", rr.Body.String(), err)
+	}
+	token := queuedToken(t, s, testUserEmail, testVerifyURL)
+	createTestSession(s, testUserID, testUserEmail)
+
+	// до подтверждения ящик закрыт
+	for _, method := range []string{"GET", "POST"} {
+		rr = doJSON(t, router, method, "/mail/folders", FolderRequest{Name: "Work"})
+		if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "email_not_verified") {
+			t.Errorf("%s folders: %v %s", method, rr.Code, rr.Body.String())
+		}
+	}
+
+	if rr := doJSON(t, router, "POST", "/verify-email/resend", nil); rr.Code != http.StatusOK {
+		t.Errorf("resend: %v", rr.Code)
+	}
+	if resent := queuedToken(t, s, testUserEmail, testVerifyURL); resent == "" {
+		t.Error("empty resent token")
+	}
+
+	expired := s.links.Sign(verifyEmailPurpose, testUserEmail, time.Now().Add(-time.Minute))
+	rr = doJSON(t, router, "POST", "/verify-email", VerifyEmailRequest{Token: expired})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "token_expired") {
+		t.Errorf("expired token: %v %s", rr.Code, rr.Body.String())
+	}
+	rr = doJSON(t, router, "POST", "/verify-email", VerifyEmailRequest{Token: token + "x"})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid_token") {
+		t.Errorf("invalid token: %v %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := doJSON(t, router, "POST", "/verify-email", VerifyEmailRequest{Token: token}); rr.Code != http.StatusOK {
+		t.Fatalf("verify: %v %s", rr.Code, rr.Body.String())
+	}
+	if user, _ := s.repo.Users.GetByEmail(context.Background(), testUserEmail); user.Pending {
+		t.Error("user is still pending")
+	}
+	if rr := doJSON(t, router, "POST", "/verify-email", VerifyEmailRequest{Token: token}); rr.Code != http.StatusOK {
+		t.Errorf("repeated verify: %v", rr.Code)
+	}
+	if rr := doJSON(t, router, "POST", "/mail/folders", FolderRequest{Name: "Work"}); rr.Code != http.StatusOK {
+		t.Errorf("create folder after verify: %v %s", rr.Code, rr.Body.String())
+	}
+	if rr := doJSON(t, router, "POST", "/verify-email/resend", nil); rr.Code != http.StatusConflict {
+		t.Errorf("resend after verify: %v", rr.Code)
+	}
+}
+
+func TestVerifyLocalAddress(t *testing.T) {
+	s := newTestServer()
+	router := newAccountRouter(s, testUserEmail, true)
+	if rr := doJSON(t, router, "POST", "/signup", UserJSON{Name: "nick", Email: testUserEmail, Password: "12345", RePassword: "12345"}); rr.Code != http.StatusOK {
+		t.Fatalf("signup: %v %s", rr.Code, rr.Body.String())
+	}
+	token := queuedToken(t, s, testUserEmail, testVerifyURL)
+	createTestSession(s, testUserID, testUserEmail)
+
+	// письмо со ссылкой на локальный адрес доставляется в этот же ящик
+	id := seedMessage(s, database.Message{
+		Owner:    testUserEmail,
+		FolderID: systemFolderID(s, testUserEmail, database.FolderInbox),
+		Subject:  "Подтвердите адрес электронной почты",
+		TextBody: testVerifyURL + url.QueryEscape(token),
+	})
+	for _, path := range []string{"/mail/inbox", "/mail/messages/" + strconv.FormatInt(id, 10), "/mail/search?q=verify"} {
+		rr := doJSON(t, router, "GET", path, nil)
+		if rr.Code != http.StatusForbidden || strings.Contains(rr.Body.String(), "token=") {
+			t.Errorf("%s: %v %s", path, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestSignUpWithoutVerification(t *testing.T) {
+	s := newTestServer()
+	router := newTestRouter(s, testUserEmail)
+	rr := doJSON(t, router, "POST", "/signup", UserJSON{Name: "nick", Email: testUserEmail, Password: "12345", RePassword: "12345"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("signup: %v", rr.Code)
+	}
+	if user, _ := s.repo.Users.GetByEmail(context.Background(), testUserEmail); user.Pending {
+		t.Error("user is pending with verification disabled")
+	}
+	if queued, _ := s.repo.Outbound.ClaimDue(context.Background(), time.Now().Add(time.Hour), time.Minute, 10); len(queued) != 0 {
+		t.Errorf("queued %d messages", len(queued))
+	}
+}
@@ -0,0 +1,569 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mail/config"
+	"mail/database"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gorilla/mux"
+)
+
+// Назначения подписанного состояния церемоний WebAuthn.
+const (
+	webauthnRegisterPurpose = "webauthn-register"
+	webauthnLoginPurpose    = "webauthn-login"
+	webauthn2FAPurpose      = "webauthn-2fa"
+	webauthnCeremonyTTL     = 5 * time.Minute
+	maxCredentialName       = 64
+)
+
+var errCredentialCloned = errors.New("authenticator signature counter went backwards")
+
+// WebAuthnOptions - параметры для navigator.credentials.create() или
+// get() и подписанное состояние церемонии, которое нужно вернуть вместе
+// с ответом ключа.
+type WebAuthnOptions struct {
+	Options  interface{} `json:"options"`
+	Ceremony string      `json:"ceremony"`
+}
+
+type WebAuthnBeginRequest struct {
+	Token string `json:"token"` // токен из ответа /login, только для второго фактора
+}
+
+// WebAuthnFinishRequest - ответ ключа (PublicKeyCredential в JSON) и
+// состояние церемонии из begin.
+type WebAuthnFinishRequest struct {
+	Ceremony   string          `json:"ceremony"`
+	Name       string          `json:"name"` // подпись ключа при регистрации
+	Credential json.RawMessage `json:"credential"`
+}
+
+type CredentialJSON struct {
+	ID         string     `json:"id"` // base64url, как rawId в браузере
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// ceremony - состояние церемонии между begin и finish.
+type ceremony struct {
+	Subject string
+	Session webauthn.SessionData
+}
+
+// ceremonyToken - подписанная часть церемонии, которую клиент
+// возвращает в finish. Само состояние хранится на сервере.
+type ceremonyToken struct {
+	Subject   string `json:"subject"`
+	Challenge string `json:"challenge"`
+}
+
+type pendingCeremony struct {
+	session webauthn.SessionData
+	expires time.Time
+}
+
+// ceremonyStore хранит начатые церемонии до finish. Церемония забирается
+// из хранилища один раз, поэтому повторно отправленный ответ ключа
+// с тем же challenge не примется.
+type ceremonyStore struct {
+	mu      sync.Mutex
+	pending map[string]pendingCeremony
+}
+
+func newCeremonyStore() *ceremonyStore {
+	return &ceremonyStore{pending: make(map[string]pendingCeremony)}
+}
+
+// put запоминает церемонию, заменяя прежнюю с тем же ключом, и удаляет
+// истёкшие.
+func (c *ceremonyStore) put(key string, session webauthn.SessionData, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, p := range c.pending {
+		if !now.Before(p.expires) {
+			delete(c.pending, k)
+		}
+	}
+	c.pending[key] = pendingCeremony{session: session, expires: now.Add(webauthnCeremonyTTL)}
+}
+
+// take возвращает и удаляет церемонию с ключом key, если её challenge
+// совпадает и срок не истёк.
+func (c *ceremonyStore) take(key string, challenge string, now time.Time) (webauthn.SessionData, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.pending[key]
+	if !ok || p.session.Challenge != challenge {
+		return webauthn.SessionData{}, false
+	}
+	delete(c.pending, key)
+	return p.session, now.Before(p.expires)
+}
+
+// ceremonyKey - ключ церемонии: пользователь и назначение. У входа без
+// пароля пользователь заранее неизвестен, вместо него берётся challenge.
+func ceremonyKey(purpose string, subject string, challenge string) string {
+	if subject == "" {
+		return purpose + ":" + challenge
+	}
+	return purpose + ":" + subject
+}
+
+// webauthnUser - пользователь с ключами в виде, который ждёт библиотека.
+type webauthnUser struct {
+	user        database.User
+	handle      []byte
+	credentials []webauthn.Credential
+}
+
+func (u *webauthnUser) WebAuthnID() []byte                         { return u.handle }
+func (u *webauthnUser) WebAuthnName() string                       { return u.user.Email }
+func (u *webauthnUser) WebAuthnDisplayName() string                { return u.user.Name }
+func (u *webauthnUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+func newWebAuthn(cfg config.WebAuthnConfig) *webauthn.WebAuthn {
+	if cfg.RPID == "" {
+		return nil
+	}
+	rpName := cfg.RPName
+	if rpName == "" {
+		rpName = cfg.RPID
+	}
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: rpName,
+		RPOrigins:     cfg.Origins,
+	})
+	if err != nil {
+		slog.Error("webauthn is disabled", "error", err)
+		return nil
+	}
+	return w
+}
+
+func toCredentialJSON(credential database.WebAuthnCredential) CredentialJSON {
+	result := CredentialJSON{
+		ID:        base64.RawURLEncoding.EncodeToString(credential.ID),
+		Name:      credential.Name,
+		CreatedAt: credential.CreatedAt,
+	}
+	if !credential.LastUsedAt.IsZero() {
+		result.LastUsedAt = &credential.LastUsedAt
+	}
+	return result
+}
+
+// loadWebAuthnUser собирает пользователя с его ключами. Если ключей нет,
+// создаётся новый случайный user handle.
+func (s *HTTPServer) loadWebAuthnUser(ctx context.Context, user database.User) (*webauthnUser, []database.WebAuthnCredential, error) {
+	stored, err := s.repo.WebAuthn.List(ctx, user.Email)
+	if err != nil {
+		return nil, nil, err
+	}
+	result := &webauthnUser{user: user}
+	for _, credential := range stored {
+		var data webauthn.Credential
+		if err := json.Unmarshal(credential.Data, &data); err != nil {
+			return nil, nil, err
+		}
+		result.handle = credential.UserHandle
+		result.credentials = append(result.credentials, data)
+	}
+	if result.handle == nil {
+		result.handle = make([]byte, 32)
+		if _, err := rand.Read(result.handle); err != nil {
+			return nil, nil, err
+		}
+	}
+	return result, stored, nil
+}
+
+// signCeremony сохраняет состояние церемонии и выдаёт клиенту токен,
+// по которому finish его найдёт.
+func (s *HTTPServer) signCeremony(purpose string, subject string, session *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(ceremonyToken{Subject: subject, Challenge: session.Challenge})
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	s.ceremonies.put(ceremonyKey(purpose, subject, session.Challenge), *session, now)
+	return s.links.Sign(purpose, string(data), now.Add(webauthnCeremonyTTL)), nil
+}
+
+// verifyCeremony проверяет токен и забирает состояние церемонии:
+// второй finish с тем же токеном получит false.
+func (s *HTTPServer) verifyCeremony(purpose string, token string) (ceremony, bool) {
+	now := time.Now()
+	data, err := s.links.Verify(purpose, token, now)
+	if err != nil {
+		return ceremony{}, false
+	}
+	var signed ceremonyToken
+	if err := json.Unmarshal([]byte(data), &signed); err != nil {
+		return ceremony{}, false
+	}
+	session, ok := s.ceremonies.take(ceremonyKey(purpose, signed.Subject, signed.Challenge), signed.Challenge, now)
+	if !ok {
+		return ceremony{}, false
+	}
+	return ceremony{Subject: signed.Subject, Session: session}, true
+}
+
+// saveAssertion сохраняет счётчик подписей после входа. Счётчик, который
+// не вырос, означает копию ключа: такой вход отклоняется.
+func (s *HTTPServer) saveAssertion(ctx context.Context, email string, credential *webauthn.Credential) error {
+	if credential.Authenticator.CloneWarning {
+		return errCredentialCloned
+	}
+	stored, err := s.repo.WebAuthn.Get(ctx, credential.ID)
+	if err != nil {
+		return err
+	}
+	if stored.Email != email {
+		return database.ErrCredentialNotFound
+	}
+	if stored.Data, err = json.Marshal(credential); err != nil {
+		return err
+	}
+	stored.LastUsedAt = time.Now()
+	return s.repo.WebAuthn.Update(ctx, stored)
+}
+
+// webauthnEnabled отвечает 404, если WebAuthn не настроен.
+func (s *HTTPServer) webauthnEnabled(w http.ResponseWriter, r *http.Request) bool {
+	if s.webauthn == nil {
+		ErrorResponseWithStatus(w, r, http.StatusNotFound, "webauthn_disabled")
+		return false
+	}
+	return true
+}
+
+func (s *HTTPServer) listCredentials(w http.ResponseWriter, r *http.Request) {
+	credentials, err := s.repo.WebAuthn.List(r.Context(), currentUser(r))
+	if err != nil {
+		slog.Error("failed to list webauthn credentials", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	result := make([]CredentialJSON, 0, len(credentials))
+	for _, credential := range credentials {
+		result = append(result, toCredentialJSON(credential))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// deleteCredential удаляет ключ после проверки текущего пароля.
+func (s *HTTPServer) deleteCredential(w http.ResponseWriter, r *http.Request) {
+	if !s.checkPassword(w, r) {
+		return
+	}
+	id, err := base64.RawURLEncoding.DecodeString(mux.Vars(r)["id"])
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusNotFound, "credential_not_found")
+		return
+	}
+	err = s.repo.WebAuthn.Delete(r.Context(), currentUser(r), id)
+	if errors.Is(err, database.ErrCredentialNotFound) {
+		ErrorResponseWithStatus(w, r, http.StatusNotFound, "credential_not_found")
+		return
+	}
+	if err != nil {
+		slog.Error("failed to delete webauthn credential", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// beginRegistration начинает добавление ключа после проверки текущего
+// пароля. Уже добавленные ключи исключаются, чтобы один ключ не
+// зарегистрировали дважды.
+func (s *HTTPServer) beginRegistration(w http.ResponseWriter, r *http.Request) {
+	if !s.webauthnEnabled(w, r) || !s.checkPassword(w, r) {
+		return
+	}
+	user, err := s.repo.Users.GetByEmail(r.Context(), currentUser(r))
+	if err != nil {
+		slog.Error("failed to get user", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	wuser, _, err := s.loadWebAuthnUser(r.Context(), user)
+	if err != nil {
+		slog.Error("failed to load webauthn credentials", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(wuser.credentials))
+	for _, credential := range wuser.credentials {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+	options, session, err := s.webauthn.BeginRegistration(wuser,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred))
+	if err != nil {
+		slog.Error("failed to begin webauthn registration", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	token, err := s.signCeremony(webauthnRegisterPurpose, user.Email, session)
+	if err != nil {
+		slog.Error("failed to sign webauthn ceremony", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, WebAuthnOptions{Options: options, Ceremony: token})
+}
+
+func (s *HTTPServer) finishRegistration(w http.ResponseWriter, r *http.Request) {
+	if !s.webauthnEnabled(w, r) {
+		return
+	}
+	var input WebAuthnFinishRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len([]rune(input.Name)) > maxCredentialName {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_input")
+		return
+	}
+	owner := currentUser(r)
+	state, ok := s.verifyCeremony(webauthnRegisterPurpose, input.Ceremony)
+	if !ok || state.Subject != owner {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_ceremony")
+		return
+	}
+	user, err := s.repo.Users.GetByEmail(r.Context(), owner)
+	if err != nil {
+		slog.Error("failed to get user", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	wuser, stored, err := s.loadWebAuthnUser(r.Context(), user)
+	if err != nil {
+		slog.Error("failed to load webauthn credentials", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	// handle выдан в begin; если тогда ключей не было, он ещё не сохранён
+	if len(stored) == 0 {
+		wuser.handle = state.Session.UserID
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(input.Credential))
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_credential")
+		return
+	}
+	credential, err := s.webauthn.CreateCredential(wuser, state.Session, parsed)
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_credential")
+		return
+	}
+	data, err := json.Marshal(credential)
+	if err != nil {
+		slog.Error("failed to encode webauthn credential", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	name := input.Name
+	if name == "" {
+		name = "Ключ " + strconv.Itoa(len(stored)+1)
+	}
+	record := database.WebAuthnCredential{
+		ID:         credential.ID,
+		Email:      owner,
+		UserHandle: wuser.handle,
+		Name:       name,
+		Data:       data,
+		CreatedAt:  time.Now(),
+	}
+	err = s.repo.WebAuthn.Create(r.Context(), record)
+	if errors.Is(err, database.ErrCredentialExists) {
+		ErrorResponseWithStatus(w, r, http.StatusConflict, "credential_exists")
+		return
+	}
+	if err != nil {
+		slog.Error("failed to save webauthn credential", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	writeJSON(w, http.StatusCreated, toCredentialJSON(record))
+}
+
+// beginPasskeyLogin начинает вход без пароля: браузер сам предложит
+// пользователю один из его ключей доступа.
+func (s *HTTPServer) beginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	if !s.webauthnEnabled(w, r) {
+		return
+	}
+	options, session, err := s.webauthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		slog.Error("failed to begin webauthn login", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	token, err := s.signCeremony(webauthnLoginPurpose, "", session)
+	if err != nil {
+		slog.Error("failed to sign webauthn ceremony", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, WebAuthnOptions{Options: options, Ceremony: token})
+}
+
+// finishPasskeyLogin создаёт сессию по ключу доступа. Ключ проверяет
+// пользователя сам (PIN или биометрия), поэтому второй фактор не
+// спрашивается.
+func (s *HTTPServer) finishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	if !s.webauthnEnabled(w, r) {
+		return
+	}
+	var input WebAuthnFinishRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	state, ok := s.verifyCeremony(webauthnLoginPurpose, input.Ceremony)
+	if !ok {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_ceremony")
+		return
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(input.Credential))
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_credential")
+		return
+	}
+
+	ctx := r.Context()
+	findUser := func(rawID []byte, userHandle []byte) (webauthn.User, error) {
+		stored, err := s.repo.WebAuthn.Get(ctx, rawID)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(stored.UserHandle, userHandle) {
+			return nil, database.ErrCredentialNotFound
+		}
+		user, err := s.repo.Users.GetByEmail(ctx, stored.Email)
+		if err != nil {
+			return nil, err
+		}
+		wuser, _, err := s.loadWebAuthnUser(ctx, user)
+		return wuser, err
+	}
+	user, credential, err := s.webauthn.ValidatePasskeyLogin(findUser, state.Session, parsed)
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_credential")
+		return
+	}
+	email := user.(*webauthnUser).user.Email
+	if err := s.saveAssertion(ctx, email, credential); errors.Is(err, errCredentialCloned) {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_credential")
+		return
+	} else if err != nil {
+		slog.Error("failed to update webauthn credential", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	s.startSession(w, r, email)
+}
+
+// beginSecondFactor - второй шаг входа ключом после правильного пароля.
+func (s *HTTPServer) beginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	if !s.webauthnEnabled(w, r) {
+		return
+	}
+	var input WebAuthnBeginRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	user, ok := s.loginUser(w, r, loginTwoFactorPurpose, input.Token)
+	if !ok {
+		return
+	}
+	wuser, _, err := s.loadWebAuthnUser(r.Context(), user)
+	if err != nil {
+		slog.Error("failed to load webauthn credentials", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	if len(wuser.credentials) == 0 {
+		ErrorResponseWithStatus(w, r, http.StatusConflict, "webauthn_not_registered")
+		return
+	}
+	options, session, err := s.webauthn.BeginLogin(wuser)
+	if err != nil {
+		slog.Error("failed to begin webauthn login", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	token, err := s.signCeremony(webauthn2FAPurpose, fingerprintSubject(user), session)
+	if err != nil {
+		slog.Error("failed to sign webauthn ceremony", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, WebAuthnOptions{Options: options, Ceremony: token})
+}
+
+func (s *HTTPServer) finishSecondFactor(w http.ResponseWriter, r *http.Request) {
+	if !s.webauthnEnabled(w, r) {
+		return
+	}
+	var input WebAuthnFinishRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	state, ok := s.verifyCeremony(webauthn2FAPurpose, input.Ceremony)
+	if !ok {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_ceremony")
+		return
+	}
+	user, ok := s.fingerprintUser(w, r, state.Subject)
+	if !ok {
+		return
+	}
+	wuser, _, err := s.loadWebAuthnUser(r.Context(), user)
+	if err != nil {
+		slog.Error("failed to load webauthn credentials", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(input.Credential))
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_credential")
+		return
+	}
+	credential, err := s.webauthn.ValidateLogin(wuser, state.Session, parsed)
+	if err != nil {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_credential")
+		return
+	}
+	if err := s.saveAssertion(r.Context(), user.Email, credential); errors.Is(err, errCredentialCloned) {
+		ErrorResponseWithStatus(w, r, http.StatusBadRequest, "invalid_credential")
+		return
+	} else if err != nil {
+		slog.Error("failed to update webauthn credential", "error", err)
+		ErrorResponseWithStatus(w, r, http.StatusInternalServerError, "Internal_error")
+		return
+	}
+	s.startSession(w, r, user.Email)
+}
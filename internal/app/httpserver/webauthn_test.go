@@ -0,0 +1,272 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"mail/config"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const testOrigin = "http://localhost:4201"
+
+var b64 = base64.RawURLEncoding
+
+func newWebAuthnRouter(s *HTTPServer, email string) http.Handler {
+	cfg := new(config.Config)
+	cfg.HTTPServer.AllowedIPsByCORS = []string{testOrigin}
+	cfg.Account = config.AccountConfig{Secret: testSecret}
+	cfg.WebAuthn = config.WebAuthnConfig{RPID: "localhost", RPName: "Giga-Mail", Origins: []string{testOrigin}}
+	s.server = new(http.Server)
+	s.configureRouter(cfg)
+	createTestSession(s, testUserID, email)
+	return s.server.Handler
+}
+
+// testAuthenticator - программный ключ с ES256 и аттестацией "none".
+type testAuthenticator struct {
+	key     *ecdsa.PrivateKey
+	id      []byte
+	handle  []byte
+	counter uint32
+}
+
+func newTestAuthenticator(t *testing.T) *testAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &testAuthenticator{key: key, id: id}
+}
+
+type testOptions struct {
+	PublicKey struct {
+		Challenge string `json:"challenge"`
+		User      struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	} `json:"publicKey"`
+}
+
+type testBegin struct {
+	Options  testOptions `json:"options"`
+	Ceremony string      `json:"ceremony"`
+}
+
+func beginCeremony(t *testing.T, router http.Handler, url string, body interface{}) testBegin {
+	t.Helper()
+	rr := doJSON(t, router, "POST", url, body)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("%s: %v %s", url, rr.Code, rr.Body.String())
+	}
+	var begin testBegin
+	if err := json.Unmarshal(rr.Body.Bytes(), &begin); err != nil {
+		t.Fatal(err)
+	}
+	return begin
+}
+
+func (a *testAuthenticator) clientData(kind string, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{"type": kind, "challenge": challenge, "origin": testOrigin})
+	return data
+}
+
+// authData собирает authenticatorData: хэш RP ID, флаги UP и UV, счётчик
+// и, при регистрации, данные нового ключа.
+func (a *testAuthenticator) authData(t *testing.T, attested bool) []byte {
+	rpID := sha256.Sum256([]byte("localhost"))
+	data := append([]byte{}, rpID[:]...)
+	flags := byte(0x01 | 0x04)
+	if attested {
+		flags |= 0x40
+	}
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.counter)
+	if !attested {
+		return data
+	}
+	data = append(data, make([]byte, 16)...) // AAGUID
+	data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+	data = append(data, a.id...)
+	public := a.key.PublicKey
+	cose, err := cbor.Marshal(map[int]interface{}{
+		1:  2,  // EC2
+		3:  -7, // ES256
+		-1: 1,  // P-256
+		-2: public.X.FillBytes(make([]byte, 32)),
+		-3: public.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(data, cose...)
+}
+
+func (a *testAuthenticator) register(t *testing.T, options testOptions) json.RawMessage {
+	t.Helper()
+	handle, err := b64.DecodeString(options.PublicKey.User.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.handle = handle
+	attestation, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(t, true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, _ := json.Marshal(map[string]interface{}{
+		"id":    b64.EncodeToString(a.id),
+		"rawId": b64.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(a.clientData("webauthn.create", options.PublicKey.Challenge)),
+			"attestationObject": b64.EncodeToString(attestation),
+		},
+	})
+	return credential
+}
+
+func (a *testAuthenticator) assert(t *testing.T, options testOptions) json.RawMessage {
+	t.Helper()
+	a.counter++
+	authData := a.authData(t, false)
+	clientData := a.clientData("webauthn.get", options.PublicKey.Challenge)
+	clientHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, _ := json.Marshal(map[string]interface{}{
+		"id":    b64.EncodeToString(a.id),
+		"rawId": b64.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(clientData),
+			"authenticatorData": b64.EncodeToString(authData),
+			"signature":         b64.EncodeToString(signature),
+			"userHandle":        b64.EncodeToString(a.handle),
+		},
+	})
+	return credential
+}
+
+func TestWebAuthnDisabled(t *testing.T) {
+	s := newTestServer()
+	createTestUser(s, "nick", testUserEmail, "12345")
+	router := newTestRouter(s, testUserEmail)
+	if rr := doJSON(t, router, "POST", "/webauthn/register/begin", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("register: %v", rr.Code)
+	}
+	if rr := doJSON(t, router, "POST", "/login/webauthn/begin", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("login: %v", rr.Code)
+	}
+}
+
+func TestWebAuthn(t *testing.T) {
+	s := newTestServer()
+	createTestUser(s, "nick", testUserEmail, "12345")
+	router := newWebAuthnRouter(s, testUserEmail)
+	key := newTestAuthenticator(t)
+
+	if rr := doJSON(t, router, "POST", "/webauthn/register/begin", PasswordRequest{Password: "wrong"}); rr.Code != http.StatusForbidden {
+		t.Errorf("register with wrong password: %v", rr.Code)
+	}
+	begin := beginCeremony(t, router, "/webauthn/register/begin", PasswordRequest{Password: "12345"})
+	credential := key.register(t, begin.Options)
+	rr := doJSON(t, router, "POST", "/webauthn/register/finish", WebAuthnFinishRequest{Ceremony: begin.Ceremony, Name: "YubiKey", Credential: credential})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("register: %v %s", rr.Code, rr.Body.String())
+	}
+	// церемония завершается один раз
+	rr = doJSON(t, router, "POST", "/webauthn/register/finish", WebAuthnFinishRequest{Ceremony: begin.Ceremony, Credential: credential})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid_ceremony") {
+		t.Errorf("replayed ceremony: %v %s", rr.Code, rr.Body.String())
+	}
+	// тот же ключ второй раз не добавляется
+	begin = beginCeremony(t, router, "/webauthn/register/begin", PasswordRequest{Password: "12345"})
+	rr = doJSON(t, router, "POST", "/webauthn/register/finish", WebAuthnFinishRequest{Ceremony: begin.Ceremony, Credential: key.register(t, begin.Options)})
+	if rr.Code != http.StatusConflict {
+		t.Errorf("duplicate key: %v %s", rr.Code, rr.Body.String())
+	}
+	rr = doJSON(t, router, "GET", "/webauthn/credentials", nil)
+	var credentials []CredentialJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &credentials); err != nil || len(credentials) != 1 || credentials[0].Name != "YubiKey" || credentials[0].ID != b64.EncodeToString(key.id) {
+		t.Fatalf("credentials %s, %v", rr.Body.String(), err)
+	}
+
+	// после пароля нужен ключ, код TOTP не подходит
+	rr = doJSON(t, router, "POST", "/login", UserLogin{Email: testUserEmail, Password: "12345"})
+	var login LoginResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &login); err != nil || !login.TwoFactorRequired || len(login.Methods) != 1 || login.Methods[0] != "webauthn" {
+		t.Fatalf("login %s, %v", rr.Body.String(), err)
+	}
+	if rr := doJSON(t, router, "POST", "/login/2fa", LoginTwoFactorRequest{Token: login.Token, Code: "000000"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("totp without totp: %v", rr.Code)
+	}
+	begin = beginCeremony(t, router, "/login/2fa/webauthn/begin", WebAuthnBeginRequest{Token: login.Token})
+	rr = doJSON(t, router, "POST", "/login/2fa/webauthn/finish", WebAuthnFinishRequest{Ceremony: begin.Ceremony, Credential: key.assert(t, begin.Options)})
+	if rr.Code != http.StatusOK || !hasSessionCookie(rr) {
+		t.Fatalf("second factor: %v %s", rr.Code, rr.Body.String())
+	}
+
+	// вход без пароля
+	begin = beginCeremony(t, router, "/login/webauthn/begin", nil)
+	assertion := key.assert(t, begin.Options)
+	rr = doJSON(t, router, "POST", "/login/webauthn/finish", WebAuthnFinishRequest{Ceremony: begin.Ceremony, Credential: assertion})
+	if rr.Code != http.StatusOK || !hasSessionCookie(rr) {
+		t.Fatalf("passkey login: %v %s", rr.Code, rr.Body.String())
+	}
+	// перехваченный ответ ключа не подходит для второго входа
+	rr = doJSON(t, router, "POST", "/login/webauthn/finish", WebAuthnFinishRequest{Ceremony: begin.Ceremony, Credential: assertion})
+	if rr.Code != http.StatusBadRequest || hasSessionCookie(rr) {
+		t.Errorf("replayed login: %v %s", rr.Code, rr.Body.String())
+	}
+	stored, _ := s.repo.WebAuthn.Get(context.Background(), key.id)
+	if stored.LastUsedAt.IsZero() {
+		t.Error("last use is not saved")
+	}
+
+	// копия ключа со старым счётчиком
+	key.counter = 0
+	begin = beginCeremony(t, router, "/login/webauthn/begin", nil)
+	rr = doJSON(t, router, "POST", "/login/webauthn/finish", WebAuthnFinishRequest{Ceremony: begin.Ceremony, Credential: key.assert(t, begin.Options)})
+	if rr.Code != http.StatusBadRequest || hasSessionCookie(rr) {
+		t.Errorf("cloned key: %v %s", rr.Code, rr.Body.String())
+	}
+	// чужой ключ
+	other := newTestAuthenticator(t)
+	other.handle = key.handle
+	begin = beginCeremony(t, router, "/login/webauthn/begin", nil)
+	rr = doJSON(t, router, "POST", "/login/webauthn/finish", WebAuthnFinishRequest{Ceremony: begin.Ceremony, Credential: other.assert(t, begin.Options)})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid_credential") {
+		t.Errorf("unknown key: %v %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := doJSON(t, router, "DELETE", "/webauthn/credentials/"+credentials[0].ID, PasswordRequest{Password: "wrong"}); rr.Code != http.StatusForbidden {
+		t.Errorf("delete with wrong password: %v", rr.Code)
+	}
+	if rr := doJSON(t, router, "DELETE", "/webauthn/credentials/"+credentials[0].ID, PasswordRequest{Password: "12345"}); rr.Code != http.StatusOK {
+		t.Fatalf("delete: %v", rr.Code)
+	}
+	if rr := doJSON(t, router, "DELETE", "/webauthn/credentials/"+credentials[0].ID, PasswordRequest{Password: "12345"}); rr.Code != http.StatusNotFound {
+		t.Errorf("delete again: %v", rr.Code)
+	}
+	if rr := doJSON(t, router, "POST", "/login", UserLogin{Email: testUserEmail, Password: "12345"}); !hasSessionCookie(rr) {
+		t.Error("no session after the key is deleted")
+	}
+}
@@ -0,0 +1,48 @@
+package outbound
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"mail/database"
+	"mail/pkg/mailauth"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// sign подписывает письмо активным ключом DKIM домена из заголовка From.
+// Подпись ставится при каждой попытке отправки, поэтому после ротации
+// ключа письма из очереди уходят уже с новым. Без ключа или при ошибке
+// письмо отправляется без подписи.
+func (q *Queue) sign(ctx context.Context, data []byte) []byte {
+	domain := fromDomain(data)
+	if domain == "" {
+		return data
+	}
+	key, err := q.repo.DKIM.Active(ctx, domain)
+	if errors.Is(err, database.ErrDKIMKeyNotFound) {
+		return data
+	}
+	if err != nil {
+		slog.Error("failed to get dkim key", "domain", domain, "error", err)
+		return data
+	}
+	signed, err := mailauth.Sign(data, key.Domain, key.Selector, key.PrivateKey)
+	if err != nil {
+		slog.Error("failed to sign message", "domain", domain, "error", err)
+		return data
+	}
+	return signed
+}
+
+func fromDomain(data []byte) string {
+	header, _ := textproto.NewReader(bufio.NewReader(bytes.NewReader(data))).ReadMIMEHeader()
+	address, err := mail.ParseAddress(header.Get("From"))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(address.Address[strings.LastIndex(address.Address, "@")+1:])
+}
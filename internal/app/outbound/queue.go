@@ -0,0 +1,279 @@
+package outbound
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"mail/config"
+	"mail/database"
+	"mail/pkg/mimemsg"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWorkers        = 4
+	defaultPollInterval   = 5 * time.Second
+	defaultMaxAttempts    = 8
+	defaultInitialBackoff = time.Minute
+	defaultMaxBackoff     = 6 * time.Hour
+
+	// lease - сколько запись считается занятой обработчиком.
+	lease       = 10 * time.Minute
+	sendTimeout = 5 * time.Minute
+)
+
+// Queue складывает исходящие письма в OutboundRepository и доставляет
+// их через Sender с повторными попытками. Получателям, доставка на
+// которых окончательно не удалась, отправителю приходит уведомление
+// о недоставке во входящие.
+type Queue struct {
+	repo     *database.Repositories
+	sender   Sender
+	hostname string
+
+	workers        int
+	pollInterval   time.Duration
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	now func() time.Time
+}
+
+func NewQueue(repo *database.Repositories, sender Sender, cfg config.OutboundConfig) *Queue {
+	q := &Queue{
+		repo:           repo,
+		sender:         sender,
+		hostname:       cfg.Hostname,
+		workers:        cfg.Workers,
+		pollInterval:   cfg.PollInterval,
+		maxAttempts:    cfg.MaxAttempts,
+		initialBackoff: cfg.InitialBackoff,
+		maxBackoff:     cfg.MaxBackoff,
+		now:            time.Now,
+	}
+	if q.hostname == "" {
+		q.hostname = "localhost"
+	}
+	if q.workers <= 0 {
+		q.workers = defaultWorkers
+	}
+	if q.pollInterval <= 0 {
+		q.pollInterval = defaultPollInterval
+	}
+	if q.maxAttempts <= 0 {
+		q.maxAttempts = defaultMaxAttempts
+	}
+	if q.initialBackoff <= 0 {
+		q.initialBackoff = defaultInitialBackoff
+	}
+	if q.maxBackoff <= 0 {
+		q.maxBackoff = defaultMaxBackoff
+	}
+	return q
+}
+
+func NewSMTPRelay(cfg config.OutboundConfig) *SMTPRelay {
+	return &SMTPRelay{
+		Addr:     cfg.RelayHost + ":" + cfg.RelayPort,
+		Hostname: cfg.Hostname,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		StartTLS: cfg.StartTLS,
+	}
+}
+
+func (q *Queue) Hostname() string {
+	return q.hostname
+}
+
+// Enqueue ставит письмо в очередь на всех получателей из To, Cc и Bcc.
+// Если исходный текст письма не задан в Raw, он собирается из полей.
+func (q *Queue) Enqueue(ctx context.Context, message database.Message) error {
+	data := message.Raw
+	if data == nil {
+		var err error
+		if data, err = mimemsg.Compose(message); err != nil {
+			return err
+		}
+	}
+	_, err := q.repo.Outbound.Enqueue(ctx, database.OutboundMessage{
+		Sender:     message.Owner,
+		From:       message.From.Email,
+		Recipients: envelopeRecipients(message),
+		Data:       data,
+		CreatedAt:  q.now(),
+	})
+	return err
+}
+
+func envelopeRecipients(message database.Message) []string {
+	seen := make(map[string]bool)
+	result := make([]string, 0)
+	for _, list := range [][]database.Address{message.To, message.Cc, message.Bcc} {
+		for _, address := range list {
+			email := strings.ToLower(address.Email)
+			if seen[email] {
+				continue
+			}
+			seen[email] = true
+			result = append(result, address.Email)
+		}
+	}
+	return result
+}
+
+// Run обрабатывает очередь, пока не отменён ctx.
+func (q *Queue) Run(ctx context.Context) {
+	ticker := time.NewTicker(q.pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := q.ProcessDue(ctx); err != nil {
+			slog.Error("outbound queue", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessDue доставляет все записи, время попытки которых наступило.
+func (q *Queue) ProcessDue(ctx context.Context) error {
+	for {
+		due, err := q.repo.Outbound.ClaimDue(ctx, q.now(), lease, q.workers)
+		if err != nil {
+			return err
+		}
+		if len(due) == 0 {
+			return nil
+		}
+
+		var wg sync.WaitGroup
+		for _, message := range due {
+			wg.Add(1)
+			go func(message database.OutboundMessage) {
+				defer wg.Done()
+				if err := q.deliver(ctx, message); err != nil {
+					slog.Error("outbound delivery", "id", message.ID, "error", err)
+				}
+			}(message)
+		}
+		wg.Wait()
+	}
+}
+
+func (q *Queue) backoff(attempts int) time.Duration {
+	delay := q.initialBackoff
+	for i := 1; i < attempts && delay < q.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > q.maxBackoff {
+		delay = q.maxBackoff
+	}
+	return delay
+}
+
+func (q *Queue) deliver(ctx context.Context, message database.OutboundMessage) error {
+	sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+	failed, sendErr := q.sender.Send(sendCtx, message.From, message.Recipients, q.sign(ctx, message.Data))
+	cancel()
+
+	retry := make([]string, 0)
+	bounced := make(map[string]error)
+	var lastErr error
+	for _, rcpt := range message.Recipients {
+		err, ok := failed[rcpt]
+		if !ok {
+			err = sendErr
+		}
+		switch {
+		case err == nil:
+		case isPermanent(err):
+			bounced[rcpt] = err
+		case message.Attempts >= q.maxAttempts:
+			bounced[rcpt] = fmt.Errorf("giving up after %d attempts: %w", message.Attempts, err)
+		default:
+			retry = append(retry, rcpt)
+			lastErr = err
+		}
+	}
+
+	if len(bounced) > 0 {
+		if err := q.bounce(ctx, message, bounced); err != nil {
+			slog.Error("failed to store bounce", "id", message.ID, "error", err)
+		}
+	}
+	if len(retry) > 0 {
+		next := q.now().Add(q.backoff(message.Attempts))
+		return q.repo.Outbound.Reschedule(ctx, message.ID, retry, next, lastErr.Error())
+	}
+	if len(bounced) == len(message.Recipients) {
+		return q.repo.Outbound.Complete(ctx, message.ID, database.OutboundFailed, describeFailures(bounced))
+	}
+	return q.repo.Outbound.Complete(ctx, message.ID, database.OutboundSent, describeFailures(bounced))
+}
+
+func describeFailures(failures map[string]error) string {
+	lines := make([]string, 0, len(failures))
+	for rcpt, err := range failures {
+		lines = append(lines, rcpt+": "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// bounce кладёт отправителю во входящие уведомление о недоставке.
+func (q *Queue) bounce(ctx context.Context, message database.OutboundMessage, failures map[string]error) error {
+	if message.Sender == "" {
+		return nil
+	}
+	inbox, err := q.repo.Folders.GetSystem(ctx, message.Sender, database.FolderInbox)
+	if err != nil {
+		return err
+	}
+
+	var body strings.Builder
+	body.WriteString("This is the mail system at host " + q.hostname + ".\n\n")
+	body.WriteString("I'm sorry to have to inform you that your message could not\n")
+	body.WriteString("be delivered to one or more recipients.\n\n")
+	for rcpt, err := range failures {
+		fmt.Fprintf(&body, "<%s>: %v\n", rcpt, err)
+	}
+	body.WriteString("\n--- Original message headers ---\n\n")
+	body.WriteString(originalHeaders(message.Data))
+
+	_, err = q.repo.Messages.Create(ctx, database.Message{
+		Owner:     message.Sender,
+		MessageID: mimemsg.GenerateMessageID(q.hostname),
+		From:      database.Address{Name: "Mail Delivery System", Email: "MAILER-DAEMON@" + q.hostname},
+		To:        []database.Address{{Email: message.Sender}},
+		Subject:   "Undelivered Mail Returned to Sender",
+		TextBody:  body.String(),
+		Headers: map[string][]string{
+			"Auto-Submitted": {"auto-replied"},
+		},
+		FolderID: inbox.ID,
+		Date:     q.now(),
+	})
+	return err
+}
+
+func originalHeaders(data []byte) string {
+	header, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(data))).ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for _, key := range []string{"From", "To", "Cc", "Subject", "Date", "Message-Id"} {
+		for _, value := range header[key] {
+			b.WriteString(key + ": " + value + "\n")
+		}
+	}
+	return b.String()
+}
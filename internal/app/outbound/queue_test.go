@@ -0,0 +1,331 @@
+package outbound
+
+import (
+	"context"
+	"io"
+	"mail/config"
+	"mail/database"
+	"mail/internal/app/delivery"
+	"mail/pkg/mailauth"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// fakeSMTP - SMTP-сервер для тестов: адреса с префиксом reject
+// отклоняются навсегда (550), с префиксом later - временно (451).
+type fakeSMTP struct {
+	mu        sync.Mutex
+	delivered map[string][]string
+}
+
+type fakeSession struct {
+	server *fakeSMTP
+	rcpts  []string
+}
+
+func (s *fakeSession) Mail(from string, opts *smtp.MailOptions) error { return nil }
+
+func (s *fakeSession) Rcpt(to string, opts *smtp.RcptOptions) error {
+	switch {
+	case strings.HasPrefix(to, "reject"):
+		return &smtp.SMTPError{Code: 550, EnhancedCode: smtp.EnhancedCode{5, 1, 1}, Message: "No such user"}
+	case strings.HasPrefix(to, "later"):
+		return &smtp.SMTPError{Code: 451, EnhancedCode: smtp.EnhancedCode{4, 3, 0}, Message: "Try again later"}
+	}
+	s.rcpts = append(s.rcpts, to)
+	return nil
+}
+
+func (s *fakeSession) Data(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.server.mu.Lock()
+	defer s.server.mu.Unlock()
+	for _, rcpt := range s.rcpts {
+		s.server.delivered[rcpt] = append(s.server.delivered[rcpt], string(data))
+	}
+	return nil
+}
+
+func (s *fakeSession) Reset()        { s.rcpts = nil }
+func (s *fakeSession) Logout() error { return nil }
+
+func (f *fakeSMTP) received(rcpt string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.delivered[rcpt]
+}
+
+func startFakeSMTP(t *testing.T) (*fakeSMTP, string) {
+	t.Helper()
+	fake := &fakeSMTP{delivered: make(map[string][]string)}
+	server := smtp.NewServer(smtp.BackendFunc(func(c *smtp.Conn) (smtp.Session, error) {
+		return &fakeSession{server: fake}, nil
+	}))
+	server.Domain = "relay.test"
+	server.AllowInsecureAuth = true
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+	return fake, l.Addr().String()
+}
+
+func newTestQueue(t *testing.T, addr string) (*Queue, *database.Repositories, *time.Time) {
+	t.Helper()
+	repo := database.NewInMemoryRepositories()
+	cfg := config.OutboundConfig{Hostname: "giga-mail.ru", MaxAttempts: 3, InitialBackoff: time.Minute, MaxBackoff: time.Hour}
+	q := NewQueue(repo, &SMTPRelay{Addr: addr, Hostname: "giga-mail.ru"}, cfg)
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	q.now = func() time.Time { return now }
+	return q, repo, &now
+}
+
+func testMessage(to ...string) database.Message {
+	message := database.Message{
+		Owner:     "nick@giga-mail.ru",
+		MessageID: "1@giga-mail.ru",
+		From:      database.Address{Email: "nick@giga-mail.ru"},
+		Subject:   "Квартальный отчёт",
+		TextBody:  "Привет!",
+		Date:      time.Now(),
+	}
+	for _, rcpt := range to {
+		message.To = append(message.To, database.Address{Email: rcpt})
+	}
+	return message
+}
+
+func inboxSubjects(t *testing.T, repo *database.Repositories, owner string) []string {
+	t.Helper()
+	ctx := context.Background()
+	inbox, err := repo.Folders.GetSystem(ctx, owner, database.FolderInbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, err := repo.Messages.ListByFolder(ctx, owner, inbox.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subjects := make([]string, 0, len(messages))
+	for _, m := range messages {
+		subjects = append(subjects, m.Subject)
+	}
+	return subjects
+}
+
+func TestQueueDelivers(t *testing.T) {
+	fake, addr := startFakeSMTP(t)
+	q, repo, _ := newTestQueue(t, addr)
+	ctx := context.Background()
+
+	message := testMessage("jane@example.com")
+	message.Bcc = []database.Address{{Email: "boss@example.com"}}
+	if err := q.Enqueue(ctx, message); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.ProcessDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rcpt := range []string{"jane@example.com", "boss@example.com"} {
+		got := fake.received(rcpt)
+		if len(got) != 1 || strings.Contains(got[0], "boss@example.com") {
+			t.Errorf("%s received %q", rcpt, got)
+		}
+	}
+	stored, _ := repo.Outbound.(*database.OutboundStore).Get(1)
+	if stored.Status != database.OutboundSent {
+		t.Errorf("got status %q want %q", stored.Status, database.OutboundSent)
+	}
+}
+
+func TestQueueBouncesPermanentFailure(t *testing.T) {
+	fake, addr := startFakeSMTP(t)
+	q, repo, _ := newTestQueue(t, addr)
+	ctx := context.Background()
+
+	if err := q.Enqueue(ctx, testMessage("jane@example.com", "reject@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.ProcessDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.received("jane@example.com")) != 1 {
+		t.Error("valid recipient should still get the message")
+	}
+	subjects := inboxSubjects(t, repo, "nick@giga-mail.ru")
+	if len(subjects) != 1 || subjects[0] != "Undelivered Mail Returned to Sender" {
+		t.Errorf("expected bounce in sender inbox, got %q", subjects)
+	}
+	stored, _ := repo.Outbound.(*database.OutboundStore).Get(1)
+	if stored.Status != database.OutboundSent || !strings.Contains(stored.LastError, "reject@example.com") {
+		t.Errorf("unexpected queue entry: %+v", stored)
+	}
+}
+
+func TestQueueRetriesWithBackoff(t *testing.T) {
+	_, addr := startFakeSMTP(t)
+	q, repo, now := newTestQueue(t, addr)
+	ctx := context.Background()
+	store := repo.Outbound.(*database.OutboundStore)
+
+	if err := q.Enqueue(ctx, testMessage("later@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	start := *now
+	if err := q.ProcessDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := store.Get(1)
+	if stored.Status != database.OutboundPending || !stored.NextAttemptAt.Equal(start.Add(time.Minute)) {
+		t.Fatalf("first retry: %+v", stored)
+	}
+
+	*now = start.Add(time.Minute)
+	q.ProcessDue(ctx)
+	stored, _ = store.Get(1)
+	if !stored.NextAttemptAt.Equal(now.Add(2 * time.Minute)) {
+		t.Fatalf("second retry should back off exponentially: %+v", stored)
+	}
+	if subjects := inboxSubjects(t, repo, "nick@giga-mail.ru"); len(subjects) != 0 {
+		t.Errorf("no bounce expected before max attempts, got %q", subjects)
+	}
+
+	*now = now.Add(2 * time.Minute)
+	q.ProcessDue(ctx)
+	stored, _ = store.Get(1)
+	if stored.Status != database.OutboundFailed {
+		t.Errorf("got status %q want %q after max attempts", stored.Status, database.OutboundFailed)
+	}
+	if subjects := inboxSubjects(t, repo, "nick@giga-mail.ru"); len(subjects) != 1 {
+		t.Errorf("expected bounce after max attempts, got %q", subjects)
+	}
+}
+
+func TestQueueRetriesWhenRelayIsDown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	q, repo, _ := newTestQueue(t, addr)
+	ctx := context.Background()
+	if err := q.Enqueue(ctx, testMessage("jane@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.ProcessDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := repo.Outbound.(*database.OutboundStore).Get(1)
+	if stored.Status != database.OutboundPending || stored.LastError == "" {
+		t.Errorf("unexpected queue entry: %+v", stored)
+	}
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	q := NewQueue(database.NewInMemoryRepositories(), nil, config.OutboundConfig{InitialBackoff: time.Minute, MaxBackoff: 10 * time.Minute})
+	for attempts, want := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 4: 8 * time.Minute, 5: 10 * time.Minute, 30: 10 * time.Minute} {
+		if got := q.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestQueueDeliversLocallyThroughRouter(t *testing.T) {
+	fake, addr := startFakeSMTP(t)
+	q, repo, _ := newTestQueue(t, addr)
+	repo.Users.Create(context.Background(), database.User{Name: "Jane", Email: "jane@giga-mail.ru", Password: "x"})
+	deliverer := delivery.NewDeliverer(repo, []string{"giga-mail.ru"}, nil, nil)
+	q.sender = &Router{Local: deliverer, Remote: q.sender, IsLocal: deliverer.IsLocal}
+	ctx := context.Background()
+
+	if err := q.Enqueue(ctx, testMessage("jane@giga-mail.ru", "ghost@giga-mail.ru", "mark@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.ProcessDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if subjects := inboxSubjects(t, repo, "jane@giga-mail.ru"); len(subjects) != 1 || subjects[0] != "Квартальный отчёт" {
+		t.Errorf("local recipient inbox: %q", subjects)
+	}
+	if len(fake.received("mark@example.com")) != 1 || len(fake.received("jane@giga-mail.ru")) != 0 {
+		t.Error("only remote recipients should go through the relay")
+	}
+	if subjects := inboxSubjects(t, repo, "nick@giga-mail.ru"); len(subjects) != 1 {
+		t.Errorf("expected bounce for unknown local user, got %q", subjects)
+	}
+}
+
+func TestQueueDoesNotBounceAutoReply(t *testing.T) {
+	_, addr := startFakeSMTP(t)
+	q, repo, now := newTestQueue(t, addr)
+	*now = time.Now().Add(time.Minute)
+	ctx := context.Background()
+	const owner = "jane@giga-mail.ru"
+	repo.Users.Create(ctx, database.User{Name: "Jane", Email: owner, Password: "x"})
+	repo.Vacation.Save(ctx, database.Vacation{Owner: owner, Enabled: true, Body: "Away", Start: time.Now().Add(-time.Hour)})
+	deliverer := delivery.NewDeliverer(repo, []string{"giga-mail.ru"}, nil, nil)
+
+	// поддельный отправитель: автоответ на него не доставить
+	data := "From: reject@example.com\r\nTo: " + owner + "\r\nSubject: Hello\r\n\r\nHi\r\n"
+	if _, err := deliverer.Send(ctx, "reject@example.com", []string{owner}, []byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.ProcessDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if subjects := inboxSubjects(t, repo, owner); len(subjects) != 1 || subjects[0] != "Hello" {
+		t.Errorf("expected only the original message, got %q", subjects)
+	}
+	stored, _ := repo.Outbound.(*database.OutboundStore).Get(1)
+	if stored.Status != database.OutboundFailed {
+		t.Errorf("auto reply was not rejected: %+v", stored)
+	}
+}
+
+func TestQueueSignsWithDKIM(t *testing.T) {
+	fake, addr := startFakeSMTP(t)
+	q, repo, _ := newTestQueue(t, addr)
+	ctx := context.Background()
+	key, err := mailauth.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.DKIM.Rotate(ctx, database.DKIMKey{Domain: "giga-mail.ru", Selector: "s1", PrivateKey: key})
+
+	if err := q.Enqueue(ctx, testMessage("jane@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.ProcessDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got := fake.received("jane@example.com")
+	if len(got) != 1 || !strings.HasPrefix(got[0], "DKIM-Signature: ") {
+		t.Fatalf("message is not signed: %q", got)
+	}
+
+	record, _ := mailauth.DNSRecord(key)
+	verifier := mailauth.NewVerifier(mailauth.StaticResolver{TXT: map[string][]string{
+		mailauth.DNSName("giga-mail.ru", "s1"): {record},
+	}}, "mx.example.com")
+	results := verifier.Verify(ctx, net.ParseIP("192.0.2.1"), "giga-mail.ru", "nick@giga-mail.ru", []byte(got[0]))
+	if len(results.DKIM) != 1 || results.DKIM[0].Result != "pass" || results.DKIM[0].Domain != "giga-mail.ru" {
+		t.Errorf("signature does not verify: %+v", results.DKIM)
+	}
+}
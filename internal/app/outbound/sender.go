@@ -0,0 +1,138 @@
+package outbound
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+)
+
+const dialTimeout = 30 * time.Second
+
+// Sender передаёт письмо дальше. failed содержит ошибки отдельных
+// получателей, err относится ко всем остальным получателям.
+type Sender interface {
+	Send(ctx context.Context, from string, recipients []string, data []byte) (failed map[string]error, err error)
+}
+
+// SMTPRelay отправляет всю исходящую почту через один SMTP-сервер.
+type SMTPRelay struct {
+	Addr     string
+	Hostname string
+	Username string
+	Password string
+	StartTLS bool
+}
+
+func (r *SMTPRelay) Send(ctx context.Context, from string, recipients []string, data []byte) (map[string]error, error) {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", r.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var c *smtp.Client
+	if r.StartTLS {
+		host, _, _ := net.SplitHostPort(r.Addr)
+		if c, err = smtp.NewClientStartTLS(conn, &tls.Config{ServerName: host}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	} else {
+		c = smtp.NewClient(conn)
+	}
+	defer c.Close()
+
+	if err := c.Hello(r.Hostname); err != nil {
+		return nil, err
+	}
+	if r.Username != "" {
+		if err := c.Auth(sasl.NewPlainClient("", r.Username, r.Password)); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Mail(from, nil); err != nil {
+		return nil, err
+	}
+
+	failed := make(map[string]error)
+	accepted := 0
+	for _, rcpt := range recipients {
+		if err := c.Rcpt(rcpt, nil); err != nil {
+			failed[rcpt] = err
+			continue
+		}
+		accepted++
+	}
+	if accepted == 0 {
+		c.Quit()
+		return failed, nil
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return failed, err
+	}
+	if _, err := bytes.NewReader(data).WriteTo(w); err != nil {
+		return failed, err
+	}
+	if err := w.Close(); err != nil {
+		return failed, err
+	}
+	c.Quit()
+	return failed, nil
+}
+
+// isPermanent различает постоянные (5xx) и временные ошибки доставки.
+// Сетевые и прочие ошибки считаются временными.
+func isPermanent(err error) bool {
+	var smtpErr *smtp.SMTPError
+	return errors.As(err, &smtpErr) && smtpErr.Code/100 == 5
+}
+
+// Router отправляет письма локальным получателям через Local, а всем
+// остальным - через Remote.
+type Router struct {
+	Local   Sender
+	Remote  Sender
+	IsLocal func(address string) bool
+}
+
+func (r *Router) Send(ctx context.Context, from string, recipients []string, data []byte) (map[string]error, error) {
+	local := make([]string, 0)
+	remote := make([]string, 0)
+	for _, rcpt := range recipients {
+		if r.IsLocal(rcpt) {
+			local = append(local, rcpt)
+		} else {
+			remote = append(remote, rcpt)
+		}
+	}
+
+	failed := make(map[string]error)
+	for _, route := range []struct {
+		sender     Sender
+		recipients []string
+	}{{r.Local, local}, {r.Remote, remote}} {
+		if len(route.recipients) == 0 {
+			continue
+		}
+		routeFailed, err := route.sender.Send(ctx, from, route.recipients, data)
+		for _, rcpt := range route.recipients {
+			if rcptErr, ok := routeFailed[rcpt]; ok {
+				failed[rcpt] = rcptErr
+			} else if err != nil {
+				failed[rcpt] = err
+			}
+		}
+	}
+	return failed, nil
+}
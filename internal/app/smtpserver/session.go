@@ -0,0 +1,102 @@
+package smtpserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"mail/internal/app/delivery"
+	"net"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+const deliveryTimeout = time.Minute
+
+type backend struct {
+	server *SMTPServer
+}
+
+func (b *backend) NewSession(c *smtp.Conn) (smtp.Session, error) {
+	return &session{server: b.server, conn: c}, nil
+}
+
+// session - одна SMTP-транзакция: получатели проверяются сразу на RCPT,
+// чтобы отклонить неизвестные адреса до передачи тела письма.
+type session struct {
+	server *SMTPServer
+	conn   *smtp.Conn
+	from   string
+	rcpts  []string
+}
+
+func (s *session) Mail(from string, opts *smtp.MailOptions) error {
+	s.from = from
+	s.rcpts = nil
+	return nil
+}
+
+func (s *session) Rcpt(to string, opts *smtp.RcptOptions) error {
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+	defer cancel()
+	if err := s.server.deliverer.CheckRecipient(ctx, to); err != nil {
+		return err
+	}
+	s.rcpts = append(s.rcpts, to)
+	return nil
+}
+
+func (s *session) Data(r io.Reader) error {
+	var data bytes.Buffer
+	data.WriteString(s.receivedHeader())
+	if _, err := data.ReadFrom(r); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+	defer cancel()
+	if addr, ok := s.conn.Conn().RemoteAddr().(*net.TCPAddr); ok {
+		ctx = delivery.WithClient(ctx, delivery.Client{IP: addr.IP, Helo: s.conn.Hostname()})
+	}
+	failed, err := s.server.deliverer.Send(ctx, s.from, s.rcpts, data.Bytes())
+	if err != nil {
+		return err
+	}
+	// В SMTP на DATA отвечают один раз за всех получателей. Если хоть
+	// кому-то письмо доставлено, возвращаем успех, иначе клиент повторит
+	// отправку и доставленные получат дубликат. Остальным письмо
+	// доставит очередь, приняв его, мы за него отвечаем.
+	if len(failed) > 0 && len(failed) < len(s.rcpts) {
+		retry := make([]string, 0, len(failed))
+		for rcpt, err := range failed {
+			slog.Error("inbound delivery failed, queued for retry", "rcpt", rcpt, "error", err)
+			retry = append(retry, rcpt)
+		}
+		return s.server.deliverer.Defer(ctx, s.from, retry, data.Bytes())
+	}
+	for _, err := range failed {
+		return err
+	}
+	return nil
+}
+
+// receivedHeader - трассировочный заголовок Received (RFC 5321, 4.4).
+func (s *session) receivedHeader() string {
+	remote := ""
+	if addr, ok := s.conn.Conn().RemoteAddr().(*net.TCPAddr); ok {
+		remote = " ([" + addr.IP.String() + "])"
+	}
+	return fmt.Sprintf("Received: from %s%s\r\n\tby %s with ESMTP;\r\n\t%s\r\n",
+		s.conn.Hostname(), remote, s.server.hostname, time.Now().Format(time.RFC1123Z))
+}
+
+func (s *session) Reset() {
+	s.from = ""
+	s.rcpts = nil
+}
+
+func (s *session) Logout() error {
+	return nil
+}
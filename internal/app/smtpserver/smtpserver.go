@@ -0,0 +1,65 @@
+package smtpserver
+
+import (
+	"log/slog"
+	config "mail/config"
+	"mail/internal/app/delivery"
+	"net"
+
+	"github.com/emersion/go-smtp"
+)
+
+const (
+	defaultMaxMessageBytes = 25 << 20
+	defaultMaxRecipients   = 100
+)
+
+// SMTPServer принимает входящую почту для локальных доменов и передаёт
+// её в Deliverer. Авторизация не поддерживается: сервер не выполняет
+// релей, только приём.
+type SMTPServer struct {
+	server    *smtp.Server
+	deliverer *delivery.Deliverer
+	hostname  string
+}
+
+func NewSMTPServer(deliverer *delivery.Deliverer) *SMTPServer {
+	return &SMTPServer{deliverer: deliverer}
+}
+
+func (s *SMTPServer) Start(cfg *config.Config) error {
+	s.configure(cfg)
+	slog.Info("SMTP server is running on", "port", cfg.SMTPServer.Port)
+	return s.server.ListenAndServe()
+}
+
+// Serve обслуживает уже открытый listener, используется в тестах.
+func (s *SMTPServer) Serve(cfg *config.Config, l net.Listener) error {
+	s.configure(cfg)
+	return s.server.Serve(l)
+}
+
+func (s *SMTPServer) Close() error {
+	return s.server.Close()
+}
+
+func (s *SMTPServer) configure(cfg *config.Config) {
+	s.hostname = cfg.SMTPServer.Hostname
+	if s.hostname == "" {
+		s.hostname = "localhost"
+	}
+
+	s.server = smtp.NewServer(&backend{server: s})
+	s.server.Addr = cfg.SMTPServer.IP + ":" + cfg.SMTPServer.Port
+	s.server.Domain = s.hostname
+	s.server.MaxMessageBytes = cfg.SMTPServer.MaxMessageBytes
+	if s.server.MaxMessageBytes <= 0 {
+		s.server.MaxMessageBytes = defaultMaxMessageBytes
+	}
+	s.server.MaxRecipients = cfg.SMTPServer.MaxRecipients
+	if s.server.MaxRecipients <= 0 {
+		s.server.MaxRecipients = defaultMaxRecipients
+	}
+	s.server.ReadTimeout = cfg.SMTPServer.ReadTimeout
+	s.server.WriteTimeout = cfg.SMTPServer.WriteTimeout
+}
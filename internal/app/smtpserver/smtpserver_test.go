@@ -0,0 +1,402 @@
+package smtpserver
+
+import (
+	"context"
+	"errors"
+	"mail/config"
+	"mail/database"
+	"mail/internal/app/delivery"
+	"mail/internal/app/outbound"
+	"mail/pkg/mailauth"
+	"mail/pkg/spam"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+const testMessage = "From: Mark Brown <mark.brown@example.com>\r\n" +
+	"To: jane@giga-mail.ru\r\n" +
+	"Subject: Report Update\r\n" +
+	"Message-ID: <42@example.com>\r\n" +
+	"\r\n" +
+	"Please review the report.\r\n"
+
+func startTestServer(t *testing.T) (*database.Repositories, string) {
+	t.Helper()
+	return startVerifyingServer(t, nil)
+}
+
+// startVerifyingServer запускает сервер, который проверяет входящие
+// письма через auth.
+func startVerifyingServer(t *testing.T, auth *mailauth.Verifier) (*database.Repositories, string) {
+	t.Helper()
+	repo := database.NewInMemoryRepositories()
+	repo.Users.Create(context.Background(), database.User{Name: "Jane", Email: "jane@giga-mail.ru", Password: "x"})
+
+	cfg := new(config.Config)
+	cfg.SMTPServer.Hostname = "mx.giga-mail.ru"
+	cfg.SMTPServer.LocalDomains = []string{"giga-mail.ru"}
+	cfg.SMTPServer.MaxMessageBytes = 1024
+	cfg.SMTPServer.MaxRecipients = 2
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := spam.NewPipeline(spam.DefaultThreshold, spam.Headers{}, spam.NewBayes(repo.Spam, 2))
+	s := NewSMTPServer(delivery.NewDeliverer(repo, cfg.SMTPServer.LocalDomains, filter, auth))
+	go s.Serve(cfg, l)
+	t.Cleanup(func() { l.Close() })
+	return repo, l.Addr().String()
+}
+
+func dial(t *testing.T, addr string) *smtp.Client {
+	t.Helper()
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if err := c.Hello("mx.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func smtpCode(err error) int {
+	var smtpErr *smtp.SMTPError
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Code
+	}
+	return 0
+}
+
+func TestDeliverToInbox(t *testing.T) {
+	repo, addr := startTestServer(t)
+	c := dial(t, addr)
+	if err := c.SendMail("mark.brown@example.com", []string{"jane@giga-mail.ru"}, strings.NewReader(testMessage)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	inbox, err := repo.Folders.GetSystem(ctx, "jane@giga-mail.ru", database.FolderInbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, err := repo.Messages.ListByFolder(ctx, "jane@giga-mail.ru", inbox.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 message in inbox, got %d", len(messages))
+	}
+	message := messages[0]
+	if message.Subject != "Report Update" || message.From.Email != "mark.brown@example.com" ||
+		strings.TrimSpace(message.TextBody) != "Please review the report." {
+		t.Errorf("unexpected message: %+v", message)
+	}
+	if message.Date.IsZero() || message.ReceivedAt.IsZero() {
+		t.Errorf("dates are not set: %v %v", message.Date, message.ReceivedAt)
+	}
+	if got := message.Headers["Return-Path"]; len(got) != 1 || got[0] != "<mark.brown@example.com>" {
+		t.Errorf("unexpected Return-Path: %v", got)
+	}
+	if got := message.Headers["Received"]; len(got) != 1 || !strings.Contains(got[0], "by mx.giga-mail.ru") {
+		t.Errorf("unexpected Received: %v", got)
+	}
+	raw, err := repo.Messages.GetRaw(ctx, "jane@giga-mail.ru", message.ID)
+	if err != nil || !strings.HasPrefix(string(raw), "Return-Path: <mark.brown@example.com>\r\nReceived: ") ||
+		!strings.HasSuffix(string(raw), testMessage) {
+		t.Errorf("unexpected raw message: %q %v", raw, err)
+	}
+}
+
+func TestRejectRecipients(t *testing.T) {
+	_, addr := startTestServer(t)
+	c := dial(t, addr)
+	if err := c.Mail("mark.brown@example.com", nil); err != nil {
+		t.Fatal(err)
+	}
+	if code := smtpCode(c.Rcpt("nobody@giga-mail.ru", nil)); code != 550 {
+		t.Errorf("unknown user: expected 550, got %d", code)
+	}
+	if code := smtpCode(c.Rcpt("someone@example.org", nil)); code != 550 {
+		t.Errorf("foreign domain: expected 550, got %d", code)
+	}
+	if err := c.Rcpt("jane@giga-mail.ru", nil); err != nil {
+		t.Errorf("local user rejected: %v", err)
+	}
+	if err := c.Rcpt("jane@giga-mail.ru", nil); err != nil {
+		t.Errorf("local user rejected: %v", err)
+	}
+	if code := smtpCode(c.Rcpt("jane@giga-mail.ru", nil)); code != 452 {
+		t.Errorf("too many recipients: expected 452, got %d", code)
+	}
+}
+
+func TestRejectTooLarge(t *testing.T) {
+	repo, addr := startTestServer(t)
+	c := dial(t, addr)
+	big := testMessage + strings.Repeat("padding line\r\n", 200)
+	err := c.SendMail("mark.brown@example.com", []string{"jane@giga-mail.ru"}, strings.NewReader(big))
+	if code := smtpCode(err); code != 552 {
+		t.Fatalf("expected 552, got %v", err)
+	}
+
+	ctx := context.Background()
+	inbox, _ := repo.Folders.GetSystem(ctx, "jane@giga-mail.ru", database.FolderInbox)
+	messages, _ := repo.Messages.ListByFolder(ctx, "jane@giga-mail.ru", inbox.ID)
+	if len(messages) != 0 {
+		t.Errorf("oversized message was stored")
+	}
+}
+
+func TestDeliverWithFilters(t *testing.T) {
+	repo, addr := startTestServer(t)
+	ctx := context.Background()
+	const owner = "jane@giga-mail.ru"
+	reports, err := repo.Folders.Create(ctx, database.Folder{Owner: owner, Name: "Reports"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Filters.Create(ctx, database.FilterRule{
+		Owner:   owner,
+		Enabled: true,
+		Conditions: []database.FilterCondition{
+			{Field: database.FilterFieldSubject, Op: database.FilterOpContains, Value: "report"},
+		},
+		Actions: []database.FilterAction{
+			{Type: database.FilterActionFileInto, FolderID: reports},
+			{Type: database.FilterActionFlag, Flag: database.FlagFlagged},
+			{Type: database.FilterActionRedirect, Address: "boss@example.com"},
+		},
+	})
+	repo.Filters.Create(ctx, database.FilterRule{
+		Owner:      owner,
+		Enabled:    true,
+		Conditions: []database.FilterCondition{{Field: database.FilterFieldFrom, Op: database.FilterOpIs, Value: "spam@example.com"}},
+		Actions:    []database.FilterAction{{Type: database.FilterActionDiscard}},
+	})
+
+	c := dial(t, addr)
+	if err := c.SendMail("mark.brown@example.com", []string{owner}, strings.NewReader(testMessage)); err != nil {
+		t.Fatal(err)
+	}
+	spam := strings.NewReplacer("mark.brown@example.com", "spam@example.com", "Report Update", "You won").Replace(testMessage)
+	if err := c.SendMail("spam@example.com", []string{owner}, strings.NewReader(spam)); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := repo.Messages.ListByFolder(ctx, owner, reports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || !messages[0].HasFlag(database.FlagFlagged) {
+		t.Fatalf("unexpected filtered messages: %+v", messages)
+	}
+	inbox, _ := repo.Folders.GetSystem(ctx, owner, database.FolderInbox)
+	if messages, _ := repo.Messages.ListByFolder(ctx, owner, inbox.ID); len(messages) != 0 {
+		t.Errorf("discarded message was stored: %+v", messages)
+	}
+
+	queued, err := repo.Outbound.ClaimDue(ctx, time.Now().Add(time.Minute), time.Minute, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 1 || queued[0].Recipients[0] != "boss@example.com" ||
+		!strings.HasPrefix(string(queued[0].Data), "Delivered-To: "+owner+"\r\nReceived: ") {
+		t.Errorf("unexpected redirect: %+v", queued)
+	}
+}
+
+func TestVacationAutoReply(t *testing.T) {
+	repo, addr := startTestServer(t)
+	ctx := context.Background()
+	const owner = "jane@giga-mail.ru"
+	repo.Vacation.Save(ctx, database.Vacation{
+		Owner:   owner,
+		Enabled: true,
+		Body:    "I am on vacation until Monday.",
+		Start:   time.Now().Add(-time.Hour),
+		Days:    database.DefaultVacationDays,
+	})
+
+	c := dial(t, addr)
+	for _, message := range []string{
+		testMessage,
+		testMessage,
+		"List-Id: <news.example.com>\r\n" + testMessage,
+		"Auto-Submitted: auto-generated\r\n" + testMessage,
+		strings.Replace(testMessage, "To: jane@giga-mail.ru", "To: team@example.com", 1),
+	} {
+		if err := c.SendMail("mark.brown@example.com", []string{owner}, strings.NewReader(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.SendMail("", []string{owner}, strings.NewReader(testMessage)); err != nil {
+		t.Fatal(err)
+	}
+
+	queued, err := repo.Outbound.ClaimDue(ctx, time.Now().Add(time.Minute), time.Minute, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 1 {
+		t.Fatalf("expected 1 auto reply, got %d", len(queued))
+	}
+	reply := string(queued[0].Data)
+	if queued[0].From != "" || queued[0].Recipients[0] != "mark.brown@example.com" ||
+		!strings.Contains(reply, "Auto-Submitted: auto-replied") || !strings.Contains(reply, "Subject: Auto: Report Update") ||
+		!strings.Contains(reply, "In-Reply-To: <42@example.com>") {
+		t.Errorf("unexpected auto reply: %+v\n%s", queued[0], reply)
+	}
+}
+
+func TestDeliverSpam(t *testing.T) {
+	repo, addr := startTestServer(t)
+	ctx := context.Background()
+	const owner = "jane@giga-mail.ru"
+	for i := 0; i < 2; i++ {
+		spam.Train(ctx, repo.Spam, owner, database.Message{Subject: "Casino jackpot", TextBody: "Free spins"}, true, 1)
+		spam.Train(ctx, repo.Spam, owner, database.Message{Subject: "Report", TextBody: "Please review"}, false, 1)
+	}
+	repo.Vacation.Save(ctx, database.Vacation{Owner: owner, Enabled: true, Body: "Away", Days: 1})
+
+	c := dial(t, addr)
+	spamMessage := strings.NewReplacer("Report Update", "Casino jackpot", "Please review the report.", "Free spins").Replace(testMessage)
+	forged := "X-Spam-Status: Yes\r\n" + testMessage
+	for _, message := range []string{spamMessage, forged} {
+		if err := c.SendMail("mark.brown@example.com", []string{owner}, strings.NewReader(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	spamFolder, _ := repo.Folders.GetSystem(ctx, owner, database.FolderSpam)
+	messages, _ := repo.Messages.ListByFolder(ctx, owner, spamFolder.ID)
+	if len(messages) != 1 || messages[0].Subject != "Casino jackpot" ||
+		!strings.HasPrefix(messages[0].Headers["X-Spam-Status"][0], "Yes, ") {
+		t.Fatalf("unexpected spam folder: %+v", messages)
+	}
+	inbox, _ := repo.Folders.GetSystem(ctx, owner, database.FolderInbox)
+	messages, _ = repo.Messages.ListByFolder(ctx, owner, inbox.ID)
+	if len(messages) != 1 || !strings.HasPrefix(messages[0].Headers["X-Spam-Status"][0], "No, ") {
+		t.Fatalf("unexpected inbox: %+v", messages)
+	}
+
+	// автоответ получает только нормальное письмо
+	queued, _ := repo.Outbound.ClaimDue(ctx, time.Now().Add(time.Minute), time.Minute, 10)
+	if len(queued) != 1 {
+		t.Errorf("expected 1 auto reply, got %d", len(queued))
+	}
+}
+
+func TestVerifyAuthentication(t *testing.T) {
+	key, err := mailauth.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, _ := mailauth.DNSRecord(key)
+	resolver := mailauth.StaticResolver{TXT: map[string][]string{
+		mailauth.DNSName("example.com", "s1"): {record},
+		"_dmarc.example.com":                  {"v=DMARC1; p=reject"},
+		"_dmarc.example.org":                  {"v=DMARC1; p=quarantine"},
+	}}
+	repo, addr := startVerifyingServer(t, mailauth.NewVerifier(resolver, "mx.giga-mail.ru"))
+	ctx := context.Background()
+	const owner = "jane@giga-mail.ru"
+
+	signed, err := mailauth.Sign([]byte(testMessage), "example.com", "s1", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := "Authentication-Results: mx.giga-mail.ru; dmarc=pass\r\n" + string(signed)
+	c := dial(t, addr)
+	if err := c.SendMail("mark.brown@example.com", []string{owner}, strings.NewReader(forged)); err != nil {
+		t.Fatal(err)
+	}
+	err = c.SendMail("mark.brown@example.com", []string{owner}, strings.NewReader(testMessage))
+	if smtpCode(err) != 550 {
+		t.Errorf("unsigned message from reject domain: got %v", err)
+	}
+	quarantined := strings.Replace(testMessage, "mark.brown@example.com>", "mark.brown@example.org>", 1)
+	if err := c.SendMail("mark.brown@example.org", []string{owner}, strings.NewReader(quarantined)); err != nil {
+		t.Fatal(err)
+	}
+
+	inbox, _ := repo.Folders.GetSystem(ctx, owner, database.FolderInbox)
+	messages, _ := repo.Messages.ListByFolder(ctx, owner, inbox.ID)
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 message in inbox, got %d", len(messages))
+	}
+	results := messages[0].Headers["Authentication-Results"]
+	if len(results) != 1 || !strings.Contains(results[0], "dkim=pass header.d=example.com") ||
+		!strings.Contains(results[0], "dmarc=pass header.from=example.com") {
+		t.Errorf("unexpected Authentication-Results: %q", results)
+	}
+
+	spamFolder, _ := repo.Folders.GetSystem(ctx, owner, database.FolderSpam)
+	messages, _ = repo.Messages.ListByFolder(ctx, owner, spamFolder.ID)
+	if len(messages) != 1 || !strings.Contains(messages[0].Headers["X-Spam-Status"][0], "DMARC_QUARANTINE") {
+		t.Errorf("quarantined message is not in spam: %+v", messages)
+	}
+}
+
+// failingMessages не сохраняет письма владельца owner, пока fail true.
+type failingMessages struct {
+	database.MessageRepository
+	owner string
+	fail  bool
+}
+
+func (m *failingMessages) Create(ctx context.Context, message database.Message) (int64, error) {
+	if m.fail && message.Owner == m.owner {
+		return 0, errors.New("storage is unavailable")
+	}
+	return m.MessageRepository.Create(ctx, message)
+}
+
+func TestRetryFailedRecipient(t *testing.T) {
+	repo := database.NewInMemoryRepositories()
+	ctx := context.Background()
+	repo.Users.Create(ctx, database.User{Name: "Jane", Email: "jane@giga-mail.ru", Password: "x"})
+	repo.Users.Create(ctx, database.User{Name: "Nick", Email: "nick@giga-mail.ru", Password: "x"})
+	messages := &failingMessages{MessageRepository: repo.Messages, owner: "nick@giga-mail.ru", fail: true}
+	repo.Messages = messages
+
+	cfg := new(config.Config)
+	cfg.SMTPServer.Hostname = "mx.giga-mail.ru"
+	cfg.SMTPServer.LocalDomains = []string{"giga-mail.ru"}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliverer := delivery.NewDeliverer(repo, cfg.SMTPServer.LocalDomains, nil, nil)
+	s := NewSMTPServer(deliverer)
+	go s.Serve(cfg, l)
+	t.Cleanup(func() { l.Close() })
+
+	c := dial(t, l.Addr().String())
+	if err := c.SendMail("mark.brown@example.com", []string{"jane@giga-mail.ru", "nick@giga-mail.ru"}, strings.NewReader(testMessage)); err != nil {
+		t.Fatal(err)
+	}
+	count := func(owner string) int {
+		inbox, _ := repo.Folders.GetSystem(ctx, owner, database.FolderInbox)
+		list, _ := repo.Messages.ListByFolder(ctx, owner, inbox.ID)
+		return len(list)
+	}
+	if count("jane@giga-mail.ru") != 1 || count("nick@giga-mail.ru") != 0 {
+		t.Fatalf("jane %d, nick %d", count("jane@giga-mail.ru"), count("nick@giga-mail.ru"))
+	}
+
+	messages.fail = false
+	queue := outbound.NewQueue(repo, deliverer, config.OutboundConfig{})
+	if err := queue.ProcessDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if count("jane@giga-mail.ru") != 1 || count("nick@giga-mail.ru") != 1 {
+		t.Errorf("after retry: jane %d, nick %d", count("jane@giga-mail.ru"), count("nick@giga-mail.ru"))
+	}
+}
@@ -0,0 +1,67 @@
+package blobstore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mail/config"
+	"strings"
+)
+
+const (
+	StorageFS = "fs"
+	StorageS3 = "s3"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// Store хранит содержимое вложений. Ключи выдаёт NewKey, хранилище
+// ничего не знает о письмах и владельцах.
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get возвращает ErrNotFound, если ключа нет.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete не считает ошибкой отсутствие ключа.
+	Delete(ctx context.Context, key string) error
+}
+
+// New создаёт хранилище, выбранное в конфиге. По умолчанию вложения
+// лежат в локальной директории.
+func New(cfg config.AttachmentsConfig) (Store, error) {
+	switch cfg.Storage {
+	case "", StorageFS:
+		dir := cfg.Dir
+		if dir == "" {
+			dir = "./data/attachments"
+		}
+		return NewFSStore(dir)
+	case StorageS3:
+		return NewS3Store(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown attachments storage %q", cfg.Storage)
+	}
+}
+
+// NewKey генерирует случайный ключ для нового объекта.
+func NewKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// validKey допускает только ключи из NewKey, чтобы ключ нельзя было
+// использовать для выхода за пределы директории или бакета.
+func validKey(key string) bool {
+	if len(key) != 32 {
+		return false
+	}
+	return strings.Trim(key, "0123456789abcdef") == ""
+}
@@ -0,0 +1,63 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mail/config"
+	"strings"
+	"testing"
+)
+
+// testStore проверяет контракт Store на любой реализации.
+func testStore(t *testing.T, store Store) {
+	t.Helper()
+	ctx := context.Background()
+	key := NewKey()
+	data := []byte("%PDF-1.4 quarterly report")
+
+	if err := store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), "application/pdf"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("got %q, %v want %q", got, err, data)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v want %v after delete", err, ErrNotFound)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("second delete: %v", err)
+	}
+	if err := store.Put(ctx, "../../etc/passwd", strings.NewReader("x"), 1, ""); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("got %v want %v for path traversal key", err, ErrInvalidKey)
+	}
+}
+
+func TestFSStore(t *testing.T) {
+	store, err := New(config.AttachmentsConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestUnknownStorage(t *testing.T) {
+	if _, err := New(config.AttachmentsConfig{Storage: "ftp"}); err == nil {
+		t.Error("expected error for unknown storage")
+	}
+}
@@ -0,0 +1,74 @@
+// Package linktoken выпускает подписанные ссылки с ограниченным сроком
+// действия: подтверждение адреса, сброс пароля и т.п.
+package linktoken
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalid = errors.New("invalid link token")
+	ErrExpired = errors.New("link token expired")
+)
+
+// Signer подписывает токены ключом HMAC-SHA256.
+type Signer struct {
+	key []byte
+}
+
+// NewSigner создаёт подпись с ключом secret. Длину секрета проверяет
+// config.GetConfig.
+func NewSigner(secret string) *Signer {
+	return &Signer{key: []byte(secret)}
+}
+
+// Sign выпускает токен для subject, действующий до expires. purpose
+// разделяет назначения: токен подтверждения адреса не подойдёт для
+// сброса пароля.
+func (s *Signer) Sign(purpose string, subject string, expires time.Time) string {
+	payload := strconv.FormatInt(expires.Unix(), 10) + ":" + subject
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(s.mac(purpose, payload))
+}
+
+// Verify проверяет подпись и срок токена и возвращает его subject.
+func (s *Signer) Verify(purpose string, token string, now time.Time) (string, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrInvalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, s.mac(purpose, string(payload))) {
+		return "", ErrInvalid
+	}
+	expires, subject, ok := strings.Cut(string(payload), ":")
+	if !ok {
+		return "", ErrInvalid
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return "", ErrInvalid
+	}
+	if !now.Before(time.Unix(unix, 0)) {
+		return "", ErrExpired
+	}
+	return subject, nil
+}
+
+func (s *Signer) mac(purpose string, payload string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(purpose))
+	h.Write([]byte{0})
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
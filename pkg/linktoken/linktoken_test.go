@@ -0,0 +1,36 @@
+package linktoken
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	signer := NewSigner("secret")
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	token := signer.Sign("verify-email", "user@giga-mail.ru", now.Add(time.Hour))
+
+	subject, err := signer.Verify("verify-email", token, now)
+	if err != nil || subject != "user@giga-mail.ru" {
+		t.Fatalf("got %q, %v", subject, err)
+	}
+	if _, err := signer.Verify("verify-email", token, now.Add(time.Hour)); !errors.Is(err, ErrExpired) {
+		t.Errorf("expired token: %v", err)
+	}
+	if _, err := signer.Verify("reset-password", token, now); !errors.Is(err, ErrInvalid) {
+		t.Errorf("other purpose: %v", err)
+	}
+	if _, err := NewSigner("other").Verify("verify-email", token, now); !errors.Is(err, ErrInvalid) {
+		t.Errorf("other key: %v", err)
+	}
+	forged := NewSigner("secret").Sign("verify-email", "admin@giga-mail.ru", now.Add(time.Hour))
+	if _, err := signer.Verify("verify-email", forged[:len(forged)-2]+token[len(token)-2:], now); !errors.Is(err, ErrInvalid) {
+		t.Errorf("tampered token: %v", err)
+	}
+	for _, token := range []string{"", "abc", "abc.def", "!!.??"} {
+		if _, err := signer.Verify("verify-email", token, now); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%q: %v", token, err)
+		}
+	}
+}
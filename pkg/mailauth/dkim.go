@@ -0,0 +1,88 @@
+package mailauth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// dkimKeyBits - размер ключа RSA. RFC 8301 требует не меньше 1024 бит,
+// крупные почтовые сервисы ожидают 2048.
+const dkimKeyBits = 2048
+
+var ErrMalformedKey = errors.New("malformed dkim private key")
+
+// signedHeaders - заголовки, которые подписываются (RFC 6376, 5.4.1).
+// From указан дважды, чтобы к письму нельзя было добавить второй From.
+var signedHeaders = []string{
+	"From", "From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+}
+
+// GenerateKey создаёт ключ RSA для DKIM и возвращает его в PEM.
+func GenerateKey() ([]byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, dkimKeyBits)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), nil
+}
+
+func parseKey(privateKey []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(privateKey)
+	if block == nil {
+		return nil, ErrMalformedKey
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, ErrMalformedKey
+	}
+	return key, nil
+}
+
+// DNSName - имя TXT-записи, в которой публикуется ключ.
+func DNSName(domain string, selector string) string {
+	return selector + "._domainkey." + domain
+}
+
+// DNSRecord - текст TXT-записи с открытым ключом.
+func DNSRecord(privateKey []byte) (string, error) {
+	key, err := parseKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(public), nil
+}
+
+// Sign добавляет к письму заголовок DKIM-Signature с подписью ключом
+// домена. Заголовки и тело приводятся к виду relaxed, который
+// переживает переформатирование заголовков релеями.
+func Sign(data []byte, domain string, selector string, privateKey []byte) ([]byte, error) {
+	key, err := parseKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	var signed bytes.Buffer
+	err = dkim.Sign(&signed, bytes.NewReader(data), &dkim.SignOptions{
+		Domain:                 domain,
+		Selector:               selector,
+		Signer:                 key,
+		HeaderCanonicalization: dkim.CanonicalizationRelaxed,
+		BodyCanonicalization:   dkim.CanonicalizationRelaxed,
+		HeaderKeys:             signedHeaders,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return signed.Bytes(), nil
+}
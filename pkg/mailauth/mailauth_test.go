@@ -0,0 +1,127 @@
+package mailauth
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-msgauth/authres"
+	"github.com/emersion/go-msgauth/dmarc"
+)
+
+const testMessage = "From: Mark <mark@example.com>\r\n" +
+	"To: jane@giga-mail.ru\r\n" +
+	"Subject: Report\r\n" +
+	"Date: Fri, 18 Oct 2024 10:00:00 +0300\r\n" +
+	"Message-ID: <1@example.com>\r\n" +
+	"\r\n" +
+	"Please review the report.\r\n"
+
+func TestSPF(t *testing.T) {
+	resolver := StaticResolver{
+		TXT: map[string][]string{
+			"example.com":          {"google-site-verification=x", "v=spf1 ip4:192.0.2.0/24 include:_spf.example.net mx a:relay.example.com/30 -all"},
+			"_spf.example.net":     {"v=spf1 ip6:2001:db8::/32 ~all"},
+			"soft.example.com":     {"v=spf1 ~all"},
+			"redirect.example.com": {"v=spf1 redirect=example.com"},
+			"macro.example.com":    {"v=spf1 exists:%{ir}.%{l1r-}.spf.example.com -all"},
+			"double.example.com":   {"v=spf1 -all", "v=spf1 +all"},
+			"loop.example.com":     {"v=spf1 include:loop.example.com -all"},
+			"void.example.com":     {"v=spf1 a:a.void.example.com a:b.void.example.com a:c.void.example.com -all"},
+			"broken.example.com":   {"v=spf1 ip4:300.0.0.1 -all"},
+		},
+		IP: map[string][]string{
+			"mx1.example.com":                {"198.51.100.10"},
+			"relay.example.com":              {"203.0.113.1"},
+			"4.3.2.203.mark.spf.example.com": {"127.0.0.2"},
+		},
+		MX: map[string][]string{"example.com": {"mx1.example.com"}},
+	}
+	for _, tc := range []struct {
+		ip     string
+		sender string
+		want   SPFResult
+	}{
+		{"192.0.2.15", "mark@example.com", SPFPass},
+		{"198.51.100.10", "mark@example.com", SPFPass},
+		{"203.0.113.3", "mark@example.com", SPFPass},
+		{"203.0.113.4", "mark@example.com", SPFFail},
+		{"2001:db8::1", "mark@example.com", SPFPass},
+		{"2001:db9::1", "mark@example.com", SPFFail},
+		{"192.0.2.15", "mark@soft.example.com", SPFSoftFail},
+		{"192.0.2.15", "mark@redirect.example.com", SPFPass},
+		{"203.2.3.4", "mark-news@macro.example.com", SPFPass},
+		{"203.2.3.5", "mark@macro.example.com", SPFFail},
+		{"192.0.2.15", "mark@nospf.example.org", SPFNone},
+		{"192.0.2.15", "mark@double.example.com", SPFPermError},
+		{"192.0.2.15", "mark@loop.example.com", SPFPermError},
+		{"192.0.2.15", "mark@void.example.com", SPFPermError},
+		{"192.0.2.15", "mark@broken.example.com", SPFPermError},
+	} {
+		got, _ := CheckSPF(context.Background(), resolver, net.ParseIP(tc.ip), "mx.example.com", tc.sender)
+		if got != tc.want {
+			t.Errorf("%s from %s: got %s want %s", tc.sender, tc.ip, got, tc.want)
+		}
+	}
+	if _, domain := CheckSPF(context.Background(), resolver, net.ParseIP("192.0.2.15"), "mx.example.com", ""); domain != "mx.example.com" {
+		t.Errorf("empty sender checked %q instead of HELO", domain)
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := DNSRecord(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := Sign([]byte(testMessage), "example.com", "s1", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(signed), "DKIM-Signature: ") || !strings.HasSuffix(string(signed), testMessage) {
+		t.Fatalf("unexpected signed message: %q", signed)
+	}
+
+	resolver := StaticResolver{TXT: map[string][]string{
+		DNSName("example.com", "s1"): {record},
+		"_dmarc.example.com":         {"v=DMARC1; p=reject; sp=quarantine"},
+	}}
+	verifier := NewVerifier(resolver, "mx.giga-mail.ru")
+	// SPF не проходит, DMARC проходит за счёт подписи
+	results := verifier.Verify(context.Background(), net.ParseIP("192.0.2.1"), "mx.example.com", "mark@example.com", signed)
+	if results.SPF != SPFNone || len(results.DKIM) != 1 || results.DKIM[0].Result != authres.ResultPass ||
+		results.DMARC != authres.ResultPass || results.Policy != "" {
+		t.Errorf("unexpected results for signed message: %+v", results)
+	}
+	header := results.Header("mx.giga-mail.ru")
+	if !strings.HasPrefix(header, "Authentication-Results: mx.giga-mail.ru; spf=none") ||
+		!strings.Contains(header, "dkim=pass header.d=example.com") || !strings.Contains(header, "dmarc=pass header.from=example.com") {
+		t.Errorf("unexpected header: %s", header)
+	}
+
+	tampered := strings.Replace(string(signed), "Please review", "Please pay", 1)
+	results = verifier.Verify(context.Background(), net.ParseIP("192.0.2.1"), "mx.example.com", "mark@example.com", []byte(tampered))
+	if results.DKIM[0].Result != authres.ResultFail || results.DMARC != authres.ResultFail || results.Policy != dmarc.PolicyReject {
+		t.Errorf("unexpected results for tampered message: %+v", results)
+	}
+
+	// у поддомена нет своей записи: действует sp организационного домена
+	fromSubdomain := strings.Replace(testMessage, "mark@example.com", "mark@news.example.com", 1)
+	results = verifier.Verify(context.Background(), net.ParseIP("192.0.2.1"), "mx.example.com", "", []byte(fromSubdomain))
+	if results.DMARC != authres.ResultFail || results.Policy != dmarc.PolicyQuarantine {
+		t.Errorf("unexpected results for subdomain: %+v", results)
+	}
+}
+
+func TestStripResults(t *testing.T) {
+	data := "Authentication-Results: MX.giga-mail.ru; spf=pass\r\n\tsmtp.mailfrom=example.com\r\n" +
+		"Authentication-Results: mx.example.com; spf=pass\r\n" + testMessage
+	got := string(StripResults([]byte(data), "mx.giga-mail.ru"))
+	if got != "Authentication-Results: mx.example.com; spf=pass\r\n"+testMessage {
+		t.Errorf("unexpected message: %q", got)
+	}
+}
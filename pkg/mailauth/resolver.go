@@ -0,0 +1,67 @@
+package mailauth
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// Resolver - запросы DNS, нужные для SPF, DKIM и DMARC. net.Resolver
+// подходит как есть.
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// StaticResolver отвечает из таблиц без обращения к сети: для тестов
+// и работы без DNS. Имена, которых нет в таблицах, не существуют.
+type StaticResolver struct {
+	TXT map[string][]string
+	IP  map[string][]string
+	MX  map[string][]string
+}
+
+func notFound(name string) error {
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+func (r StaticResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if records, ok := r.TXT[normalizeName(name)]; ok {
+		return records, nil
+	}
+	return nil, notFound(name)
+}
+
+func (r StaticResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs, ok := r.IP[normalizeName(host)]
+	if !ok {
+		return nil, notFound(host)
+	}
+	result := make([]net.IPAddr, 0, len(addrs))
+	for _, addr := range addrs {
+		result = append(result, net.IPAddr{IP: net.ParseIP(addr)})
+	}
+	return result, nil
+}
+
+func (r StaticResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	hosts, ok := r.MX[normalizeName(name)]
+	if !ok {
+		return nil, notFound(name)
+	}
+	result := make([]*net.MX, 0, len(hosts))
+	for i, host := range hosts {
+		result = append(result, &net.MX{Host: host, Pref: uint16(10 * (i + 1))})
+	}
+	return result, nil
+}
+
+func isNotFound(err error) bool {
+	dnsErr, ok := err.(*net.DNSError)
+	return ok && dnsErr.IsNotFound
+}
@@ -0,0 +1,429 @@
+package mailauth
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SPFResult - результат проверки SPF (RFC 7208, 2.6).
+type SPFResult string
+
+const (
+	SPFNone      SPFResult = "none"
+	SPFNeutral   SPFResult = "neutral"
+	SPFPass      SPFResult = "pass"
+	SPFFail      SPFResult = "fail"
+	SPFSoftFail  SPFResult = "softfail"
+	SPFTempError SPFResult = "temperror"
+	SPFPermError SPFResult = "permerror"
+)
+
+// Ограничения RFC 7208, 4.6.4 на запросы DNS за одну проверку.
+const (
+	maxSPFLookups     = 10
+	maxSPFVoidLookups = 2
+	maxSPFMXHosts     = 10
+)
+
+var spfModifier = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9._-]*)=(.*)$`)
+
+type spfCheck struct {
+	ctx      context.Context
+	resolver Resolver
+	ip       net.IP
+	helo     string
+	sender   string
+	lookups  int
+	voids    int
+}
+
+// CheckSPF проверяет, может ли ip отправлять почту от имени домена
+// адреса sender из MAIL FROM. Для пустого обратного адреса проверяется
+// имя из HELO. Возвращает результат и проверенный домен.
+func CheckSPF(ctx context.Context, resolver Resolver, ip net.IP, helo string, sender string) (SPFResult, string) {
+	if sender == "" {
+		sender = "postmaster@" + helo
+	} else if !strings.Contains(sender, "@") {
+		sender = "postmaster@" + sender
+	}
+	domain := domainOf(sender)
+	c := &spfCheck{ctx: ctx, resolver: resolver, ip: ip, helo: helo, sender: sender}
+	return c.check(domain), domain
+}
+
+func domainOf(address string) string {
+	return strings.ToLower(address[strings.LastIndex(address, "@")+1:])
+}
+
+// check вычисляет check_host() для домена.
+func (c *spfCheck) check(domain string) SPFResult {
+	record, result := c.record(domain)
+	if result != "" {
+		return result
+	}
+	var redirect string
+	for _, term := range strings.Fields(record)[1:] {
+		if m := spfModifier.FindStringSubmatch(term); m != nil {
+			// exp и неизвестные модификаторы не влияют на результат
+			if strings.EqualFold(m[1], "redirect") {
+				if redirect != "" {
+					return SPFPermError
+				}
+				redirect = m[2]
+			}
+			continue
+		}
+		qualifier := SPFPass
+		switch term[0] {
+		case '+':
+			term = term[1:]
+		case '-':
+			qualifier, term = SPFFail, term[1:]
+		case '~':
+			qualifier, term = SPFSoftFail, term[1:]
+		case '?':
+			qualifier, term = SPFNeutral, term[1:]
+		}
+		matched, result := c.match(domain, term)
+		if result != "" {
+			return result
+		}
+		if matched {
+			return qualifier
+		}
+	}
+	if redirect == "" {
+		return SPFNeutral
+	}
+	if !c.count() {
+		return SPFPermError
+	}
+	target, ok := c.expand(redirect, domain)
+	if !ok {
+		return SPFPermError
+	}
+	result = c.check(target)
+	if result == SPFNone {
+		return SPFPermError
+	}
+	return result
+}
+
+// record находит единственную запись v=spf1 домена.
+func (c *spfCheck) record(domain string) (string, SPFResult) {
+	txts, err := c.resolver.LookupTXT(c.ctx, domain)
+	if isNotFound(err) {
+		return "", SPFNone
+	}
+	if err != nil {
+		return "", SPFTempError
+	}
+	var records []string
+	for _, txt := range txts {
+		lower := strings.ToLower(txt)
+		if lower == "v=spf1" || strings.HasPrefix(lower, "v=spf1 ") {
+			records = append(records, txt)
+		}
+	}
+	switch len(records) {
+	case 0:
+		return "", SPFNone
+	case 1:
+		return records[0], ""
+	}
+	return "", SPFPermError
+}
+
+// count учитывает механизм, который обращается к DNS.
+func (c *spfCheck) count() bool {
+	c.lookups++
+	return c.lookups <= maxSPFLookups
+}
+
+// match проверяет механизм. Непустой SPFResult - ошибка, прерывающая
+// проверку.
+func (c *spfCheck) match(domain string, term string) (bool, SPFResult) {
+	name := term
+	rest := ""
+	if i := strings.IndexAny(term, ":/"); i >= 0 {
+		name, rest = term[:i], term[i:]
+	}
+	name = strings.ToLower(name)
+
+	switch name {
+	case "all":
+		return rest == "", spfErrorIf(rest != "")
+	case "ip4", "ip6":
+		_, network, err := net.ParseCIDR(strings.TrimPrefix(rest, ":"))
+		if err != nil {
+			if ip := net.ParseIP(strings.TrimPrefix(rest, ":")); ip != nil {
+				return ip.Equal(c.ip), ""
+			}
+			return false, SPFPermError
+		}
+		return network.Contains(c.ip), ""
+	}
+
+	if !c.count() {
+		return false, SPFPermError
+	}
+	target, cidr, _ := strings.Cut(rest, "/")
+	if cidr != "" {
+		cidr = "/" + cidr
+	}
+	target = strings.TrimPrefix(target, ":")
+	if target == "" {
+		if name == "include" || name == "exists" {
+			return false, SPFPermError
+		}
+		target = domain
+	} else {
+		var ok bool
+		if target, ok = c.expand(target, domain); !ok {
+			return false, SPFPermError
+		}
+	}
+
+	switch name {
+	case "include":
+		switch c.check(target) {
+		case SPFPass:
+			return true, ""
+		case SPFTempError:
+			return false, SPFTempError
+		case SPFPermError, SPFNone:
+			return false, SPFPermError
+		}
+		return false, ""
+	case "exists":
+		addrs, result := c.lookupIP(target)
+		return len(addrs) > 0, result
+	case "a":
+		v4, v6, ok := parseDualCIDR(cidr)
+		if !ok {
+			return false, SPFPermError
+		}
+		addrs, result := c.lookupIP(target)
+		return c.matchAddrs(addrs, v4, v6), result
+	case "mx":
+		v4, v6, ok := parseDualCIDR(cidr)
+		if !ok {
+			return false, SPFPermError
+		}
+		hosts, err := c.resolver.LookupMX(c.ctx, target)
+		if err != nil && !isNotFound(err) {
+			return false, SPFTempError
+		}
+		if len(hosts) == 0 {
+			return false, c.void()
+		}
+		if len(hosts) > maxSPFMXHosts {
+			return false, SPFPermError
+		}
+		for _, mx := range hosts {
+			addrs, result := c.lookupIP(mx.Host)
+			if result != "" {
+				return false, result
+			}
+			if c.matchAddrs(addrs, v4, v6) {
+				return true, ""
+			}
+		}
+		return false, ""
+	case "ptr":
+		// RFC 7208, 5.5: ptr не следует использовать, считаем, что он
+		// не совпал
+		return false, ""
+	}
+	return false, SPFPermError
+}
+
+func spfErrorIf(failed bool) SPFResult {
+	if failed {
+		return SPFPermError
+	}
+	return ""
+}
+
+// lookupIP учитывает пустые ответы: их больше двух - ошибка записи.
+func (c *spfCheck) lookupIP(host string) ([]net.IPAddr, SPFResult) {
+	addrs, err := c.resolver.LookupIPAddr(c.ctx, host)
+	if err != nil && !isNotFound(err) {
+		return nil, SPFTempError
+	}
+	if len(addrs) == 0 {
+		return nil, c.void()
+	}
+	return addrs, ""
+}
+
+func (c *spfCheck) void() SPFResult {
+	c.voids++
+	return spfErrorIf(c.voids > maxSPFVoidLookups)
+}
+
+func (c *spfCheck) matchAddrs(addrs []net.IPAddr, v4 int, v6 int) bool {
+	for _, addr := range addrs {
+		bits, size := v6, 128
+		if addr.IP.To4() != nil {
+			bits, size = v4, 32
+		}
+		if (addr.IP.To4() == nil) != (c.ip.To4() == nil) {
+			continue
+		}
+		network := net.IPNet{IP: addr.IP.Mask(net.CIDRMask(bits, size)), Mask: net.CIDRMask(bits, size)}
+		if network.Contains(c.ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDualCIDR разбирает суффикс /24//64 механизмов a и mx.
+func parseDualCIDR(s string) (int, int, bool) {
+	v4, v6 := 32, 128
+	if s == "" {
+		return v4, v6, true
+	}
+	first, second, dual := strings.Cut(strings.TrimPrefix(s, "/"), "//")
+	if strings.HasPrefix(s, "//") {
+		first, second, dual = "", strings.TrimPrefix(s, "//"), true
+	}
+	var err error
+	if first != "" {
+		if v4, err = strconv.Atoi(first); err != nil || v4 < 0 || v4 > 32 {
+			return 0, 0, false
+		}
+	}
+	if dual {
+		if v6, err = strconv.Atoi(second); err != nil || v6 < 0 || v6 > 128 {
+			return 0, 0, false
+		}
+	}
+	return v4, v6, true
+}
+
+// expand раскрывает макросы RFC 7208, 7 в domain-spec.
+func (c *spfCheck) expand(spec string, domain string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(spec); i++ {
+		if spec[i] != '%' {
+			b.WriteByte(spec[i])
+			continue
+		}
+		if i+1 >= len(spec) {
+			return "", false
+		}
+		i++
+		switch spec[i] {
+		case '%':
+			b.WriteByte('%')
+			continue
+		case '_':
+			b.WriteByte(' ')
+			continue
+		case '-':
+			b.WriteString("%20")
+			continue
+		case '{':
+		default:
+			return "", false
+		}
+		end := strings.IndexByte(spec[i:], '}')
+		if end < 2 {
+			return "", false
+		}
+		value, ok := c.macro(spec[i+1:i+end], domain)
+		if !ok {
+			return "", false
+		}
+		b.WriteString(value)
+		i += end
+	}
+	return strings.TrimSuffix(b.String(), "."), true
+}
+
+// macro вычисляет один макрос: букву, число оставляемых справа частей,
+// r для обратного порядка и разделители.
+func (c *spfCheck) macro(macro string, domain string) (string, bool) {
+	var value string
+	switch macro[0] {
+	case 's', 'S':
+		value = c.sender
+	case 'l', 'L':
+		value = c.sender[:strings.LastIndex(c.sender, "@")]
+	case 'o', 'O':
+		value = domainOf(c.sender)
+	case 'd', 'D':
+		value = domain
+	case 'h', 'H':
+		value = c.helo
+	case 'i', 'I':
+		value = dottedIP(c.ip)
+	case 'v', 'V':
+		value = "in-addr"
+		if c.ip.To4() == nil {
+			value = "ip6"
+		}
+	case 'p', 'P':
+		value = "unknown"
+	default:
+		return "", false
+	}
+
+	rest := macro[1:]
+	digits := 0
+	for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+		digits++
+	}
+	keep := 0
+	if digits > 0 {
+		keep, _ = strconv.Atoi(rest[:digits])
+		if keep == 0 {
+			return "", false
+		}
+	}
+	rest = rest[digits:]
+	reverse := strings.HasPrefix(rest, "r") || strings.HasPrefix(rest, "R")
+	if reverse {
+		rest = rest[1:]
+	}
+	delimiters := "."
+	if rest != "" {
+		if strings.Trim(rest, ".-+,/_=") != "" {
+			return "", false
+		}
+		delimiters = rest
+	}
+	if digits == 0 && !reverse && rest == "" {
+		return value, true
+	}
+
+	parts := strings.FieldsFunc(value, func(r rune) bool { return strings.ContainsRune(delimiters, r) })
+	if reverse {
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+	}
+	if keep > 0 && keep < len(parts) {
+		parts = parts[len(parts)-keep:]
+	}
+	return strings.Join(parts, "."), true
+}
+
+// dottedIP - адрес для макроса %{i}: IPv4 как есть, IPv6 полубайтами
+// через точку.
+func dottedIP(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.String()
+	}
+	const hex = "0123456789abcdef"
+	nibbles := make([]string, 0, 32)
+	for _, b := range ip.To16() {
+		nibbles = append(nibbles, string(hex[b>>4]), string(hex[b&0xf]))
+	}
+	return strings.Join(nibbles, ".")
+}
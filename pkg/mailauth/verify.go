@@ -0,0 +1,222 @@
+package mailauth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/emersion/go-msgauth/authres"
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/emersion/go-msgauth/dmarc"
+	"golang.org/x/net/publicsuffix"
+)
+
+// maxDKIMSignatures - сколько подписей одного письма проверяется.
+const maxDKIMSignatures = 5
+
+// DKIMResult - результат проверки одной подписи.
+type DKIMResult struct {
+	Domain string
+	Result authres.ResultValue
+	Reason string
+}
+
+// Results - итог проверки входящего письма. Policy - действие, которое
+// домен отправителя просит выполнить с письмом, не прошедшим DMARC:
+// dmarc.PolicyQuarantine, dmarc.PolicyReject или пусто.
+type Results struct {
+	SPF        SPFResult
+	SPFDomain  string
+	DKIM       []DKIMResult
+	DMARC      authres.ResultValue
+	FromDomain string
+	Policy     dmarc.Policy
+}
+
+// Verifier проверяет SPF, DKIM и DMARC входящих писем. hostname - имя
+// сервера в заголовке Authentication-Results (authserv-id).
+type Verifier struct {
+	resolver Resolver
+	hostname string
+}
+
+func NewVerifier(resolver Resolver, hostname string) *Verifier {
+	return &Verifier{resolver: resolver, hostname: hostname}
+}
+
+func (v *Verifier) Hostname() string {
+	return v.hostname
+}
+
+// Verify проверяет письмо data, полученное от ip с приветствием helo
+// и обратным адресом mailFrom.
+func (v *Verifier) Verify(ctx context.Context, ip net.IP, helo string, mailFrom string, data []byte) Results {
+	var results Results
+	results.SPF, results.SPFDomain = CheckSPF(ctx, v.resolver, ip, helo, mailFrom)
+
+	lookupTXT := func(name string) ([]string, error) {
+		return v.resolver.LookupTXT(ctx, name)
+	}
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(data), &dkim.VerifyOptions{
+		LookupTXT:        lookupTXT,
+		MaxVerifications: maxDKIMSignatures,
+	})
+	if err != nil && !errors.Is(err, dkim.ErrTooManySignatures) {
+		results.DKIM = append(results.DKIM, DKIMResult{Result: authres.ResultPermError, Reason: err.Error()})
+	}
+	for _, verification := range verifications {
+		result := DKIMResult{Domain: strings.ToLower(verification.Domain), Result: authres.ResultPass}
+		switch {
+		case verification.Err == nil:
+		case dkim.IsTempFail(verification.Err):
+			result.Result, result.Reason = authres.ResultTempError, verification.Err.Error()
+		case dkim.IsPermFail(verification.Err):
+			result.Result, result.Reason = authres.ResultPermError, verification.Err.Error()
+		default:
+			result.Result, result.Reason = authres.ResultFail, verification.Err.Error()
+		}
+		results.DKIM = append(results.DKIM, result)
+	}
+
+	results.FromDomain = headerFromDomain(data)
+	results.DMARC, results.Policy = v.dmarc(results, lookupTXT)
+	return results
+}
+
+// HeaderFromDomain - домен единственного адреса в заголовке From или
+// пустая строка, если адресов нет или их несколько.
+func headerFromDomain(data []byte) string {
+	header, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(data))).ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return ""
+	}
+	if len(header.Values("From")) != 1 {
+		return ""
+	}
+	addresses, err := mail.ParseAddressList(header.Get("From"))
+	if err != nil || len(addresses) != 1 {
+		return ""
+	}
+	return domainOf(addresses[0].Address)
+}
+
+// dmarc оценивает письмо по политике домена из From (RFC 7489, 6.6).
+// Если у домена нет своей записи, берётся запись организационного
+// домена с политикой для поддоменов.
+func (v *Verifier) dmarc(results Results, lookupTXT func(string) ([]string, error)) (authres.ResultValue, dmarc.Policy) {
+	domain := results.FromDomain
+	if domain == "" {
+		return authres.ResultPermError, ""
+	}
+	options := &dmarc.LookupOptions{LookupTXT: lookupTXT}
+	record, err := dmarc.LookupWithOptions(domain, options)
+	policy := dmarc.Policy("")
+	if errors.Is(err, dmarc.ErrNoPolicy) {
+		if org := organizationalDomain(domain); org != domain {
+			record, err = dmarc.LookupWithOptions(org, options)
+			if err == nil {
+				policy = record.SubdomainPolicy
+			}
+		}
+	}
+	switch {
+	case errors.Is(err, dmarc.ErrNoPolicy):
+		return authres.ResultNone, ""
+	case dmarc.IsTempFail(err):
+		return authres.ResultTempError, ""
+	case err != nil:
+		return authres.ResultPermError, ""
+	}
+	if policy == "" {
+		policy = record.Policy
+	}
+
+	if results.SPF == SPFPass && aligned(results.SPFDomain, domain, record.SPFAlignment) {
+		return authres.ResultPass, ""
+	}
+	for _, signature := range results.DKIM {
+		if signature.Result == authres.ResultPass && aligned(signature.Domain, domain, record.DKIMAlignment) {
+			return authres.ResultPass, ""
+		}
+	}
+
+	if policy == dmarc.PolicyNone {
+		return authres.ResultFail, ""
+	}
+	// pct: политика применяется только к части писем, остальные
+	// обрабатываются на ступень мягче (RFC 7489, 6.6.4)
+	if record.Percent != nil && rand.Intn(100) >= *record.Percent {
+		if policy == dmarc.PolicyReject {
+			return authres.ResultFail, dmarc.PolicyQuarantine
+		}
+		return authres.ResultFail, ""
+	}
+	return authres.ResultFail, policy
+}
+
+func aligned(domain string, fromDomain string, mode dmarc.AlignmentMode) bool {
+	if domain == "" {
+		return false
+	}
+	if mode == dmarc.AlignmentStrict {
+		return strings.EqualFold(domain, fromDomain)
+	}
+	return organizationalDomain(domain) == organizationalDomain(fromDomain)
+}
+
+func organizationalDomain(domain string) string {
+	domain = strings.ToLower(domain)
+	org, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return domain
+	}
+	return org
+}
+
+// Header - заголовок Authentication-Results с результатами (RFC 8601).
+func (r Results) Header(hostname string) string {
+	values := []authres.Result{
+		&authres.SPFResult{Value: authres.ResultValue(r.SPF), From: r.SPFDomain},
+	}
+	if len(r.DKIM) == 0 {
+		values = append(values, &authres.DKIMResult{Value: authres.ResultNone})
+	}
+	for _, signature := range r.DKIM {
+		values = append(values, &authres.DKIMResult{Value: signature.Result, Reason: signature.Reason, Domain: signature.Domain})
+	}
+	values = append(values, &authres.DMARCResult{Value: r.DMARC, From: r.FromDomain})
+	return "Authentication-Results: " + authres.Format(hostname, values) + "\r\n"
+}
+
+// StripResults удаляет из заголовка письма Authentication-Results
+// с authserv-id hostname: их мог подделать отправитель (RFC 8601, 5).
+func StripResults(data []byte, hostname string) []byte {
+	end := bytes.Index(data, []byte("\r\n\r\n"))
+	if end < 0 {
+		return data
+	}
+	var result bytes.Buffer
+	result.Grow(len(data))
+	skip := false
+	for _, line := range bytes.SplitAfter(data[:end+2], []byte("\r\n")) {
+		if len(line) > 0 && line[0] != ' ' && line[0] != '\t' {
+			skip = false
+			name, value, ok := bytes.Cut(line, []byte(":"))
+			if ok && strings.EqualFold(string(bytes.TrimSpace(name)), "Authentication-Results") {
+				id, _, _ := strings.Cut(string(value), ";")
+				skip = strings.EqualFold(strings.TrimSpace(id), hostname)
+			}
+		}
+		if !skip {
+			result.Write(line)
+		}
+	}
+	result.Write(data[end+2:])
+	return result.Bytes()
+}
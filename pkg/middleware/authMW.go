@@ -2,33 +2,138 @@ package middleware
 
 import (
 	"context"
+	"log/slog"
+	"mail/config"
 	"mail/database"
+	"net"
 	"net/http"
+	"time"
 )
 
 type contextKey string
 
 const Key = contextKey("session")
 
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Пропуск аутентификации для предзапросов CORS
-		if r.Method == http.MethodOptions {
-			next.ServeHTTP(w, r)
-			return
-		}
-
-		cookie, err := r.Cookie("session")
-		if err != nil || !checkAuthorization(cookie.Value) {
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-		ctx := context.WithValue(r.Context(), Key, database.UserHash[cookie.Value])
-		next.ServeHTTP(w, r.WithContext(ctx))
+const (
+	SessionCookie      = "session"
+	DefaultSessionTTL  = 30 * 24 * time.Hour
+	DefaultIdleTimeout = 24 * time.Hour
+	// touchInterval - как часто записывать продление сессии, чтобы не
+	// обновлять базу на каждый запрос.
+	touchInterval = time.Minute
+)
+
+// SessionExpiry - срок сессии, созданной в created, после запроса в now.
+func SessionExpiry(cfg config.SessionConfig, created time.Time, now time.Time) time.Time {
+	ttl, idle := cfg.TTL, cfg.IdleTimeout
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+	if idle <= 0 {
+		idle = DefaultIdleTimeout
+	}
+	expires := now.Add(idle)
+	if limit := created.Add(ttl); limit.Before(expires) {
+		return limit
+	}
+	return expires
+}
+
+// SessionCookieName - имя куки сессии. С Secure используется префикс
+// __Host-: браузер примет такую куку только по HTTPS, с Path=/ и без
+// Domain, поэтому её нельзя подменить с поддомена.
+func SessionCookieName(cfg config.SessionConfig) string {
+	if cfg.Secure {
+		return "__Host-" + SessionCookie
+	}
+	return SessionCookie
+}
+
+func sameSite(cfg config.SessionConfig) http.SameSite {
+	switch cfg.SameSite {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteLaxMode
+}
+
+func SetSessionCookie(w http.ResponseWriter, cfg config.SessionConfig, hash string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName(cfg),
+		Value:    hash,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   cfg.Secure,
+		HttpOnly: true,
+		SameSite: sameSite(cfg),
 	})
 }
 
-func checkAuthorization(hash string) bool {
-	_, exists := database.UserHash[hash]
-	return exists
+func ClearSessionCookie(w http.ResponseWriter, cfg config.SessionConfig) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName(cfg),
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   cfg.Secure,
+		HttpOnly: true,
+		SameSite: sameSite(cfg),
+	})
+}
+
+// SessionHash возвращает хэш сессии из куки запроса или пустую строку.
+func SessionHash(r *http.Request, cfg config.SessionConfig) string {
+	cookie, err := r.Cookie(SessionCookieName(cfg))
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// ClientIP - адрес клиента без порта.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// AuthMiddleware пускает запросы с действующей сессией и продлевает её:
+// срок отсчитывается от последнего запроса, кука выдаётся заново.
+func AuthMiddleware(sessions database.SessionRepository, cfg config.SessionConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Пропуск аутентификации для предзапросов CORS
+			if r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			hash := SessionHash(r, cfg)
+			if hash == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			session, err := sessions.Get(r.Context(), hash)
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			now := time.Now()
+			if now.Sub(session.LastSeenAt) >= touchInterval {
+				expires := SessionExpiry(cfg, session.CreatedAt, now)
+				// сбой продления не мешает запросу, сессия ещё действует
+				if err := sessions.Touch(r.Context(), session.Hash, ClientIP(r), now, expires); err != nil {
+					slog.Error("failed to renew session", "error", err)
+				} else {
+					SetSessionCookie(w, cfg, session.Hash, expires)
+				}
+			}
+			ctx := context.WithValue(r.Context(), Key, session.Email)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
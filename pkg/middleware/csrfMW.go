@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"mail/config"
+	"net/http"
+	"net/url"
+)
+
+// CSRFHeader - заголовок, в котором фронтенд передаёт CSRF-токен.
+const CSRFHeader = "X-CSRF-Token"
+
+// CSRFKey выводит ключ CSRF-токенов из секрета account.secret.
+func CSRFKey(secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("csrf"))
+	return mac.Sum(nil)
+}
+
+// CSRFToken - токен сессии hash. Он вычисляется из хэша сессии, поэтому
+// не хранится и перестаёт подходить, когда сессия завершена.
+func CSRFToken(key []byte, hash string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(hash))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func csrfFailed(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": http.StatusForbidden, "body": "csrf_failed"})
+}
+
+// CheckOrigin отклоняет изменяющие запросы, пришедшие со страниц чужих
+// сайтов. Запросы без Origin (не из браузера) пропускаются.
+func CheckOrigin(allowed []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if isSafeMethod(r.Method) || origin == "" || originAllowed(origin, r.Host, allowed) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			csrfFailed(w)
+		})
+	}
+}
+
+func originAllowed(origin string, host string, allowed []string) bool {
+	for _, a := range allowed {
+		if origin == a {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == host
+}
+
+// CSRF требует для изменяющих запросов с сессией токен этой сессии в
+// заголовке X-CSRF-Token. Ставится после AuthMiddleware.
+func CSRF(key []byte, cfg config.SessionConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isSafeMethod(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			expected := CSRFToken(key, SessionHash(r, cfg))
+			if !hmac.Equal([]byte(r.Header.Get(CSRFHeader)), []byte(expected)) {
+				csrfFailed(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
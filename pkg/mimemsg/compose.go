@@ -0,0 +1,200 @@
+package mimemsg
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"mail/database"
+	"net/textproto"
+
+	"github.com/emersion/go-message/mail"
+)
+
+// managedHeaders формируются из полей письма, одноимённые значения
+// из Message.Headers при сборке игнорируются. Bcc в письмо не попадает.
+var managedHeaders = map[string]bool{
+	"Date":                      true,
+	"From":                      true,
+	"To":                        true,
+	"Cc":                        true,
+	"Bcc":                       true,
+	"Subject":                   true,
+	"Message-Id":                true,
+	"Mime-Version":              true,
+	"Content-Type":              true,
+	"Content-Transfer-Encoding": true,
+	"Content-Disposition":       true,
+}
+
+// GenerateMessageID возвращает новый Message-ID без угловых скобок.
+func GenerateMessageID(hostname string) string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b) + "@" + hostname
+}
+
+func toMailAddresses(addresses []database.Address) []*mail.Address {
+	result := make([]*mail.Address, 0, len(addresses))
+	for _, a := range addresses {
+		result = append(result, &mail.Address{Name: a.Name, Address: a.Email})
+	}
+	return result
+}
+
+func composeHeader(message database.Message) mail.Header {
+	var h mail.Header
+	for key, values := range message.Headers {
+		if managedHeaders[textproto.CanonicalMIMEHeaderKey(key)] {
+			continue
+		}
+		for _, value := range values {
+			h.Add(key, value)
+		}
+	}
+	h.SetDate(message.Date)
+	h.SetAddressList("From", toMailAddresses([]database.Address{message.From}))
+	if len(message.To) > 0 {
+		h.SetAddressList("To", toMailAddresses(message.To))
+	}
+	if len(message.Cc) > 0 {
+		h.SetAddressList("Cc", toMailAddresses(message.Cc))
+	}
+	h.SetSubject(message.Subject)
+	if message.MessageID != "" {
+		h.SetMessageID(message.MessageID)
+	}
+	// заголовки из Headers остаются, если у письма нет своих ссылок
+	if len(message.References) > 0 {
+		h.SetMsgIDList("In-Reply-To", message.References[len(message.References)-1:])
+		h.SetMsgIDList("References", message.References)
+	}
+	return h
+}
+
+func writePart(w io.WriteCloser, body string) error {
+	if _, err := io.WriteString(w, body); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// Attachment - вложение вместе с содержимым для сборки письма.
+type Attachment struct {
+	Meta database.AttachmentMeta
+	Data []byte
+}
+
+// Compose собирает письмо в формате RFC 5322. Если заданы оба тела,
+// они идут как multipart/alternative, вложения добавляются через
+// multipart/mixed.
+func Compose(message database.Message, attachments ...Attachment) ([]byte, error) {
+	var buf bytes.Buffer
+	h := composeHeader(message)
+	alternative := message.HTMLBody != "" && message.TextBody != ""
+
+	if len(attachments) == 0 {
+		if alternative {
+			iw, err := mail.CreateInlineWriter(&buf, h)
+			if err != nil {
+				return nil, err
+			}
+			if err := writeAlternative(iw, message); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		}
+		contentType, body := singleBody(message)
+		h.SetContentType(contentType, map[string]string{"charset": "utf-8"})
+		w, err := mail.CreateSingleInlineWriter(&buf, h)
+		if err != nil {
+			return nil, err
+		}
+		if err := writePart(w, body); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mw, err := mail.CreateWriter(&buf, h)
+	if err != nil {
+		return nil, err
+	}
+	if alternative {
+		iw, err := mw.CreateInline()
+		if err != nil {
+			return nil, err
+		}
+		if err := writeAlternative(iw, message); err != nil {
+			return nil, err
+		}
+	} else {
+		contentType, body := singleBody(message)
+		var ph mail.InlineHeader
+		ph.SetContentType(contentType, map[string]string{"charset": "utf-8"})
+		w, err := mw.CreateSingleInline(ph)
+		if err != nil {
+			return nil, err
+		}
+		if err := writePart(w, body); err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range attachments {
+		if err := writeAttachment(mw, a); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func singleBody(message database.Message) (contentType string, body string) {
+	if message.HTMLBody != "" {
+		return "text/html", message.HTMLBody
+	}
+	return "text/plain", message.TextBody
+}
+
+func writeAlternative(iw *mail.InlineWriter, message database.Message) error {
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", message.TextBody},
+		{"text/html", message.HTMLBody},
+	} {
+		var ph mail.InlineHeader
+		ph.SetContentType(part.contentType, map[string]string{"charset": "utf-8"})
+		w, err := iw.CreatePart(ph)
+		if err != nil {
+			return err
+		}
+		if err := writePart(w, part.body); err != nil {
+			return err
+		}
+	}
+	return iw.Close()
+}
+
+func writeAttachment(mw *mail.Writer, a Attachment) error {
+	var ah mail.AttachmentHeader
+	contentType := a.Meta.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	ah.Set("Content-Type", contentType)
+	ah.SetFilename(a.Meta.Filename)
+	if a.Meta.ContentID != "" {
+		ah.Set("Content-Id", "<"+a.Meta.ContentID+">")
+	}
+	w, err := mw.CreateAttachment(ah)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(a.Data); err != nil {
+		return err
+	}
+	return w.Close()
+}
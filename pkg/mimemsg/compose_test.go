@@ -0,0 +1,113 @@
+package mimemsg
+
+import (
+	"bytes"
+	"io"
+	"mail/database"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+)
+
+func TestComposeAlternative(t *testing.T) {
+	message := database.Message{
+		MessageID: "123@giga-mail.ru",
+		From:      database.Address{Name: "Николай", Email: "nick@giga-mail.ru"},
+		To:        []database.Address{{Email: "jane@example.com"}},
+		Bcc:       []database.Address{{Email: "secret@example.com"}},
+		Subject:   "Отчёт за квартал",
+		TextBody:  "Привет!",
+		HTMLBody:  "<p>Привет!</p>",
+		Headers:   map[string][]string{"In-Reply-To": {"<1@example.com>"}, "Bcc": {"x@example.com"}},
+		Date:      time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC),
+	}
+	raw, err := Compose(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "secret@example.com") || strings.Contains(string(raw), "x@example.com") {
+		t.Errorf("composed message leaks Bcc:\n%s", raw)
+	}
+
+	r, err := mail.CreateReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := r.Header.Subject()
+	if subject != message.Subject {
+		t.Errorf("got subject %q want %q", subject, message.Subject)
+	}
+	if id, _ := r.Header.MessageID(); id != message.MessageID {
+		t.Errorf("got Message-ID %q want %q", id, message.MessageID)
+	}
+	if r.Header.Get("In-Reply-To") != "<1@example.com>" {
+		t.Errorf("custom header lost: %q", r.Header.Get("In-Reply-To"))
+	}
+
+	var bodies []string
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(part.Body)
+		bodies = append(bodies, string(body))
+	}
+	if len(bodies) != 2 || bodies[0] != "Привет!" || bodies[1] != "<p>Привет!</p>" {
+		t.Errorf("unexpected parts: %q", bodies)
+	}
+}
+
+func TestComposeSinglePart(t *testing.T) {
+	raw, err := Compose(database.Message{
+		From:     database.Address{Email: "nick@giga-mail.ru"},
+		To:       []database.Address{{Email: "jane@example.com"}},
+		Subject:  "Hi",
+		TextBody: "plain text",
+		Date:     time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(raw), "Content-Type: text/plain; charset=utf-8") || strings.Contains(string(raw), "multipart") {
+		t.Errorf("unexpected message:\n%s", raw)
+	}
+}
+
+func TestComposeWithAttachments(t *testing.T) {
+	message := database.Message{
+		From:     database.Address{Email: "nick@giga-mail.ru"},
+		To:       []database.Address{{Email: "jane@example.com"}},
+		Subject:  "Отчёт",
+		TextBody: "См. вложение",
+		HTMLBody: "<p>См. вложение</p>",
+		Date:     time.Now(),
+	}
+	raw, err := Compose(message, Attachment{
+		Meta: database.AttachmentMeta{Filename: "отчёт.pdf", ContentType: "application/pdf"},
+		Data: []byte("%PDF-1.4"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, structure, err := Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if structure.ContentType != "multipart/mixed" || structure.Parts[0].ContentType != "multipart/alternative" {
+		t.Errorf("unexpected structure: %+v", structure)
+	}
+	if parsed.TextBody != message.TextBody || parsed.HTMLBody != message.HTMLBody {
+		t.Errorf("unexpected bodies: %q %q", parsed.TextBody, parsed.HTMLBody)
+	}
+	_, data, err := ExtractAttachment(raw, 1)
+	if err != nil || string(data) != "%PDF-1.4" || parsed.Attachments[0].Filename != "отчёт.pdf" {
+		t.Errorf("unexpected attachment: %+v %q %v", parsed.Attachments, data, err)
+	}
+}
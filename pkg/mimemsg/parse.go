@@ -0,0 +1,256 @@
+package mimemsg
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mail/database"
+	"strings"
+
+	"github.com/emersion/go-message"
+	_ "github.com/emersion/go-message/charset"
+	"github.com/emersion/go-message/mail"
+)
+
+// maxDepth ограничивает вложенность multipart и message/rfc822, чтобы
+// специально собранное письмо не разворачивалось бесконечно.
+const maxDepth = 16
+
+var (
+	ErrTooDeep            = errors.New("mime structure is nested too deeply")
+	ErrAttachmentNotFound = errors.New("attachment not found")
+)
+
+// Part - узел MIME-дерева письма. Size - размер декодированного тела
+// листовой части, у multipart и message/rfc822 есть только Parts.
+// Subject заполняется для вложенных писем message/rfc822.
+type Part struct {
+	ContentType string
+	Charset     string
+	Disposition string
+	Filename    string
+	ContentID   string
+	Subject     string
+	Size        int64
+	Parts       []Part
+}
+
+// parser обходит дерево один раз, собирая тела, вложения и структуру.
+// Если задан want, содержимое вложения с этим ID сохраняется в found.
+type parser struct {
+	message database.Message
+	want    int64
+	found   []byte
+}
+
+// Parse разбирает письмо RFC 5322: адреса и тему с декодированием
+// RFC 2047, тексты из multipart/alternative с переводом в UTF-8,
+// сведения о вложениях и MIME-структуру. Вложения нумеруются с 1
+// в порядке следования. Owner и FolderID не заполняются.
+func Parse(raw []byte) (database.Message, Part, error) {
+	p := &parser{}
+	root, err := p.parse(raw)
+	return p.message, root, err
+}
+
+// ExtractAttachment возвращает сведения о вложении с номером id
+// и его декодированное содержимое.
+func ExtractAttachment(raw []byte, id int64) (database.AttachmentMeta, []byte, error) {
+	p := &parser{want: id}
+	if _, err := p.parse(raw); err != nil {
+		return database.AttachmentMeta{}, nil, err
+	}
+	if id < 1 || id > int64(len(p.message.Attachments)) {
+		return database.AttachmentMeta{}, nil, ErrAttachmentNotFound
+	}
+	return p.message.Attachments[id-1], p.found, nil
+}
+
+func (p *parser) parse(raw []byte) (Part, error) {
+	e, err := readEntity(bytes.NewReader(raw))
+	if err != nil {
+		return Part{}, err
+	}
+	h := mail.Header{Header: e.Header}
+
+	p.message.Headers = make(map[string][]string)
+	fields := h.Fields()
+	for fields.Next() {
+		value, err := fields.Text()
+		if err != nil {
+			value = fields.Value()
+		}
+		p.message.Headers[fields.Key()] = append(p.message.Headers[fields.Key()], value)
+	}
+	p.message.From, p.message.To, p.message.Cc = parseAddresses(h)
+	p.message.Subject = headerText(e.Header, "Subject")
+	p.message.MessageID, _ = h.MessageID()
+	p.message.References = parseReferences(h)
+	p.message.Date, _ = h.Date()
+
+	return p.walk(e, 0, false)
+}
+
+// parseReferences собирает предков письма из References и In-Reply-To.
+// In-Reply-To ставится последним: это ближайший родитель.
+func parseReferences(h mail.Header) []string {
+	refs, _ := h.MsgIDList("References")
+	parents, _ := h.MsgIDList("In-Reply-To")
+	for _, parent := range parents {
+		for i, ref := range refs {
+			if ref == parent {
+				refs = append(refs[:i], refs[i+1:]...)
+				break
+			}
+		}
+		refs = append(refs, parent)
+	}
+	return refs
+}
+
+func (p *parser) addAttachment(meta database.AttachmentMeta, body []byte) {
+	meta.ID = int64(len(p.message.Attachments)) + 1
+	if meta.ID == p.want {
+		p.found = body
+	}
+	p.message.Attachments = append(p.message.Attachments, meta)
+}
+
+// readEntity читает часть, не считая ошибкой неизвестную кодировку или
+// charset: такая часть отдаётся как есть.
+func readEntity(r io.Reader) (*message.Entity, error) {
+	e, err := message.Read(r)
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return nil, err
+	}
+	return e, nil
+}
+
+func headerText(h message.Header, key string) string {
+	value, err := h.Text(key)
+	if err != nil {
+		return h.Get(key)
+	}
+	return value
+}
+
+func parseAddresses(h mail.Header) (from database.Address, to []database.Address, cc []database.Address) {
+	if list, err := h.AddressList("From"); err == nil && len(list) > 0 {
+		from = fromMailAddresses(list)[0]
+	}
+	if list, err := h.AddressList("To"); err == nil {
+		to = fromMailAddresses(list)
+	}
+	if list, err := h.AddressList("Cc"); err == nil {
+		cc = fromMailAddresses(list)
+	}
+	return from, to, cc
+}
+
+func fromMailAddresses(list []*mail.Address) []database.Address {
+	result := make([]database.Address, 0, len(list))
+	for _, a := range list {
+		result = append(result, database.Address{Name: a.Name, Email: a.Address})
+	}
+	return result
+}
+
+// walk разбирает часть e. attached выставляется внутри вложенного письма:
+// его тексты не должны подменять тело основного.
+func (p *parser) walk(e *message.Entity, depth int, attached bool) (Part, error) {
+	if depth > maxDepth {
+		return Part{}, ErrTooDeep
+	}
+	contentType, params, _ := e.Header.ContentType()
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	disposition, dispParams, _ := e.Header.ContentDisposition()
+	part := Part{
+		ContentType: contentType,
+		Charset:     params["charset"],
+		Disposition: disposition,
+		Filename:    dispParams["filename"],
+		ContentID:   strings.Trim(e.Header.Get("Content-Id"), "<>"),
+	}
+	if part.Filename == "" {
+		part.Filename = params["name"]
+	}
+
+	if mr := e.MultipartReader(); mr != nil {
+		for {
+			child, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+				return part, err
+			}
+			childPart, err := p.walk(child, depth+1, attached)
+			if err != nil {
+				return part, err
+			}
+			part.Parts = append(part.Parts, childPart)
+		}
+		return part, nil
+	}
+
+	body, err := io.ReadAll(e.Body)
+	if err != nil {
+		return part, err
+	}
+	part.Size = int64(len(body))
+
+	if contentType == "message/rfc822" {
+		if err := p.walkEmbedded(&part, body, depth, attached); err != nil {
+			return part, err
+		}
+		return part, nil
+	}
+
+	if !attached && disposition != "attachment" {
+		switch {
+		case contentType == "text/plain" && p.message.TextBody == "":
+			p.message.TextBody = string(body)
+			return part, nil
+		case contentType == "text/html" && p.message.HTMLBody == "":
+			p.message.HTMLBody = string(body)
+			return part, nil
+		}
+	}
+	if !attached {
+		p.addAttachment(database.AttachmentMeta{
+			Filename:    part.Filename,
+			ContentType: contentType,
+			Size:        part.Size,
+			ContentID:   part.ContentID,
+		}, body)
+	}
+	return part, nil
+}
+
+// walkEmbedded разбирает вложенное письмо. Само оно считается одним
+// вложением, его части попадают только в структуру.
+func (p *parser) walkEmbedded(part *Part, body []byte, depth int, attached bool) error {
+	embedded, err := readEntity(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	part.Subject = headerText(embedded.Header, "Subject")
+	if part.Filename == "" {
+		part.Filename = part.Subject + ".eml"
+	}
+	if !attached {
+		p.addAttachment(database.AttachmentMeta{
+			Filename:    part.Filename,
+			ContentType: part.ContentType,
+			Size:        part.Size,
+		}, body)
+	}
+	child, err := p.walk(embedded, depth+1, true)
+	if err != nil {
+		return err
+	}
+	part.Parts = []Part{child}
+	return nil
+}
@@ -0,0 +1,179 @@
+package mimemsg
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+const testMultipart = "From: Mark Brown <mark.brown@example.com>\r\n" +
+	"To: jane@giga-mail.ru, Nick <nick@giga-mail.ru>\r\n" +
+	"Subject: Report Update\r\n" +
+	"Message-ID: <42@example.com>\r\n" +
+	"Date: Tue, 01 Oct 2024 12:00:00 +0000\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Please review the report.\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>Please review the report.</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf\r\n" +
+	"Content-Disposition: attachment; filename=report.pdf\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0xLjQK\r\n" +
+	"--outer--\r\n"
+
+func TestParseMultipart(t *testing.T) {
+	message, structure, err := Parse([]byte(testMultipart))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.From.Email != "mark.brown@example.com" || message.From.Name != "Mark Brown" {
+		t.Errorf("unexpected from: %+v", message.From)
+	}
+	if len(message.To) != 2 || message.To[1].Email != "nick@giga-mail.ru" {
+		t.Errorf("unexpected to: %+v", message.To)
+	}
+	if message.Subject != "Report Update" || message.MessageID != "42@example.com" || message.Date.IsZero() {
+		t.Errorf("unexpected header fields: %q %q %v", message.Subject, message.MessageID, message.Date)
+	}
+	if strings.TrimSpace(message.TextBody) != "Please review the report." ||
+		strings.TrimSpace(message.HTMLBody) != "<p>Please review the report.</p>" {
+		t.Errorf("unexpected bodies: %q %q", message.TextBody, message.HTMLBody)
+	}
+	if len(message.Attachments) != 1 || message.Attachments[0].Filename != "report.pdf" || message.Attachments[0].Size != 9 {
+		t.Errorf("unexpected attachments: %+v", message.Attachments)
+	}
+	if structure.ContentType != "multipart/mixed" || len(structure.Parts) != 2 ||
+		len(structure.Parts[0].Parts) != 2 || structure.Parts[0].Parts[1].ContentType != "text/html" {
+		t.Errorf("unexpected structure: %+v", structure)
+	}
+}
+
+func TestParseEncodedSubject(t *testing.T) {
+	raw := "From: nick@giga-mail.ru\r\nTo: jane@example.com\r\nSubject: =?utf-8?q?=D0=9F=D1=80=D0=B8=D0=B2=D0=B5=D1=82?=\r\n\r\nhello\r\n"
+	message, _, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Subject != "Привет" || strings.TrimSpace(message.TextBody) != "hello" {
+		t.Errorf("unexpected message: %+v", message)
+	}
+}
+
+func TestParseReferences(t *testing.T) {
+	raw := "From: nick@giga-mail.ru\r\nSubject: Re: Re: hi\r\n" +
+		"In-Reply-To: <2@example.com>\r\nReferences: <2@example.com> <1@example.com>\r\n\r\nhello\r\n"
+	message, _, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(message.References, " ") != "1@example.com 2@example.com" {
+		t.Errorf("got references %q", message.References)
+	}
+}
+
+func TestParseCyrillicCharsets(t *testing.T) {
+	// «Привет» в KOI8-R для темы и в windows-1251 для тела.
+	koi8 := base64.StdEncoding.EncodeToString([]byte{0xF0, 0xD2, 0xC9, 0xD7, 0xC5, 0xD4})
+	cp1251 := string([]byte{0xCF, 0xF0, 0xE8, 0xE2, 0xE5, 0xF2})
+	raw := "From: =?koi8-r?B?" + koi8 + "?= <ivan@example.ru>\r\n" +
+		"Subject: =?koi8-r?B?" + koi8 + "?=\r\n" +
+		"Content-Type: multipart/alternative; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain; charset=windows-1251\r\n" +
+		"\r\n" +
+		cp1251 + "\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/html; charset=koi8-r\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"<b>=F0=D2=C9=D7=C5=D4</b>\r\n" +
+		"--b--\r\n"
+
+	message, structure, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Subject != "Привет" || message.From.Name != "Привет" {
+		t.Errorf("headers are not decoded: %q %q", message.Subject, message.From.Name)
+	}
+	if strings.TrimSpace(message.TextBody) != "Привет" || strings.TrimSpace(message.HTMLBody) != "<b>Привет</b>" {
+		t.Errorf("bodies are not decoded: %q %q", message.TextBody, message.HTMLBody)
+	}
+	if len(message.Attachments) != 0 || structure.Parts[0].Charset != "windows-1251" {
+		t.Errorf("unexpected attachments or structure: %+v %+v", message.Attachments, structure)
+	}
+}
+
+func TestParseNestedMessage(t *testing.T) {
+	raw := "From: nick@giga-mail.ru\r\n" +
+		"Subject: Fwd: Report\r\n" +
+		"Content-Type: multipart/mixed; boundary=outer\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"See below.\r\n" +
+		"--outer\r\n" +
+		"Content-Type: message/rfc822\r\n" +
+		"\r\n" +
+		"From: mark@example.com\r\n" +
+		"Subject: Report\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Original text.\r\n" +
+		"--outer--\r\n"
+
+	message, structure, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(message.TextBody) != "See below." {
+		t.Errorf("nested message replaced the body: %q", message.TextBody)
+	}
+	if len(message.Attachments) != 1 || message.Attachments[0].Filename != "Report.eml" ||
+		message.Attachments[0].ContentType != "message/rfc822" {
+		t.Errorf("unexpected attachments: %+v", message.Attachments)
+	}
+	nested := structure.Parts[1]
+	if nested.Subject != "Report" || len(nested.Parts) != 1 || nested.Parts[0].ContentType != "text/plain" {
+		t.Errorf("unexpected nested structure: %+v", nested)
+	}
+}
+
+func TestParseTooDeep(t *testing.T) {
+	var b strings.Builder
+	for i := 0; i <= maxDepth+1; i++ {
+		b.WriteString("Content-Type: message/rfc822\r\n\r\n")
+	}
+	b.WriteString("hello\r\n")
+	if _, _, err := Parse([]byte(b.String())); err != ErrTooDeep {
+		t.Errorf("expected ErrTooDeep, got %v", err)
+	}
+}
+
+func TestExtractAttachment(t *testing.T) {
+	meta, data, err := ExtractAttachment([]byte(testMultipart), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ID != 1 || meta.Filename != "report.pdf" || string(data) != "%PDF-1.4\n" {
+		t.Errorf("unexpected attachment: %+v %q", meta, data)
+	}
+	if _, _, err := ExtractAttachment([]byte(testMultipart), 2); err != ErrAttachmentNotFound {
+		t.Errorf("expected ErrAttachmentNotFound, got %v", err)
+	}
+}
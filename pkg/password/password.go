@@ -0,0 +1,166 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mail/config"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+
+	saltLength = 16
+	keyLength  = 32
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown password hashing algorithm")
+	ErrMalformedHash    = errors.New("malformed password hash")
+	ErrUnknownHash      = errors.New("unknown password hash format")
+)
+
+type argon2Params struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+// Hasher хэширует и проверяет пароли. Хэши хранятся в самоописывающем
+// формате ($argon2id$... или $2a$...), поэтому старые хэши продолжают
+// проверяться после смены алгоритма или параметров в конфиге.
+type Hasher struct {
+	algorithm  string
+	argon2     argon2Params
+	bcryptCost int
+	plaintext  bool
+}
+
+func NewHasher(cfg config.PasswordConfig) (*Hasher, error) {
+	h := &Hasher{
+		algorithm: cfg.Algorithm,
+		argon2: argon2Params{
+			time:    cfg.Argon2.Time,
+			memory:  cfg.Argon2.Memory,
+			threads: cfg.Argon2.Threads,
+		},
+		bcryptCost: cfg.BcryptCost,
+		plaintext:  cfg.AllowPlaintext,
+	}
+	if h.algorithm == "" {
+		h.algorithm = Argon2id
+	}
+	if h.algorithm != Argon2id && h.algorithm != Bcrypt {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, h.algorithm)
+	}
+	if h.argon2.time == 0 {
+		h.argon2.time = 1
+	}
+	if h.argon2.memory == 0 {
+		h.argon2.memory = 64 * 1024
+	}
+	if h.argon2.threads == 0 {
+		h.argon2.threads = 4
+	}
+	if h.bcryptCost == 0 {
+		h.bcryptCost = bcrypt.DefaultCost
+	}
+	if h.bcryptCost < bcrypt.MinCost || h.bcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost %d is out of range", h.bcryptCost)
+	}
+	return h, nil
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.algorithm == Bcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		return string(hash), err
+	}
+
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.argon2.time, h.argon2.memory, h.argon2.threads, keyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.argon2.memory, h.argon2.time, h.argon2.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify сравнивает пароль с хэшем за постоянное время. needsRehash
+// выставляется, если хэш сделан другим алгоритмом или с меньшими
+// параметрами, чем в текущем конфиге, и его стоит пересчитать.
+// Строка без известного префикса считается паролем, сохранённым
+// до появления хэширования, только если это разрешено в конфиге
+// (allow_plaintext), иначе возвращается ErrUnknownHash.
+func (h *Hasher) Verify(password string, encoded string) (ok bool, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		params, salt, key, err := decodeArgon2(encoded)
+		if err != nil {
+			return false, false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return false, false, nil
+		}
+		return true, h.algorithm != Argon2id || params.time < h.argon2.time ||
+			params.memory < h.argon2.memory || params.threads < h.argon2.threads, nil
+
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, err
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return false, false, err
+		}
+		return true, h.algorithm != Bcrypt || cost < h.bcryptCost, nil
+
+	default:
+		if !h.plaintext {
+			return false, false, ErrUnknownHash
+		}
+		if subtle.ConstantTimeCompare([]byte(password), []byte(encoded)) != 1 {
+			return false, false, nil
+		}
+		return true, true, nil
+	}
+}
+
+func decodeArgon2(encoded string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrMalformedHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrMalformedHash
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,94 @@
+package password
+
+import (
+	"errors"
+	"mail/config"
+	"strings"
+	"testing"
+)
+
+func newTestHasher(t *testing.T, algorithm string, time uint32, bcryptCost int) *Hasher {
+	t.Helper()
+	var cfg config.PasswordConfig
+	cfg.Algorithm = algorithm
+	cfg.Argon2.Time = time
+	cfg.Argon2.Memory = 1024
+	cfg.Argon2.Threads = 1
+	cfg.BcryptCost = bcryptCost
+	h, err := NewHasher(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestHashAndVerify(t *testing.T) {
+	for _, algorithm := range []string{Argon2id, Bcrypt} {
+		h := newTestHasher(t, algorithm, 1, 4)
+		hash, err := h.Hash("12345")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(hash, "12345") {
+			t.Errorf("%s: hash contains plaintext password: %s", algorithm, hash)
+		}
+
+		ok, needsRehash, err := h.Verify("12345", hash)
+		if err != nil || !ok || needsRehash {
+			t.Errorf("%s: got ok=%v needsRehash=%v err=%v", algorithm, ok, needsRehash, err)
+		}
+		ok, _, err = h.Verify("54321", hash)
+		if err != nil || ok {
+			t.Errorf("%s: wrong password accepted: ok=%v err=%v", algorithm, ok, err)
+		}
+	}
+}
+
+func TestVerifyNeedsRehash(t *testing.T) {
+	weak := newTestHasher(t, Argon2id, 1, 4)
+	hash, err := weak.Hash("12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strong := newTestHasher(t, Argon2id, 2, 4)
+	if ok, needsRehash, _ := strong.Verify("12345", hash); !ok || !needsRehash {
+		t.Errorf("raised argon2 time: got ok=%v needsRehash=%v", ok, needsRehash)
+	}
+
+	bcryptHasher := newTestHasher(t, Bcrypt, 1, 5)
+	if ok, needsRehash, _ := bcryptHasher.Verify("12345", hash); !ok || !needsRehash {
+		t.Errorf("changed algorithm: got ok=%v needsRehash=%v", ok, needsRehash)
+	}
+
+	bcryptHash, err := newTestHasher(t, Bcrypt, 1, 4).Hash("12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, needsRehash, _ := bcryptHasher.Verify("12345", bcryptHash); !ok || !needsRehash {
+		t.Errorf("raised bcrypt cost: got ok=%v needsRehash=%v", ok, needsRehash)
+	}
+}
+
+func TestVerifyLegacyPlaintext(t *testing.T) {
+	h := newTestHasher(t, Argon2id, 1, 4)
+	if ok, _, err := h.Verify("12345", "12345"); ok || !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("plaintext without allow_plaintext: got ok=%v err=%v", ok, err)
+	}
+
+	h.plaintext = true
+	if ok, needsRehash, err := h.Verify("12345", "12345"); !ok || !needsRehash || err != nil {
+		t.Errorf("got ok=%v needsRehash=%v err=%v", ok, needsRehash, err)
+	}
+	if ok, _, _ := h.Verify("1234", "12345"); ok {
+		t.Error("wrong plaintext password accepted")
+	}
+}
+
+func TestUnknownAlgorithm(t *testing.T) {
+	var cfg config.PasswordConfig
+	cfg.Algorithm = "md5"
+	if _, err := NewHasher(cfg); err == nil {
+		t.Error("expected error for unknown algorithm")
+	}
+}
@@ -0,0 +1,308 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"html"
+	"mail/database"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var htmlTag = regexp.MustCompile(`(?s)<(script|style)[^>]*>.*?</(script|style)>|<[^>]*>`)
+
+// document - письмо в индексе: слова по полям в порядке следования,
+// чтобы проверять фразы.
+type document struct {
+	id            int64
+	date          time.Time
+	hasAttachment bool
+	fields        map[string][]string
+}
+
+// userIndex - индекс одного ящика. Пока loaded не выставлен, изменения
+// писем в нём не учитываются: они попадут в индекс при загрузке.
+type userIndex struct {
+	mu       sync.Mutex
+	loaded   bool
+	docs     map[int64]*document
+	postings map[string]map[int64]struct{}
+}
+
+// Index - встроенный полнотекстовый индекс писем. Ящик индексируется
+// целиком при первом поиске, дальше индекс обновляется через Indexed.
+// Индекс хранится в памяти процесса и после перезапуска строится заново.
+type Index struct {
+	repo *database.Repositories
+
+	mu    sync.Mutex
+	users map[string]*userIndex
+}
+
+func NewIndex(repo *database.Repositories) *Index {
+	return &Index{repo: repo, users: make(map[string]*userIndex)}
+}
+
+// Result - страница найденных писем, новые первыми. Total - число всех
+// найденных писем, Next пуст на последней странице.
+type Result struct {
+	Messages []database.Message
+	Total    int
+	Next     *database.Cursor
+}
+
+func newDocument(message database.Message) *document {
+	body := message.TextBody
+	if body == "" {
+		body = html.UnescapeString(htmlTag.ReplaceAllString(message.HTMLBody, " "))
+	}
+	var to, attachments []string
+	for _, address := range append(append(append([]database.Address(nil), message.To...), message.Cc...), message.Bcc...) {
+		to = append(to, address.Name, address.Email)
+	}
+	for _, a := range message.Attachments {
+		attachments = append(attachments, a.Filename)
+	}
+	return &document{
+		id:            message.ID,
+		date:          message.Date,
+		hasAttachment: len(message.Attachments) > 0,
+		fields: map[string][]string{
+			FieldSubject:    Tokens(message.Subject),
+			FieldFrom:       Tokens(message.From.Name + " " + message.From.Email),
+			FieldTo:         Tokens(strings.Join(to, " ")),
+			FieldBody:       Tokens(body),
+			FieldAttachment: Tokens(strings.Join(attachments, " ")),
+		},
+	}
+}
+
+func (u *userIndex) add(doc *document) {
+	u.remove(doc.id)
+	u.docs[doc.id] = doc
+	for _, tokens := range doc.fields {
+		for _, token := range tokens {
+			ids, ok := u.postings[token]
+			if !ok {
+				ids = make(map[int64]struct{})
+				u.postings[token] = ids
+			}
+			ids[doc.id] = struct{}{}
+		}
+	}
+}
+
+func (u *userIndex) remove(id int64) {
+	doc, ok := u.docs[id]
+	if !ok {
+		return
+	}
+	delete(u.docs, id)
+	for _, tokens := range doc.fields {
+		for _, token := range tokens {
+			if ids, ok := u.postings[token]; ok {
+				delete(ids, id)
+				if len(ids) == 0 {
+					delete(u.postings, token)
+				}
+			}
+		}
+	}
+}
+
+func (u *userIndex) reset() {
+	u.loaded = false
+	u.docs = make(map[int64]*document)
+	u.postings = make(map[string]map[int64]struct{})
+}
+
+func (i *Index) user(owner string) *userIndex {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	u, ok := i.users[owner]
+	if !ok {
+		u = &userIndex{}
+		u.reset()
+		i.users[owner] = u
+	}
+	return u
+}
+
+// load индексирует все письма ящика. Вызывается под u.mu.
+func (i *Index) load(ctx context.Context, owner string, u *userIndex) error {
+	folders, err := i.repo.Folders.List(ctx, owner)
+	if err != nil {
+		return err
+	}
+	for _, folder := range folders {
+		messages, err := i.repo.Messages.ListByFolder(ctx, owner, folder.ID)
+		if err != nil {
+			u.reset()
+			return err
+		}
+		for _, message := range messages {
+			u.add(newDocument(message))
+		}
+	}
+	u.loaded = true
+	return nil
+}
+
+// Refresh переиндексирует письмо после изменения, если ящик уже загружен.
+func (i *Index) Refresh(ctx context.Context, owner string, id int64) error {
+	u := i.user(owner)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.loaded {
+		return nil
+	}
+	message, err := i.repo.Messages.GetByID(ctx, owner, id)
+	if errors.Is(err, database.ErrMessageNotFound) {
+		u.remove(id)
+		return nil
+	}
+	if err != nil {
+		// индекс отстал от хранилища: при следующем поиске он будет
+		// построен заново
+		u.reset()
+		return err
+	}
+	u.add(newDocument(message))
+	return nil
+}
+
+// Remove убирает письмо из индекса.
+func (i *Index) Remove(owner string, id int64) {
+	u := i.user(owner)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.remove(id)
+}
+
+// Search ищет письма владельца. after - позиция последнего письма
+// предыдущей страницы, limit 0 отдаёт все найденные письма.
+func (i *Index) Search(ctx context.Context, owner string, query Query, after *database.Cursor, limit int) (Result, error) {
+	docs, err := i.match(ctx, owner, query)
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{Total: len(docs), Messages: make([]database.Message, 0)}
+	if after != nil {
+		rest := docs[:0]
+		for _, doc := range docs {
+			if doc.date.Before(after.Date) || doc.date.Equal(after.Date) && doc.id < after.ID {
+				rest = append(rest, doc)
+			}
+		}
+		docs = rest
+	}
+	if limit > 0 && len(docs) > limit {
+		docs = docs[:limit]
+		last := docs[len(docs)-1]
+		result.Next = &database.Cursor{Date: last.date, ID: last.id}
+	}
+	for _, doc := range docs {
+		message, err := i.repo.Messages.GetByID(ctx, owner, doc.id)
+		if errors.Is(err, database.ErrMessageNotFound) {
+			continue
+		}
+		if err != nil {
+			return Result{}, err
+		}
+		result.Messages = append(result.Messages, message)
+	}
+	return result, nil
+}
+
+// match возвращает подходящие документы, новые первыми.
+func (i *Index) match(ctx context.Context, owner string, query Query) ([]document, error) {
+	u := i.user(owner)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.loaded {
+		if err := i.load(ctx, owner, u); err != nil {
+			return nil, err
+		}
+	}
+
+	result := make([]document, 0)
+	for _, doc := range u.candidates(query) {
+		if query.HasAttachment && !doc.hasAttachment ||
+			!query.After.IsZero() && doc.date.Before(query.After) ||
+			!query.Before.IsZero() && !doc.date.Before(query.Before) {
+			continue
+		}
+		matched := true
+		for _, term := range query.Terms {
+			if !doc.matches(term) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			result = append(result, *doc)
+		}
+	}
+	sort.Slice(result, func(a, b int) bool {
+		if !result[a].date.Equal(result[b].date) {
+			return result[a].date.After(result[b].date)
+		}
+		return result[a].id > result[b].id
+	})
+	return result, nil
+}
+
+// candidates отбирает по словарю письма, где есть все слова запроса.
+func (u *userIndex) candidates(query Query) []*document {
+	var smallest map[int64]struct{}
+	for _, term := range query.Terms {
+		for _, token := range term.Tokens {
+			ids := u.postings[token]
+			if smallest == nil || len(ids) < len(smallest) {
+				smallest = ids
+			}
+		}
+	}
+	result := make([]*document, 0)
+	if len(query.Terms) == 0 {
+		for _, doc := range u.docs {
+			result = append(result, doc)
+		}
+		return result
+	}
+	for id := range smallest {
+		result = append(result, u.docs[id])
+	}
+	return result
+}
+
+func (d *document) matches(term Term) bool {
+	if term.Field != "" {
+		return containsPhrase(d.fields[term.Field], term.Tokens)
+	}
+	for _, tokens := range d.fields {
+		if containsPhrase(tokens, term.Tokens) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPhrase(tokens []string, phrase []string) bool {
+	for start := 0; start+len(phrase) <= len(tokens); start++ {
+		matched := true
+		for i, token := range phrase {
+			if tokens[start+i] != token {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
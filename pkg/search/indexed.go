@@ -0,0 +1,49 @@
+package search
+
+import (
+	"context"
+	"log/slog"
+	"mail/database"
+)
+
+// indexedMessages обновляет индекс при изменении писем. Перенос и флаги
+// на индекс не влияют: папку и флаги письма поиск берёт из хранилища.
+type indexedMessages struct {
+	database.MessageRepository
+	index *Index
+}
+
+// Indexed добавляет к хранилищу писем обновление индекса.
+func Indexed(messages database.MessageRepository, index *Index) database.MessageRepository {
+	return &indexedMessages{MessageRepository: messages, index: index}
+}
+
+func (r *indexedMessages) refresh(ctx context.Context, owner string, id int64) {
+	if err := r.index.Refresh(ctx, owner, id); err != nil {
+		slog.Error("failed to index message", "id", id, "error", err)
+	}
+}
+
+func (r *indexedMessages) Create(ctx context.Context, message database.Message) (int64, error) {
+	id, err := r.MessageRepository.Create(ctx, message)
+	if err == nil {
+		r.refresh(ctx, message.Owner, id)
+	}
+	return id, err
+}
+
+func (r *indexedMessages) Update(ctx context.Context, message database.Message) error {
+	err := r.MessageRepository.Update(ctx, message)
+	if err == nil {
+		r.refresh(ctx, message.Owner, message.ID)
+	}
+	return err
+}
+
+func (r *indexedMessages) Delete(ctx context.Context, owner string, id int64) error {
+	err := r.MessageRepository.Delete(ctx, owner, id)
+	if err == nil {
+		r.index.Remove(owner, id)
+	}
+	return err
+}
@@ -0,0 +1,108 @@
+package search
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrEmptyQuery = errors.New("empty search query")
+
+// Поля письма в индексе. Term без поля ищется во всех.
+const (
+	FieldSubject    = "subject"
+	FieldFrom       = "from"
+	FieldTo         = "to"
+	FieldBody       = "body"
+	FieldAttachment = "attachment"
+)
+
+// Term - слово или фраза в кавычках, Tokens идут подряд.
+type Term struct {
+	Field  string
+	Tokens []string
+}
+
+// Query - разобранный поисковый запрос. Все условия должны выполняться
+// одновременно, Before не включается в интервал.
+type Query struct {
+	Terms         []Term
+	HasAttachment bool
+	After         time.Time
+	Before        time.Time
+}
+
+// Parse разбирает запрос вида
+//
+//	from:john subject:"квартальный отчёт" has:attachment after:2024-10-01 бюджет
+//
+// Поддерживаются from:, to:, subject:, has:attachment, before:, after:
+// (даты в формате 2024-10-01) и фразы в кавычках. Остальные слова
+// ищутся во всех полях письма.
+func Parse(input string) (Query, error) {
+	var query Query
+	for _, word := range splitQuery(input) {
+		key, value, found := strings.Cut(word, ":")
+		if !found || value == "" {
+			query.addTerm("", word)
+			continue
+		}
+		value = strings.Trim(value, `"`)
+		switch strings.ToLower(key) {
+		case FieldFrom, FieldTo, FieldSubject:
+			query.addTerm(strings.ToLower(key), value)
+		case "has":
+			if !strings.EqualFold(value, "attachment") {
+				return query, errors.New("unknown has: value " + value)
+			}
+			query.HasAttachment = true
+		case "before", "after":
+			date, err := time.Parse(time.DateOnly, value)
+			if err != nil {
+				return query, err
+			}
+			if strings.EqualFold(key, "before") {
+				query.Before = date
+			} else {
+				query.After = date
+			}
+		default:
+			query.addTerm("", word)
+		}
+	}
+	if len(query.Terms) == 0 && !query.HasAttachment && query.After.IsZero() && query.Before.IsZero() {
+		return query, ErrEmptyQuery
+	}
+	return query, nil
+}
+
+func (q *Query) addTerm(field string, text string) {
+	if tokens := Tokens(text); len(tokens) > 0 {
+		q.Terms = append(q.Terms, Term{Field: field, Tokens: tokens})
+	}
+}
+
+// splitQuery делит запрос по пробелам, не разрывая текст в кавычках.
+func splitQuery(input string) []string {
+	var words []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range input {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if current.Len() > 0 {
+				words = append(words, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		words = append(words, current.String())
+	}
+	return words
+}
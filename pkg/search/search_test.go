@@ -0,0 +1,134 @@
+package search
+
+import (
+	"context"
+	"mail/database"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokens(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+	}{
+		{"Квартальный отчёт", "квартальные отчеты"},
+		{"reports", "REPORT"},
+		{"running", "run"},
+	} {
+		if a, b := strings.Join(Tokens(tt.a), " "), strings.Join(Tokens(tt.b), " "); a != b {
+			t.Errorf("%q and %q have different stems: %q %q", tt.a, tt.b, a, b)
+		}
+	}
+	if tokens := Tokens("q3.csv, john@example.com"); strings.Join(tokens, " ") != "q3 csv john exampl com" {
+		t.Errorf("got %q", tokens)
+	}
+}
+
+func TestParse(t *testing.T) {
+	query, err := Parse(`from:john subject:"квартальный отчёт" has:attachment after:2024-10-01 before:2024-11-01 "бюджет на год" ещё`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(query.Terms) != 4 || query.Terms[0].Field != FieldFrom || query.Terms[1].Field != FieldSubject ||
+		len(query.Terms[1].Tokens) != 2 || len(query.Terms[2].Tokens) != 3 || query.Terms[3].Field != "" {
+		t.Errorf("unexpected terms: %+v", query.Terms)
+	}
+	if !query.HasAttachment || query.After.Month() != time.October || query.Before.Month() != time.November {
+		t.Errorf("unexpected filters: %+v", query)
+	}
+	for _, input := range []string{"", "  ", "!!!", "has:pictures", "before:yesterday"} {
+		if _, err := Parse(input); err == nil {
+			t.Errorf("%q: expected error", input)
+		}
+	}
+}
+
+func TestIndexSearch(t *testing.T) {
+	ctx := context.Background()
+	const owner = "jane@giga-mail.ru"
+	repo := database.NewInMemoryRepositories()
+	index := NewIndex(repo)
+	repo.Messages = Indexed(repo.Messages, index)
+	inbox, _ := repo.Folders.GetSystem(ctx, owner, database.FolderInbox)
+	date := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	report, _ := repo.Messages.Create(ctx, database.Message{
+		Owner:       owner,
+		From:        database.Address{Name: "John Doe", Email: "john@example.com"},
+		To:          []database.Address{{Email: owner}},
+		Subject:     "Квартальный отчёт",
+		HTMLBody:    "<p>Бюджет на год <b>утверждён</b></p>",
+		Attachments: []database.AttachmentMeta{{ID: 1, Filename: "budget-2024.xlsx"}},
+		FolderID:    inbox.ID,
+		Date:        date,
+	})
+	search := func(input string) []int64 {
+		t.Helper()
+		query, err := Parse(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := index.Search(ctx, owner, query, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int64
+		for _, m := range result.Messages {
+			ids = append(ids, m.ID)
+		}
+		return ids
+	}
+	if ids := search("отчеты"); len(ids) != 1 || ids[0] != report {
+		t.Fatalf("got %v", ids)
+	}
+
+	// после первого поиска индекс обновляется при изменении писем
+	meeting, _ := repo.Messages.Create(ctx, database.Message{
+		Owner:    owner,
+		From:     database.Address{Email: "mark@example.com"},
+		Subject:  "Meeting about the report",
+		TextBody: "The budget meetings are moved",
+		FolderID: inbox.ID,
+		Date:     date.Add(24 * time.Hour),
+	})
+	for _, tt := range []struct {
+		query string
+		ids   []int64
+	}{
+		{"бюджет", []int64{report}},
+		{"budget", []int64{meeting, report}},
+		{"report", []int64{meeting}},
+		{"from:john", []int64{report}},
+		{"from:mark@example.com", []int64{meeting}},
+		{"to:jane", []int64{report}},
+		{"subject:meeting", []int64{meeting}},
+		{"meeting john", nil},
+		{`"бюджет на год"`, []int64{report}},
+		{`"на бюджет год"`, nil},
+		{"has:attachment", []int64{report}},
+		{"after:2024-10-02", []int64{meeting}},
+		{"before:2024-10-02 budget", []int64{report}},
+	} {
+		ids := search(tt.query)
+		if len(ids) != len(tt.ids) || len(ids) > 0 && ids[0] != tt.ids[0] {
+			t.Errorf("%q: got %v want %v", tt.query, ids, tt.ids)
+		}
+	}
+
+	message, _ := repo.Messages.GetByID(ctx, owner, meeting)
+	message.Subject = "Planning"
+	if err := repo.Messages.Update(ctx, message); err != nil {
+		t.Fatal(err)
+	}
+	if ids := search("subject:meeting"); len(ids) != 0 {
+		t.Errorf("updated message still found by old subject: %v", ids)
+	}
+	repo.Messages.Delete(ctx, owner, report)
+	if ids := search("budget"); len(ids) != 1 || ids[0] != meeting {
+		t.Errorf("deleted message still found: %v", ids)
+	}
+	if ids, _ := index.Search(ctx, "mark@example.com", Query{Terms: []Term{{Tokens: Tokens("budget")}}}, nil, 0); ids.Total != 0 {
+		t.Errorf("found messages of another user: %+v", ids)
+	}
+}
@@ -0,0 +1,45 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/kljensen/snowball/english"
+	"github.com/kljensen/snowball/russian"
+)
+
+// Tokens разбивает текст на слова и приводит их к основе: кириллицу
+// стеммером для русского, латиницу - для английского. Числа и слова
+// из смешанных алфавитов остаются как есть.
+func Tokens(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	result := make([]string, 0, len(words))
+	for _, word := range words {
+		result = append(result, stem(strings.ReplaceAll(word, "ё", "е")))
+	}
+	return result
+}
+
+func stem(word string) string {
+	var cyrillic, latin bool
+	for _, r := range word {
+		switch {
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic = true
+		case unicode.Is(unicode.Latin, r):
+			latin = true
+		case unicode.IsDigit(r):
+		default:
+			return word
+		}
+	}
+	switch {
+	case cyrillic && !latin:
+		return russian.Stem(word, true)
+	case latin && !cyrillic:
+		return english.Stem(word, true)
+	}
+	return word
+}
@@ -0,0 +1,125 @@
+package sieve
+
+import (
+	"fmt"
+	"mail/database"
+	"strings"
+)
+
+// Format записывает правила скриптом Sieve, который понимает Parse.
+// Имя правила пишется в комментарий перед ним, выключенное правило
+// оборачивается в allof(false, ...). Действия с удалёнными папками
+// и метками пропускаются.
+func Format(rules []database.FilterRule, mailbox Mailbox) string {
+	folders := mailbox.folderPaths()
+	labels := make(map[int64]string, len(mailbox.Labels))
+	for _, label := range mailbox.Labels {
+		labels[label.ID] = label.Name
+	}
+
+	var body strings.Builder
+	used := make(map[string]bool)
+	for i, rule := range rules {
+		if i > 0 {
+			body.WriteString("\n")
+		}
+		if rule.Name != "" {
+			fmt.Fprintf(&body, "# %s[%s]\n", rulePrefix, strings.ReplaceAll(rule.Name, "\n", " "))
+		}
+		fmt.Fprintf(&body, "if %s {\n", formatTest(rule))
+		for _, action := range rule.Actions {
+			line, capability := formatAction(action, folders, labels)
+			if line == "" {
+				continue
+			}
+			if capability != "" {
+				used[capability] = true
+			}
+			fmt.Fprintf(&body, "    %s;\n", line)
+		}
+		body.WriteString("}\n")
+	}
+
+	var script strings.Builder
+	var required []string
+	for _, capability := range []string{CapabilityFileInto, CapabilityIMAP4Flags, CapabilityLabel} {
+		if used[capability] {
+			required = append(required, quote(capability))
+		}
+	}
+	if len(required) > 0 {
+		fmt.Fprintf(&script, "require [%s];\n\n", strings.Join(required, ", "))
+	}
+	script.WriteString(body.String())
+	return script.String()
+}
+
+func formatTest(rule database.FilterRule) string {
+	tests := make([]string, 0, len(rule.Conditions))
+	for _, condition := range rule.Conditions {
+		tests = append(tests, formatCondition(condition))
+	}
+	var test string
+	switch {
+	case len(tests) == 0:
+		test = "true"
+	case len(tests) == 1:
+		test = tests[0]
+	case rule.MatchAll:
+		test = "allof (" + strings.Join(tests, ", ") + ")"
+	default:
+		test = "anyof (" + strings.Join(tests, ", ") + ")"
+	}
+	if !rule.Enabled {
+		return "allof (false, " + test + ")"
+	}
+	return test
+}
+
+func formatCondition(condition database.FilterCondition) string {
+	var test string
+	switch condition.Field {
+	case database.FilterFieldSize:
+		test = fmt.Sprintf("size :%s %s", condition.Op, condition.Value)
+	case database.FilterFieldFrom:
+		test = fmt.Sprintf("header :%s %s %s", condition.Op, quote("from"), quote(condition.Value))
+	case database.FilterFieldTo:
+		test = fmt.Sprintf("header :%s [%s, %s] %s", condition.Op, quote("to"), quote("cc"), quote(condition.Value))
+	case database.FilterFieldSubject:
+		test = fmt.Sprintf("header :%s %s %s", condition.Op, quote("subject"), quote(condition.Value))
+	default:
+		test = fmt.Sprintf("header :%s %s %s", condition.Op, quote(condition.Header), quote(condition.Value))
+	}
+	if condition.Not {
+		return "not " + test
+	}
+	return test
+}
+
+// formatAction возвращает команду и расширение, которое для неё нужно.
+func formatAction(action database.FilterAction, folders map[int64]string, labels map[int64]string) (string, string) {
+	switch action.Type {
+	case database.FilterActionFileInto:
+		if path, ok := folders[action.FolderID]; ok {
+			return "fileinto " + quote(path), CapabilityFileInto
+		}
+	case database.FilterActionLabel:
+		if name, ok := labels[action.LabelID]; ok {
+			return "label " + quote(name), CapabilityLabel
+		}
+	case database.FilterActionFlag:
+		return "addflag " + quote(action.Flag), CapabilityIMAP4Flags
+	case database.FilterActionRedirect:
+		return "redirect " + quote(action.Address), ""
+	case database.FilterActionDiscard:
+		return "discard", ""
+	case database.FilterActionStop:
+		return "stop", ""
+	}
+	return "", ""
+}
+
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
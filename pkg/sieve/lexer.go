@@ -0,0 +1,161 @@
+package sieve
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdentifier
+	tokenTag
+	tokenString
+	tokenNumber
+	tokenComment
+	tokenPunct
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	number int64
+	line   int
+}
+
+// lex разбивает скрипт на лексемы RFC 5228. Комментарии сохраняются:
+// в них записываются имена правил.
+func lex(script string) ([]token, error) {
+	var tokens []token
+	line := 1
+	for i := 0; i < len(script); {
+		c := script[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				end = len(script) - i
+			}
+			tokens = append(tokens, token{kind: tokenComment, text: strings.TrimSpace(script[i+1 : i+end]), line: line})
+			i += end
+		case strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				return nil, &Error{Line: line, Message: "unterminated comment"}
+			}
+			line += strings.Count(script[i:i+2+end], "\n")
+			i += end + 4
+		case c == '"':
+			text, n, err := lexQuoted(script[i:])
+			if err != nil {
+				return nil, &Error{Line: line, Message: err.Error()}
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text, line: line})
+			line += strings.Count(script[i:i+n], "\n")
+			i += n
+		case strings.HasPrefix(script[i:], "text:"):
+			text, n, err := lexMultiline(script[i:])
+			if err != nil {
+				return nil, &Error{Line: line, Message: err.Error()}
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text, line: line})
+			line += strings.Count(script[i:i+n], "\n")
+			i += n
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(script) && script[j] >= '0' && script[j] <= '9' {
+				j++
+			}
+			number, err := strconv.ParseInt(script[i:j], 10, 64)
+			if err != nil {
+				return nil, &Error{Line: line, Message: fmt.Sprintf("bad number %q", script[i:j])}
+			}
+			if j < len(script) {
+				switch script[j] {
+				case 'K', 'k':
+					number, j = number<<10, j+1
+				case 'M', 'm':
+					number, j = number<<20, j+1
+				case 'G', 'g':
+					number, j = number<<30, j+1
+				}
+			}
+			tokens = append(tokens, token{kind: tokenNumber, number: number, line: line})
+			i = j
+		case c == ':' || isIdentifierStart(c):
+			j := i + 1
+			for j < len(script) && (isIdentifierStart(script[j]) || script[j] >= '0' && script[j] <= '9') {
+				j++
+			}
+			kind := tokenIdentifier
+			if c == ':' {
+				kind = tokenTag
+				if j == i+1 {
+					return nil, &Error{Line: line, Message: "empty tag"}
+				}
+			}
+			tokens = append(tokens, token{kind: kind, text: strings.ToLower(script[i:j]), line: line})
+			i = j
+		case strings.IndexByte("[](),;{}", c) >= 0:
+			tokens = append(tokens, token{kind: tokenPunct, text: string(c), line: line})
+			i++
+		default:
+			return nil, &Error{Line: line, Message: fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, line: line}), nil
+}
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// lexQuoted читает строку в кавычках, \ экранирует следующий символ.
+func lexQuoted(s string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", 0, errors.New("unterminated string")
+}
+
+// lexMultiline читает строку text: ... до строки из одной точки.
+// Точка в начале строки удваивается.
+func lexMultiline(s string) (string, int, error) {
+	start := strings.IndexByte(s, '\n')
+	if start < 0 {
+		return "", 0, errors.New("unterminated multi-line string")
+	}
+	var lines []string
+	for i := start + 1; i < len(s); {
+		end := strings.IndexByte(s[i:], '\n')
+		if end < 0 {
+			break
+		}
+		line := strings.TrimSuffix(s[i:i+end], "\r")
+		if line == "." {
+			return strings.Join(lines, "\r\n"), i + end + 1, nil
+		}
+		lines = append(lines, strings.TrimPrefix(line, "."))
+		i += end + 1
+	}
+	return "", 0, errors.New("unterminated multi-line string")
+}
@@ -0,0 +1,602 @@
+package sieve
+
+import (
+	"fmt"
+	"mail/database"
+	"strconv"
+	"strings"
+)
+
+// Расширения, которые понимает импорт. Метки - собственное расширение:
+// в RFC 5228 и его дополнениях такого действия нет.
+const (
+	CapabilityFileInto   = "fileinto"
+	CapabilityIMAP4Flags = "imap4flags"
+	CapabilityLabel      = "vnd.gigamail.label"
+	CapabilityCopy       = "copy"
+)
+
+var capabilities = map[string]bool{
+	CapabilityFileInto:           true,
+	CapabilityIMAP4Flags:         true,
+	CapabilityLabel:              true,
+	CapabilityCopy:               true,
+	"comparator-i;ascii-casemap": true,
+}
+
+// rulePrefix - комментарий перед правилом с его именем, как у Roundcube.
+const rulePrefix = "rule:"
+
+// inboxName - имя входящих в скриптах, как в IMAP.
+const inboxName = "INBOX"
+
+// Mailbox - папки и метки пользователя, по которым имена в скрипте
+// сопоставляются с ID. Вложенные папки записываются через "/".
+type Mailbox struct {
+	Folders []database.Folder
+	Labels  []database.Label
+}
+
+// folderPaths возвращает полные имена папок по ID.
+func (m Mailbox) folderPaths() map[int64]string {
+	byID := make(map[int64]database.Folder, len(m.Folders))
+	for _, folder := range m.Folders {
+		byID[folder.ID] = folder
+	}
+	paths := make(map[int64]string, len(m.Folders))
+	for _, folder := range m.Folders {
+		if folder.System == database.FolderInbox {
+			paths[folder.ID] = inboxName
+			continue
+		}
+		path := folder.Name
+		// глубина ограничена числом папок на случай испорченного дерева
+		for parent, depth := folder.ParentID, 0; parent != 0 && depth < len(m.Folders); depth++ {
+			p, ok := byID[parent]
+			if !ok {
+				break
+			}
+			path = p.Name + "/" + path
+			parent = p.ParentID
+		}
+		paths[folder.ID] = path
+	}
+	return paths
+}
+
+// Error - ошибка разбора скрипта с номером строки.
+type Error struct {
+	Line    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+type parser struct {
+	tokens   []token
+	pos      int
+	folders  map[string]int64
+	labels   map[string]int64
+	required map[string]bool
+	name     string
+}
+
+// Parse разбирает скрипт Sieve в правила. Поддерживается подмножество,
+// которое выражается правилами: команды if без elsif и else с тестами
+// header, address, size, true, false, not, allof и anyof, действия
+// fileinto, addflag, label, redirect, discard, keep и stop. Команды вне
+// if становятся правилом без условий. Правило вида
+// if allof(false, ...) импортируется выключенным.
+func Parse(script string, mailbox Mailbox) ([]database.FilterRule, error) {
+	tokens, err := lex(script)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{
+		tokens:   tokens,
+		folders:  make(map[string]int64),
+		labels:   make(map[string]int64),
+		required: make(map[string]bool),
+	}
+	for id, path := range mailbox.folderPaths() {
+		p.folders[path] = id
+	}
+	for _, label := range mailbox.Labels {
+		p.labels[label.Name] = label.ID
+	}
+
+	rules := make([]database.FilterRule, 0)
+	var loose *database.FilterRule
+	for {
+		p.skipComments()
+		tok := p.peek()
+		if tok.kind == tokenEOF {
+			break
+		}
+		if tok.kind != tokenIdentifier {
+			return nil, p.errorf(tok, "command expected")
+		}
+		switch tok.text {
+		case "require":
+			p.next()
+			if err := p.parseRequire(); err != nil {
+				return nil, err
+			}
+		case "if":
+			p.next()
+			rule, err := p.parseIf()
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, rule)
+			loose = nil
+		default:
+			// подряд идущие команды вне if собираются в одно правило
+			if loose == nil {
+				rules = append(rules, database.FilterRule{Name: p.ruleName(), Enabled: true, MatchAll: true})
+				loose = &rules[len(rules)-1]
+			}
+			actions, err := p.parseCommand()
+			if err != nil {
+				return nil, err
+			}
+			loose.Actions = append(loose.Actions, actions...)
+		}
+	}
+	return rules, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// skipComments пропускает комментарии, запоминая имя правила из последнего.
+func (p *parser) skipComments() {
+	for p.peek().kind == tokenComment {
+		text := p.next().text
+		if strings.HasPrefix(text, rulePrefix) {
+			name := strings.TrimSpace(strings.TrimPrefix(text, rulePrefix))
+			p.name = strings.TrimSuffix(strings.TrimPrefix(name, "["), "]")
+		}
+	}
+}
+
+// ruleName отдаёт имя из комментария перед правилом и сбрасывает его.
+func (p *parser) ruleName() string {
+	name := p.name
+	p.name = ""
+	return name
+}
+
+func (p *parser) errorf(tok token, format string, args ...interface{}) error {
+	return &Error{Line: tok.line, Message: fmt.Sprintf(format, args...)}
+}
+
+// expect читает знак препинания text.
+func (p *parser) expect(text string) error {
+	p.skipComments()
+	tok := p.next()
+	if tok.kind != tokenPunct || tok.text != text {
+		return p.errorf(tok, "%q expected", text)
+	}
+	return nil
+}
+
+func (p *parser) accept(text string) bool {
+	p.skipComments()
+	if tok := p.peek(); tok.kind == tokenPunct && tok.text == text {
+		p.next()
+		return true
+	}
+	return false
+}
+
+// parseStrings читает строку или список строк.
+func (p *parser) parseStrings() ([]string, error) {
+	p.skipComments()
+	tok := p.next()
+	if tok.kind == tokenString {
+		return []string{tok.text}, nil
+	}
+	if tok.kind != tokenPunct || tok.text != "[" {
+		return nil, p.errorf(tok, "string or string list expected")
+	}
+	var result []string
+	for {
+		p.skipComments()
+		item := p.next()
+		if item.kind != tokenString {
+			return nil, p.errorf(item, "string expected")
+		}
+		result = append(result, item.text)
+		if p.accept("]") {
+			return result, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseString() (string, error) {
+	p.skipComments()
+	tok := p.next()
+	if tok.kind != tokenString {
+		return "", p.errorf(tok, "string expected")
+	}
+	return tok.text, nil
+}
+
+func (p *parser) parseRequire() error {
+	tok := p.peek()
+	names, err := p.parseStrings()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !capabilities[strings.ToLower(name)] {
+			return p.errorf(tok, "unsupported extension %q", name)
+		}
+		p.required[strings.ToLower(name)] = true
+	}
+	return p.expect(";")
+}
+
+func (p *parser) parseIf() (database.FilterRule, error) {
+	rule := database.FilterRule{Name: p.ruleName(), Enabled: true}
+	tok := p.peek()
+	test, err := p.parseTest()
+	if err != nil {
+		return rule, err
+	}
+	if test.disabled {
+		rule.Enabled = false
+	}
+	switch {
+	case len(test.conditions) <= 1:
+		rule.MatchAll = true
+	case test.combine == combineNone:
+		return rule, p.errorf(tok, "test is too complex")
+	default:
+		rule.MatchAll = test.combine == combineAll
+	}
+	if test.never && !test.disabled {
+		return rule, p.errorf(tok, "test is always false")
+	}
+	rule.Conditions = test.conditions
+
+	if err := p.expect("{"); err != nil {
+		return rule, err
+	}
+	for !p.accept("}") {
+		if p.peek().kind == tokenEOF {
+			return rule, p.errorf(p.peek(), `"}" expected`)
+		}
+		actions, err := p.parseCommand()
+		if err != nil {
+			return rule, err
+		}
+		rule.Actions = append(rule.Actions, actions...)
+	}
+	p.skipComments()
+	if next := p.peek(); next.kind == tokenIdentifier && (next.text == "elsif" || next.text == "else") {
+		return rule, p.errorf(next, "%s is not supported", next.text)
+	}
+	return rule, nil
+}
+
+type combine int
+
+const (
+	// combineNone - тест из одного условия, его можно вставить в любой список.
+	combineNone combine = iota
+	combineAll
+	combineAny
+)
+
+// test - разобранный тест в виде плоского списка условий. never - тест
+// всегда ложен (false), disabled - правило выключено через allof(false, ...).
+type test struct {
+	conditions []database.FilterCondition
+	combine    combine
+	never      bool
+	disabled   bool
+}
+
+func (p *parser) parseTest() (test, error) {
+	p.skipComments()
+	tok := p.next()
+	if tok.kind != tokenIdentifier {
+		return test{}, p.errorf(tok, "test expected")
+	}
+	switch tok.text {
+	case "true":
+		return test{}, nil
+	case "false":
+		return test{never: true}, nil
+	case "not":
+		inner, err := p.parseTest()
+		if err != nil {
+			return inner, err
+		}
+		return negate(inner, tok, p)
+	case "allof", "anyof":
+		return p.parseTestList(tok)
+	case "header", "address":
+		return p.parseHeaderTest(tok)
+	case "size":
+		return p.parseSizeTest()
+	}
+	return test{}, p.errorf(tok, "unsupported test %q", tok.text)
+}
+
+// negate применяет not: по законам де Моргана меняет all и any.
+func negate(t test, tok token, p *parser) (test, error) {
+	if t.never || len(t.conditions) == 0 || t.disabled {
+		return t, p.errorf(tok, "not is supported only for header, address and size tests")
+	}
+	for i := range t.conditions {
+		t.conditions[i].Not = !t.conditions[i].Not
+	}
+	switch t.combine {
+	case combineAll:
+		t.combine = combineAny
+	case combineAny:
+		t.combine = combineAll
+	}
+	return t, nil
+}
+
+func (p *parser) parseTestList(tok token) (test, error) {
+	result := test{combine: combineAny}
+	if tok.text == "allof" {
+		result.combine = combineAll
+	}
+	if err := p.expect("("); err != nil {
+		return result, err
+	}
+	var items []test
+	var itemTokens []token
+	for {
+		itemTokens = append(itemTokens, p.peek())
+		item, err := p.parseTest()
+		if err != nil {
+			return result, err
+		}
+		items = append(items, item)
+		if p.accept(")") {
+			break
+		}
+		if err := p.expect(","); err != nil {
+			return result, err
+		}
+	}
+
+	// allof(false, ...) - выключенное правило, остальное - его условие
+	if result.combine == combineAll && items[0].never && !items[0].disabled {
+		items, itemTokens = items[1:], itemTokens[1:]
+		if len(items) == 1 {
+			inner := items[0]
+			if inner.never || inner.disabled {
+				return result, p.errorf(itemTokens[0], "false is supported only as the first test of allof")
+			}
+			inner.disabled = true
+			return inner, nil
+		}
+		result.disabled = true
+	}
+
+	always := false
+	for i, item := range items {
+		switch {
+		case item.never || item.disabled:
+			return result, p.errorf(itemTokens[i], "false is supported only as the first test of allof")
+		case len(item.conditions) == 0:
+			// true не влияет на allof, а anyof с ним срабатывает всегда
+			always = always || result.combine == combineAny
+		case item.combine != combineNone && item.combine != result.combine:
+			return result, p.errorf(itemTokens[i], "nested allof and anyof are not supported")
+		default:
+			result.conditions = append(result.conditions, item.conditions...)
+		}
+	}
+	if always {
+		result.conditions = nil
+	}
+	return result, nil
+}
+
+// parseHeaderTest разбирает header и address. Адреса сравниваются
+// целиком (:all), компаратор поддерживается только i;ascii-casemap.
+func (p *parser) parseHeaderTest(tok token) (test, error) {
+	op := database.FilterOpIs
+	for p.skipComments(); p.peek().kind == tokenTag; p.skipComments() {
+		tag := p.next()
+		switch tag.text {
+		case ":is", ":contains", ":matches":
+			op = strings.TrimPrefix(tag.text, ":")
+		case ":all":
+			if tok.text != "address" {
+				return test{}, p.errorf(tag, "unexpected %s", tag.text)
+			}
+		case ":comparator":
+			comparator, err := p.parseString()
+			if err != nil {
+				return test{}, err
+			}
+			if comparator != "i;ascii-casemap" {
+				return test{}, p.errorf(tag, "unsupported comparator %q", comparator)
+			}
+		default:
+			return test{}, p.errorf(tag, "unsupported tag %s", tag.text)
+		}
+	}
+	headers, err := p.parseStrings()
+	if err != nil {
+		return test{}, err
+	}
+	keys, err := p.parseStrings()
+	if err != nil {
+		return test{}, err
+	}
+
+	var conditions []database.FilterCondition
+	for _, field := range headerFields(headers) {
+		for _, key := range keys {
+			condition := field
+			condition.Op = op
+			condition.Value = key
+			conditions = append(conditions, condition)
+		}
+	}
+	result := test{conditions: conditions}
+	if len(conditions) > 1 {
+		result.combine = combineAny
+	}
+	return result, nil
+}
+
+// headerFields сопоставляет заголовкам поля условий. To и Cc проверяются
+// одним условием to.
+func headerFields(headers []string) []database.FilterCondition {
+	var result []database.FilterCondition
+	seen := make(map[string]bool)
+	for _, header := range headers {
+		condition := database.FilterCondition{Field: database.FilterFieldHeader, Header: header}
+		switch strings.ToLower(header) {
+		case "from":
+			condition = database.FilterCondition{Field: database.FilterFieldFrom}
+		case "to", "cc":
+			condition = database.FilterCondition{Field: database.FilterFieldTo}
+		case "subject":
+			condition = database.FilterCondition{Field: database.FilterFieldSubject}
+		}
+		key := condition.Field + ":" + strings.ToLower(condition.Header)
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, condition)
+		}
+	}
+	return result
+}
+
+func (p *parser) parseSizeTest() (test, error) {
+	p.skipComments()
+	tag := p.next()
+	if tag.kind != tokenTag || tag.text != ":over" && tag.text != ":under" {
+		return test{}, p.errorf(tag, ":over or :under expected")
+	}
+	p.skipComments()
+	limit := p.next()
+	if limit.kind != tokenNumber {
+		return test{}, p.errorf(limit, "number expected")
+	}
+	return test{conditions: []database.FilterCondition{{
+		Field: database.FilterFieldSize,
+		Op:    strings.TrimPrefix(tag.text, ":"),
+		Value: strconv.FormatInt(limit.number, 10),
+	}}}, nil
+}
+
+// parseCommand разбирает действие и возвращает соответствующие ему
+// действия правила. keep ничего не добавляет: письмо и так сохраняется.
+func (p *parser) parseCommand() ([]database.FilterAction, error) {
+	p.skipComments()
+	tok := p.next()
+	if tok.kind != tokenIdentifier {
+		return nil, p.errorf(tok, "command expected")
+	}
+	var actions []database.FilterAction
+	switch tok.text {
+	case "keep":
+	case "discard":
+		actions = append(actions, database.FilterAction{Type: database.FilterActionDiscard})
+	case "stop":
+		actions = append(actions, database.FilterAction{Type: database.FilterActionStop})
+	case "fileinto":
+		if err := p.requireCapability(tok, CapabilityFileInto); err != nil {
+			return nil, err
+		}
+		p.skipTag(":copy")
+		name, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		id, ok := p.folders[name]
+		if !ok && strings.EqualFold(name, inboxName) {
+			id, ok = p.folders[inboxName]
+		}
+		if !ok {
+			return nil, p.errorf(tok, "unknown folder %q", name)
+		}
+		actions = append(actions, database.FilterAction{Type: database.FilterActionFileInto, FolderID: id})
+	case "label":
+		if err := p.requireCapability(tok, CapabilityLabel); err != nil {
+			return nil, err
+		}
+		names, err := p.parseStrings()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			id, ok := p.labels[name]
+			if !ok {
+				return nil, p.errorf(tok, "unknown label %q", name)
+			}
+			actions = append(actions, database.FilterAction{Type: database.FilterActionLabel, LabelID: id})
+		}
+	case "addflag":
+		if err := p.requireCapability(tok, CapabilityIMAP4Flags); err != nil {
+			return nil, err
+		}
+		flags, err := p.parseStrings()
+		if err != nil {
+			return nil, err
+		}
+		for _, list := range flags {
+			// в одной строке imap4flags можно перечислить флаги через пробел
+			for _, flag := range strings.Fields(list) {
+				if !database.ValidFlag(flag) {
+					return nil, p.errorf(tok, "invalid flag %q", flag)
+				}
+				actions = append(actions, database.FilterAction{Type: database.FilterActionFlag, Flag: flag})
+			}
+		}
+	case "redirect":
+		p.skipTag(":copy")
+		address, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, database.FilterAction{Type: database.FilterActionRedirect, Address: address})
+	case "if", "elsif", "else":
+		return nil, p.errorf(tok, "nested %s is not supported", tok.text)
+	default:
+		return nil, p.errorf(tok, "unsupported command %q", tok.text)
+	}
+	return actions, p.expect(";")
+}
+
+func (p *parser) requireCapability(tok token, capability string) error {
+	if !p.required[capability] {
+		return p.errorf(tok, "%s requires %q", tok.text, capability)
+	}
+	return nil
+}
+
+func (p *parser) skipTag(tag string) {
+	p.skipComments()
+	if tok := p.peek(); tok.kind == tokenTag && tok.text == tag {
+		p.next()
+	}
+}
@@ -0,0 +1,137 @@
+package sieve
+
+import (
+	"errors"
+	"mail/database"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testMailbox = Mailbox{
+	Folders: []database.Folder{
+		{ID: 1, Name: database.FolderInbox, System: database.FolderInbox},
+		{ID: 5, Name: database.FolderSpam, System: database.FolderSpam},
+		{ID: 10, Name: "Работа"},
+		{ID: 11, Name: "Отчёты", ParentID: 10},
+	},
+	Labels: []database.Label{{ID: 3, Name: "важное"}},
+}
+
+func TestParse(t *testing.T) {
+	script := `require ["fileinto", "imap4flags", "vnd.gigamail.label"];
+# rule:[Отчёты]
+if allof (header :contains "subject" "отчёт", address :is ["to", "cc"] "team@giga-mail.ru") {
+    fileinto "Работа/Отчёты";
+    addflag "\\Flagged $Important";
+    label "важное";
+    stop;
+}
+/* старые
+   правила */
+if anyof (size :over 10M, not header :matches "X-Mailer" "Outlook*") { discard; }
+if allof (false, header :is "from" "boss@example.com") { redirect "me@example.com"; }
+keep;
+`
+	rules, err := Parse(script, testMailbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 4 {
+		t.Fatalf("got %d rules: %+v", len(rules), rules)
+	}
+	want := database.FilterRule{
+		Name:     "Отчёты",
+		Enabled:  true,
+		MatchAll: true,
+		Conditions: []database.FilterCondition{
+			{Field: database.FilterFieldSubject, Op: database.FilterOpContains, Value: "отчёт"},
+			{Field: database.FilterFieldTo, Op: database.FilterOpIs, Value: "team@giga-mail.ru"},
+		},
+		Actions: []database.FilterAction{
+			{Type: database.FilterActionFileInto, FolderID: 11},
+			{Type: database.FilterActionFlag, Flag: database.FlagFlagged},
+			{Type: database.FilterActionFlag, Flag: database.FlagImportant},
+			{Type: database.FilterActionLabel, LabelID: 3},
+			{Type: database.FilterActionStop},
+		},
+	}
+	if !reflect.DeepEqual(rules[0], want) {
+		t.Errorf("got %+v\nwant %+v", rules[0], want)
+	}
+	if rules[1].MatchAll || len(rules[1].Conditions) != 2 || rules[1].Conditions[0].Value != "10485760" ||
+		!rules[1].Conditions[1].Not || rules[1].Conditions[1].Header != "X-Mailer" {
+		t.Errorf("unexpected anyof rule: %+v", rules[1])
+	}
+	if rules[2].Enabled || len(rules[2].Conditions) != 1 || rules[2].Actions[0].Address != "me@example.com" {
+		t.Errorf("unexpected disabled rule: %+v", rules[2])
+	}
+	if len(rules[3].Conditions) != 0 || len(rules[3].Actions) != 0 {
+		t.Errorf("unexpected keep rule: %+v", rules[3])
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for script, line := range map[string]int{
+		`fileinto "Работа";`:                                                           1,
+		"require \"fileinto\";\n\nfileinto \"Нет такой\";":                             3,
+		`require "vacation";`:                                                          1,
+		`if header :regex "subject" "x" { stop; }`:                                     1,
+		`if true { stop; } else { discard; }`:                                          1,
+		`if allof (header :is "a" "b", anyof (size :over 1, size :under 2)) { stop; }`: 1,
+		`if header :is "a" "b" { if true { stop; } }`:                                  1,
+		`if header :is "a" "b" { stop; `:                                               1,
+		"discard;\n\"unterminated":                                                     2,
+	} {
+		_, err := Parse(script, testMailbox)
+		var parseErr *Error
+		if !errors.As(err, &parseErr) {
+			t.Errorf("%q: expected parse error, got %v", script, err)
+			continue
+		}
+		if parseErr.Line != line {
+			t.Errorf("%q: got line %d want %d (%v)", script, parseErr.Line, line, err)
+		}
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	rules := []database.FilterRule{
+		{
+			Name:     `Цитата "и" \слэш`,
+			Enabled:  true,
+			MatchAll: false,
+			Conditions: []database.FilterCondition{
+				{Field: database.FilterFieldFrom, Op: database.FilterOpMatches, Value: "*@example.com"},
+				{Field: database.FilterFieldHeader, Header: "List-Id", Op: database.FilterOpContains, Value: `"news"`, Not: true},
+				{Field: database.FilterFieldSize, Op: database.FilterOpUnder, Value: "1024"},
+			},
+			Actions: []database.FilterAction{
+				{Type: database.FilterActionFileInto, FolderID: 5},
+				{Type: database.FilterActionLabel, LabelID: 3},
+			},
+		},
+		{
+			Enabled:  false,
+			MatchAll: true,
+			Actions: []database.FilterAction{
+				{Type: database.FilterActionFileInto, FolderID: 1},
+				{Type: database.FilterActionDiscard},
+			},
+		},
+	}
+	script := Format(rules, testMailbox)
+	if !strings.HasPrefix(script, `require ["fileinto", "vnd.gigamail.label"];`) {
+		t.Errorf("unexpected require: %s", script)
+	}
+	if !strings.Contains(script, `fileinto "spam";`) || !strings.Contains(script, `fileinto "INBOX";`) {
+		t.Errorf("unexpected folders: %s", script)
+	}
+	parsed, err := Parse(script, testMailbox)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, script)
+	}
+	if !reflect.DeepEqual(parsed, rules) {
+		t.Errorf("got %+v\nwant %+v\n%s", parsed, rules, script)
+	}
+}
@@ -0,0 +1,198 @@
+package spam
+
+import (
+	"context"
+	"fmt"
+	"mail/database"
+	"mail/pkg/search"
+	"math"
+	"sort"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultMinTraining - сколько писем каждого класса нужно, чтобы
+	// байесовский фильтр начал влиять на оценку.
+	DefaultMinTraining = 10
+
+	// bayesWeight - оценка при полной уверенности фильтра, знак зависит
+	// от класса.
+	bayesWeight = 5
+
+	// interestingTokens - сколько самых показательных токенов участвует
+	// в оценке, остальные только добавляют шум.
+	interestingTokens = 15
+
+	// maxGlobalTraining - сколько писем каждого класса один пользователь
+	// может добавить в общий корпус, чтобы новый аккаунт не перевесил
+	// отметки всех остальных.
+	maxGlobalTraining = 20
+
+	maxTokenLength = 40
+	maxTokens      = 1000
+
+	// Параметры сглаживания Робинсона: вероятность неизвестного токена
+	// и вес этого предположения в числе писем.
+	unknownProbability = 0.5
+	unknownStrength    = 1
+)
+
+// Bayes - наивный байесовский фильтр с комбинированием вероятностей по
+// Робинсону-Фишеру. Счётчики берутся из личного корпуса получателя и
+// общего: отметки пользователя попадают в оба, поэтому его собственные
+// решения весят вдвое больше чужих. В общий корпус от одного
+// пользователя попадает не больше maxGlobalTraining писем каждого класса.
+type Bayes struct {
+	corpus      database.SpamRepository
+	minTraining int
+}
+
+func NewBayes(corpus database.SpamRepository, minTraining int) *Bayes {
+	if minTraining <= 0 {
+		minTraining = DefaultMinTraining
+	}
+	return &Bayes{corpus: corpus, minTraining: minTraining}
+}
+
+func (b *Bayes) Check(ctx context.Context, input Input) ([]Hit, error) {
+	tokens := Tokens(input.Message)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	global, err := b.corpus.Corpus(ctx, database.GlobalCorpus, tokens)
+	if err != nil {
+		return nil, err
+	}
+	personal, err := b.corpus.Corpus(ctx, input.Owner, tokens)
+	if err != nil {
+		return nil, err
+	}
+	spamTotal, hamTotal := global.Spam+personal.Spam, global.Ham+personal.Ham
+	if spamTotal < b.minTraining || hamTotal < b.minTraining {
+		return nil, nil
+	}
+
+	probabilities := make([]float64, 0, len(tokens))
+	for _, token := range tokens {
+		spam := global.Tokens[token].Spam + personal.Tokens[token].Spam
+		ham := global.Tokens[token].Ham + personal.Tokens[token].Ham
+		probabilities = append(probabilities, tokenProbability(spam, ham, spamTotal, hamTotal))
+	}
+	sort.Slice(probabilities, func(i, j int) bool {
+		return math.Abs(probabilities[i]-0.5) > math.Abs(probabilities[j]-0.5)
+	})
+	var interesting []float64
+	for _, p := range probabilities {
+		if len(interesting) == interestingTokens || math.Abs(p-0.5) < 0.1 {
+			break
+		}
+		interesting = append(interesting, p)
+	}
+	if len(interesting) == 0 {
+		return nil, nil
+	}
+
+	probability := combine(interesting)
+	return []Hit{{
+		Rule:   "BAYES",
+		Score:  (probability - 0.5) * 2 * bayesWeight,
+		Detail: fmt.Sprintf("spam probability %.2f", probability),
+	}}, nil
+}
+
+// tokenProbability - сглаженная вероятность того, что письмо с токеном -
+// спам. Частоты нормируются на размер корпусов, чтобы перекос в числе
+// писем одного класса не сдвигал оценку.
+func tokenProbability(spam int, ham int, spamTotal int, hamTotal int) float64 {
+	n := float64(spam + ham)
+	if n == 0 {
+		return unknownProbability
+	}
+	spamRate := math.Min(float64(spam)/float64(spamTotal), 1)
+	hamRate := math.Min(float64(ham)/float64(hamTotal), 1)
+	p := spamRate / (spamRate + hamRate)
+	return (unknownStrength*unknownProbability + n*p) / (unknownStrength + n)
+}
+
+// combine объединяет вероятности методом Фишера: I = (1 + H - S) / 2,
+// где H и S - уверенность в том, что письмо нормальное и спам.
+func combine(probabilities []float64) float64 {
+	var logSpam, logHam float64
+	for _, p := range probabilities {
+		p = math.Min(math.Max(p, 0.01), 0.99)
+		logSpam += math.Log(1 - p)
+		logHam += math.Log(p)
+	}
+	n := len(probabilities)
+	h := chi2Q(-2*logHam, 2*n)
+	s := chi2Q(-2*logSpam, 2*n)
+	return (1 + h - s) / 2
+}
+
+// chi2Q - вероятность того, что величина с распределением хи-квадрат
+// с v (чётным) степенями свободы не меньше x2.
+func chi2Q(x2 float64, v int) float64 {
+	m := x2 / 2
+	term := math.Exp(-m)
+	sum := term
+	for i := 1; i < v/2; i++ {
+		term *= m / float64(i)
+		sum += term
+	}
+	return math.Min(sum, 1)
+}
+
+// Tokens - уникальные токены письма для обучения и оценки: слова темы
+// и текста после стемминга и домен отправителя.
+func Tokens(message database.Message) []string {
+	words := search.Tokens(message.Subject)
+	for i := range words {
+		words[i] = "subject:" + words[i]
+	}
+	body := message.TextBody
+	if body == "" {
+		body = message.HTMLBody
+	}
+	words = append(words, search.Tokens(body)...)
+	if message.From.Email != "" {
+		words = append(words, "from:"+domain(message.From.Email))
+	}
+
+	seen := make(map[string]bool, len(words))
+	tokens := make([]string, 0, len(words))
+	for _, word := range words {
+		if seen[word] || utf8.RuneCountInString(word) > maxTokenLength {
+			continue
+		}
+		seen[word] = true
+		tokens = append(tokens, word)
+		if len(tokens) == maxTokens {
+			break
+		}
+	}
+	return tokens
+}
+
+// Train учит личный и общий корпуса на письме, которое пользователь
+// отметил как спам или не спам. delta = -1 отменяет прежнее обучение.
+// Общий корпус учится, только пока личных писем этого класса не больше
+// maxGlobalTraining, так вклад пользователя в него равен
+// min(писем, maxGlobalTraining).
+func Train(ctx context.Context, corpus database.SpamRepository, owner string, message database.Message, spam bool, delta int) error {
+	personal, err := corpus.Corpus(ctx, owner, nil)
+	if err != nil {
+		return err
+	}
+	trained := personal.Ham
+	if spam {
+		trained = personal.Spam
+	}
+	tokens := Tokens(message)
+	if err := corpus.Train(ctx, owner, tokens, spam, delta); err != nil {
+		return err
+	}
+	if (delta > 0 && trained >= maxGlobalTraining) || (delta < 0 && (trained == 0 || trained > maxGlobalTraining)) {
+		return nil
+	}
+	return corpus.Train(ctx, database.GlobalCorpus, tokens, spam, delta)
+}
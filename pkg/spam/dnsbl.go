@@ -0,0 +1,102 @@
+package spam
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// defaultDNSBLScore - оценка за попадание в один чёрный список.
+const defaultDNSBLScore = 3
+
+// Resolver - та часть net.Resolver, которая нужна для запросов к DNSBL.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// StaticResolver отвечает из таблицы без обращения к сети: для работы
+// без доступа в интернет и для тестов. Имена, которых нет в таблице,
+// не существуют.
+type StaticResolver map[string][]string
+
+func (r StaticResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := r[strings.ToLower(strings.TrimSuffix(host, "."))]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// DNSBL проверяет IP-адрес SMTP-клиента по чёрным спискам (RFC 5782).
+// Адреса из локальных и частных сетей не проверяются.
+type DNSBL struct {
+	Zones    []string
+	Resolver Resolver
+	Score    float64
+}
+
+func (d *DNSBL) Check(ctx context.Context, input Input) ([]Hit, error) {
+	ip := input.ClientIP
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return nil, nil
+	}
+	score := d.Score
+	if score == 0 {
+		score = defaultDNSBLScore
+	}
+	reversed := reverseIP(ip)
+
+	var hits []Hit
+	var failed error
+	for _, zone := range d.Zones {
+		addrs, err := d.Resolver.LookupHost(ctx, reversed+"."+zone)
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			continue
+		}
+		if err != nil {
+			failed = err
+			continue
+		}
+		if listed(addrs) {
+			hits = append(hits, Hit{
+				Rule:   "DNSBL_" + strings.ToUpper(strings.ReplaceAll(zone, ".", "_")),
+				Score:  score,
+				Detail: ip.String() + " listed in " + zone,
+			})
+		}
+	}
+	// ошибка возвращается, только если не ответил ни один список
+	if len(hits) == 0 && failed != nil {
+		return nil, failed
+	}
+	return hits, nil
+}
+
+// reverseIP переворачивает октеты IPv4 или полубайты IPv6 для запроса.
+func reverseIP(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return strconv.Itoa(int(v4[3])) + "." + strconv.Itoa(int(v4[2])) + "." +
+			strconv.Itoa(int(v4[1])) + "." + strconv.Itoa(int(v4[0]))
+	}
+	const hex = "0123456789abcdef"
+	ip = ip.To16()
+	nibbles := make([]string, 0, 32)
+	for i := len(ip) - 1; i >= 0; i-- {
+		nibbles = append(nibbles, string(hex[ip[i]&0xf]), string(hex[ip[i]>>4]))
+	}
+	return strings.Join(nibbles, ".")
+}
+
+// listed считает попаданием ответы из 127.0.0.0/8, кроме 127.255.255.0/24:
+// так списки сообщают об ошибках и превышении лимита запросов.
+func listed(addrs []string) bool {
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr).To4()
+		if ip != nil && ip[0] == 127 && !(ip[1] == 255 && ip[2] == 255) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package spam
+
+import (
+	"context"
+	"mail/database"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Headers - эвристики по заголовкам и структуре письма. Каждая даёт
+// небольшую оценку, спамом письмо становится только при нескольких
+// признаках сразу или вместе с байесовским фильтром.
+type Headers struct{}
+
+func (Headers) Check(ctx context.Context, input Input) ([]Hit, error) {
+	message := input.Message
+	var hits []Hit
+	hit := func(rule string, score float64, detail string) {
+		hits = append(hits, Hit{Rule: rule, Score: score, Detail: detail})
+	}
+
+	if message.MessageID == "" {
+		hit("MISSING_MID", 1, "no Message-ID header")
+	}
+	if headerValue(message, "Date") == "" {
+		hit("MISSING_DATE", 1, "no Date header")
+	} else if !message.ReceivedAt.IsZero() && message.Date.After(message.ReceivedAt.Add(24*time.Hour)) {
+		hit("DATE_IN_FUTURE", 1.5, "Date is more than a day ahead")
+	}
+	if strings.TrimSpace(message.Subject) == "" {
+		hit("MISSING_SUBJECT", 0.5, "empty subject")
+	} else if shouting(message.Subject) {
+		hit("SUBJ_ALL_CAPS", 1.5, "subject is in capitals")
+	}
+	if strings.Contains(message.Subject, "!!!") || strings.Contains(message.Subject, "$$$") {
+		hit("SUBJ_EXCESS_PUNCT", 1, "subject has repeated ! or $")
+	}
+	if message.HTMLBody != "" && strings.TrimSpace(message.TextBody) == "" {
+		hit("HTML_ONLY", 1, "no text/plain part")
+	}
+	if message.From.Email == "" {
+		hit("MISSING_FROM", 2, "no From address")
+	}
+	if replyTo, err := mail.ParseAddress(headerValue(message, "Reply-To")); err == nil &&
+		message.From.Email != "" && !strings.EqualFold(domain(replyTo.Address), domain(message.From.Email)) {
+		hit("REPLYTO_DIFF_DOMAIN", 1, "Reply-To domain differs from From")
+	}
+	if len(message.To) == 0 && len(message.Cc) == 0 {
+		hit("UNDISCLOSED_RECIPIENTS", 1, "no To or Cc recipients")
+	}
+	return hits, nil
+}
+
+// shouting - тема из заглавных букв, короткие аббревиатуры не считаются.
+func shouting(subject string) bool {
+	letters := 0
+	for _, r := range subject {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		if !unicode.IsUpper(r) {
+			return false
+		}
+		letters++
+	}
+	return letters >= 10
+}
+
+func domain(address string) string {
+	return address[strings.LastIndex(address, "@")+1:]
+}
+
+func headerValue(message database.Message, key string) string {
+	for k, values := range message.Headers {
+		if strings.EqualFold(k, key) && len(values) > 0 {
+			return strings.TrimSpace(values[0])
+		}
+	}
+	return ""
+}
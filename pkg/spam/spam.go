@@ -0,0 +1,106 @@
+package spam
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"mail/config"
+	"mail/database"
+	"net"
+	"strings"
+)
+
+// DefaultThreshold - оценка, начиная с которой письмо считается спамом.
+const DefaultThreshold = 5
+
+// Input - письмо, которое проверяется для получателя Owner. ClientIP -
+// адрес SMTP-клиента, передавшего письмо, nil, если он неизвестен.
+type Input struct {
+	Owner    string
+	ClientIP net.IP
+	Message  database.Message
+}
+
+// Hit - сработавшее правило. Отрицательная оценка говорит в пользу того,
+// что письмо нормальное.
+type Hit struct {
+	Rule   string
+	Score  float64
+	Detail string
+}
+
+// Check - одна ступень проверки. Ошибка означает, что проверку не удалось
+// выполнить, и её результат не учитывается.
+type Check interface {
+	Check(ctx context.Context, input Input) ([]Hit, error)
+}
+
+// Result - итог проверки: сумма оценок всех сработавших правил.
+type Result struct {
+	Score     float64
+	Threshold float64
+	Hits      []Hit
+}
+
+func (r Result) IsSpam() bool {
+	return r.Score >= r.Threshold
+}
+
+// Status - значение заголовка X-Spam-Status в формате SpamAssassin.
+func (r Result) Status() string {
+	verdict := "No"
+	if r.IsSpam() {
+		verdict = "Yes"
+	}
+	rules := make([]string, 0, len(r.Hits))
+	for _, hit := range r.Hits {
+		rules = append(rules, hit.Rule)
+	}
+	return fmt.Sprintf("%s, score=%.1f required=%.1f tests=%s", verdict, r.Score, r.Threshold, strings.Join(rules, ","))
+}
+
+// Pipeline прогоняет письмо через все проверки и складывает оценки.
+type Pipeline struct {
+	threshold float64
+	checks    []Check
+}
+
+func NewPipeline(threshold float64, checks ...Check) *Pipeline {
+	if threshold <= 0 {
+		threshold = DefaultThreshold
+	}
+	return &Pipeline{threshold: threshold, checks: checks}
+}
+
+// New собирает проверки по конфигу: эвристики заголовков, байесовский
+// фильтр на корпусах corpus и DNSBL. В режиме Offline списки
+// запрашиваются у пустого StaticResolver.
+func New(cfg config.SpamConfig, corpus database.SpamRepository) *Pipeline {
+	var resolver Resolver = net.DefaultResolver
+	if cfg.Offline {
+		resolver = StaticResolver{}
+	}
+	return NewPipeline(cfg.Threshold,
+		Headers{},
+		NewBayes(corpus, cfg.MinTraining),
+		&DNSBL{Zones: cfg.DNSBL, Resolver: resolver},
+	)
+}
+
+// Classify не возвращает ошибок: упавшая проверка пишется в лог, чтобы
+// недоступность DNS или базы не задерживала доставку почты.
+func (p *Pipeline) Classify(ctx context.Context, input Input) Result {
+	result := Result{Threshold: p.threshold}
+	for _, check := range p.checks {
+		hits, err := check.Check(ctx, input)
+		if err != nil {
+			slog.Error("spam check failed", "check", fmt.Sprintf("%T", check), "error", err)
+			continue
+		}
+		for _, hit := range hits {
+			result.Score += hit.Score
+			result.Hits = append(result.Hits, hit)
+		}
+	}
+	return result
+}
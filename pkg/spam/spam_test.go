@@ -0,0 +1,163 @@
+package spam
+
+import (
+	"context"
+	"errors"
+	"mail/database"
+	"net"
+	"testing"
+	"time"
+)
+
+func ruleNames(hits []Hit) map[string]bool {
+	names := make(map[string]bool, len(hits))
+	for _, hit := range hits {
+		names[hit.Rule] = true
+	}
+	return names
+}
+
+func TestHeaders(t *testing.T) {
+	now := time.Now()
+	hits, _ := Headers{}.Check(context.Background(), Input{Message: database.Message{
+		From:       database.Address{Email: "winner@lottery.example"},
+		Subject:    "YOU HAVE WON A PRIZE!!!",
+		HTMLBody:   "<p>Claim now</p>",
+		Headers:    map[string][]string{"Date": {"x"}, "Reply-To": {"claims@other.example"}},
+		Date:       now.Add(72 * time.Hour),
+		ReceivedAt: now,
+	}})
+	names := ruleNames(hits)
+	for _, rule := range []string{"MISSING_MID", "DATE_IN_FUTURE", "SUBJ_ALL_CAPS", "SUBJ_EXCESS_PUNCT",
+		"HTML_ONLY", "REPLYTO_DIFF_DOMAIN", "UNDISCLOSED_RECIPIENTS"} {
+		if !names[rule] {
+			t.Errorf("rule %s did not fire: %+v", rule, hits)
+		}
+	}
+
+	hits, _ = Headers{}.Check(context.Background(), Input{Message: database.Message{
+		MessageID: "1@example.com",
+		From:      database.Address{Email: "mark@example.com"},
+		To:        []database.Address{{Email: "jane@giga-mail.ru"}},
+		Subject:   "Отчёт по NASA API",
+		TextBody:  "Привет",
+		Headers:   map[string][]string{"Date": {"x"}, "Reply-To": {"Mark <mark@example.com>"}},
+	}})
+	if len(hits) != 0 {
+		t.Errorf("unexpected hits for normal message: %+v", hits)
+	}
+}
+
+func TestBayes(t *testing.T) {
+	ctx := context.Background()
+	corpus := database.NewSpamStore()
+	const owner = "jane@giga-mail.ru"
+	spam := database.Message{From: database.Address{Email: "promo@casino.example"}, Subject: "Casino bonus", TextBody: "Free spins and jackpot"}
+	ham := database.Message{From: database.Address{Email: "mark@example.com"}, Subject: "Quarterly report", TextBody: "Please review the budget"}
+
+	bayes := NewBayes(corpus, 3)
+	if hits, _ := bayes.Check(ctx, Input{Owner: owner, Message: spam}); len(hits) != 0 {
+		t.Errorf("untrained filter scored message: %+v", hits)
+	}
+	for i := 0; i < 3; i++ {
+		Train(ctx, corpus, owner, spam, true, 1)
+		Train(ctx, corpus, owner, ham, false, 1)
+	}
+
+	hits, err := bayes.Check(ctx, Input{Owner: owner, Message: database.Message{Subject: "Jackpot", TextBody: "casino free spins"}})
+	if err != nil || len(hits) != 1 || hits[0].Score < 4 {
+		t.Errorf("spam is not recognized: %+v %v", hits, err)
+	}
+	hits, _ = bayes.Check(ctx, Input{Owner: owner, Message: database.Message{Subject: "Budget", TextBody: "report review"}})
+	if len(hits) != 1 || hits[0].Score > -4 {
+		t.Errorf("ham is not recognized: %+v", hits)
+	}
+	// общий корпус работает и для пользователя без своей истории
+	hits, _ = bayes.Check(ctx, Input{Owner: "bob@giga-mail.ru", Message: spam})
+	if len(hits) != 1 || hits[0].Score <= 0 {
+		t.Errorf("global corpus is not used: %+v", hits)
+	}
+
+	Train(ctx, corpus, owner, spam, true, -1)
+	if c, _ := corpus.Corpus(ctx, owner, Tokens(spam)); c.Spam != 2 || c.Tokens["from:casino.example"].Spam != 2 {
+		t.Errorf("unexpected corpus after untraining: %+v", c)
+	}
+}
+
+func TestTrainGlobalLimit(t *testing.T) {
+	ctx := context.Background()
+	corpus := database.NewSpamStore()
+	casino := database.Message{From: database.Address{Email: "promo@casino.example"}, Subject: "Casino bonus", TextBody: "Free spins"}
+
+	// один аккаунт массово отмечает спам как нормальные письма
+	for i := 0; i < maxGlobalTraining+10; i++ {
+		Train(ctx, corpus, "mallory@giga-mail.ru", casino, false, 1)
+	}
+	Train(ctx, corpus, "jane@giga-mail.ru", casino, true, 1)
+
+	global, _ := corpus.Corpus(ctx, database.GlobalCorpus, Tokens(casino))
+	if global.Ham != maxGlobalTraining || global.Tokens["from:casino.example"].Ham != maxGlobalTraining || global.Spam != 1 {
+		t.Errorf("unexpected global corpus: %+v", global)
+	}
+	personal, _ := corpus.Corpus(ctx, "mallory@giga-mail.ru", nil)
+	if personal.Ham != maxGlobalTraining+10 {
+		t.Errorf("unexpected personal corpus: %+v", personal)
+	}
+
+	// отмена писем сверх лимита не трогает общий корпус
+	for i := 0; i < 11; i++ {
+		Train(ctx, corpus, "mallory@giga-mail.ru", casino, false, -1)
+	}
+	global, _ = corpus.Corpus(ctx, database.GlobalCorpus, nil)
+	if global.Ham != maxGlobalTraining-1 {
+		t.Errorf("expected %d ham messages in global corpus, got %d", maxGlobalTraining-1, global.Ham)
+	}
+}
+
+func TestDNSBL(t *testing.T) {
+	ctx := context.Background()
+	check := &DNSBL{
+		Zones: []string{"bl.example.net", "limits.example.net", "clean.example.net"},
+		Resolver: StaticResolver{
+			"2.0.0.203.bl.example.net":     {"127.0.0.2"},
+			"2.0.0.203.limits.example.net": {"127.255.255.254"},
+		},
+	}
+	hits, err := check.Check(ctx, Input{ClientIP: net.ParseIP("203.0.0.2")})
+	if err != nil || len(hits) != 1 || hits[0].Rule != "DNSBL_BL_EXAMPLE_NET" || hits[0].Score != defaultDNSBLScore {
+		t.Errorf("unexpected hits: %+v %v", hits, err)
+	}
+	for _, ip := range []string{"203.0.0.3", "127.0.0.2", "10.1.2.3"} {
+		if hits, _ := check.Check(ctx, Input{ClientIP: net.ParseIP(ip)}); len(hits) != 0 {
+			t.Errorf("%s: unexpected hits %+v", ip, hits)
+		}
+	}
+	if got := reverseIP(net.ParseIP("2001:db8::1")); got != "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2" {
+		t.Errorf("unexpected reversed IPv6: %s", got)
+	}
+}
+
+type failingCheck struct{}
+
+func (failingCheck) Check(ctx context.Context, input Input) ([]Hit, error) {
+	return nil, errors.New("dns timeout")
+}
+
+type fixedCheck float64
+
+func (c fixedCheck) Check(ctx context.Context, input Input) ([]Hit, error) {
+	return []Hit{{Rule: "FIXED", Score: float64(c)}}, nil
+}
+
+func TestPipeline(t *testing.T) {
+	result := NewPipeline(0, fixedCheck(3), failingCheck{}, fixedCheck(2.5)).Classify(context.Background(), Input{})
+	if !result.IsSpam() || result.Score != 5.5 || len(result.Hits) != 2 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if status := result.Status(); status != "Yes, score=5.5 required=5.0 tests=FIXED,FIXED" {
+		t.Errorf("unexpected status: %s", status)
+	}
+	if NewPipeline(6, fixedCheck(5.5)).Classify(context.Background(), Input{}).IsSpam() {
+		t.Error("message below threshold is spam")
+	}
+}
@@ -0,0 +1,55 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// секрет "12345678901234567890" из приложения B RFC 6238
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	for _, c := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	} {
+		got, err := Code(rfcSecret, Step(time.Unix(c.unix, 0)))
+		if err != nil || got != c.code {
+			t.Errorf("%d: got %q, %v want %q", c.unix, got, err, c.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	code, _ := Code(secret, Step(now.Add(-Period)))
+	if step, ok := Validate(secret, code, now); !ok || step != Step(now)-1 {
+		t.Errorf("previous step: %v %v", step, ok)
+	}
+	if _, ok := Validate(secret, code, now.Add(2*Period)); ok {
+		t.Error("code accepted outside the window")
+	}
+	if _, ok := Validate(secret, "12345", now); ok {
+		t.Error("short code accepted")
+	}
+	if _, ok := Validate(secret, code[:3]+" "+code[3:], now); !ok {
+		t.Error("code with space rejected")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("giga-mail.ru", "nick@giga-mail.ru", rfcSecret)
+	if !strings.HasPrefix(uri, "otpauth://totp/giga-mail.ru:nick@giga-mail.ru?") || !strings.Contains(uri, "secret="+rfcSecret) {
+		t.Errorf("got %q", uri)
+	}
+}